@@ -0,0 +1,220 @@
+package analytics
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+	"time"
+
+	"github.com/jordbick/Golang/inventory-service/database"
+	"github.com/jordbick/Golang/inventory-service/ledger"
+)
+
+// getItems returns every product with its stock value, manufacturers are named after their supplier where there is one
+func getItems() ([]Item, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	results, err := database.DbConn.QueryContext(ctx, `SELECT p.productId,
+	p.productName,
+	p.sku,
+	COALESCE(p.manufacturerId, 0),
+	COALESCE(s.name, p.manufacturer),
+	p.quantityOnHand,
+	p.pricePerUnit
+	FROM products p
+	LEFT JOIN suppliers s ON s.supplierId = p.manufacturerId
+	ORDER BY p.productId`)
+	if err != nil {
+		return nil, err
+	}
+	defer results.Close()
+	items := make([]Item, 0)
+	for results.Next() {
+		var item Item
+		var price string
+		err := results.Scan(&item.ProductID,
+			&item.ProductName,
+			&item.Sku,
+			&item.ManufacturerID,
+			&item.Manufacturer,
+			&item.QuantityOnHand,
+			&price)
+		if err != nil {
+			return nil, err
+		}
+		item.PricePerUnit, _ = strconv.ParseFloat(price, 64)
+		item.Value = round(item.PricePerUnit * float64(item.QuantityOnHand))
+		items = append(items, item)
+	}
+	return items, results.Err()
+}
+
+// getIssued returns how many units of each product were issued between the two times
+func getIssued(from, to time.Time) (map[int]int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	// issues are recorded as negative movements
+	results, err := database.DbConn.QueryContext(ctx, `SELECT productId, CAST(SUM(-quantity) AS SIGNED)
+	FROM stock_movements
+	WHERE reason = ? AND quantity < 0 AND createdAt >= ? AND createdAt < ?
+	GROUP BY productId`, ledger.ReasonIssue, from.UTC(), to.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer results.Close()
+	issued := make(map[int]int)
+	for results.Next() {
+		var productID, quantity int
+		if err := results.Scan(&productID, &quantity); err != nil {
+			return nil, err
+		}
+		issued[productID] = quantity
+	}
+	return issued, results.Err()
+}
+
+// getLastMovements returns when each product last had its stock changed
+// transfers are left out, moving stock between shelves doesn't mean anyone wants it
+func getLastMovements() (map[int]time.Time, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	results, err := database.DbConn.QueryContext(ctx, `SELECT productId, MAX(createdAt)
+	FROM stock_movements
+	WHERE reason <> ?
+	GROUP BY productId`, ledger.ReasonTransfer)
+	if err != nil {
+		return nil, err
+	}
+	defer results.Close()
+	last := make(map[int]time.Time)
+	for results.Next() {
+		var productID int
+		var createdAt sql.NullTime
+		if err := results.Scan(&productID, &createdAt); err != nil {
+			return nil, err
+		}
+		if createdAt.Valid {
+			last[productID] = createdAt.Time
+		}
+	}
+	return last, results.Err()
+}
+
+func hasSnapshot(takenAt time.Time) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	var count int
+	err := database.DbConn.QueryRowContext(ctx, `SELECT COUNT(*) FROM stock_snapshots WHERE takenAt = ?`, takenAt.UTC()).Scan(&count)
+	return count > 0, err
+}
+
+// takeSnapshot copies every product's stock into the snapshots table
+// taking the same snapshot again just refreshes it
+func takeSnapshot(takenAt time.Time) (Snapshot, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+	takenAt = takenAt.UTC()
+	_, err := database.DbConn.ExecContext(ctx, `INSERT INTO stock_snapshots (takenAt, productId, quantity, pricePerUnit, value)
+	SELECT ?, productId, quantityOnHand, pricePerUnit, pricePerUnit * quantityOnHand FROM products
+	ON DUPLICATE KEY UPDATE quantity = VALUES(quantity), pricePerUnit = VALUES(pricePerUnit), value = VALUES(value)`, takenAt)
+	if err != nil {
+		return Snapshot{}, err
+	}
+	snapshot := Snapshot{TakenAt: takenAt}
+	var value string
+	err = database.DbConn.QueryRowContext(ctx, `SELECT COUNT(*), COALESCE(SUM(quantity), 0), COALESCE(SUM(value), 0)
+	FROM stock_snapshots
+	WHERE takenAt = ?`, takenAt).Scan(&snapshot.ProductCount, &snapshot.TotalQuantity, &value)
+	snapshot.TotalValue, _ = strconv.ParseFloat(value, 64)
+	return snapshot, err
+}
+
+// getHistory returns the snapshots of a product between two times, or the totals of each snapshot if productID is 0
+func getHistory(productID int, from, to time.Time) ([]Point, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	query := `SELECT takenAt, CAST(SUM(quantity) AS SIGNED), SUM(value)
+	FROM stock_snapshots
+	WHERE takenAt >= ? AND takenAt <= ?
+	GROUP BY takenAt
+	ORDER BY takenAt`
+	queryArgs := []interface{}{from.UTC(), to.UTC()}
+	if productID != 0 {
+		query = `SELECT takenAt, quantity, value
+		FROM stock_snapshots
+		WHERE takenAt >= ? AND takenAt <= ? AND productId = ?
+		ORDER BY takenAt`
+		queryArgs = append(queryArgs, productID)
+	}
+	results, err := database.DbConn.QueryContext(ctx, query, queryArgs...)
+	if err != nil {
+		return nil, err
+	}
+	defer results.Close()
+	points := make([]Point, 0)
+	for results.Next() {
+		var point Point
+		var value string
+		if err := results.Scan(&point.TakenAt, &point.Quantity, &value); err != nil {
+			return nil, err
+		}
+		point.Value, _ = strconv.ParseFloat(value, 64)
+		points = append(points, point)
+	}
+	return points, results.Err()
+}
+
+// getSnapshotAt returns the latest snapshot taken at or before t, by product
+func getSnapshotAt(t time.Time) (time.Time, map[int]Point, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	var takenAt sql.NullTime
+	err := database.DbConn.QueryRowContext(ctx, `SELECT MAX(takenAt) FROM stock_snapshots WHERE takenAt <= ?`, t.UTC()).Scan(&takenAt)
+	if err != nil {
+		return time.Time{}, nil, err
+	}
+	if !takenAt.Valid {
+		return time.Time{}, nil, ErrNoSnapshot
+	}
+	results, err := database.DbConn.QueryContext(ctx, `SELECT productId, quantity, value FROM stock_snapshots WHERE takenAt = ?`, takenAt.Time)
+	if err != nil {
+		return time.Time{}, nil, err
+	}
+	defer results.Close()
+	points := make(map[int]Point)
+	for results.Next() {
+		var productID int
+		var value string
+		point := Point{TakenAt: takenAt.Time}
+		if err := results.Scan(&productID, &point.Quantity, &value); err != nil {
+			return time.Time{}, nil, err
+		}
+		point.Value, _ = strconv.ParseFloat(value, 64)
+		points[productID] = point
+	}
+	return takenAt.Time, points, results.Err()
+}
+
+// getAverageQuantities returns the mean quantity of each product across the snapshots between two times
+func getAverageQuantities(from, to time.Time) (map[int]float64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	results, err := database.DbConn.QueryContext(ctx, `SELECT productId, AVG(quantity)
+	FROM stock_snapshots
+	WHERE takenAt >= ? AND takenAt <= ?
+	GROUP BY productId`, from.UTC(), to.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer results.Close()
+	averages := make(map[int]float64)
+	for results.Next() {
+		var productID int
+		var average float64
+		if err := results.Scan(&productID, &average); err != nil {
+			return nil, err
+		}
+		averages[productID] = average
+	}
+	return averages, results.Err()
+}
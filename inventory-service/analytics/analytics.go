@@ -0,0 +1,331 @@
+package analytics
+
+import (
+	"errors"
+	"math"
+	"sort"
+	"time"
+)
+
+// Aggregate figures about the stock we're holding: what it's worth, where the value is concentrated and what isn't moving
+// Everything is worked out from the current products table plus the stock movement ledger
+
+// Metrics that products can be ranked and bucketed by
+const (
+	MetricQuantity = "quantityOnHand"
+	MetricPrice    = "pricePerUnit"
+	MetricValue    = "value"
+	// MetricIssued is the number of units issued over a recent number of days
+	MetricIssued = "issued"
+)
+
+// ABC classes
+const (
+	ClassA = "A"
+	ClassB = "B"
+	ClassC = "C"
+)
+
+var ErrUnknownMetric = errors.New("metric must be quantityOnHand, pricePerUnit, value or issued")
+
+// Item is a product's stock and what it's worth
+type Item struct {
+	ProductID      int     `json:"productId"`
+	ProductName    string  `json:"productName"`
+	Sku            string  `json:"sku"`
+	ManufacturerID int     `json:"manufacturerId"`
+	Manufacturer   string  `json:"manufacturer"`
+	QuantityOnHand int     `json:"quantityOnHand"`
+	PricePerUnit   float64 `json:"pricePerUnit"`
+	Value          float64 `json:"value"`
+	// Issued is only filled in when ranking by MetricIssued
+	Issued *int `json:"issued,omitempty"`
+}
+
+// Valuation is the total stock held
+type Valuation struct {
+	ProductCount  int     `json:"productCount"`
+	TotalQuantity int     `json:"totalQuantity"`
+	TotalValue    float64 `json:"totalValue"`
+}
+
+// Group is the stock held by one manufacturer or category
+type Group struct {
+	ID            int     `json:"id"`
+	Name          string  `json:"name"`
+	ProductCount  int     `json:"productCount"`
+	TotalQuantity int     `json:"totalQuantity"`
+	TotalValue    float64 `json:"totalValue"`
+	// Share is the fraction of the total inventory value
+	Share float64 `json:"share"`
+}
+
+// Histogram counts the products falling in equal width buckets of a metric
+type Histogram struct {
+	Metric  string   `json:"metric"`
+	Buckets []Bucket `json:"buckets"`
+}
+
+// Bucket holds the products with Min <= metric < Max, the last bucket includes its Max
+type Bucket struct {
+	Min   float64 `json:"min"`
+	Max   float64 `json:"max"`
+	Count int     `json:"count"`
+}
+
+// Classification is an ABC analysis, A products make up the first share of the value, B the next and C the rest
+type Classification struct {
+	AThreshold float64          `json:"aThreshold"`
+	BThreshold float64          `json:"bThreshold"`
+	Classes    []ClassSummary   `json:"classes"`
+	Products   []ClassifiedItem `json:"products"`
+}
+
+type ClassSummary struct {
+	Class        string  `json:"class"`
+	ProductCount int     `json:"productCount"`
+	TotalValue   float64 `json:"totalValue"`
+	Share        float64 `json:"share"`
+}
+
+type ClassifiedItem struct {
+	Item
+	Class           string  `json:"class"`
+	CumulativeShare float64 `json:"cumulativeShare"`
+}
+
+// DeadStock is a product with stock on hand that hasn't moved for a while
+type DeadStock struct {
+	Item
+	// LastMovementAt is nil if the product has never moved
+	LastMovementAt *time.Time `json:"lastMovementAt"`
+	DaysIdle       *int       `json:"daysIdle"`
+}
+
+// value returns an item's value of the metric, which must already have been checked
+func value(item Item, metric string) float64 {
+	switch metric {
+	case MetricQuantity:
+		return float64(item.QuantityOnHand)
+	case MetricPrice:
+		return item.PricePerUnit
+	case MetricIssued:
+		if item.Issued == nil {
+			return 0
+		}
+		return float64(*item.Issued)
+	}
+	return item.Value
+}
+
+// ValidMetric returns whether products can be ranked by the metric
+func ValidMetric(metric string) bool {
+	switch metric {
+	case MetricQuantity, MetricPrice, MetricValue, MetricIssued:
+		return true
+	}
+	return false
+}
+
+func valuate(items []Item) Valuation {
+	var v Valuation
+	for _, item := range items {
+		v.ProductCount++
+		v.TotalQuantity += item.QuantityOnHand
+		v.TotalValue += item.Value
+	}
+	v.TotalValue = round(v.TotalValue)
+	return v
+}
+
+// byManufacturer groups items by supplier, products with no supplier are grouped by their manufacturer text under ID 0
+func byManufacturer(items []Item) []Group {
+	type key struct {
+		id   int
+		name string
+	}
+	groups := make(map[key]*Group)
+	total := 0.0
+	for _, item := range items {
+		k := key{id: item.ManufacturerID}
+		if k.id == 0 {
+			k.name = item.Manufacturer
+		}
+		g, ok := groups[k]
+		if !ok {
+			g = &Group{ID: item.ManufacturerID, Name: item.Manufacturer}
+			groups[k] = g
+		}
+		g.ProductCount++
+		g.TotalQuantity += item.QuantityOnHand
+		g.TotalValue += item.Value
+		total += item.Value
+	}
+	list := make([]Group, 0, len(groups))
+	for _, g := range groups {
+		list = append(list, *g)
+	}
+	return withShares(list, total)
+}
+
+// withShares fills in each group's share of the total and orders the groups by value, highest first
+func withShares(groups []Group, total float64) []Group {
+	for i := range groups {
+		if total > 0 {
+			groups[i].Share = round4(groups[i].TotalValue / total)
+		}
+		groups[i].TotalValue = round(groups[i].TotalValue)
+	}
+	sort.SliceStable(groups, func(i, j int) bool {
+		if groups[i].TotalValue != groups[j].TotalValue {
+			return groups[i].TotalValue > groups[j].TotalValue
+		}
+		return groups[i].Name < groups[j].Name
+	})
+	return groups
+}
+
+// histogram splits the range of the metric into equal buckets
+func histogram(items []Item, metric string, buckets int) Histogram {
+	h := Histogram{Metric: metric, Buckets: make([]Bucket, 0, buckets)}
+	if len(items) == 0 {
+		return h
+	}
+	min, max := math.Inf(1), math.Inf(-1)
+	for _, item := range items {
+		v := value(item, metric)
+		min = math.Min(min, v)
+		max = math.Max(max, v)
+	}
+	// everything the same gives a single bucket rather than dividing by zero
+	if max == min {
+		h.Buckets = append(h.Buckets, Bucket{Min: round(min), Max: round(max), Count: len(items)})
+		return h
+	}
+	width := (max - min) / float64(buckets)
+	for i := 0; i < buckets; i++ {
+		h.Buckets = append(h.Buckets, Bucket{Min: round(min + float64(i)*width), Max: round(min + float64(i+1)*width)})
+	}
+	h.Buckets[buckets-1].Max = round(max)
+	for _, item := range items {
+		i := int((value(item, metric) - min) / width)
+		if i >= buckets {
+			i = buckets - 1
+		}
+		h.Buckets[i].Count++
+	}
+	return h
+}
+
+// classify ranks items by value, an item is in class A while the value before it is under aThreshold of the total,
+// B while it's under bThreshold, and C after that. Items with no value are always C
+func classify(items []Item, aThreshold, bThreshold float64) Classification {
+	sorted := make([]Item, len(items))
+	copy(sorted, items)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Value > sorted[j].Value })
+	total := 0.0
+	for _, item := range sorted {
+		total += item.Value
+	}
+
+	c := Classification{
+		AThreshold: aThreshold,
+		BThreshold: bThreshold,
+		Classes:    []ClassSummary{{Class: ClassA}, {Class: ClassB}, {Class: ClassC}},
+		Products:   make([]ClassifiedItem, 0, len(sorted)),
+	}
+	cumulative := 0.0
+	for _, item := range sorted {
+		before := 0.0
+		if total > 0 {
+			before = cumulative / total
+		}
+		class, summary := ClassC, 2
+		switch {
+		case item.Value <= 0:
+		case before < aThreshold:
+			class, summary = ClassA, 0
+		case before < bThreshold:
+			class, summary = ClassB, 1
+		}
+		cumulative += item.Value
+		share := 0.0
+		if total > 0 {
+			share = cumulative / total
+		}
+		c.Products = append(c.Products, ClassifiedItem{Item: item, Class: class, CumulativeShare: round4(share)})
+		c.Classes[summary].ProductCount++
+		c.Classes[summary].TotalValue += item.Value
+	}
+	for i := range c.Classes {
+		if total > 0 {
+			c.Classes[i].Share = round4(c.Classes[i].TotalValue / total)
+		}
+		c.Classes[i].TotalValue = round(c.Classes[i].TotalValue)
+	}
+	return c
+}
+
+// top returns the first limit items ranked by the metric, ties are broken by product ID so the order is stable
+func top(items []Item, metric string, descending bool, limit int) []Item {
+	sorted := make([]Item, len(items))
+	copy(sorted, items)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		x, y := value(sorted[i], metric), value(sorted[j], metric)
+		if x == y {
+			return sorted[i].ProductID < sorted[j].ProductID
+		}
+		if descending {
+			return x > y
+		}
+		return x < y
+	})
+	if limit < len(sorted) {
+		sorted = sorted[:limit]
+	}
+	return sorted
+}
+
+// deadStock returns the items with stock that haven't moved since the cutoff, the longest idle first
+func deadStock(items []Item, lastMovements map[int]time.Time, now time.Time, days int) []DeadStock {
+	cutoff := now.AddDate(0, 0, -days)
+	dead := make([]DeadStock, 0)
+	for _, item := range items {
+		if item.QuantityOnHand <= 0 {
+			continue
+		}
+		d := DeadStock{Item: item}
+		if last, ok := lastMovements[item.ProductID]; ok {
+			if !last.Before(cutoff) {
+				continue
+			}
+			idle := int(now.Sub(last).Hours() / 24)
+			d.LastMovementAt, d.DaysIdle = &last, &idle
+		}
+		dead = append(dead, d)
+	}
+	// never moved comes first, then the oldest movement, and the most valuable stock within each
+	sort.SliceStable(dead, func(i, j int) bool {
+		a, b := dead[i].LastMovementAt, dead[j].LastMovementAt
+		switch {
+		case a == nil && b != nil:
+			return true
+		case a != nil && b == nil:
+			return false
+		case a != nil && !a.Equal(*b):
+			return a.Before(*b)
+		}
+		return dead[i].Value > dead[j].Value
+	})
+	return dead
+}
+
+// round rounds money to cents
+func round(f float64) float64 {
+	return math.Round(f*100) / 100
+}
+
+// round4 rounds a share to 4 decimal places, i.e. a hundredth of a percent
+func round4(f float64) float64 {
+	return math.Round(f*10000) / 10000
+}
@@ -0,0 +1,442 @@
+package analytics
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jordbick/Golang/inventory-service/category"
+	"github.com/jordbick/Golang/inventory-service/cors"
+	"github.com/jordbick/Golang/inventory-service/response"
+)
+
+const analyticsBasePath = "analytics"
+
+// SetupRoutes registers the analytics handler, everything is a GET under /api/analytics/
+//
+//	/valuation                      total quantity and value of the stock
+//	/valuation/manufacturers        value by manufacturer
+//	/valuation/categories           value by category, including subcategories
+//	/histogram?metric=&buckets=     how many products fall in each range of the metric
+//	/abc?a=0.8&b=0.95               ABC classification by value
+//	/deadstock?days=90              stock that hasn't moved in the number of days
+//	/top?metric=&order=&limit=&days= products ranked by a metric, days is the window for issued
+//	/history?productId=&from=&to=   stock at each snapshot, of one product or in total
+//	/compare?from=&to=              the change in stock between two snapshots
+//	/turnover?from=&to=             turnover and days of supply over a period
+//	/stream?productId=&from=        server sent events, the history followed by each new snapshot as it's taken
+func SetupRoutes(apiBasePath string) {
+	http.Handle(fmt.Sprintf("%s/%s/", apiBasePath, analyticsBasePath), cors.Middleware(http.HandlerFunc(analyticsHandler)))
+}
+
+// intParam reads an optional integer query parameter that must be between min and max
+func intParam(r *http.Request, name string, def, min, max int) (int, error) {
+	s := r.URL.Query().Get(name)
+	if s == "" {
+		return def, nil
+	}
+	i, err := strconv.Atoi(s)
+	if err != nil || i < min || i > max {
+		return 0, fmt.Errorf("%s must be a whole number from %d to %d", name, min, max)
+	}
+	return i, nil
+}
+
+// fractionParam reads an optional query parameter between 0 and 1
+func fractionParam(r *http.Request, name string, def float64) (float64, error) {
+	s := r.URL.Query().Get(name)
+	if s == "" {
+		return def, nil
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || f <= 0 || f > 1 {
+		return 0, fmt.Errorf("%s must be a fraction greater than 0 and at most 1", name)
+	}
+	return f, nil
+}
+
+// timeParam reads an optional date (2006-01-02, the start of that day in UTC) or RFC 3339 time
+func timeParam(r *http.Request, name string, def time.Time) (time.Time, error) {
+	s := r.URL.Query().Get(name)
+	if s == "" {
+		return def, nil
+	}
+	if t, err := time.Parse("2006-01-02", s); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%s must be a date like 2006-01-02 or an RFC 3339 time", name)
+	}
+	return t, nil
+}
+
+// periodParams reads ?from= and ?to=, by default the last 30 days
+func periodParams(r *http.Request) (time.Time, time.Time, error) {
+	now := time.Now().UTC()
+	from, err := timeParam(r, "from", now.AddDate(0, 0, -30))
+	if err != nil {
+		return from, now, err
+	}
+	to, err := timeParam(r, "to", now)
+	if err != nil {
+		return from, to, err
+	}
+	if !to.After(from) {
+		return from, to, fmt.Errorf("to must be after from")
+	}
+	return from, to, nil
+}
+
+func analyticsHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodOptions:
+		return
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	urlPathSegments := strings.SplitN(r.URL.Path, analyticsBasePath+"/", 2)
+	name := strings.Trim(urlPathSegments[len(urlPathSegments)-1], "/")
+
+	var result interface{}
+	var err error
+	switch name {
+	case "valuation":
+		result, err = valuationAnalytic()
+	case "valuation/manufacturers":
+		result, err = manufacturersAnalytic()
+	case "valuation/categories":
+		result, err = categoriesAnalytic()
+	case "histogram":
+		result, err = histogramAnalytic(r)
+	case "abc":
+		result, err = abcAnalytic(r)
+	case "deadstock":
+		result, err = deadStockAnalytic(r)
+	case "top":
+		result, err = topAnalytic(r)
+	case "history":
+		result, err = historyAnalytic(r)
+	case "compare":
+		result, err = compareAnalytic(r)
+	case "turnover":
+		result, err = turnoverAnalytic(r)
+	case "stream":
+		streamHandler(w, r)
+		return
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if badRequest, ok := err.(paramError); ok {
+		http.Error(w, badRequest.Error(), http.StatusBadRequest)
+		return
+	} else if err == ErrNoSnapshot {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	} else if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	response.WriteJSON(w, result)
+}
+
+// paramError is a problem with the query parameters rather than with working out the analytic
+type paramError struct {
+	error
+}
+
+func valuationAnalytic() (interface{}, error) {
+	items, err := getItems()
+	if err != nil {
+		return nil, err
+	}
+	return valuate(items), nil
+}
+
+func manufacturersAnalytic() (interface{}, error) {
+	items, err := getItems()
+	if err != nil {
+		return nil, err
+	}
+	return byManufacturer(items), nil
+}
+
+// categoriesAnalytic uses the category rollups, a product in a subcategory counts towards every category above it
+// so the shares of nested categories add up to more than 1
+func categoriesAnalytic() (interface{}, error) {
+	items, err := getItems()
+	if err != nil {
+		return nil, err
+	}
+	rollups, err := category.GetRollups()
+	if err != nil {
+		return nil, err
+	}
+	groups := make([]Group, len(rollups))
+	for i, rollup := range rollups {
+		groups[i] = Group{
+			ID:            rollup.CategoryID,
+			Name:          rollup.Name,
+			ProductCount:  rollup.ProductCount,
+			TotalQuantity: rollup.TotalQuantity,
+			TotalValue:    rollup.TotalValue,
+		}
+	}
+	return withShares(groups, valuate(items).TotalValue), nil
+}
+
+func histogramAnalytic(r *http.Request) (interface{}, error) {
+	metric := r.URL.Query().Get("metric")
+	if metric == "" {
+		metric = MetricQuantity
+	}
+	if !ValidMetric(metric) {
+		return nil, paramError{ErrUnknownMetric}
+	}
+	buckets, err := intParam(r, "buckets", 10, 1, 100)
+	if err != nil {
+		return nil, paramError{err}
+	}
+	items, err := itemsWithIssued(r, metric)
+	if err != nil {
+		return nil, err
+	}
+	return histogram(items, metric, buckets), nil
+}
+
+func abcAnalytic(r *http.Request) (interface{}, error) {
+	a, err := fractionParam(r, "a", 0.8)
+	if err != nil {
+		return nil, paramError{err}
+	}
+	b, err := fractionParam(r, "b", 0.95)
+	if err != nil {
+		return nil, paramError{err}
+	}
+	if b < a {
+		return nil, paramError{fmt.Errorf("b can't be less than a")}
+	}
+	items, err := getItems()
+	if err != nil {
+		return nil, err
+	}
+	return classify(items, a, b), nil
+}
+
+func deadStockAnalytic(r *http.Request) (interface{}, error) {
+	days, err := intParam(r, "days", 90, 1, 3650)
+	if err != nil {
+		return nil, paramError{err}
+	}
+	items, err := getItems()
+	if err != nil {
+		return nil, err
+	}
+	lastMovements, err := getLastMovements()
+	if err != nil {
+		return nil, err
+	}
+	return deadStock(items, lastMovements, time.Now().UTC(), days), nil
+}
+
+func topAnalytic(r *http.Request) (interface{}, error) {
+	query := r.URL.Query()
+	metric := query.Get("metric")
+	if metric == "" {
+		metric = MetricQuantity
+	}
+	if !ValidMetric(metric) {
+		return nil, paramError{ErrUnknownMetric}
+	}
+	descending := true
+	switch strings.ToLower(query.Get("order")) {
+	case "", "desc":
+	case "asc":
+		descending = false
+	default:
+		return nil, paramError{fmt.Errorf("order must be asc or desc")}
+	}
+	limit, err := intParam(r, "limit", 10, 1, 1000)
+	if err != nil {
+		return nil, paramError{err}
+	}
+	items, err := itemsWithIssued(r, metric)
+	if err != nil {
+		return nil, err
+	}
+	return top(items, metric, descending, limit), nil
+}
+
+// itemsWithIssued gets the items, filling in how many units were issued over ?days= (30 by default) if the metric needs it
+func itemsWithIssued(r *http.Request, metric string) ([]Item, error) {
+	days, err := intParam(r, "days", 30, 1, 3650)
+	if err != nil {
+		return nil, paramError{err}
+	}
+	items, err := getItems()
+	if err != nil || metric != MetricIssued {
+		return items, err
+	}
+	now := time.Now()
+	issued, err := getIssued(now.AddDate(0, 0, -days), now)
+	if err != nil {
+		return nil, err
+	}
+	for i := range items {
+		n := issued[items[i].ProductID]
+		items[i].Issued = &n
+	}
+	return items, nil
+}
+
+func historyAnalytic(r *http.Request) (interface{}, error) {
+	productID, err := intParam(r, "productId", 0, 0, math.MaxInt32)
+	if err != nil {
+		return nil, paramError{err}
+	}
+	from, to, err := periodParams(r)
+	if err != nil {
+		return nil, paramError{err}
+	}
+	return getHistory(productID, from, to)
+}
+
+// compareAnalytic compares the snapshots in place at ?from= and ?to=, by default a week ago and now
+func compareAnalytic(r *http.Request) (interface{}, error) {
+	now := time.Now().UTC()
+	from, err := timeParam(r, "from", now.AddDate(0, 0, -7))
+	if err != nil {
+		return nil, paramError{err}
+	}
+	to, err := timeParam(r, "to", now)
+	if err != nil {
+		return nil, paramError{err}
+	}
+	fromTakenAt, before, err := getSnapshotAt(from)
+	if err != nil {
+		return nil, err
+	}
+	toTakenAt, after, err := getSnapshotAt(to)
+	if err != nil {
+		return nil, err
+	}
+	items, err := getItems()
+	if err != nil {
+		return nil, err
+	}
+	names := make(map[int]string, len(items))
+	for _, item := range items {
+		names[item.ProductID] = item.ProductName
+	}
+	return compare(fromTakenAt, toTakenAt, before, after, names), nil
+}
+
+func turnoverAnalytic(r *http.Request) (interface{}, error) {
+	from, to, err := periodParams(r)
+	if err != nil {
+		return nil, paramError{err}
+	}
+	items, err := getItems()
+	if err != nil {
+		return nil, err
+	}
+	issued, err := getIssued(from, to)
+	if err != nil {
+		return nil, err
+	}
+	averages, err := getAverageQuantities(from, to)
+	if err != nil {
+		return nil, err
+	}
+	days := to.Sub(from).Hours() / 24
+	products, overall := turnover(items, issued, averages, days)
+	return TurnoverReport{From: from, To: to, Days: round(days), Overall: overall, Products: products}, nil
+}
+
+// streamHandler sends the stock history as server sent events for the dashboard to chart
+// a "history" event has the points so far, then a "point" event follows each new snapshot
+func streamHandler(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	productID, err := intParam(r, "productId", 0, 0, math.MaxInt32)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	from, err := timeParam(r, "from", time.Now().UTC().AddDate(0, 0, -30))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// subscribe before reading the history so a snapshot taken in between isn't missed
+	snapshots := subscribe()
+	defer unsubscribe(snapshots)
+	history, err := getHistory(productID, from, time.Now().UTC())
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	send := func(event string, v interface{}) bool {
+		j, err := json.Marshal(v)
+		if err != nil {
+			log.Println(err)
+			return false
+		}
+		if _, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, j); err != nil {
+			return false
+		}
+		flusher.Flush()
+		return true
+	}
+	if !send("history", history) {
+		return
+	}
+
+	// a comment every so often stops proxies closing a quiet connection
+	keepAlive := time.NewTicker(30 * time.Second)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case snapshot := <-snapshots:
+			point := Point{TakenAt: snapshot.TakenAt, Quantity: snapshot.TotalQuantity, Value: snapshot.TotalValue}
+			if productID != 0 {
+				points, err := getHistory(productID, snapshot.TakenAt, snapshot.TakenAt)
+				if err != nil {
+					log.Println(err)
+					continue
+				}
+				if len(points) == 0 {
+					continue
+				}
+				point = points[0]
+			}
+			if !send("point", point) {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,244 @@
+package analytics
+
+import (
+	"errors"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Snapshots record the quantity and value of every product at regular intervals, daily by default
+// They're what the history, compare and turnover analytics are worked out from, and each new one is pushed to /api/analytics/stream
+
+var ErrNoSnapshot = errors.New("no stock snapshot taken on or before that time")
+
+// Snapshot is the totals of one snapshot
+type Snapshot struct {
+	TakenAt       time.Time `json:"takenAt"`
+	ProductCount  int       `json:"productCount"`
+	TotalQuantity int       `json:"totalQuantity"`
+	TotalValue    float64   `json:"totalValue"`
+}
+
+// Point is the stock of one product, or the total stock, when a snapshot was taken
+type Point struct {
+	TakenAt  time.Time `json:"takenAt"`
+	Quantity int       `json:"quantity"`
+	Value    float64   `json:"value"`
+}
+
+// Change is the difference in a product's stock between two snapshots, a ProductID of 0 is the total
+type Change struct {
+	ProductID      int     `json:"productId,omitempty"`
+	ProductName    string  `json:"productName,omitempty"`
+	FromQuantity   int     `json:"fromQuantity"`
+	ToQuantity     int     `json:"toQuantity"`
+	QuantityChange int     `json:"quantityChange"`
+	FromValue      float64 `json:"fromValue"`
+	ToValue        float64 `json:"toValue"`
+	ValueChange    float64 `json:"valueChange"`
+}
+
+// Comparison compares the snapshots nearest to (but not after) two times
+type Comparison struct {
+	From     time.Time `json:"from"`
+	To       time.Time `json:"to"`
+	Totals   Change    `json:"totals"`
+	Products []Change  `json:"products"`
+}
+
+// Turnover is how fast a product's stock is used over a period
+type Turnover struct {
+	ProductID       int     `json:"productId,omitempty"`
+	ProductName     string  `json:"productName,omitempty"`
+	QuantityOnHand  int     `json:"quantityOnHand"`
+	Issued          int     `json:"issued"`
+	AverageQuantity float64 `json:"averageQuantity"`
+	// Turnover is units issued over the average stock held, nil when no stock was held
+	Turnover *float64 `json:"turnover"`
+	// DailyUsage is the average units issued per day
+	DailyUsage float64 `json:"dailyUsage"`
+	// DaysOfSupply is how long the stock on hand lasts at the daily usage, nil when nothing is being used
+	DaysOfSupply *float64 `json:"daysOfSupply"`
+}
+
+// TurnoverReport is the turnover of every product and overall
+type TurnoverReport struct {
+	From     time.Time  `json:"from"`
+	To       time.Time  `json:"to"`
+	Days     float64    `json:"days"`
+	Overall  Turnover   `json:"overall"`
+	Products []Turnover `json:"products"`
+}
+
+// Snapshotter takes a snapshot at the start of every Interval
+type Snapshotter struct {
+	Interval time.Duration
+	// PollInterval is how often to check whether the next snapshot is due
+	PollInterval time.Duration
+}
+
+// NewSnapshotter returns a snapshotter that takes a snapshot each day at midnight UTC
+func NewSnapshotter() *Snapshotter {
+	return &Snapshotter{Interval: 24 * time.Hour, PollInterval: time.Minute}
+}
+
+// Start takes any snapshot that's missing for the current interval, checking again every PollInterval until done is closed
+func (s *Snapshotter) Start(done <-chan struct{}) {
+	go func() {
+		ticker := time.NewTicker(s.PollInterval)
+		defer ticker.Stop()
+		for {
+			if err := s.snapshotIfDue(time.Now()); err != nil {
+				log.Println(err)
+			}
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// snapshotIfDue takes the snapshot for the current interval unless it's already been taken
+// the snapshot is stamped with the start of the interval, so restarting the service doesn't take it twice
+func (s *Snapshotter) snapshotIfDue(now time.Time) error {
+	takenAt := now.UTC().Truncate(s.Interval)
+	taken, err := hasSnapshot(takenAt)
+	if err != nil || taken {
+		return err
+	}
+	snapshot, err := takeSnapshot(takenAt)
+	if err != nil {
+		return err
+	}
+	publishSnapshot(snapshot)
+	return nil
+}
+
+// subscribers are the streams waiting for new snapshots
+var subscribers = struct {
+	sync.Mutex
+	channels map[chan Snapshot]bool
+}{channels: make(map[chan Snapshot]bool)}
+
+func subscribe() chan Snapshot {
+	ch := make(chan Snapshot, 1)
+	subscribers.Lock()
+	defer subscribers.Unlock()
+	subscribers.channels[ch] = true
+	return ch
+}
+
+func unsubscribe(ch chan Snapshot) {
+	subscribers.Lock()
+	defer subscribers.Unlock()
+	delete(subscribers.channels, ch)
+}
+
+// publishSnapshot tells every stream about a new snapshot, a stream that hasn't dealt with the last one misses it
+func publishSnapshot(snapshot Snapshot) {
+	subscribers.Lock()
+	defer subscribers.Unlock()
+	for ch := range subscribers.channels {
+		select {
+		case ch <- snapshot:
+		default:
+		}
+	}
+}
+
+// compare works out the change in each product between two snapshots, products missing from one of them count as zero
+// the biggest changes in value come first
+func compare(from, to time.Time, before, after map[int]Point, names map[int]string) Comparison {
+	c := Comparison{From: from, To: to, Products: make([]Change, 0, len(after))}
+	ids := make(map[int]bool, len(after))
+	for id := range before {
+		ids[id] = true
+	}
+	for id := range after {
+		ids[id] = true
+	}
+	for id := range ids {
+		change := Change{
+			ProductID:    id,
+			ProductName:  names[id],
+			FromQuantity: before[id].Quantity,
+			ToQuantity:   after[id].Quantity,
+			FromValue:    before[id].Value,
+			ToValue:      after[id].Value,
+		}
+		change.QuantityChange = change.ToQuantity - change.FromQuantity
+		change.ValueChange = round(change.ToValue - change.FromValue)
+		c.Totals.FromQuantity += change.FromQuantity
+		c.Totals.ToQuantity += change.ToQuantity
+		c.Totals.FromValue += change.FromValue
+		c.Totals.ToValue += change.ToValue
+		c.Products = append(c.Products, change)
+	}
+	c.Totals.QuantityChange = c.Totals.ToQuantity - c.Totals.FromQuantity
+	c.Totals.FromValue, c.Totals.ToValue = round(c.Totals.FromValue), round(c.Totals.ToValue)
+	c.Totals.ValueChange = round(c.Totals.ToValue - c.Totals.FromValue)
+	sort.SliceStable(c.Products, func(i, j int) bool {
+		x, y := abs(c.Products[i].ValueChange), abs(c.Products[j].ValueChange)
+		if x != y {
+			return x > y
+		}
+		return c.Products[i].ProductID < c.Products[j].ProductID
+	})
+	return c
+}
+
+func abs(f float64) float64 {
+	if f < 0 {
+		return -f
+	}
+	return f
+}
+
+// turnover works out the turnover and days of supply of each item over a period of days
+// averages are the mean of the snapshots in the period, falling back to the stock on hand if there aren't any
+func turnover(items []Item, issued map[int]int, averages map[int]float64, days float64) ([]Turnover, Turnover) {
+	list := make([]Turnover, 0, len(items))
+	var overall Turnover
+	for _, item := range items {
+		average, ok := averages[item.ProductID]
+		if !ok {
+			average = float64(item.QuantityOnHand)
+		}
+		t := newTurnover(item.QuantityOnHand, issued[item.ProductID], average, days)
+		t.ProductID, t.ProductName = item.ProductID, item.ProductName
+		list = append(list, t)
+		overall.QuantityOnHand += item.QuantityOnHand
+		overall.Issued += t.Issued
+		overall.AverageQuantity += average
+	}
+	overall = newTurnover(overall.QuantityOnHand, overall.Issued, overall.AverageQuantity, days)
+	// fastest moving first, then the ones that aren't moving at all
+	sort.SliceStable(list, func(i, j int) bool {
+		x, y := list[i].Turnover, list[j].Turnover
+		if x == nil || y == nil {
+			return x != nil && y == nil
+		}
+		return *x > *y
+	})
+	return list, overall
+}
+
+func newTurnover(quantityOnHand, issued int, average, days float64) Turnover {
+	t := Turnover{QuantityOnHand: quantityOnHand, Issued: issued, AverageQuantity: round(average)}
+	if average > 0 {
+		rate := round(float64(issued) / average)
+		t.Turnover = &rate
+	}
+	if days > 0 {
+		t.DailyUsage = round(float64(issued) / days)
+	}
+	if t.DailyUsage > 0 {
+		supply := round(float64(quantityOnHand) / (float64(issued) / days))
+		t.DaysOfSupply = &supply
+	}
+	return t
+}
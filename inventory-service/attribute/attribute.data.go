@@ -0,0 +1,271 @@
+package attribute
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jordbick/Golang/inventory-service/category"
+	"github.com/jordbick/Golang/inventory-service/database"
+)
+
+// getDefinitions returns the attribute definitions of the given categories, or every definition if categoryIDs is nil
+func getDefinitions(categoryIDs []int) ([]Definition, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	definitions := make([]Definition, 0)
+	queryArgs := make([]interface{}, 0)
+	query := `SELECT attributeId,
+	categoryId,
+	name,
+	dataType,
+	enumValues
+	FROM attribute_definitions `
+	if categoryIDs != nil {
+		if len(categoryIDs) == 0 {
+			return definitions, nil
+		}
+		in, args := category.InClause(categoryIDs)
+		query += `WHERE categoryId ` + in + ` `
+		queryArgs = append(queryArgs, args...)
+	}
+	query += `ORDER BY attributeId`
+	results, err := database.DbConn.QueryContext(ctx, query, queryArgs...)
+	if err != nil {
+		return nil, err
+	}
+	defer results.Close()
+	for results.Next() {
+		var definition Definition
+		var enumValues string
+		err := results.Scan(&definition.AttributeID,
+			&definition.CategoryID,
+			&definition.Name,
+			&definition.DataType,
+			&enumValues)
+		if err != nil {
+			return nil, err
+		}
+		definition.EnumValues = make([]string, 0)
+		if enumValues != "" {
+			definition.EnumValues = strings.Split(enumValues, ",")
+		}
+		definitions = append(definitions, definition)
+	}
+	return definitions, nil
+}
+
+func getDefinition(attributeID int) (*Definition, error) {
+	definitions, err := getDefinitions(nil)
+	if err != nil {
+		return nil, err
+	}
+	for _, d := range definitions {
+		if d.AttributeID == attributeID {
+			return &d, nil
+		}
+	}
+	return nil, nil
+}
+
+func insertDefinition(definition Definition) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	result, err := database.DbConn.ExecContext(ctx, `INSERT INTO attribute_definitions (
+	categoryId,
+	name,
+	dataType,
+	enumValues) VALUES (?, ?, ?, ?)`,
+		definition.CategoryID,
+		strings.TrimSpace(definition.Name),
+		definition.DataType,
+		strings.Join(definition.EnumValues, ","))
+	if err != nil {
+		return 0, err
+	}
+	insertID, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	return int(insertID), nil
+}
+
+// updateDefinition renames an attribute or changes its enum values, the category and data type are fixed once created
+func updateDefinition(definition Definition) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	_, err := database.DbConn.ExecContext(ctx, `UPDATE attribute_definitions SET
+	name=?,
+	enumValues=?
+	WHERE attributeId=?`,
+		strings.TrimSpace(definition.Name),
+		strings.Join(definition.EnumValues, ","),
+		definition.AttributeID)
+	return err
+}
+
+// removeDefinition deletes an attribute along with every product's value for it
+func removeDefinition(attributeID int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	tx, err := database.DbConn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	_, err = tx.ExecContext(ctx, `DELETE FROM product_attributes WHERE attributeId = ?`, attributeID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `DELETE FROM attribute_definitions WHERE attributeId = ?`, attributeID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetProductAttributes returns the attribute values of each of the given products, keyed by attribute name
+func GetProductAttributes(productIDs []int) (map[int]map[string]interface{}, error) {
+	attributes := make(map[int]map[string]interface{})
+	if len(productIDs) == 0 {
+		return attributes, nil
+	}
+	definitions, err := getDefinitions(nil)
+	if err != nil {
+		return nil, err
+	}
+	byID := make(map[int]Definition, len(definitions))
+	for _, d := range definitions {
+		byID[d.AttributeID] = d
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	in, args := category.InClause(productIDs)
+	results, err := database.DbConn.QueryContext(ctx, `SELECT productId, attributeId, value FROM product_attributes WHERE productId `+in, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer results.Close()
+	for results.Next() {
+		var productID, attributeID int
+		var value string
+		if err := results.Scan(&productID, &attributeID, &value); err != nil {
+			return nil, err
+		}
+		definition, ok := byID[attributeID]
+		if !ok {
+			continue
+		}
+		if attributes[productID] == nil {
+			attributes[productID] = make(map[string]interface{})
+		}
+		attributes[productID][definition.Name] = definition.parse(value)
+	}
+	return attributes, nil
+}
+
+// prepareValues checks the values against the attributes defined on the product's categories (and their parents)
+func prepareValues(categoryIDs []int, values map[string]interface{}) ([]storedValue, error) {
+	applicable, err := category.AncestorIDs(categoryIDs)
+	if err != nil {
+		return nil, err
+	}
+	definitions, err := getDefinitions(applicable)
+	if err != nil {
+		return nil, err
+	}
+	byName := make(map[string]Definition, len(definitions))
+	for _, d := range definitions {
+		// if two categories define the same name the first one wins
+		if _, ok := byName[d.Name]; !ok {
+			byName[d.Name] = d
+		}
+	}
+	stored := make([]storedValue, 0, len(values))
+	for name, value := range values {
+		definition, ok := byName[name]
+		if !ok {
+			return nil, fmt.Errorf("attribute [%s] isn't defined for this product's categories", name)
+		}
+		s, err := definition.convert(value)
+		if err != nil {
+			return nil, err
+		}
+		stored = append(stored, s)
+	}
+	return stored, nil
+}
+
+// ValidateProductAttributes returns an error describing the first value that doesn't fit the product's attribute definitions
+func ValidateProductAttributes(categoryIDs []int, values map[string]interface{}) error {
+	_, err := prepareValues(categoryIDs, values)
+	return err
+}
+
+// SetProductAttributes validates the values and then replaces the product's stored values with them
+func SetProductAttributes(productID int, categoryIDs []int, values map[string]interface{}) error {
+	stored, err := prepareValues(categoryIDs, values)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	tx, err := database.DbConn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	_, err = tx.ExecContext(ctx, `DELETE FROM product_attributes WHERE productId = ?`, productID)
+	if err != nil {
+		return err
+	}
+	for _, s := range stored {
+		var numberValue sql.NullFloat64
+		if s.numberValue != nil {
+			numberValue = sql.NullFloat64{Float64: *s.numberValue, Valid: true}
+		}
+		_, err = tx.ExecContext(ctx, `INSERT INTO product_attributes (productId, attributeId, value, numberValue) VALUES (?, ?, ?, ?)`,
+			productID, s.attributeID, s.value, numberValue)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// RemoveProduct deletes all of a product's attribute values, as part of the transaction deleting the product
+func RemoveProduct(ctx context.Context, tx *sql.Tx, productID int) error {
+	_, err := tx.ExecContext(ctx, `DELETE FROM product_attributes WHERE productId = ?`, productID)
+	return err
+}
+
+// Condition returns a WHERE clause condition on products.productId matching products whose named attribute has the value
+// The value is put in the stored form for each attribute with the name, so 10.0 finds a number stored as 10 and True finds true,
+// a value that doesn't fit an attribute's type can't match it
+func Condition(name, value string) (string, []interface{}, error) {
+	definitions, err := getDefinitions(nil)
+	if err != nil {
+		return "", nil, err
+	}
+	matches := make([]string, 0)
+	args := make([]interface{}, 0)
+	for _, d := range definitions {
+		if d.Name != name {
+			continue
+		}
+		stored, err := d.convert(d.filterValue(value))
+		if err != nil {
+			continue
+		}
+		matches = append(matches, `(attributeId = ? AND value = ?)`)
+		args = append(args, d.AttributeID, stored.value)
+	}
+	if len(matches) == 0 {
+		return `FALSE`, args, nil
+	}
+	return `productId IN (SELECT productId FROM product_attributes
+		WHERE ` + strings.Join(matches, " OR ") + `)`, args, nil
+}
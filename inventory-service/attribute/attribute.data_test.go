@@ -0,0 +1,60 @@
+package attribute
+
+import (
+	"database/sql/driver"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/jordbick/Golang/inventory-service/database"
+	"github.com/jordbick/Golang/inventory-service/database/dbtest"
+)
+
+func TestCondition(t *testing.T) {
+	database.DbConn = dbtest.Open(func(query string, args []driver.Value) (dbtest.Result, error) {
+		return dbtest.Result{Rows: [][]driver.Value{
+			{int64(1), int64(1), "length", TypeNumber, ""},
+			{int64(2), int64(1), "cotton", TypeBool, ""},
+			{int64(3), int64(1), "size", TypeEnum, "S,M,L"},
+			// another category can use the same name for a different type
+			{int64(4), int64(2), "size", TypeNumber, ""},
+			{int64(5), int64(1), "color", TypeString, ""},
+		}}, nil
+	})
+	tests := []struct {
+		name, value string
+		args        []interface{}
+	}{
+		{"length", "10.0", []interface{}{1, "10"}},
+		{"length", " 2.50 ", []interface{}{1, "2.5"}},
+		{"cotton", "True", []interface{}{2, "true"}},
+		{"cotton", "0", []interface{}{2, "false"}},
+		{"size", "M", []interface{}{3, "M"}},
+		{"size", "12.0", []interface{}{4, "12"}},
+		{"color", "Dark Red", []interface{}{5, "Dark Red"}},
+		// values that don't fit the type, and names nobody defined, match nothing
+		{"length", "long", nil},
+		{"cotton", "maybe", nil},
+		{"size", "XL", nil},
+		{"weight", "1", nil},
+	}
+	for _, test := range tests {
+		condition, args, err := Condition(test.name, test.value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if test.args == nil {
+			if condition != "FALSE" || len(args) != 0 {
+				t.Errorf("Condition(%s, %q) = %s %v, want FALSE", test.name, test.value, condition, args)
+			}
+			continue
+		}
+		if !strings.HasPrefix(condition, "productId IN (SELECT productId FROM product_attributes") ||
+			strings.Count(condition, "(attributeId = ? AND value = ?)") != len(test.args)/2 {
+			t.Errorf("Condition(%s, %q) = %s", test.name, test.value, condition)
+		}
+		if !reflect.DeepEqual(args, test.args) {
+			t.Errorf("Condition(%s, %q) args %v, want %v", test.name, test.value, args, test.args)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package attribute
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Custom attributes are defined per category, e.g. a "Clothing" category might define a "size" enum and a "cotton" bool
+// A product can have a value for any attribute defined on its categories or their parents
+// Values are stored as text, with numbers also kept in a DOUBLE column so they can be compared numerically
+
+// Data types
+const (
+	TypeString = "string"
+	TypeNumber = "number"
+	TypeBool   = "bool"
+	TypeEnum   = "enum"
+)
+
+var ErrAttributeNotFound = errors.New("attribute not found")
+
+// Definition
+type Definition struct {
+	AttributeID int      `json:"attributeId"`
+	CategoryID  int      `json:"categoryId"`
+	Name        string   `json:"name"`
+	DataType    string   `json:"dataType"`
+	EnumValues  []string `json:"enumValues"`
+}
+
+// storedValue is a validated attribute value ready to be written to product_attributes
+type storedValue struct {
+	attributeID int
+	value       string
+	numberValue *float64
+}
+
+func validateDefinition(definition Definition) error {
+	if strings.TrimSpace(definition.Name) == "" || strings.ContainsAny(definition.Name, ",=") {
+		return fmt.Errorf("name is required and can't contain ',' or '='")
+	}
+	switch definition.DataType {
+	case TypeString, TypeNumber, TypeBool:
+		if len(definition.EnumValues) > 0 {
+			return fmt.Errorf("enumValues are only allowed for the %s type", TypeEnum)
+		}
+	case TypeEnum:
+		if len(definition.EnumValues) == 0 {
+			return fmt.Errorf("an %s needs at least one of enumValues", TypeEnum)
+		}
+		for _, v := range definition.EnumValues {
+			if strings.Contains(v, ",") {
+				return fmt.Errorf("enum value [%s] can't contain ','", v)
+			}
+		}
+	default:
+		return fmt.Errorf("dataType must be one of %s, %s, %s or %s", TypeString, TypeNumber, TypeBool, TypeEnum)
+	}
+	return nil
+}
+
+// convert checks a JSON value against the definition and returns it in its stored form
+func (d Definition) convert(value interface{}) (storedValue, error) {
+	stored := storedValue{attributeID: d.AttributeID}
+	switch d.DataType {
+	case TypeNumber:
+		n, ok := value.(float64)
+		if !ok {
+			return stored, fmt.Errorf("attribute [%s] must be a number", d.Name)
+		}
+		stored.value = strconv.FormatFloat(n, 'f', -1, 64)
+		stored.numberValue = &n
+	case TypeBool:
+		b, ok := value.(bool)
+		if !ok {
+			return stored, fmt.Errorf("attribute [%s] must be true or false", d.Name)
+		}
+		stored.value = strconv.FormatBool(b)
+	case TypeEnum:
+		s, ok := value.(string)
+		if !ok {
+			return stored, fmt.Errorf("attribute [%s] must be one of %s", d.Name, strings.Join(d.EnumValues, ", "))
+		}
+		for _, allowed := range d.EnumValues {
+			if s == allowed {
+				stored.value = s
+				return stored, nil
+			}
+		}
+		return stored, fmt.Errorf("attribute [%s] must be one of %s", d.Name, strings.Join(d.EnumValues, ", "))
+	default:
+		s, ok := value.(string)
+		if !ok || len(s) > 255 {
+			return stored, fmt.Errorf("attribute [%s] must be a string of up to 255 characters", d.Name)
+		}
+		stored.value = s
+	}
+	return stored, nil
+}
+
+// filterValue turns a value given as text, e.g. in a report filter, into the JSON type convert expects
+// anything that isn't a number or bool is left as text so convert rejects it
+func (d Definition) filterValue(value string) interface{} {
+	switch d.DataType {
+	case TypeNumber:
+		if n, err := strconv.ParseFloat(strings.TrimSpace(value), 64); err == nil {
+			return n
+		}
+	case TypeBool:
+		if b, err := strconv.ParseBool(strings.TrimSpace(value)); err == nil {
+			return b
+		}
+	}
+	return value
+}
+
+// parse turns a stored value back into its JSON type
+func (d Definition) parse(value string) interface{} {
+	switch d.DataType {
+	case TypeNumber:
+		n, err := strconv.ParseFloat(value, 64)
+		if err == nil {
+			return n
+		}
+	case TypeBool:
+		return value == "true"
+	}
+	return value
+}
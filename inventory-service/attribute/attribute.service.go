@@ -0,0 +1,161 @@
+package attribute
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/jordbick/Golang/inventory-service/category"
+	"github.com/jordbick/Golang/inventory-service/cors"
+)
+
+const attributesBasePath = "attributes"
+
+// SetupRoutes registers the attribute definition handlers
+func SetupRoutes(apiBasePath string) {
+	handleAttributes := http.HandlerFunc(attributesHandler)
+	handleAttribute := http.HandlerFunc(attributeHandler)
+	http.Handle(fmt.Sprintf("%s/%s", apiBasePath, attributesBasePath), cors.Middleware(handleAttributes))
+	http.Handle(fmt.Sprintf("%s/%s/", apiBasePath, attributesBasePath), cors.Middleware(handleAttribute))
+}
+
+func attributesHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		// ?categoryId= returns the attributes a product in that category can have, including those inherited from parents
+		var categoryIDs []int
+		if c := r.URL.Query().Get("categoryId"); c != "" {
+			categoryID, err := strconv.Atoi(c)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			categoryIDs, err = category.AncestorIDs([]int{categoryID})
+			if err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+		}
+		definitions, err := getDefinitions(categoryIDs)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		definitionsJSON, err := json.Marshal(definitions)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Write(definitionsJSON)
+
+	case http.MethodPost:
+		var newDefinition Definition
+		bodyBytes, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		err = json.Unmarshal(bodyBytes, &newDefinition)
+		if err != nil || newDefinition.AttributeID != 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if err = validateDefinition(newDefinition); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err = category.ValidateIDs([]int{newDefinition.CategoryID}); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		attributeID, err := insertDefinition(newDefinition)
+		if err != nil {
+			// most likely the category already has an attribute with this name
+			log.Println(err)
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(fmt.Sprintf(`{"attributeId":%d}`, attributeID)))
+
+	case http.MethodOptions:
+		return
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func attributeHandler(w http.ResponseWriter, r *http.Request) {
+	urlPathSegments := strings.Split(r.URL.Path, fmt.Sprintf("/%s/", attributesBasePath))
+	attributeID, err := strconv.Atoi(urlPathSegments[len(urlPathSegments)-1])
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if r.Method == http.MethodOptions {
+		return
+	}
+	definition, err := getDefinition(attributeID)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if definition == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		definitionJSON, err := json.Marshal(definition)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Write(definitionJSON)
+
+	case http.MethodPut:
+		var updatedDefinition Definition
+		bodyBytes, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		err = json.Unmarshal(bodyBytes, &updatedDefinition)
+		if err != nil || updatedDefinition.AttributeID != attributeID {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if updatedDefinition.CategoryID != definition.CategoryID || updatedDefinition.DataType != definition.DataType {
+			http.Error(w, "categoryId and dataType can't be changed", http.StatusBadRequest)
+			return
+		}
+		if err = validateDefinition(updatedDefinition); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		err = updateDefinition(updatedDefinition)
+		if err != nil {
+			log.Println(err)
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+
+	case http.MethodDelete:
+		err = removeDefinition(attributeID)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,221 @@
+package barcode
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Barcodes are worked out as a row of modules, the narrowest bar or space the symbology uses
+// The renderers then draw each dark module as a bar of whatever width and height was asked for
+
+// Symbologies
+const (
+	UPCA    = "upca"
+	EAN13   = "ean13"
+	Code128 = "code128"
+)
+
+// QuietZone is the number of blank modules needed either side of the bars so scanners can find the start and end
+const QuietZone = 10
+
+var ErrInvalidCode = errors.New("code can't be encoded")
+
+// Barcode
+type Barcode struct {
+	Symbology string
+	// Modules are true for a bar and false for a space
+	Modules []bool
+	// Text is the human readable form of the code, including any check digit
+	Text string
+}
+
+// Encode encodes the code with the given symbology
+func Encode(symbology, code string) (Barcode, error) {
+	code = strings.TrimSpace(code)
+	switch symbology {
+	case UPCA:
+		return encodeUPCA(code)
+	case EAN13:
+		return encodeEAN13(code)
+	case Code128:
+		return encodeCode128(code)
+	}
+	return Barcode{}, fmt.Errorf("%w: unknown symbology [%s]", ErrInvalidCode, symbology)
+}
+
+// Patterns for EAN-13, each digit is seven modules wide
+var (
+	eanL = []string{"0001101", "0011001", "0010011", "0111101", "0100011", "0110001", "0101111", "0111011", "0110111", "0001011"}
+	eanG = []string{"0100111", "0110011", "0011011", "0100001", "0011101", "0111001", "0000101", "0010001", "0001001", "0010111"}
+	eanR = []string{"1110010", "1100110", "1101100", "1000010", "1011100", "1001110", "1010000", "1000100", "1001000", "1110100"}
+	// the first digit of an EAN-13 isn't drawn, it's carried by which of the next six digits use the L or G patterns
+	eanParity = []string{"LLLLLL", "LLGLGG", "LLGGLG", "LLGGGL", "LGLLGG", "LGGLLG", "LGGGLL", "LGLGLG", "LGLGGL", "LGGLGL"}
+)
+
+// encodeUPCA takes an 11 digit code, or 12 digits with a check digit
+// A UPC-A is drawn exactly like an EAN-13 starting with 0, only the text differs
+func encodeUPCA(code string) (Barcode, error) {
+	if len(code) == 13 && strings.HasPrefix(code, "0") {
+		code = code[1:]
+	}
+	if !digitsOnly(code) || (len(code) != 11 && len(code) != 12) {
+		return Barcode{}, fmt.Errorf("%w: a UPC-A needs 11 or 12 digits", ErrInvalidCode)
+	}
+	if len(code) == 11 {
+		code += string(checkDigit("0" + code))
+	}
+	barcode, err := encodeEAN13("0" + code)
+	if err != nil {
+		return Barcode{}, err
+	}
+	barcode.Symbology = UPCA
+	barcode.Text = barcode.Text[1:]
+	return barcode, nil
+}
+
+// encodeEAN13 takes 13 digits including the check digit, or a 12 digit UPC-A which becomes an EAN-13 starting with 0
+func encodeEAN13(code string) (Barcode, error) {
+	if len(code) == 12 {
+		code = "0" + code
+	}
+	if !digitsOnly(code) || len(code) != 13 {
+		return Barcode{}, fmt.Errorf("%w: an EAN-13 needs 13 digits", ErrInvalidCode)
+	}
+	// a wrong check digit means the code was mistyped, so don't print a label that scans as something else
+	if checkDigit(code[:12]) != code[12] {
+		return Barcode{}, fmt.Errorf("%w: check digit should be %c", ErrInvalidCode, checkDigit(code[:12]))
+	}
+
+	pattern := "101"
+	parity := eanParity[code[0]-'0']
+	for i := 1; i <= 6; i++ {
+		if parity[i-1] == 'L' {
+			pattern += eanL[code[i]-'0']
+		} else {
+			pattern += eanG[code[i]-'0']
+		}
+	}
+	pattern += "01010"
+	for i := 7; i <= 12; i++ {
+		pattern += eanR[code[i]-'0']
+	}
+	pattern += "101"
+
+	modules := make([]bool, len(pattern))
+	for i := range pattern {
+		modules[i] = pattern[i] == '1'
+	}
+	return Barcode{Symbology: EAN13, Modules: modules, Text: code}, nil
+}
+
+// checkDigit works out the EAN check digit for 12 digits, weighting them alternately 1 and 3
+func checkDigit(digits string) byte {
+	sum := 0
+	for i := range digits {
+		d := int(digits[i] - '0')
+		if i%2 == 1 {
+			d *= 3
+		}
+		sum += d
+	}
+	return byte('0' + (10-sum%10)%10)
+}
+
+// Bar and space widths of each Code 128 symbol, indexed by symbol value
+var code128Widths = []string{
+	"212222", "222122", "222221", "121223", "121322", "131222", "122213", "122312", "132212", "221213",
+	"221312", "231212", "112232", "122132", "122231", "113222", "123122", "123221", "223211", "221132",
+	"221231", "213212", "223112", "312131", "311222", "321122", "321221", "312212", "322112", "322211",
+	"212123", "212321", "232121", "111323", "131123", "131321", "112313", "132113", "132311", "211313",
+	"231113", "231311", "112133", "112331", "132131", "113123", "113321", "133121", "313121", "211331",
+	"231131", "213113", "213311", "213131", "311123", "311321", "331121", "312113", "312311", "332111",
+	"314111", "221411", "431111", "111224", "111422", "121124", "121421", "141122", "141221", "112214",
+	"112412", "122114", "122411", "142112", "142211", "241211", "221114", "413111", "241112", "134111",
+	"111242", "121142", "121241", "114212", "124112", "124211", "411212", "421112", "421211", "212141",
+	"214121", "412121", "111143", "111341", "131141", "114113", "114311", "411113", "411311", "113141",
+	"114131", "311141", "411131", "211412", "211214", "211232", "2331112",
+}
+
+// Code 128 special symbols
+const (
+	code128CodeC  = 99
+	code128CodeB  = 100
+	code128StartB = 104
+	code128StartC = 105
+	code128Stop   = 106
+)
+
+// encodeCode128 encodes printable ASCII using code set B, switching to code set C for runs of digits which packs two digits into each symbol
+func encodeCode128(code string) (Barcode, error) {
+	if code == "" {
+		return Barcode{}, fmt.Errorf("%w: code is empty", ErrInvalidCode)
+	}
+	for i := 0; i < len(code); i++ {
+		if code[i] < 32 || code[i] > 126 {
+			return Barcode{}, fmt.Errorf("%w: only printable ASCII is allowed", ErrInvalidCode)
+		}
+	}
+
+	values := make([]int, 0, len(code)+4)
+	setC := digitRun(code, 0) >= 4
+	if setC {
+		values = append(values, code128StartC)
+	} else {
+		values = append(values, code128StartB)
+	}
+	for i := 0; i < len(code); {
+		run := digitRun(code, i)
+		if setC {
+			if run >= 2 {
+				values = append(values, int(code[i]-'0')*10+int(code[i+1]-'0'))
+				i += 2
+				continue
+			}
+			values = append(values, code128CodeB)
+			setC = false
+		}
+		// only worth switching for a long run of digits, or a shorter one that finishes the code
+		if run >= 6 || (run >= 4 && i+run == len(code)) {
+			if run%2 == 1 {
+				values = append(values, int(code[i])-32)
+				i++
+			}
+			values = append(values, code128CodeC)
+			setC = true
+			continue
+		}
+		values = append(values, int(code[i])-32)
+		i++
+	}
+
+	checksum := values[0]
+	for i := 1; i < len(values); i++ {
+		checksum += values[i] * i
+	}
+	values = append(values, checksum%103, code128Stop)
+
+	modules := make([]bool, 0, len(values)*11+2)
+	for _, v := range values {
+		for i, width := range code128Widths[v] {
+			for n := 0; n < int(width-'0'); n++ {
+				// bars and spaces alternate, starting with a bar
+				modules = append(modules, i%2 == 0)
+			}
+		}
+	}
+	return Barcode{Symbology: Code128, Modules: modules, Text: code}, nil
+}
+
+func digitsOnly(code string) bool {
+	return digitRun(code, 0) == len(code)
+}
+
+// digitRun counts the digits in a row starting at i
+func digitRun(code string, i int) int {
+	n := 0
+	for i+n < len(code) && code[i+n] >= '0' && code[i+n] <= '9' {
+		n++
+	}
+	return n
+}
@@ -0,0 +1,137 @@
+package barcode
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCheckDigit(t *testing.T) {
+	tests := []struct {
+		digits string
+		want   byte
+	}{
+		{"003600029145", '2'},
+		{"400638133393", '1'},
+		{"590123412345", '7'},
+		{"000000000000", '0'},
+	}
+	for _, test := range tests {
+		if got := checkDigit(test.digits); got != test.want {
+			t.Errorf("checkDigit(%s) = %c, want %c", test.digits, got, test.want)
+		}
+	}
+}
+
+func TestEncodeEAN(t *testing.T) {
+	tests := []struct {
+		symbology, code string
+		text            string
+		err             string
+	}{
+		{UPCA, "036000291452", "036000291452", ""},
+		// 11 digits get their check digit added
+		{UPCA, "03600029145", "036000291452", ""},
+		// and a UPC-A given as an EAN-13 loses its leading 0
+		{UPCA, "0036000291452", "036000291452", ""},
+		{EAN13, "4006381333931", "4006381333931", ""},
+		{EAN13, "036000291452", "0036000291452", ""},
+		// a mistyped check digit is refused rather than printed as some other product's code
+		{UPCA, "036000291453", "", "check digit should be 2"},
+		{EAN13, "4006381333932", "", "check digit should be 1"},
+		{UPCA, "0360002914", "", "a UPC-A needs 11 or 12 digits"},
+		{EAN13, "40063813339X1", "", "an EAN-13 needs 13 digits"},
+	}
+	for _, test := range tests {
+		b, err := Encode(test.symbology, test.code)
+		if test.err != "" {
+			if !errors.Is(err, ErrInvalidCode) || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("Encode(%s, %s) = %v, want %q", test.symbology, test.code, err, test.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Encode(%s, %s): %v", test.symbology, test.code, err)
+			continue
+		}
+		if b.Symbology != test.symbology || b.Text != test.text || len(b.Modules) != 95 {
+			t.Errorf("Encode(%s, %s) = %s %s with %d modules", test.symbology, test.code, b.Symbology, b.Text, len(b.Modules))
+		}
+	}
+
+	// a UPC-A is the same bars as the EAN-13 with a leading 0
+	upc, _ := Encode(UPCA, "036000291452")
+	ean, _ := Encode(EAN13, "0036000291452")
+	if !reflect.DeepEqual(upc.Modules, ean.Modules) {
+		t.Error("UPC-A and EAN-13 bars differ")
+	}
+}
+
+// code128Values reads the symbol values back out of the modules
+func code128Values(t *testing.T, modules []bool) []int {
+	widths := make([]byte, 0, len(modules))
+	for i := 0; i < len(modules); {
+		n := 1
+		for i+n < len(modules) && modules[i+n] == modules[i] {
+			n++
+		}
+		widths = append(widths, byte('0'+n))
+		i += n
+	}
+	values := make([]int, 0)
+	for len(widths) > 0 {
+		size := 6
+		if len(widths) == 7 {
+			size = 7
+		}
+		found := false
+		for v, w := range code128Widths {
+			if w == string(widths[:size]) {
+				values = append(values, v)
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Fatalf("no symbol with widths %s", widths[:size])
+		}
+		widths = widths[size:]
+	}
+	return values
+}
+
+func TestEncodeCode128(t *testing.T) {
+	tests := []struct {
+		code    string
+		modules int
+		values  []int
+	}{
+		// a long run of digits switches to code set C, with an odd digit first left in code set B
+		{"ABC-1234567", 134, []int{code128StartB, 33, 34, 35, 13, 17, code128CodeC, 23, 45, 67, 2, code128Stop}},
+		// starting with digits starts in code set C, and the odd digit left at the end goes back to code set B
+		{"12345", 79, []int{code128StartC, 12, 34, code128CodeB, 21, 54, code128Stop}},
+		// a short run of digits in the middle isn't worth switching for
+		{"A12B", 79, []int{code128StartB, 33, 17, 18, 34, 52, code128Stop}},
+		{"123456", 68, []int{code128StartC, 12, 34, 56, 44, code128Stop}},
+	}
+	for _, test := range tests {
+		b, err := Encode(Code128, test.code)
+		if err != nil {
+			t.Errorf("Encode(%s): %v", test.code, err)
+			continue
+		}
+		if len(b.Modules) != test.modules {
+			t.Errorf("Encode(%s) has %d modules, want %d", test.code, len(b.Modules), test.modules)
+		}
+		if got := code128Values(t, b.Modules); !reflect.DeepEqual(got, test.values) {
+			t.Errorf("Encode(%s) = symbols %v, want %v", test.code, got, test.values)
+		}
+	}
+
+	for _, code := range []string{"", "tab\there", "café"} {
+		if _, err := Encode(Code128, code); !errors.Is(err, ErrInvalidCode) {
+			t.Errorf("Encode(%q) = %v, want ErrInvalidCode", code, err)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package barcode
+
+import (
+	"io"
+
+	"github.com/jordbick/Golang/inventory-service/pdf"
+)
+
+// Label sheets are laid out for the common 30 per sheet US Letter address labels, 3 columns of 10 labels each 2 5/8" x 1"
+
+const (
+	labelColumns    = 3
+	labelRows       = 10
+	labelWidth      = 189.0
+	labelHeight     = 72.0
+	labelPitch      = 198.0
+	labelLeftMargin = 13.5
+	labelTopMargin  = 36.0
+	labelPadding    = 6.0
+	labelFontSize   = 7.0
+)
+
+// Label is one label on a sheet, a title such as the product name above the barcode
+type Label struct {
+	Title   string
+	Barcode Barcode
+}
+
+// WriteLabelSheet writes the labels out as a PDF, starting a new page every 30 labels
+func WriteLabelSheet(w io.Writer, labels []Label) error {
+	doc := pdf.New(pdf.LetterWidth, pdf.LetterHeight)
+	var page *pdf.Page
+	for i, label := range labels {
+		slot := i % (labelColumns * labelRows)
+		if slot == 0 {
+			page = doc.AddPage()
+		}
+		x := labelLeftMargin + float64(slot%labelColumns)*labelPitch
+		y := labelTopMargin + float64(slot/labelColumns)*labelHeight
+		drawLabel(page, x, y, label)
+	}
+	return doc.Write(w)
+}
+
+// drawLabel draws the title, bars and human readable code inside the label whose top left corner is at x, y
+func drawLabel(page *pdf.Page, x, y float64, label Label) {
+	page.Text(x+labelPadding, y+labelPadding+labelFontSize, pdf.HelveticaBold, labelFontSize, truncate(label.Title, 45))
+
+	// the bars are scaled to fill the label, leaving the quiet zones either side
+	modules := len(label.Barcode.Modules) + 2*QuietZone
+	moduleWidth := (labelWidth - 2*labelPadding) / float64(modules)
+	if moduleWidth > 1.5 {
+		moduleWidth = 1.5
+	}
+	barsLeft := x + (labelWidth-float64(modules)*moduleWidth)/2 + QuietZone*moduleWidth
+	barsTop := y + labelPadding + labelFontSize + 4
+	barsHeight := labelHeight - 2*labelPadding - 2*labelFontSize - 8
+	for i := 0; i < len(label.Barcode.Modules); {
+		if !label.Barcode.Modules[i] {
+			i++
+			continue
+		}
+		start := i
+		for i < len(label.Barcode.Modules) && label.Barcode.Modules[i] {
+			i++
+		}
+		page.Rect(barsLeft+float64(start)*moduleWidth, barsTop, float64(i-start)*moduleWidth, barsHeight)
+	}
+
+	page.Text(barsLeft, barsTop+barsHeight+labelFontSize+2, pdf.Helvetica, labelFontSize, label.Barcode.Text)
+}
+
+func truncate(s string, max int) string {
+	r := []rune(s)
+	if len(r) <= max {
+		return s
+	}
+	return string(r[:max-3]) + "..."
+}
@@ -0,0 +1,121 @@
+package barcode
+
+import (
+	"fmt"
+	"html"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+)
+
+// Options control how big a barcode is drawn
+type Options struct {
+	// ModuleWidth is the width in pixels of the narrowest bar
+	ModuleWidth int
+	// Height is the height in pixels of the bars
+	Height int
+	// ShowText prints the human readable code under the bars
+	ShowText bool
+}
+
+// DefaultOptions are used for anything left as zero
+var DefaultOptions = Options{ModuleWidth: 2, Height: 80, ShowText: true}
+
+func (o Options) withDefaults() Options {
+	if o.ModuleWidth <= 0 {
+		o.ModuleWidth = DefaultOptions.ModuleWidth
+	}
+	if o.Height <= 0 {
+		o.Height = DefaultOptions.Height
+	}
+	return o
+}
+
+// textScale is how many pixels each dot of the built in font is drawn with, so the text grows with the bars
+func (o Options) textScale() int {
+	return o.ModuleWidth
+}
+
+// size returns the width and height of the whole image including the quiet zones and any text
+func (b Barcode) size(o Options) (int, int) {
+	width := (len(b.Modules) + 2*QuietZone) * o.ModuleWidth
+	height := o.Height + 2*o.ModuleWidth
+	if o.ShowText {
+		height += (glyphHeight + 2) * o.textScale()
+	}
+	return width, height
+}
+
+// WritePNG draws the barcode as a black and white PNG
+func (b Barcode) WritePNG(w io.Writer, o Options) error {
+	o = o.withDefaults()
+	width, height := b.size(o)
+	img := image.NewGray(image.Rect(0, 0, width, height))
+	for i := range img.Pix {
+		img.Pix[i] = 0xff
+	}
+	fill := func(x, y, w, h int) {
+		for py := y; py < y+h; py++ {
+			for px := x; px < x+w; px++ {
+				img.SetGray(px, py, color.Gray{Y: 0})
+			}
+		}
+	}
+
+	top := o.ModuleWidth
+	for i, bar := range b.Modules {
+		if bar {
+			fill((QuietZone+i)*o.ModuleWidth, top, o.ModuleWidth, o.Height)
+		}
+	}
+
+	if o.ShowText {
+		scale := o.textScale()
+		textWidth := len(b.Text)*(glyphWidth+1)*scale - scale
+		x := (width - textWidth) / 2
+		y := top + o.Height + scale
+		for _, c := range b.Text {
+			rows := glyph(c)
+			for row := 0; row < glyphHeight; row++ {
+				for col := 0; col < glyphWidth; col++ {
+					if rows[row]&(1<<uint(glyphWidth-1-col)) != 0 {
+						fill(x+col*scale, y+row*scale, scale, scale)
+					}
+				}
+			}
+			x += (glyphWidth + 1) * scale
+		}
+	}
+	return png.Encode(w, img)
+}
+
+// WriteSVG draws the barcode as an SVG, with neighbouring bars merged into a single rect
+func (b Barcode) WriteSVG(w io.Writer, o Options) error {
+	o = o.withDefaults()
+	width, height := b.size(o)
+	_, err := fmt.Fprintf(w, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d">`+"\n", width, height, width, height)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, `<rect width="%d" height="%d" fill="#fff"/>`+"\n", width, height)
+	top := o.ModuleWidth
+	for i := 0; i < len(b.Modules); {
+		if !b.Modules[i] {
+			i++
+			continue
+		}
+		start := i
+		for i < len(b.Modules) && b.Modules[i] {
+			i++
+		}
+		fmt.Fprintf(w, `<rect x="%d" y="%d" width="%d" height="%d"/>`+"\n", (QuietZone+start)*o.ModuleWidth, top, (i-start)*o.ModuleWidth, o.Height)
+	}
+	if o.ShowText {
+		scale := o.textScale()
+		fmt.Fprintf(w, `<text x="%d" y="%d" font-family="monospace" font-size="%d" text-anchor="middle">%s</text>`+"\n",
+			width/2, top+o.Height+(glyphHeight+1)*scale, (glyphHeight+2)*scale, html.EscapeString(b.Text))
+	}
+	_, err = fmt.Fprint(w, "</svg>\n")
+	return err
+}
@@ -0,0 +1,77 @@
+package blob
+
+import (
+	"context"
+	"errors"
+	"io"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Blob storage keeps files, such as receipts, outside the database
+// There's a store on the local file system, one for S3 compatible object storage (AWS S3, MinIO and the like)
+// so several copies of the service can share files, and one in memory for trying things out without either
+
+var (
+	ErrNotFound   = errors.New("blob not found")
+	ErrExists     = errors.New("blob already exists")
+	ErrInvalidKey = errors.New("invalid blob key")
+)
+
+// Info describes a stored blob. Stores that don't keep the content type or an ETag leave them empty
+type Info struct {
+	Key         string
+	Size        int64
+	ContentType string
+	ETag        string
+	ModTime     time.Time
+}
+
+// Store is somewhere blobs can be kept
+// Put never replaces a blob, it returns ErrExists if the key is taken, and leaves nothing behind if reading from r fails
+// Get streams the blob, the caller has to close it
+type Store interface {
+	Put(ctx context.Context, key string, r io.Reader, contentType string) (Info, error)
+	Get(ctx context.Context, key string) (io.ReadCloser, Info, error)
+	Stat(ctx context.Context, key string) (Info, error)
+	Delete(ctx context.Context, key string) error
+}
+
+// Lister is a store that can list its blobs, in key order
+type Lister interface {
+	List(ctx context.Context, prefix string) ([]Info, error)
+}
+
+// Ranger is a store that can stream a blob from part way through, to the end, so downloads can resume without fetching the whole blob
+// Stores whose Get returns an io.ReadSeeker, like the local and memory stores, don't need it
+type Ranger interface {
+	GetRange(ctx context.Context, key string, offset int64) (io.ReadCloser, error)
+}
+
+// ResponseHeaders override the headers a presigned download is served with
+type ResponseHeaders struct {
+	ContentType        string
+	ContentDisposition string
+}
+
+// Presigner is a store that can hand out links to download a blob directly from it, without going through the service
+type Presigner interface {
+	PresignGet(key string, expires time.Duration, headers ResponseHeaders) (string, error)
+}
+
+// keyPattern allows keys that are safe as both file paths and object names, e.g. "receipts/3f2a.pdf"
+var keyPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._/-]{0,511}$`)
+
+// ValidKey checks a key can't point outside the store, it can't have empty, "." or ".." parts
+func ValidKey(key string) bool {
+	if !keyPattern.MatchString(key) || strings.HasSuffix(key, "/") {
+		return false
+	}
+	for _, part := range strings.Split(key, "/") {
+		if part == "" || part == "." || part == ".." {
+			return false
+		}
+	}
+	return true
+}
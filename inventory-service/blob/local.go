@@ -0,0 +1,150 @@
+package blob
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Local keeps blobs as files under a directory, a key's slashes become sub directories
+type Local struct {
+	Dir string
+}
+
+// NewLocal returns a store for the directory
+func NewLocal(dir string) *Local {
+	return &Local{Dir: dir}
+}
+
+func (l *Local) path(key string) (string, error) {
+	if !ValidKey(key) {
+		return "", ErrInvalidKey
+	}
+	return filepath.Join(l.Dir, filepath.FromSlash(key)), nil
+}
+
+func (l *Local) info(key string, stat os.FileInfo) Info {
+	return Info{Key: key, Size: stat.Size(), ModTime: stat.ModTime().UTC()}
+}
+
+// Put writes the blob, creating the file exclusively so an existing one is never replaced
+func (l *Local) Put(ctx context.Context, key string, r io.Reader, contentType string) (Info, error) {
+	path, err := l.path(key)
+	if err != nil {
+		return Info{}, err
+	}
+	if err = os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return Info{}, err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if os.IsExist(err) {
+		return Info{}, ErrExists
+	}
+	if err != nil {
+		return Info{}, err
+	}
+	_, err = io.Copy(f, r)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+		return Info{}, err
+	}
+	return l.Stat(ctx, key)
+}
+
+// Get opens the blob's file
+func (l *Local) Get(ctx context.Context, key string) (io.ReadCloser, Info, error) {
+	path, err := l.path(key)
+	if err != nil {
+		return nil, Info{}, err
+	}
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, Info{}, ErrNotFound
+	}
+	if err != nil {
+		return nil, Info{}, err
+	}
+	stat, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, Info{}, err
+	}
+	if !stat.Mode().IsRegular() {
+		f.Close()
+		return nil, Info{}, ErrNotFound
+	}
+	return f, l.info(key, stat), nil
+}
+
+// Stat returns the blob's size and modification time
+func (l *Local) Stat(ctx context.Context, key string) (Info, error) {
+	path, err := l.path(key)
+	if err != nil {
+		return Info{}, err
+	}
+	stat, err := os.Stat(path)
+	if os.IsNotExist(err) || (err == nil && !stat.Mode().IsRegular()) {
+		return Info{}, ErrNotFound
+	}
+	if err != nil {
+		return Info{}, err
+	}
+	return l.info(key, stat), nil
+}
+
+// Delete removes the blob's file
+func (l *Local) Delete(ctx context.Context, key string) error {
+	path, err := l.path(key)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(path)
+	if os.IsNotExist(err) {
+		return ErrNotFound
+	}
+	return err
+}
+
+// List walks the directory for blobs whose keys start with the prefix
+func (l *Local) List(ctx context.Context, prefix string) ([]Info, error) {
+	list := make([]Info, 0)
+	err := filepath.Walk(l.Dir, func(path string, stat os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && path == l.Dir {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if !stat.Mode().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(l.Dir, path)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if ValidKey(key) && strings.HasPrefix(key, prefix) {
+			list = append(list, l.info(key, stat))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Key < list[j].Key })
+	return list, nil
+}
+
+var (
+	_ Store  = (*Local)(nil)
+	_ Lister = (*Local)(nil)
+)
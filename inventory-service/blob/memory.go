@@ -0,0 +1,115 @@
+package blob
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Memory keeps blobs in memory, it's for trying things out and tests, everything is lost on restart
+type Memory struct {
+	mu    sync.RWMutex
+	blobs map[string]memoryBlob
+}
+
+type memoryBlob struct {
+	data []byte
+	info Info
+}
+
+// NewMemory returns an empty in memory store
+func NewMemory() *Memory {
+	return &Memory{blobs: make(map[string]memoryBlob)}
+}
+
+// Put reads the whole blob before storing it, so a failed read stores nothing
+// the ETag is the MD5 of the content, as S3 does for single part uploads
+func (m *Memory) Put(ctx context.Context, key string, r io.Reader, contentType string) (Info, error) {
+	if !ValidKey(key) {
+		return Info{}, ErrInvalidKey
+	}
+	m.mu.RLock()
+	_, exists := m.blobs[key]
+	m.mu.RUnlock()
+	if exists {
+		return Info{}, ErrExists
+	}
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return Info{}, err
+	}
+	sum := md5.Sum(data)
+	info := Info{Key: key, Size: int64(len(data)), ContentType: contentType, ETag: `"` + hex.EncodeToString(sum[:]) + `"`, ModTime: time.Now().UTC()}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	// another Put could have got there while this one was reading
+	if _, exists = m.blobs[key]; exists {
+		return Info{}, ErrExists
+	}
+	m.blobs[key] = memoryBlob{data: data, info: info}
+	return info, nil
+}
+
+func (m *Memory) Get(ctx context.Context, key string) (io.ReadCloser, Info, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	b, ok := m.blobs[key]
+	if !ok {
+		return nil, Info{}, ErrNotFound
+	}
+	return memoryFile{bytes.NewReader(b.data)}, b.info, nil
+}
+
+// memoryFile can seek, like a file from the local store, closing it does nothing
+type memoryFile struct {
+	*bytes.Reader
+}
+
+func (memoryFile) Close() error {
+	return nil
+}
+
+func (m *Memory) Stat(ctx context.Context, key string) (Info, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	b, ok := m.blobs[key]
+	if !ok {
+		return Info{}, ErrNotFound
+	}
+	return b.info, nil
+}
+
+func (m *Memory) Delete(ctx context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.blobs[key]; !ok {
+		return ErrNotFound
+	}
+	delete(m.blobs, key)
+	return nil
+}
+
+func (m *Memory) List(ctx context.Context, prefix string) ([]Info, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	list := make([]Info, 0)
+	for key, b := range m.blobs {
+		if strings.HasPrefix(key, prefix) {
+			list = append(list, b.info)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Key < list[j].Key })
+	return list, nil
+}
+
+var (
+	_ Store  = (*Memory)(nil)
+	_ Lister = (*Memory)(nil)
+)
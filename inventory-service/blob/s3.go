@@ -0,0 +1,355 @@
+package blob
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// S3Config is how to reach an S3 compatible store
+// Endpoint is the base URL, e.g. https://s3.eu-west-2.amazonaws.com or http://localhost:9000 for MinIO
+// PathStyle puts the bucket in the path (http://localhost:9000/bucket/key) rather than the host name, which most stand-ins need
+type S3Config struct {
+	Endpoint        string
+	Region          string
+	Bucket          string
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+	PathStyle       bool
+}
+
+// S3ConfigFromEnv reads S3_ENDPOINT, S3_REGION (us-east-1 by default), S3_BUCKET, S3_ACCESS_KEY_ID, S3_SECRET_ACCESS_KEY,
+// S3_SESSION_TOKEN and S3_PATH_STYLE (true by default)
+func S3ConfigFromEnv() (S3Config, error) {
+	c := S3Config{
+		Endpoint:        os.Getenv("S3_ENDPOINT"),
+		Region:          os.Getenv("S3_REGION"),
+		Bucket:          os.Getenv("S3_BUCKET"),
+		AccessKeyID:     os.Getenv("S3_ACCESS_KEY_ID"),
+		SecretAccessKey: os.Getenv("S3_SECRET_ACCESS_KEY"),
+		SessionToken:    os.Getenv("S3_SESSION_TOKEN"),
+		PathStyle:       true,
+	}
+	if c.Region == "" {
+		c.Region = "us-east-1"
+	}
+	if v := os.Getenv("S3_PATH_STYLE"); v != "" {
+		pathStyle, err := strconv.ParseBool(v)
+		if err != nil {
+			return c, fmt.Errorf("S3_PATH_STYLE must be true or false")
+		}
+		c.PathStyle = pathStyle
+	}
+	return c, nil
+}
+
+// S3 keeps blobs as objects in a bucket of an S3 compatible store, signing requests itself
+// Put needs the size up front, so uploads are spooled to a temporary file first
+type S3 struct {
+	config   S3Config
+	endpoint *url.URL
+	signer   signer
+	Client   *http.Client
+	// TempDir is where uploads are spooled, the system default if empty
+	TempDir string
+	now     func() time.Time
+}
+
+// NewS3 checks the config and returns the store
+func NewS3(c S3Config) (*S3, error) {
+	endpoint, err := url.Parse(strings.TrimSuffix(c.Endpoint, "/"))
+	if err != nil || (endpoint.Scheme != "http" && endpoint.Scheme != "https") || endpoint.Host == "" {
+		return nil, fmt.Errorf("S3 endpoint must be an http or https URL")
+	}
+	if c.Bucket == "" || c.AccessKeyID == "" || c.SecretAccessKey == "" {
+		return nil, fmt.Errorf("S3 needs a bucket, access key ID and secret access key")
+	}
+	if c.Region == "" {
+		c.Region = "us-east-1"
+	}
+	return &S3{
+		config:   c,
+		endpoint: endpoint,
+		signer:   signer{accessKeyID: c.AccessKeyID, secretAccessKey: c.SecretAccessKey, sessionToken: c.SessionToken, region: c.Region, service: "s3"},
+		Client:   &http.Client{Timeout: 5 * time.Minute},
+		now:      time.Now,
+	}, nil
+}
+
+// objectURL returns the URL of a key, or of the bucket itself when the key is empty
+func (s *S3) objectURL(key string) *url.URL {
+	u := *s.endpoint
+	path := strings.TrimSuffix(u.Path, "/")
+	if s.config.PathStyle {
+		path += "/" + s.config.Bucket
+	} else {
+		u.Host = s.config.Bucket + "." + u.Host
+	}
+	path += "/" + key
+	u.Path = path
+	u.RawPath = awsEscape(path, false)
+	return &u
+}
+
+// s3Error is the error document S3 sends back
+type s3Error struct {
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+func responseError(resp *http.Response, method, key string) error {
+	var e s3Error
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if xml.Unmarshal(body, &e) != nil || e.Code == "" {
+		e.Code = resp.Status
+	}
+	return fmt.Errorf("s3 %s %q: %s %s", method, key, e.Code, e.Message)
+}
+
+// do signs and sends a request, body can be nil
+func (s *S3) do(ctx context.Context, method string, u *url.URL, header http.Header, body io.Reader, size int64, payloadHash string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	// NewRequest parses the URL again, keep the path escaped the way it was signed
+	req.URL = u
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	if body != nil {
+		req.ContentLength = size
+	}
+	s.signer.sign(req, payloadHash, s.now())
+	return s.Client.Do(req)
+}
+
+// infoFromHeaders reads an object's details from a GET or HEAD response
+func infoFromHeaders(key string, resp *http.Response) Info {
+	info := Info{Key: key, Size: resp.ContentLength, ContentType: resp.Header.Get("Content-Type"), ETag: resp.Header.Get("ETag")}
+	if t, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+		info.ModTime = t.UTC()
+	}
+	return info
+}
+
+// Put spools the blob to a temporary file to work out its size and hash, then uploads it
+// If-None-Match: * makes the store refuse to replace an existing object
+func (s *S3) Put(ctx context.Context, key string, r io.Reader, contentType string) (Info, error) {
+	if !ValidKey(key) {
+		return Info{}, ErrInvalidKey
+	}
+	spool, err := ioutil.TempFile(s.TempDir, "blob-")
+	if err != nil {
+		return Info{}, err
+	}
+	defer os.Remove(spool.Name())
+	defer spool.Close()
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(spool, hash), r)
+	if err != nil {
+		return Info{}, err
+	}
+	if _, err = spool.Seek(0, io.SeekStart); err != nil {
+		return Info{}, err
+	}
+
+	header := http.Header{}
+	header.Set("If-None-Match", "*")
+	if contentType != "" {
+		header.Set("Content-Type", contentType)
+	}
+	resp, err := s.do(ctx, http.MethodPut, s.objectURL(key), header, ioutil.NopCloser(spool), size, hex.EncodeToString(hash.Sum(nil)))
+	if err != nil {
+		return Info{}, err
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusPreconditionFailed:
+		return Info{}, ErrExists
+	case resp.StatusCode/100 != 2:
+		return Info{}, responseError(resp, http.MethodPut, key)
+	}
+	return Info{Key: key, Size: size, ContentType: contentType, ETag: resp.Header.Get("ETag"), ModTime: s.now().UTC()}, nil
+}
+
+// Get streams the object
+func (s *S3) Get(ctx context.Context, key string) (io.ReadCloser, Info, error) {
+	if !ValidKey(key) {
+		return nil, Info{}, ErrInvalidKey
+	}
+	resp, err := s.do(ctx, http.MethodGet, s.objectURL(key), nil, nil, 0, emptyPayloadHash)
+	if err != nil {
+		return nil, Info{}, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return nil, Info{}, ErrNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		return nil, Info{}, responseError(resp, http.MethodGet, key)
+	}
+	return resp.Body, infoFromHeaders(key, resp), nil
+}
+
+// GetRange gets the object from offset on with a Range request
+func (s *S3) GetRange(ctx context.Context, key string, offset int64) (io.ReadCloser, error) {
+	if !ValidKey(key) {
+		return nil, ErrInvalidKey
+	}
+	header := http.Header{}
+	header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	resp, err := s.do(ctx, http.MethodGet, s.objectURL(key), header, nil, 0, emptyPayloadHash)
+	if err != nil {
+		return nil, err
+	}
+	switch resp.StatusCode {
+	case http.StatusPartialContent, http.StatusOK:
+		// a store that ignores Range sends the whole object
+		if resp.StatusCode == http.StatusOK && offset > 0 {
+			if _, err = io.CopyN(ioutil.Discard, resp.Body, offset); err != nil {
+				resp.Body.Close()
+				return nil, err
+			}
+		}
+		return resp.Body, nil
+	case http.StatusRequestedRangeNotSatisfiable:
+		resp.Body.Close()
+		return ioutil.NopCloser(strings.NewReader("")), nil
+	case http.StatusNotFound:
+		resp.Body.Close()
+		return nil, ErrNotFound
+	default:
+		defer resp.Body.Close()
+		return nil, responseError(resp, http.MethodGet, key)
+	}
+}
+
+// Stat sends a HEAD request for the object
+func (s *S3) Stat(ctx context.Context, key string) (Info, error) {
+	if !ValidKey(key) {
+		return Info{}, ErrInvalidKey
+	}
+	resp, err := s.do(ctx, http.MethodHead, s.objectURL(key), nil, nil, 0, emptyPayloadHash)
+	if err != nil {
+		return Info{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return Info{}, ErrNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return Info{}, fmt.Errorf("s3 HEAD %q: %s", key, resp.Status)
+	}
+	return infoFromHeaders(key, resp), nil
+}
+
+// Delete removes the object. S3 doesn't say whether there was anything to delete, so it's checked first
+func (s *S3) Delete(ctx context.Context, key string) error {
+	if _, err := s.Stat(ctx, key); err != nil {
+		return err
+	}
+	resp, err := s.do(ctx, http.MethodDelete, s.objectURL(key), nil, nil, 0, emptyPayloadHash)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return responseError(resp, http.MethodDelete, key)
+	}
+	return nil
+}
+
+// listBucketResult is a page of ListObjectsV2
+type listBucketResult struct {
+	Contents []struct {
+		Key          string    `xml:"Key"`
+		Size         int64     `xml:"Size"`
+		ETag         string    `xml:"ETag"`
+		LastModified time.Time `xml:"LastModified"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+// List pages through ListObjectsV2
+func (s *S3) List(ctx context.Context, prefix string) ([]Info, error) {
+	list := make([]Info, 0)
+	token := ""
+	for {
+		u := s.objectURL("")
+		query := url.Values{"list-type": {"2"}}
+		if prefix != "" {
+			query.Set("prefix", prefix)
+		}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		u.RawQuery = canonicalQuery(query)
+		resp, err := s.do(ctx, http.MethodGet, u, nil, nil, 0, emptyPayloadHash)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			err = responseError(resp, "LIST", prefix)
+			resp.Body.Close()
+			return nil, err
+		}
+		var page listBucketResult
+		err = xml.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		for _, object := range page.Contents {
+			if ValidKey(object.Key) {
+				list = append(list, Info{Key: object.Key, Size: object.Size, ETag: object.ETag, ModTime: object.LastModified.UTC()})
+			}
+		}
+		if !page.IsTruncated || page.NextContinuationToken == "" {
+			return list, nil
+		}
+		token = page.NextContinuationToken
+	}
+}
+
+// MaxPresignExpiry is the longest S3 accepts a presigned URL for
+const MaxPresignExpiry = 7 * 24 * time.Hour
+
+// PresignGet returns a link that downloads the object straight from the store until it expires
+func (s *S3) PresignGet(key string, expires time.Duration, headers ResponseHeaders) (string, error) {
+	if !ValidKey(key) {
+		return "", ErrInvalidKey
+	}
+	if expires < time.Second || expires > MaxPresignExpiry {
+		return "", fmt.Errorf("presigned links last from a second to 7 days")
+	}
+	u := s.objectURL(key)
+	query := url.Values{}
+	if headers.ContentType != "" {
+		query.Set("response-content-type", headers.ContentType)
+	}
+	if headers.ContentDisposition != "" {
+		query.Set("response-content-disposition", headers.ContentDisposition)
+	}
+	u.RawQuery = canonicalQuery(query)
+	return s.signer.presign(http.MethodGet, u, expires, s.now()), nil
+}
+
+var (
+	_ Store     = (*S3)(nil)
+	_ Lister    = (*S3)(nil)
+	_ Presigner = (*S3)(nil)
+)
@@ -0,0 +1,281 @@
+package category
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jordbick/Golang/inventory-service/database"
+)
+
+func getCategoryList() ([]Category, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	results, err := database.DbConn.QueryContext(ctx, `SELECT categoryId,
+	parentId,
+	name
+	FROM categories
+	ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	defer results.Close()
+	categories := make([]Category, 0)
+	for results.Next() {
+		var category Category
+		var parentID sql.NullInt64
+		err := results.Scan(&category.CategoryID, &parentID, &category.Name)
+		if err != nil {
+			return nil, err
+		}
+		if parentID.Valid {
+			id := int(parentID.Int64)
+			category.ParentID = &id
+		}
+		categories = append(categories, category)
+	}
+	return categories, nil
+}
+
+func getCategory(categoryID int) (*Category, error) {
+	categories, err := getCategoryList()
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range categories {
+		if c.CategoryID == categoryID {
+			return &c, nil
+		}
+	}
+	return nil, nil
+}
+
+func insertCategory(category Category) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	result, err := database.DbConn.ExecContext(ctx, `INSERT INTO categories (parentId, name) VALUES (?, ?)`,
+		category.ParentID,
+		category.Name)
+	if err != nil {
+		return 0, err
+	}
+	insertID, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	return int(insertID), nil
+}
+
+// updateCategory renames and/or moves a category, refusing moves that would create a loop in the tree
+func updateCategory(category Category) error {
+	categories, err := getCategoryList()
+	if err != nil {
+		return err
+	}
+	t := newTree(categories)
+	if category.ParentID != nil {
+		if _, ok := t.categories[*category.ParentID]; !ok {
+			return ErrCategoryNotFound
+		}
+		if t.wouldCycle(category.CategoryID, *category.ParentID) {
+			return ErrCategoryCycle
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	_, err = database.DbConn.ExecContext(ctx, `UPDATE categories SET
+	parentId=?,
+	name=?
+	WHERE categoryId=?`,
+		category.ParentID,
+		category.Name,
+		category.CategoryID)
+	return err
+}
+
+// removeCategory deletes a category with no children, its products are simply unassigned from it
+func removeCategory(categoryID int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	var children int
+	err := database.DbConn.QueryRowContext(ctx, `SELECT COUNT(*) FROM categories WHERE parentId = ?`, categoryID).Scan(&children)
+	if err != nil {
+		return err
+	}
+	if children > 0 {
+		return ErrCategoryNotEmpty
+	}
+	_, err = database.DbConn.ExecContext(ctx, `DELETE FROM product_categories WHERE categoryId = ?`, categoryID)
+	if err != nil {
+		return err
+	}
+	_, err = database.DbConn.ExecContext(ctx, `DELETE FROM categories WHERE categoryId = ?`, categoryID)
+	return err
+}
+
+// DescendantIDs returns the category ID along with the IDs of all the categories below it
+func DescendantIDs(categoryID int) ([]int, error) {
+	categories, err := getCategoryList()
+	if err != nil {
+		return nil, err
+	}
+	t := newTree(categories)
+	if _, ok := t.categories[categoryID]; !ok {
+		return nil, ErrCategoryNotFound
+	}
+	return t.descendants(categoryID), nil
+}
+
+// AncestorIDs returns the given category IDs along with the IDs of all their parents, without duplicates
+func AncestorIDs(categoryIDs []int) ([]int, error) {
+	categories, err := getCategoryList()
+	if err != nil {
+		return nil, err
+	}
+	t := newTree(categories)
+	seen := make(map[int]bool)
+	ids := make([]int, 0)
+	for _, id := range categoryIDs {
+		for {
+			c, ok := t.categories[id]
+			if !ok || seen[id] {
+				break
+			}
+			seen[id] = true
+			ids = append(ids, id)
+			if c.ParentID == nil {
+				break
+			}
+			id = *c.ParentID
+		}
+	}
+	return ids, nil
+}
+
+// ValidateIDs returns ErrCategoryNotFound if any of the IDs isn't an existing category
+func ValidateIDs(categoryIDs []int) error {
+	categories, err := getCategoryList()
+	if err != nil {
+		return err
+	}
+	t := newTree(categories)
+	for _, id := range categoryIDs {
+		if _, ok := t.categories[id]; !ok {
+			return ErrCategoryNotFound
+		}
+	}
+	return nil
+}
+
+// InClause returns "IN (?, ?, ...)" and the matching arguments for a list of IDs, for building up WHERE clauses
+func InClause(ids []int) (string, []interface{}) {
+	args := make([]interface{}, 0, len(ids))
+	for _, id := range ids {
+		args = append(args, id)
+	}
+	return "IN (" + strings.TrimSuffix(strings.Repeat("?,", len(ids)), ",") + ")", args
+}
+
+// GetProductCategoryIDs returns the category IDs of each of the given products
+func GetProductCategoryIDs(productIDs []int) (map[int][]int, error) {
+	categoryIDs := make(map[int][]int)
+	if len(productIDs) == 0 {
+		return categoryIDs, nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	in, args := InClause(productIDs)
+	results, err := database.DbConn.QueryContext(ctx, `SELECT productId, categoryId FROM product_categories WHERE productId `+in+` ORDER BY categoryId`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer results.Close()
+	for results.Next() {
+		var productID, categoryID int
+		if err := results.Scan(&productID, &categoryID); err != nil {
+			return nil, err
+		}
+		categoryIDs[productID] = append(categoryIDs[productID], categoryID)
+	}
+	return categoryIDs, nil
+}
+
+// SetProductCategories replaces the categories a product is assigned to
+func SetProductCategories(productID int, categoryIDs []int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	tx, err := database.DbConn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	_, err = tx.ExecContext(ctx, `DELETE FROM product_categories WHERE productId = ?`, productID)
+	if err != nil {
+		return err
+	}
+	for _, categoryID := range categoryIDs {
+		_, err = tx.ExecContext(ctx, `INSERT IGNORE INTO product_categories (productId, categoryId) VALUES (?, ?)`, productID, categoryID)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// GetRollups works out the product count, quantity and value of every category including its descendants
+func GetRollups() ([]Rollup, error) {
+	categories, err := getCategoryList()
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	results, err := database.DbConn.QueryContext(ctx, `SELECT pc.categoryId,
+	p.productId,
+	p.quantityOnHand,
+	p.pricePerUnit
+	FROM product_categories pc
+	JOIN products p ON p.productId = pc.productId`)
+	if err != nil {
+		return nil, err
+	}
+	defer results.Close()
+	type stock struct {
+		quantity int
+		value    float64
+	}
+	products := make(map[int]stock)
+	inCategory := make(map[int][]int)
+	for results.Next() {
+		var categoryID, productID, quantity int
+		var price string
+		if err := results.Scan(&categoryID, &productID, &quantity, &price); err != nil {
+			return nil, err
+		}
+		pricePerUnit, _ := strconv.ParseFloat(price, 64)
+		products[productID] = stock{quantity: quantity, value: float64(quantity) * pricePerUnit}
+		inCategory[categoryID] = append(inCategory[categoryID], productID)
+	}
+
+	t := newTree(categories)
+	rollups := make([]Rollup, 0, len(categories))
+	for _, c := range categories {
+		rollup := Rollup{CategoryID: c.CategoryID, Name: c.Name}
+		seen := make(map[int]bool)
+		for _, id := range t.descendants(c.CategoryID) {
+			for _, productID := range inCategory[id] {
+				if seen[productID] {
+					continue
+				}
+				seen[productID] = true
+				rollup.ProductCount++
+				rollup.TotalQuantity += products[productID].quantity
+				rollup.TotalValue += products[productID].value
+			}
+		}
+		rollups = append(rollups, rollup)
+	}
+	return rollups, nil
+}
@@ -0,0 +1,65 @@
+package category
+
+import "errors"
+
+// Categories form a tree, each category can have a parent and any number of children
+// A product can be in more than one category, and filtering by a category includes the products of all its descendants
+
+var (
+	ErrCategoryNotFound = errors.New("category not found")
+	ErrCategoryCycle    = errors.New("a category can't be moved under itself or one of its descendants")
+	ErrCategoryNotEmpty = errors.New("category still has child categories")
+)
+
+// Category
+type Category struct {
+	CategoryID int    `json:"categoryId"`
+	ParentID   *int   `json:"parentId"`
+	Name       string `json:"name"`
+}
+
+// Rollup is the stock held in a category and all its descendants
+// A product in several of the subcategories is only counted once
+type Rollup struct {
+	CategoryID    int     `json:"categoryId"`
+	Name          string  `json:"name"`
+	ProductCount  int     `json:"productCount"`
+	TotalQuantity int     `json:"totalQuantity"`
+	TotalValue    float64 `json:"totalValue"`
+}
+
+// tree indexes categories by parent so the descendants of a category can be walked
+type tree struct {
+	categories map[int]Category
+	children   map[int][]int
+}
+
+func newTree(categories []Category) tree {
+	t := tree{categories: make(map[int]Category, len(categories)), children: make(map[int][]int)}
+	for _, c := range categories {
+		t.categories[c.CategoryID] = c
+		if c.ParentID != nil {
+			t.children[*c.ParentID] = append(t.children[*c.ParentID], c.CategoryID)
+		}
+	}
+	return t
+}
+
+// descendants returns the category ID followed by the IDs of everything below it
+func (t tree) descendants(categoryID int) []int {
+	ids := []int{categoryID}
+	for i := 0; i < len(ids); i++ {
+		ids = append(ids, t.children[ids[i]]...)
+	}
+	return ids
+}
+
+// wouldCycle returns whether making parentID the parent of categoryID would create a loop
+func (t tree) wouldCycle(categoryID, parentID int) bool {
+	for _, id := range t.descendants(categoryID) {
+		if id == parentID {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,174 @@
+package category
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/jordbick/Golang/inventory-service/cors"
+	"github.com/jordbick/Golang/inventory-service/response"
+)
+
+const categoriesBasePath = "categories"
+
+// SetupRoutes registers the category handlers
+func SetupRoutes(apiBasePath string) {
+	handleCategories := http.HandlerFunc(categoriesHandler)
+	handleCategory := http.HandlerFunc(categoryHandler)
+	http.Handle(fmt.Sprintf("%s/%s", apiBasePath, categoriesBasePath), cors.Middleware(handleCategories))
+	http.Handle(fmt.Sprintf("%s/%s/", apiBasePath, categoriesBasePath), cors.Middleware(handleCategory))
+}
+
+func errorStatus(err error) int {
+	switch err {
+	case ErrCategoryNotFound:
+		return http.StatusNotFound
+	case ErrCategoryCycle:
+		return http.StatusBadRequest
+	case ErrCategoryNotEmpty:
+		return http.StatusConflict
+	}
+	log.Println(err)
+	return http.StatusInternalServerError
+}
+
+func categoriesHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		categories, err := getCategoryList()
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		response.WriteJSON(w, categories)
+
+	case http.MethodPost:
+		var newCategory Category
+		bodyBytes, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		err = json.Unmarshal(bodyBytes, &newCategory)
+		if err != nil || newCategory.CategoryID != 0 || strings.TrimSpace(newCategory.Name) == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if newCategory.ParentID != nil {
+			if err = ValidateIDs([]int{*newCategory.ParentID}); err != nil {
+				w.WriteHeader(errorStatus(err))
+				return
+			}
+		}
+		categoryID, err := insertCategory(newCategory)
+		if err != nil {
+			w.WriteHeader(errorStatus(err))
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(fmt.Sprintf(`{"categoryId":%d}`, categoryID)))
+
+	case http.MethodOptions:
+		return
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// Handles /categories/rollups, /categories/{id} and /categories/{id}/rollup
+func categoryHandler(w http.ResponseWriter, r *http.Request) {
+	urlPathSegments := strings.Split(strings.Trim(strings.SplitN(r.URL.Path, fmt.Sprintf("/%s/", categoriesBasePath), 2)[1], "/"), "/")
+	if r.Method == http.MethodOptions {
+		return
+	}
+	if len(urlPathSegments) == 1 && urlPathSegments[0] == "rollups" {
+		rollupsHandler(w, r, 0)
+		return
+	}
+	categoryID, err := strconv.Atoi(urlPathSegments[0])
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	category, err := getCategory(categoryID)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if category == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if len(urlPathSegments) == 2 && urlPathSegments[1] == "rollup" {
+		rollupsHandler(w, r, categoryID)
+		return
+	}
+	if len(urlPathSegments) != 1 {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		response.WriteJSON(w, category)
+
+	case http.MethodPut:
+		var updatedCategory Category
+		bodyBytes, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		err = json.Unmarshal(bodyBytes, &updatedCategory)
+		if err != nil || updatedCategory.CategoryID != categoryID || strings.TrimSpace(updatedCategory.Name) == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		err = updateCategory(updatedCategory)
+		if err != nil {
+			w.WriteHeader(errorStatus(err))
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+
+	case http.MethodDelete:
+		err = removeCategory(categoryID)
+		if err != nil {
+			w.WriteHeader(errorStatus(err))
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// rollupsHandler returns the stock and value rollup of every category, or just one if categoryID is set
+func rollupsHandler(w http.ResponseWriter, r *http.Request, categoryID int) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	rollups, err := GetRollups()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if categoryID == 0 {
+		response.WriteJSON(w, rollups)
+		return
+	}
+	for _, rollup := range rollups {
+		if rollup.CategoryID == categoryID {
+			response.WriteJSON(w, rollup)
+			return
+		}
+	}
+	w.WriteHeader(http.StatusNotFound)
+}
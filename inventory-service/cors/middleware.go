@@ -9,8 +9,9 @@ func Middleware(handler http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Add("Access-Control-Allow-Origin", "*")
 		w.Header().Add("Content-Type", "application/json")
-		w.Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS, PUT, DELETE")
-		w.Header().Set("Access-Control-Allow-Headers", "Accept, Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization")
+		w.Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS, PUT, DELETE, PATCH, HEAD")
+		w.Header().Set("Access-Control-Allow-Headers", "Accept, Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, X-User, Upload-Offset, Upload-Checksum, Range, If-Range, If-None-Match, If-Modified-Since")
+		w.Header().Set("Access-Control-Expose-Headers", "X-Total-Count, Location, Upload-Offset, Upload-Length, ETag, Last-Modified, Content-Range, Accept-Ranges, Content-Disposition, Retry-After")
 		handler.ServeHTTP(w, r)
 	})
 }
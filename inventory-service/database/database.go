@@ -24,13 +24,19 @@ func SetupDatabase() {
 	var err error
 	// mysql is driver name
 	// connection string = username:password@host:port/nameofDB
-	DbConn, err = sql.Open("mysql", "root:root@tcp(127.0.0.1:3306)/inventorydb")
+	// parseTime=true so that DATETIME columns can be scanned straight into time.Time
+	DbConn, err = sql.Open("mysql", "root:root@tcp(127.0.0.1:3306)/inventorydb?parseTime=true")
 	if err != nil {
 		log.Fatal(err)
 	}
 	DbConn.SetMaxOpenConns(4)
 	DbConn.SetMaxIdleConns(4)
 	DbConn.SetConnMaxLifetime(60 * time.Second)
+	// bring the schema up to date before any of the handlers start using it
+	err = migrate()
+	if err != nil {
+		log.Fatal(err)
+	}
 }
 
 // Interacting with DB
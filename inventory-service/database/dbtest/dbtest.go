@@ -0,0 +1,149 @@
+// Package dbtest is a fake database/sql database for testing the data code without a MySQL server
+// Every statement is passed to a Handler along with its arguments, and answered with whatever the handler returns,
+// so a test can check the SQL a function sends and hand back the rows it would have read
+package dbtest
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"io"
+)
+
+// Result is the answer to a statement, Columns and Rows for a query, RowsAffected and LastInsertID for anything else
+type Result struct {
+	Columns      []string
+	Rows         [][]driver.Value
+	RowsAffected int64
+	LastInsertID int64
+}
+
+// Handler answers a statement. Transactions are passed through as the statements BEGIN, COMMIT and ROLLBACK
+type Handler func(query string, args []driver.Value) (Result, error)
+
+// Open returns a database that sends every statement to handler
+func Open(handler Handler) *sql.DB {
+	return sql.OpenDB(connector{handler})
+}
+
+type connector struct {
+	handler Handler
+}
+
+func (c connector) Connect(ctx context.Context) (driver.Conn, error) {
+	return &conn{c.handler}, nil
+}
+
+func (c connector) Driver() driver.Driver {
+	return fakeDriver{c.handler}
+}
+
+type fakeDriver struct {
+	handler Handler
+}
+
+func (d fakeDriver) Open(name string) (driver.Conn, error) {
+	return &conn{d.handler}, nil
+}
+
+type conn struct {
+	handler Handler
+}
+
+func (c *conn) Prepare(query string) (driver.Stmt, error) {
+	return &stmt{c.handler, query}, nil
+}
+
+func (c *conn) Close() error {
+	return nil
+}
+
+func (c *conn) Begin() (driver.Tx, error) {
+	if _, err := c.handler("BEGIN", nil); err != nil {
+		return nil, err
+	}
+	return tx{c.handler}, nil
+}
+
+type tx struct {
+	handler Handler
+}
+
+func (t tx) Commit() error {
+	_, err := t.handler("COMMIT", nil)
+	return err
+}
+
+func (t tx) Rollback() error {
+	_, err := t.handler("ROLLBACK", nil)
+	return err
+}
+
+type stmt struct {
+	handler Handler
+	query   string
+}
+
+func (s *stmt) Close() error {
+	return nil
+}
+
+// NumInput is -1 so database/sql doesn't check the number of arguments
+func (s *stmt) NumInput() int {
+	return -1
+}
+
+func (s *stmt) Exec(args []driver.Value) (driver.Result, error) {
+	result, err := s.handler(s.query, args)
+	if err != nil {
+		return nil, err
+	}
+	return execResult{result.LastInsertID, result.RowsAffected}, nil
+}
+
+func (s *stmt) Query(args []driver.Value) (driver.Rows, error) {
+	result, err := s.handler(s.query, args)
+	if err != nil {
+		return nil, err
+	}
+	return &rows{result: result}, nil
+}
+
+type execResult struct {
+	lastInsertID int64
+	rowsAffected int64
+}
+
+func (r execResult) LastInsertId() (int64, error) {
+	return r.lastInsertID, nil
+}
+
+func (r execResult) RowsAffected() (int64, error) {
+	return r.rowsAffected, nil
+}
+
+type rows struct {
+	result Result
+	next   int
+}
+
+func (r *rows) Columns() []string {
+	if r.result.Columns == nil && len(r.result.Rows) > 0 {
+		// the column names don't matter to Scan, only how many there are
+		return make([]string, len(r.result.Rows[0]))
+	}
+	return r.result.Columns
+}
+
+func (r *rows) Close() error {
+	return nil
+}
+
+func (r *rows) Next(dest []driver.Value) error {
+	if r.next >= len(r.result.Rows) {
+		return io.EOF
+	}
+	copy(dest, r.result.Rows[r.next])
+	r.next++
+	return nil
+}
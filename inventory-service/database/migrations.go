@@ -0,0 +1,494 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// Schema changes are kept here as an ordered list of migrations
+// Each migration has a version number, and once it has been applied the version is recorded in the schema_migrations table
+// so it won't be run again the next time the service starts up
+// New migrations should always be added to the end of the list with the next version number
+type migration struct {
+	version    int
+	name       string
+	statements []string
+	// check is run before the statements, to stop with a useful message when the data isn't ready for them
+	check func(ctx context.Context) error
+}
+
+var migrations = []migration{
+	{
+		version: 1,
+		name:    "create webhook subscriptions and outbox",
+		statements: []string{
+			`CREATE TABLE IF NOT EXISTS webhook_subscriptions (
+			subscriptionId INT NOT NULL AUTO_INCREMENT,
+			url VARCHAR(2048) NOT NULL,
+			secret VARCHAR(255) NOT NULL,
+			eventTypes VARCHAR(1024) NOT NULL,
+			active TINYINT(1) NOT NULL DEFAULT 1,
+			createdAt DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (subscriptionId))`,
+			`CREATE TABLE IF NOT EXISTS webhook_deliveries (
+			deliveryId INT NOT NULL AUTO_INCREMENT,
+			subscriptionId INT NOT NULL,
+			eventType VARCHAR(255) NOT NULL,
+			payload MEDIUMTEXT NOT NULL,
+			status VARCHAR(20) NOT NULL,
+			attempts INT NOT NULL DEFAULT 0,
+			nextAttemptAt DATETIME NOT NULL,
+			lastStatusCode INT NOT NULL DEFAULT 0,
+			lastError VARCHAR(1024) NOT NULL DEFAULT '',
+			createdAt DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			deliveredAt DATETIME NULL,
+			PRIMARY KEY (deliveryId),
+			INDEX idx_webhook_deliveries_due (status, nextAttemptAt),
+			INDEX idx_webhook_deliveries_subscription (subscriptionId))`,
+		},
+	},
+	{
+		version: 2,
+		name:    "create locations, stock levels and stock movement ledger",
+		statements: []string{
+			`CREATE TABLE IF NOT EXISTS locations (
+			locationId INT NOT NULL AUTO_INCREMENT,
+			parentId INT NULL,
+			locationType VARCHAR(20) NOT NULL,
+			code VARCHAR(64) NOT NULL,
+			name VARCHAR(255) NOT NULL,
+			PRIMARY KEY (locationId),
+			UNIQUE KEY uq_locations_parent_code (parentId, code),
+			INDEX idx_locations_parent (parentId))`,
+			`CREATE TABLE IF NOT EXISTS stock_levels (
+			productId INT NOT NULL,
+			locationId INT NOT NULL,
+			quantity INT NOT NULL DEFAULT 0,
+			PRIMARY KEY (productId, locationId),
+			INDEX idx_stock_levels_location (locationId))`,
+			`CREATE TABLE IF NOT EXISTS stock_movements (
+			movementId INT NOT NULL AUTO_INCREMENT,
+			productId INT NOT NULL,
+			locationId INT NULL,
+			quantity INT NOT NULL,
+			reason VARCHAR(32) NOT NULL,
+			reference VARCHAR(255) NOT NULL DEFAULT '',
+			createdAt DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (movementId),
+			INDEX idx_stock_movements_product (productId, createdAt),
+			INDEX idx_stock_movements_location (locationId))`,
+		},
+	},
+	{
+		version: 3,
+		name:    "create purchase orders",
+		statements: []string{
+			`CREATE TABLE IF NOT EXISTS purchase_orders (
+			purchaseOrderId INT NOT NULL AUTO_INCREMENT,
+			supplier VARCHAR(255) NOT NULL,
+			status VARCHAR(32) NOT NULL,
+			notes VARCHAR(1024) NOT NULL DEFAULT '',
+			createdAt DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			orderedAt DATETIME NULL,
+			PRIMARY KEY (purchaseOrderId),
+			INDEX idx_purchase_orders_status (status))`,
+			`CREATE TABLE IF NOT EXISTS purchase_order_lines (
+			lineId INT NOT NULL AUTO_INCREMENT,
+			purchaseOrderId INT NOT NULL,
+			productId INT NOT NULL,
+			quantityOrdered INT NOT NULL,
+			quantityReceived INT NOT NULL DEFAULT 0,
+			unitCost DECIMAL(13,2) NOT NULL,
+			PRIMARY KEY (lineId),
+			INDEX idx_purchase_order_lines_po (purchaseOrderId))`,
+			`CREATE TABLE IF NOT EXISTS purchase_order_receipts (
+			purchaseOrderId INT NOT NULL,
+			receiptName VARCHAR(255) NOT NULL,
+			attachedAt DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (purchaseOrderId, receiptName))`,
+		},
+	},
+	{
+		version: 4,
+		name:    "create suppliers and link products and purchase orders to them",
+		statements: []string{
+			`CREATE TABLE IF NOT EXISTS suppliers (
+			supplierId INT NOT NULL AUTO_INCREMENT,
+			name VARCHAR(255) NOT NULL,
+			normalizedName VARCHAR(255) NOT NULL,
+			contactName VARCHAR(255) NOT NULL DEFAULT '',
+			email VARCHAR(255) NOT NULL DEFAULT '',
+			phone VARCHAR(64) NOT NULL DEFAULT '',
+			address VARCHAR(1024) NOT NULL DEFAULT '',
+			leadTimeDays INT NOT NULL DEFAULT 0,
+			PRIMARY KEY (supplierId),
+			UNIQUE KEY uq_suppliers_normalized_name (normalizedName))`,
+			`ALTER TABLE products ADD COLUMN manufacturerId INT NULL, ADD INDEX idx_products_manufacturer (manufacturerId)`,
+			`ALTER TABLE purchase_orders ADD COLUMN supplierId INT NULL, ADD INDEX idx_purchase_orders_supplier (supplierId)`,
+		},
+	},
+	{
+		version: 5,
+		name:    "create product categories",
+		statements: []string{
+			`CREATE TABLE IF NOT EXISTS categories (
+			categoryId INT NOT NULL AUTO_INCREMENT,
+			parentId INT NULL,
+			name VARCHAR(255) NOT NULL,
+			PRIMARY KEY (categoryId),
+			INDEX idx_categories_parent (parentId))`,
+			`CREATE TABLE IF NOT EXISTS product_categories (
+			productId INT NOT NULL,
+			categoryId INT NOT NULL,
+			PRIMARY KEY (productId, categoryId),
+			INDEX idx_product_categories_category (categoryId))`,
+		},
+	},
+	{
+		version: 6,
+		name:    "add product variants and custom attributes",
+		statements: []string{
+			`ALTER TABLE products ADD COLUMN parentProductId INT NULL, ADD INDEX idx_products_parent (parentProductId)`,
+			`CREATE TABLE IF NOT EXISTS attribute_definitions (
+			attributeId INT NOT NULL AUTO_INCREMENT,
+			categoryId INT NOT NULL,
+			name VARCHAR(64) NOT NULL,
+			dataType VARCHAR(16) NOT NULL,
+			enumValues VARCHAR(1024) NOT NULL DEFAULT '',
+			PRIMARY KEY (attributeId),
+			UNIQUE KEY uq_attribute_definitions_category_name (categoryId, name))`,
+			`CREATE TABLE IF NOT EXISTS product_attributes (
+			productId INT NOT NULL,
+			attributeId INT NOT NULL,
+			value VARCHAR(255) NOT NULL,
+			numberValue DOUBLE NULL,
+			PRIMARY KEY (productId, attributeId),
+			INDEX idx_product_attributes_value (attributeId, value))`,
+		},
+	},
+	{
+		// NULLIF lets any number of products have a blank SKU or UPC, only real codes have to be unique
+		// If existing products already share a code this migration fails listing them, and they have to be fixed by hand
+		version: 7,
+		name:    "enforce unique product SKU and UPC",
+		statements: []string{
+			`CREATE UNIQUE INDEX uq_products_sku ON products ((NULLIF(TRIM(sku), '')))`,
+			`CREATE UNIQUE INDEX uq_products_upc ON products ((NULLIF(TRIM(upc), '')))`,
+		},
+		check: func(ctx context.Context) error {
+			return checkUniqueCodes(ctx, productCode{"sku", `NULLIF(TRIM(sku), '')`}, productCode{"upc", `NULLIF(TRIM(upc), '')`})
+		},
+	},
+	{
+		version: 8,
+		name:    "create saved searches, report schedules and run history",
+		statements: []string{
+			`CREATE TABLE IF NOT EXISTS saved_searches (
+			savedSearchId INT NOT NULL AUTO_INCREMENT,
+			name VARCHAR(255) NOT NULL,
+			filter TEXT NOT NULL,
+			sortBy VARCHAR(255) NOT NULL DEFAULT '',
+			columns VARCHAR(1024) NOT NULL DEFAULT '',
+			createdAt DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (savedSearchId),
+			UNIQUE KEY uq_saved_searches_name (name))`,
+			`CREATE TABLE IF NOT EXISTS report_schedules (
+			scheduleId INT NOT NULL AUTO_INCREMENT,
+			savedSearchId INT NOT NULL,
+			cron VARCHAR(255) NOT NULL,
+			format VARCHAR(20) NOT NULL,
+			sink VARCHAR(64) NOT NULL,
+			target VARCHAR(2048) NOT NULL DEFAULT '',
+			enabled TINYINT(1) NOT NULL DEFAULT 1,
+			nextRunAt DATETIME NULL,
+			lastRunAt DATETIME NULL,
+			PRIMARY KEY (scheduleId),
+			INDEX idx_report_schedules_due (enabled, nextRunAt),
+			INDEX idx_report_schedules_saved_search (savedSearchId))`,
+			`CREATE TABLE IF NOT EXISTS report_runs (
+			runId INT NOT NULL AUTO_INCREMENT,
+			scheduleId INT NOT NULL,
+			status VARCHAR(20) NOT NULL,
+			startedAt DATETIME NOT NULL,
+			finishedAt DATETIME NULL,
+			location VARCHAR(2048) NOT NULL DEFAULT '',
+			error VARCHAR(1024) NOT NULL DEFAULT '',
+			PRIMARY KEY (runId),
+			INDEX idx_report_runs_schedule (scheduleId, startedAt))`,
+		},
+	},
+	{
+		version: 9,
+		name:    "create custom report templates",
+		statements: []string{
+			`CREATE TABLE IF NOT EXISTS report_templates (
+			name VARCHAR(64) NOT NULL,
+			body MEDIUMTEXT NOT NULL,
+			createdAt DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			updatedAt DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (name))`,
+		},
+	},
+	{
+		version: 10,
+		name:    "create stock snapshots",
+		statements: []string{
+			`CREATE TABLE IF NOT EXISTS stock_snapshots (
+			takenAt DATETIME NOT NULL,
+			productId INT NOT NULL,
+			quantity INT NOT NULL,
+			pricePerUnit DECIMAL(13,2) NOT NULL,
+			value DECIMAL(17,2) NOT NULL,
+			PRIMARY KEY (takenAt, productId),
+			INDEX idx_stock_snapshots_product (productId, takenAt))`,
+		},
+	},
+	{
+		version: 11,
+		name:    "create receipts",
+		// links to purchase orders stay in purchase_order_receipts, where receiptName now holds the receipt ID
+		statements: []string{
+			`CREATE TABLE IF NOT EXISTS receipts (
+			receiptId VARCHAR(128) NOT NULL,
+			name VARCHAR(255) NOT NULL,
+			contentType VARCHAR(255) NOT NULL,
+			size BIGINT NOT NULL,
+			checksum CHAR(64) NOT NULL,
+			uploadedBy VARCHAR(255) NOT NULL DEFAULT '',
+			uploadedAt DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (receiptId),
+			INDEX idx_receipts_uploaded (uploadedAt),
+			INDEX idx_receipts_checksum (checksum))`,
+			`CREATE TABLE IF NOT EXISTS receipt_tags (
+			receiptId VARCHAR(128) NOT NULL,
+			tag VARCHAR(64) NOT NULL,
+			PRIMARY KEY (receiptId, tag),
+			INDEX idx_receipt_tags_tag (tag))`,
+			`CREATE TABLE IF NOT EXISTS receipt_products (
+			receiptId VARCHAR(128) NOT NULL,
+			productId INT NOT NULL,
+			PRIMARY KEY (receiptId, productId),
+			INDEX idx_receipt_products_product (productId))`,
+		},
+	},
+	{
+		// every receipt gets a version 1 from its existing file, which keeps the receipt ID as its blob key
+		version: 12,
+		name:    "add receipt versions and audit log",
+		statements: []string{
+			`ALTER TABLE receipts
+			ADD COLUMN version INT NOT NULL DEFAULT 1,
+			ADD COLUMN deletedAt DATETIME NULL`,
+			`CREATE TABLE IF NOT EXISTS receipt_versions (
+			receiptId VARCHAR(128) NOT NULL,
+			version INT NOT NULL,
+			blobKey VARCHAR(512) NOT NULL,
+			name VARCHAR(255) NOT NULL,
+			contentType VARCHAR(255) NOT NULL,
+			size BIGINT NOT NULL,
+			checksum CHAR(64) NOT NULL,
+			uploadedBy VARCHAR(255) NOT NULL DEFAULT '',
+			uploadedAt DATETIME NOT NULL,
+			supersededAt DATETIME NULL,
+			expiredAt DATETIME NULL,
+			PRIMARY KEY (receiptId, version),
+			INDEX idx_receipt_versions_superseded (supersededAt))`,
+			`INSERT IGNORE INTO receipt_versions (receiptId, version, blobKey, name, contentType, size, checksum, uploadedBy, uploadedAt)
+			SELECT receiptId, 1, receiptId, name, contentType, size, checksum, uploadedBy, uploadedAt FROM receipts`,
+			`CREATE TABLE IF NOT EXISTS receipt_audit (
+			auditId INT NOT NULL AUTO_INCREMENT,
+			receiptId VARCHAR(128) NOT NULL,
+			version INT NULL,
+			action VARCHAR(32) NOT NULL,
+			actor VARCHAR(255) NOT NULL DEFAULT '',
+			detail VARCHAR(1024) NOT NULL DEFAULT '',
+			createdAt DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (auditId),
+			INDEX idx_receipt_audit_receipt (receiptId, auditId))`,
+		},
+	},
+	{
+		version: 13,
+		name:    "create resumable receipt uploads",
+		statements: []string{
+			`CREATE TABLE IF NOT EXISTS receipt_uploads (
+			uploadId VARCHAR(64) NOT NULL,
+			receiptId VARCHAR(128) NOT NULL DEFAULT '',
+			filename VARCHAR(255) NOT NULL,
+			size BIGINT NOT NULL,
+			uploadOffset BIGINT NOT NULL DEFAULT 0,
+			checksum CHAR(64) NOT NULL DEFAULT '',
+			details TEXT NOT NULL,
+			status VARCHAR(16) NOT NULL,
+			createdAt DATETIME NOT NULL,
+			expiresAt DATETIME NOT NULL,
+			PRIMARY KEY (uploadId),
+			INDEX idx_receipt_uploads_expires (expiresAt))`,
+			`CREATE TABLE IF NOT EXISTS receipt_upload_chunks (
+			uploadId VARCHAR(64) NOT NULL,
+			chunkOffset BIGINT NOT NULL,
+			length BIGINT NOT NULL,
+			blobKey VARCHAR(512) NOT NULL,
+			checksum CHAR(64) NOT NULL,
+			PRIMARY KEY (uploadId, chunkOffset))`,
+		},
+	},
+	{
+		version: 14,
+		name:    "create receipt extractions",
+		statements: []string{
+			`CREATE TABLE IF NOT EXISTS receipt_extractions (
+			receiptId VARCHAR(128) NOT NULL,
+			version INT NOT NULL,
+			method VARCHAR(16) NOT NULL,
+			text MEDIUMTEXT NOT NULL,
+			document MEDIUMTEXT NOT NULL,
+			extractedAt DATETIME NOT NULL,
+			PRIMARY KEY (receiptId, version))`,
+		},
+	},
+	{
+		// a 13 digit EAN-13 with a leading 0 is the same code as the 12 digit UPC-A, they're stored as the UPC-A so the unique index covers both
+		version: 15,
+		name:    "store product UPCs as UPC-A",
+		statements: []string{
+			`UPDATE products SET upc = SUBSTRING(TRIM(upc), 2) WHERE TRIM(upc) REGEXP '^0[0-9]{12}$'`,
+		},
+		check: func(ctx context.Context) error {
+			return checkUniqueCodes(ctx, productCode{"upc", `CASE WHEN TRIM(upc) REGEXP '^0[0-9]{12}$' THEN SUBSTRING(TRIM(upc), 2) ELSE NULLIF(TRIM(upc), '') END`})
+		},
+	},
+	{
+		// warehouses have no parent, and NULLs never clash in a unique key, so the key is on COALESCE(parentId, 0) instead
+		version: 16,
+		name:    "enforce unique warehouse codes",
+		statements: []string{
+			`CREATE UNIQUE INDEX uq_locations_parent_code_all ON locations ((COALESCE(parentId, 0)), code)`,
+			`DROP INDEX uq_locations_parent_code ON locations`,
+		},
+		check: checkUniqueWarehouseCodes,
+	},
+}
+
+// checkUniqueWarehouseCodes fails listing any warehouses that share a code
+func checkUniqueWarehouseCodes(ctx context.Context) error {
+	results, err := DbConn.QueryContext(ctx, `SELECT code, GROUP_CONCAT(locationId ORDER BY locationId SEPARATOR ', ')
+	FROM locations
+	WHERE parentId IS NULL
+	GROUP BY code
+	HAVING COUNT(*) > 1
+	ORDER BY code`)
+	if err != nil {
+		return err
+	}
+	defer results.Close()
+	var duplicates []string
+	for results.Next() {
+		var code, locationIDs string
+		if err := results.Scan(&code, &locationIDs); err != nil {
+			return err
+		}
+		duplicates = append(duplicates, fmt.Sprintf("code %q is used by locations %s", code, locationIDs))
+	}
+	if err := results.Err(); err != nil {
+		return err
+	}
+	if len(duplicates) > 0 {
+		return fmt.Errorf("warehouses share codes that have to be unique, change them and restart: %s", strings.Join(duplicates, "; "))
+	}
+	return nil
+}
+
+// productCode is a product column that has to be unique, expression is the SQL for the value that's compared
+type productCode struct {
+	name       string
+	expression string
+}
+
+// checkUniqueCodes fails listing any products that share a code
+func checkUniqueCodes(ctx context.Context, codes ...productCode) error {
+	var duplicates []string
+	for _, c := range codes {
+		results, err := DbConn.QueryContext(ctx, `SELECT `+c.expression+` AS code, GROUP_CONCAT(productId ORDER BY productId SEPARATOR ', ')
+		FROM products
+		WHERE `+c.expression+` IS NOT NULL
+		GROUP BY code
+		HAVING COUNT(*) > 1
+		ORDER BY code`)
+		if err != nil {
+			return err
+		}
+		for results.Next() {
+			var code, productIDs string
+			if err := results.Scan(&code, &productIDs); err != nil {
+				results.Close()
+				return err
+			}
+			duplicates = append(duplicates, fmt.Sprintf("%s %q is used by products %s", c.name, code, productIDs))
+		}
+		err = results.Err()
+		results.Close()
+		if err != nil {
+			return err
+		}
+	}
+	if len(duplicates) > 0 {
+		return fmt.Errorf("products share codes that have to be unique, change them and restart: %s", strings.Join(duplicates, "; "))
+	}
+	return nil
+}
+
+// migrate creates the schema_migrations table if needed and then applies any migrations that haven't been run yet
+func migrate() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+	_, err := DbConn.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+	version INT NOT NULL,
+	name VARCHAR(255) NOT NULL,
+	appliedAt DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (version))`)
+	if err != nil {
+		return err
+	}
+
+	applied := make(map[int]bool)
+	results, err := DbConn.QueryContext(ctx, `SELECT version FROM schema_migrations`)
+	if err != nil {
+		return err
+	}
+	defer results.Close()
+	for results.Next() {
+		var version int
+		if err := results.Scan(&version); err != nil {
+			return err
+		}
+		applied[version] = true
+	}
+	results.Close()
+
+	for _, m := range migrations {
+		if applied[m.version] {
+			continue
+		}
+		if m.check != nil {
+			if err := m.check(ctx); err != nil {
+				return fmt.Errorf("migration %d (%s): %w", m.version, m.name, err)
+			}
+		}
+		// MySQL commits DDL statements implicitly so there's no point wrapping these in a transaction
+		for _, stmt := range m.statements {
+			if _, err := DbConn.ExecContext(ctx, stmt); err != nil {
+				return fmt.Errorf("migration %d (%s): %w", m.version, m.name, err)
+			}
+		}
+		_, err = DbConn.ExecContext(ctx, `INSERT INTO schema_migrations (version, name) VALUES (?, ?)`, m.version, m.name)
+		if err != nil {
+			return err
+		}
+		log.Printf("applied migration %d: %s\n", m.version, m.name)
+	}
+	return nil
+}
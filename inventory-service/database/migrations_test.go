@@ -0,0 +1,109 @@
+package database
+
+import (
+	"database/sql/driver"
+	"strings"
+	"testing"
+
+	"github.com/jordbick/Golang/inventory-service/database/dbtest"
+)
+
+// migrationDB has every migration before 7 applied, and answers the duplicate checks with duplicates
+type migrationDB struct {
+	duplicates map[string][][]driver.Value
+	executed   []string
+}
+
+func (m *migrationDB) handle(query string, args []driver.Value) (dbtest.Result, error) {
+	switch {
+	case strings.HasPrefix(query, "SELECT version FROM schema_migrations"):
+		var rows [][]driver.Value
+		for version := int64(1); version < 7; version++ {
+			rows = append(rows, []driver.Value{version})
+		}
+		return dbtest.Result{Rows: rows}, nil
+	case strings.Contains(query, "GROUP_CONCAT"):
+		for code, rows := range m.duplicates {
+			if strings.HasPrefix(query, "SELECT "+code) {
+				return dbtest.Result{Rows: rows}, nil
+			}
+		}
+		return dbtest.Result{Columns: []string{"code", "productIds"}}, nil
+	}
+	m.executed = append(m.executed, query)
+	return dbtest.Result{}, nil
+}
+
+func TestMigrateDuplicateCodes(t *testing.T) {
+	db := &migrationDB{duplicates: map[string][][]driver.Value{
+		"NULLIF(TRIM(sku)": {{"WID-100", "3, 8"}},
+		"NULLIF(TRIM(upc)": {{"036000291452", "4, 5, 9"}},
+	}}
+	DbConn = dbtest.Open(db.handle)
+	err := migrate()
+	if err == nil {
+		t.Fatal("migrate() succeeded with duplicate codes")
+	}
+	for _, want := range []string{"migration 7", `sku "WID-100" is used by products 3, 8`, `upc "036000291452" is used by products 4, 5, 9`} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("migrate() = %q, want it to mention %s", err, want)
+		}
+	}
+	for _, query := range db.executed {
+		if strings.Contains(query, "uq_products") || strings.Contains(query, "INSERT INTO schema_migrations") {
+			t.Errorf("ran %q with duplicate codes", query)
+		}
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	db := &migrationDB{}
+	DbConn = dbtest.Open(db.handle)
+	if err := migrate(); err != nil {
+		t.Fatal(err)
+	}
+	var applied []string
+	for _, query := range db.executed {
+		if strings.HasPrefix(query, "INSERT INTO schema_migrations") {
+			applied = append(applied, query)
+		}
+	}
+	if want := len(migrations) - 6; len(applied) != want {
+		t.Errorf("applied %d migrations, want %d", len(applied), want)
+	}
+}
+
+func TestMigrateDuplicateWarehouseCodes(t *testing.T) {
+	var executed []string
+	DbConn = dbtest.Open(func(query string, args []driver.Value) (dbtest.Result, error) {
+		switch {
+		case strings.HasPrefix(query, "SELECT version FROM schema_migrations"):
+			var rows [][]driver.Value
+			for version := int64(1); version < 16; version++ {
+				rows = append(rows, []driver.Value{version})
+			}
+			return dbtest.Result{Rows: rows}, nil
+		case strings.Contains(query, "FROM locations"):
+			return dbtest.Result{Rows: [][]driver.Value{{"WH1", "2, 7"}}}, nil
+		}
+		executed = append(executed, query)
+		return dbtest.Result{}, nil
+	})
+	err := migrate()
+	if err == nil || !strings.Contains(err.Error(), `code "WH1" is used by locations 2, 7`) {
+		t.Errorf("migrate() = %v, want it to list the warehouses sharing WH1", err)
+	}
+	for _, query := range executed {
+		if strings.Contains(query, "uq_locations") {
+			t.Errorf("ran %q with duplicate warehouse codes", query)
+		}
+	}
+}
+
+func TestMigrationVersions(t *testing.T) {
+	for i, m := range migrations {
+		if m.version != i+1 {
+			t.Errorf("migration %q is version %d, want %d", m.name, m.version, i+1)
+		}
+	}
+}
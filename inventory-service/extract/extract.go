@@ -0,0 +1,136 @@
+package extract
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"mime"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/jordbick/Golang/inventory-service/pdf"
+)
+
+// Getting the text out of receipts so their details can be read rather than typed in
+// Plain text is read as it is and PDFs have their text drawn out, both in Go. Images need an OCR engine, which isn't built in,
+// anything that implements OCR can be plugged in. What comes out is parsed into a Document, see Parse
+
+var ErrUnsupported = errors.New("can't extract text from this type of file")
+
+// MaxTextSize is the most text read from a plain text receipt, in bytes
+var MaxTextSize int64 = 1 << 20
+
+// Extractor gets the text out of a file of the type it's registered for
+type Extractor interface {
+	Extract(ctx context.Context, r io.Reader, contentType string) (string, error)
+}
+
+// ExtractorFunc lets a function be used as an Extractor
+type ExtractorFunc func(ctx context.Context, r io.Reader, contentType string) (string, error)
+
+func (f ExtractorFunc) Extract(ctx context.Context, r io.Reader, contentType string) (string, error) {
+	return f(ctx, r, contentType)
+}
+
+// OCR reads the text in an image, a line for each line of text
+type OCR interface {
+	Recognize(ctx context.Context, image io.Reader, contentType string) (string, error)
+}
+
+// FakeOCR is an OCR engine that always reads Text from an image, or fails with Err, for trying out the pipeline without a real engine
+type FakeOCR struct {
+	Text string
+	Err  error
+}
+
+func (f FakeOCR) Recognize(ctx context.Context, image io.Reader, contentType string) (string, error) {
+	if f.Err != nil {
+		return "", f.Err
+	}
+	if _, err := io.Copy(ioutil.Discard, image); err != nil {
+		return "", err
+	}
+	return f.Text, nil
+}
+
+// Result is what was extracted from a file, its text and the details parsed from it
+// Method is how the text was got: text, pdf or ocr
+type Result struct {
+	Method   string   `json:"method"`
+	Text     string   `json:"text"`
+	Document Document `json:"document"`
+}
+
+// Pipeline picks an Extractor by the file's media type
+type Pipeline struct {
+	extractors map[string]Extractor
+	methods    map[string]string
+}
+
+// NewPipeline reads plain text and PDFs, and JPEG, PNG, GIF and WebP images too if there's an OCR engine
+func NewPipeline(ocr OCR) *Pipeline {
+	p := &Pipeline{extractors: make(map[string]Extractor), methods: make(map[string]string)}
+	p.Register("text", ExtractorFunc(plainText), "text/plain")
+	p.Register("pdf", ExtractorFunc(pdfText), "application/pdf")
+	if ocr != nil {
+		p.Register("ocr", ExtractorFunc(func(ctx context.Context, r io.Reader, contentType string) (string, error) {
+			return ocr.Recognize(ctx, r, contentType)
+		}), "image/jpeg", "image/png", "image/gif", "image/webp")
+	}
+	return p
+}
+
+// Register uses the extractor for the media types, in place of any that was there before
+func (p *Pipeline) Register(method string, extractor Extractor, mediaTypes ...string) {
+	for _, mediaType := range mediaTypes {
+		mediaType = strings.ToLower(mediaType)
+		p.extractors[mediaType] = extractor
+		p.methods[mediaType] = method
+	}
+}
+
+// Supports reports whether the pipeline can extract text from the content type
+func (p *Pipeline) Supports(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	return err == nil && p.extractors[strings.ToLower(mediaType)] != nil
+}
+
+// Run extracts the file's text and parses it
+func (p *Pipeline) Run(ctx context.Context, r io.Reader, contentType string) (*Result, error) {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil, ErrUnsupported
+	}
+	mediaType = strings.ToLower(mediaType)
+	extractor := p.extractors[mediaType]
+	if extractor == nil {
+		return nil, ErrUnsupported
+	}
+	text, err := extractor.Extract(ctx, r, contentType)
+	if err != nil {
+		return nil, err
+	}
+	return &Result{Method: p.methods[mediaType], Text: text, Document: Parse(text)}, nil
+}
+
+// plainText reads a text receipt, which is assumed to be UTF-8, or Latin-1 if it isn't valid UTF-8
+func plainText(ctx context.Context, r io.Reader, contentType string) (string, error) {
+	b, err := ioutil.ReadAll(io.LimitReader(r, MaxTextSize))
+	if err != nil {
+		return "", err
+	}
+	text := string(b)
+	if !utf8.ValidString(text) {
+		runes := make([]rune, len(b))
+		for i, c := range b {
+			runes[i] = rune(c)
+		}
+		text = string(runes)
+	}
+	return strings.TrimPrefix(strings.NewReplacer("\r\n", "\n", "\r", "\n").Replace(text), "\ufeff"), nil
+}
+
+func pdfText(ctx context.Context, r io.Reader, contentType string) (string, error) {
+	return pdf.ExtractText(r)
+}
@@ -0,0 +1,78 @@
+package extract
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/jordbick/Golang/inventory-service/pdf"
+)
+
+func TestPipeline(t *testing.T) {
+	ocr := FakeOCR{Text: "Corner Shop\n2 x Widget 5.00\nTotal 5.00"}
+	p := NewPipeline(ocr)
+
+	result, err := p.Run(context.Background(), strings.NewReader("\x89PNG..."), "image/png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Method != "ocr" || result.Text != ocr.Text {
+		t.Errorf("image: method %q, text %q", result.Method, result.Text)
+	}
+	if result.Document.Vendor != "Corner Shop" || result.Document.Total != "5.00" || len(result.Document.Lines) != 1 || result.Document.Lines[0].Quantity != 2 {
+		t.Errorf("image: document %+v", result.Document)
+	}
+
+	// content types are matched on their media type alone
+	result, err = p.Run(context.Background(), strings.NewReader("\ufeffShop\r\nTea 1.20\r\n"), "Text/Plain; charset=utf-8")
+	if err != nil || result.Method != "text" || result.Text != "Shop\nTea 1.20\n" {
+		t.Errorf("text: %+v, %v", result, err)
+	}
+
+	doc := pdf.New(pdf.A4Width, pdf.A4Height)
+	doc.AddPage().Text(72, 72, pdf.Helvetica, 10, "Coffee 3.40")
+	var b bytes.Buffer
+	if err = doc.Write(&b); err != nil {
+		t.Fatal(err)
+	}
+	result, err = p.Run(context.Background(), &b, "application/pdf")
+	if err != nil || result.Method != "pdf" || len(result.Document.Lines) != 1 || result.Document.Lines[0].Amount != "3.40" {
+		t.Errorf("pdf: %+v, %v", result, err)
+	}
+
+	for _, contentType := range []string{"application/zip", "not a type", ""} {
+		if p.Supports(contentType) {
+			t.Errorf("Supports(%q) = true", contentType)
+		}
+		if _, err = p.Run(context.Background(), strings.NewReader("x"), contentType); err != ErrUnsupported {
+			t.Errorf("Run() for %q = %v, want ErrUnsupported", contentType, err)
+		}
+	}
+}
+
+func TestPipelineWithoutOCR(t *testing.T) {
+	p := NewPipeline(nil)
+	if p.Supports("image/jpeg") || !p.Supports("text/plain") || !p.Supports("application/pdf") {
+		t.Error("a pipeline without OCR should only support text and PDFs")
+	}
+	if _, err := p.Run(context.Background(), strings.NewReader("x"), "image/jpeg"); err != ErrUnsupported {
+		t.Errorf("Run() for an image = %v, want ErrUnsupported", err)
+	}
+}
+
+func TestPipelineOCRError(t *testing.T) {
+	failed := errors.New("engine unavailable")
+	p := NewPipeline(FakeOCR{Err: failed})
+	if _, err := p.Run(context.Background(), strings.NewReader("x"), "image/jpeg"); err != failed {
+		t.Errorf("Run() = %v, want the OCR engine's error", err)
+	}
+}
+
+func TestPlainTextLatin1(t *testing.T) {
+	text, err := plainText(context.Background(), strings.NewReader("Caf\xe9 2.50"), "text/plain")
+	if err != nil || text != "Café 2.50" {
+		t.Errorf("plainText() = %q, %v", text, err)
+	}
+}
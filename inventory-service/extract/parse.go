@@ -0,0 +1,370 @@
+package extract
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Receipts don't follow any one layout, so the parser works line by line with patterns that suit most of them
+// Amounts need two decimal places (12.50, $1,234.00), which keeps them apart from quantities and codes
+// Dates can be 2024-03-05, 03/05/2024 (month first unless that can't be right), 5 Mar 2024 or Mar 5, 2024
+// A line with an amount on it is a line item unless it's a total, tax, payment or change line
+// Everything parsed is a suggestion for someone to check, nothing is taken as certain
+
+// Document is what could be read from a receipt. Amounts are decimal strings, like a product's pricePerUnit
+type Document struct {
+	Vendor   string     `json:"vendor"`
+	Date     *time.Time `json:"date"`
+	Subtotal string     `json:"subtotal,omitempty"`
+	Tax      string     `json:"tax,omitempty"`
+	Total    string     `json:"total,omitempty"`
+	Lines    []Line     `json:"lines"`
+}
+
+// Line is a line item. Text is the line as it was on the receipt, Description what's left once codes, quantities and prices are taken out
+// Quantity is 1 unless the line says otherwise, Amount is the line's total
+type Line struct {
+	Text        string `json:"text"`
+	Description string `json:"description"`
+	SKU         string `json:"sku,omitempty"`
+	UPC         string `json:"upc,omitempty"`
+	Quantity    int    `json:"quantity"`
+	UnitPrice   string `json:"unitPrice,omitempty"`
+	Amount      string `json:"amount"`
+}
+
+var (
+	amountPattern = regexp.MustCompile(`(?:^|[\s(])(-?)[$£€]?\s?(-?)((?:\d{1,3}(?:,\d{3})+|\d+)\.\d{2})\b`)
+	// unit prices are written "@ 4.99" or "@4.99 ea", with the quantity in front of them if it's "2 @ 4.99"
+	unitPricePattern = regexp.MustCompile(`(?:(?:^|\s)(\d{1,5})\s*)?@\s*[$£€]?\s?(\d+(?:,\d{3})*\.\d{2,3})(?:\s*(?:ea|each)\b)?`)
+	// quantities are "2 x", "x2", "qty 2" or "2 @"
+	quantityPatterns = []*regexp.Regexp{
+		regexp.MustCompile(`(?i)\bqty\.?:?\s*(\d{1,5})\b`),
+		regexp.MustCompile(`(?i)(?:^|\s)(\d{1,5})\s*(?:x|@|pcs?\b|ea\b|units?\b)`),
+		regexp.MustCompile(`(?i)(?:^|\s)x\s?(\d{1,5})\b`),
+	}
+	skuPattern = regexp.MustCompile(`(?i)\b(?:sku|item|part|art|ref)\s*(?:no\.?|#|:)?\s*:?\s*([A-Z0-9][A-Z0-9._/-]{1,63})\b`)
+	// without a label, a SKU looks like letters and digits split by dashes, e.g. WID-100 or AB-12-C, see bareSku
+	bareSkuPattern = regexp.MustCompile(`\b[A-Za-z0-9]{1,16}(?:-[A-Za-z0-9]{1,16})+\b`)
+	upcPattern     = regexp.MustCompile(`(?:^|\D)(\d{12,13})(?:\D|$)`)
+	// a leading number followed by words is a quantity, "3 Widgets 12.00"
+	leadingQuantityPattern = regexp.MustCompile(`^(\d{1,4})\s+[A-Za-z]`)
+
+	datePatterns = []struct {
+		pattern *regexp.Regexp
+		parse   func(m []string) (time.Time, bool)
+	}{
+		{regexp.MustCompile(`\b(\d{4})[-/.](\d{1,2})[-/.](\d{1,2})\b`), func(m []string) (time.Time, bool) {
+			return makeDate(m[1], m[2], m[3])
+		}},
+		{regexp.MustCompile(`\b(\d{1,2})[/.-](\d{1,2})[/.-](\d{4}|\d{2})\b`), func(m []string) (time.Time, bool) {
+			// month first, unless the first number can't be a month
+			if t, ok := makeDate(m[3], m[1], m[2]); ok {
+				return t, true
+			}
+			return makeDate(m[3], m[2], m[1])
+		}},
+		{regexp.MustCompile(`(?i)\b(\d{1,2})(?:st|nd|rd|th)?\s+(jan|feb|mar|apr|may|jun|jul|aug|sep|sept|oct|nov|dec)[a-z]*\.?,?\s+(\d{4})\b`), func(m []string) (time.Time, bool) {
+			return makeDate(m[3], monthNumber(m[2]), m[1])
+		}},
+		{regexp.MustCompile(`(?i)\b(jan|feb|mar|apr|may|jun|jul|aug|sep|sept|oct|nov|dec)[a-z]*\.?\s+(\d{1,2})(?:st|nd|rd|th)?,?\s+(\d{4})\b`), func(m []string) (time.Time, bool) {
+			return makeDate(m[3], monthNumber(m[1]), m[2])
+		}},
+	}
+)
+
+// words that mark a line as something other than an item
+var (
+	subtotalWords = []string{"subtotal", "sub total", "sub-total", "net total"}
+	taxWords      = []string{"tax", "vat", "gst", "hst", "pst"}
+	totalWords    = []string{"total", "amount due", "balance due", "grand total", "to pay"}
+	skipWords     = []string{"cash", "change", "tendered", "visa", "mastercard", "amex", "debit", "credit", "card", "payment", "paid", "discount", "savings", "rounding", "tip", "gratuity", "balance", "deposit"}
+	headerWords   = []string{"receipt", "invoice", "tax invoice", "sales receipt", "order", "bill", "statement", "thank you", "welcome"}
+)
+
+// Parse reads what it can from a receipt's text
+func Parse(text string) Document {
+	doc := Document{Lines: make([]Line, 0)}
+	for _, raw := range strings.Split(text, "\n") {
+		line := strings.Join(strings.Fields(raw), " ")
+		if line == "" {
+			continue
+		}
+		lower := strings.ToLower(line)
+		if doc.Date == nil {
+			if t, ok := findDate(line); ok {
+				doc.Date = &t
+			}
+		}
+		amounts := findAmounts(line)
+		if len(amounts) == 0 {
+			if doc.Vendor == "" && len(doc.Lines) == 0 && vendorLike(line, lower) {
+				doc.Vendor = line
+				if len(doc.Vendor) > 255 {
+					doc.Vendor = strings.TrimSpace(doc.Vendor[:255])
+				}
+			}
+			continue
+		}
+		last := formatCents(amounts[len(amounts)-1])
+		switch {
+		case hasWord(lower, subtotalWords):
+			doc.Subtotal = last
+		case hasWord(lower, taxWords) && !hasWord(lower, totalWords):
+			doc.Tax = last
+		case hasWord(lower, totalWords):
+			// the first total is usually the one that counts, later ones tend to be in another currency or after payments
+			if doc.Total == "" {
+				doc.Total = last
+			}
+		case hasWord(lower, skipWords):
+		default:
+			if _, isDate := findDate(line); isDate && len(amounts) == 1 && strings.Count(line, " ") < 2 {
+				continue
+			}
+			doc.Lines = append(doc.Lines, parseLine(line, amounts))
+		}
+	}
+	return doc
+}
+
+// parseLine reads a line item, amounts are the line's amounts in cents in the order they appear
+func parseLine(text string, amounts []int64) Line {
+	line := Line{Text: text, Quantity: 1}
+	rest := text
+	amount := amounts[len(amounts)-1]
+	line.Amount = formatCents(amount)
+
+	if m := upcPattern.FindStringSubmatch(rest); m != nil && validUPC(m[1]) {
+		line.UPC = m[1]
+		rest = strings.Replace(rest, m[1], " ", 1)
+	}
+	if m := skuPattern.FindStringSubmatchIndex(rest); m != nil {
+		line.SKU = rest[m[2]:m[3]]
+		rest = rest[:m[0]] + " " + rest[m[1]:]
+	} else if m := bareSku(rest); m != nil {
+		line.SKU = rest[m[0]:m[1]]
+		rest = rest[:m[0]] + " " + rest[m[1]:]
+	}
+
+	unitPrice := int64(-1)
+	quantityFound := false
+	if m := unitPricePattern.FindStringSubmatchIndex(rest); m != nil {
+		if cents, ok := parseCents(rest[m[4]:m[5]]); ok {
+			unitPrice = cents
+		}
+		if m[2] >= 0 {
+			if q, err := strconv.Atoi(rest[m[2]:m[3]]); err == nil && q > 0 {
+				line.Quantity = q
+				quantityFound = true
+			}
+		}
+		rest = rest[:m[0]] + " " + rest[m[1]:]
+	}
+	for i := 0; i < len(quantityPatterns) && !quantityFound; i++ {
+		if m := quantityPatterns[i].FindStringSubmatchIndex(rest); m != nil {
+			if q, err := strconv.Atoi(rest[m[2]:m[3]]); err == nil && q > 0 {
+				line.Quantity = q
+				quantityFound = true
+				rest = rest[:m[0]] + " " + rest[m[1]:]
+			}
+		}
+	}
+	if !quantityFound {
+		if m := leadingQuantityPattern.FindStringSubmatch(rest); m != nil {
+			if q, err := strconv.Atoi(m[1]); err == nil && q > 0 {
+				line.Quantity = q
+				rest = strings.TrimPrefix(rest, m[1])
+			}
+		}
+	}
+
+	// two amounts on an item line are the unit price and the line total
+	if unitPrice < 0 && len(amounts) >= 2 {
+		unitPrice = amounts[len(amounts)-2]
+	}
+	if unitPrice < 0 && amount%int64(line.Quantity) == 0 {
+		unitPrice = amount / int64(line.Quantity)
+	}
+	if unitPrice >= 0 {
+		line.UnitPrice = formatCents(unitPrice)
+		// a quantity that wasn't written down can be worked out from the prices
+		if !quantityFound && line.Quantity == 1 && unitPrice > 0 && amount > unitPrice && amount%unitPrice == 0 {
+			line.Quantity = int(amount / unitPrice)
+		}
+	}
+
+	rest = amountPattern.ReplaceAllString(rest, " ")
+	rest = strings.Trim(strings.Join(strings.Fields(rest), " "), " -:*#")
+	line.Description = rest
+	return line
+}
+
+// bareSku finds an unlabelled SKU, it needs both letters and digits so words like T-shirt and dates aren't taken for one
+func bareSku(line string) []int {
+	for _, m := range bareSkuPattern.FindAllStringIndex(line, -1) {
+		code := line[m[0]:m[1]]
+		if strings.IndexAny(code, "0123456789") >= 0 && strings.IndexFunc(code, func(r rune) bool { return isLetter(byte(r)) }) >= 0 {
+			return m
+		}
+	}
+	return nil
+}
+
+// findAmounts returns the amounts in a line in cents
+func findAmounts(line string) []int64 {
+	var amounts []int64
+	for _, m := range amountPattern.FindAllStringSubmatch(line, -1) {
+		cents, ok := parseCents(m[3])
+		if !ok {
+			continue
+		}
+		if m[1] == "-" || m[2] == "-" {
+			cents = -cents
+		}
+		amounts = append(amounts, cents)
+	}
+	return amounts
+}
+
+// parseCents reads 1,234.56 as 123456
+func parseCents(s string) (int64, bool) {
+	s = strings.ReplaceAll(s, ",", "")
+	parts := strings.SplitN(s, ".", 2)
+	whole, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	cents := whole * 100
+	if len(parts) == 2 {
+		fraction := (parts[1] + "00")[:2]
+		f, err := strconv.ParseInt(fraction, 10, 64)
+		if err != nil {
+			return 0, false
+		}
+		cents += f
+	}
+	return cents, true
+}
+
+func formatCents(cents int64) string {
+	sign := ""
+	if cents < 0 {
+		sign = "-"
+		cents = -cents
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, cents/100, cents%100)
+}
+
+// findDate returns the first date in the line that's a real date
+func findDate(line string) (time.Time, bool) {
+	for _, d := range datePatterns {
+		for _, m := range d.pattern.FindAllStringSubmatch(line, -1) {
+			if t, ok := d.parse(m); ok {
+				return t, true
+			}
+		}
+	}
+	return time.Time{}, false
+}
+
+// makeDate checks the year, month and day make a real date, a two digit year is in this century
+func makeDate(year, month, day string) (time.Time, bool) {
+	y, err1 := strconv.Atoi(year)
+	m, err2 := strconv.Atoi(month)
+	d, err3 := strconv.Atoi(day)
+	if err1 != nil || err2 != nil || err3 != nil || m < 1 || m > 12 || d < 1 || d > 31 {
+		return time.Time{}, false
+	}
+	if y < 100 {
+		y += 2000
+	}
+	if y < 1970 || y > 2100 {
+		return time.Time{}, false
+	}
+	t := time.Date(y, time.Month(m), d, 0, 0, 0, 0, time.UTC)
+	// time.Date moves the 31st of a short month into the next month
+	if t.Day() != d {
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+func monthNumber(name string) string {
+	months := []string{"jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}
+	name = strings.ToLower(name)
+	for i, month := range months {
+		if strings.HasPrefix(name, month) {
+			return strconv.Itoa(i + 1)
+		}
+	}
+	return ""
+}
+
+// hasWord reports whether any of the words appear in the line as whole words
+func hasWord(lower string, words []string) bool {
+	for _, word := range words {
+		i := strings.Index(lower, word)
+		for i >= 0 {
+			before := i == 0 || !isLetter(lower[i-1])
+			after := i+len(word) == len(lower) || !isLetter(lower[i+len(word)])
+			if before && after {
+				return true
+			}
+			next := strings.Index(lower[i+1:], word)
+			if next < 0 {
+				break
+			}
+			i += next + 1
+		}
+	}
+	return false
+}
+
+func isLetter(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+// vendorLike reports whether a line could be the vendor's name: it has letters, and isn't a date, phone number, address or heading
+func vendorLike(line, lower string) bool {
+	letters := 0
+	for i := 0; i < len(line); i++ {
+		if isLetter(line[i]) {
+			letters++
+		}
+	}
+	if letters < 2 {
+		return false
+	}
+	if _, ok := findDate(line); ok {
+		return false
+	}
+	for _, word := range headerWords {
+		if lower == word || strings.HasPrefix(lower, word+" #") || strings.HasPrefix(lower, word+" no") || strings.HasPrefix(lower, word+":") {
+			return false
+		}
+	}
+	for _, prefix := range []string{"tel", "phone", "fax", "www.", "http", "date", "time", "cashier", "server", "store #", "vat no", "abn", "gst no"} {
+		if strings.HasPrefix(lower, prefix) {
+			return false
+		}
+	}
+	return true
+}
+
+// validUPC checks the check digit of a 12 digit UPC-A or 13 digit EAN-13
+func validUPC(code string) bool {
+	sum := 0
+	for i := len(code) - 2; i >= 0; i-- {
+		digit := int(code[i] - '0')
+		// counting back from the check digit, odd positions are weighted 3
+		if (len(code)-2-i)%2 == 0 {
+			digit *= 3
+		}
+		sum += digit
+	}
+	return (10-sum%10)%10 == int(code[len(code)-1]-'0')
+}
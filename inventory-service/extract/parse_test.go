@@ -0,0 +1,184 @@
+package extract
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestFindDate(t *testing.T) {
+	tests := []struct {
+		line string
+		want string
+	}{
+		{"Date: 2024-03-05 14:22", "2024-03-05"},
+		{"2024/3/5", "2024-03-05"},
+		{"03/05/2024", "2024-03-05"},
+		// the first number can't be a month, so it's the day
+		{"25/12/2023", "2023-12-25"},
+		{"12.25.23", "2023-12-25"},
+		{"5 Mar 2024", "2024-03-05"},
+		{"1st September, 2024", "2024-09-01"},
+		{"Mar 5, 2024", "2024-03-05"},
+		{"December 31st 2023", "2023-12-31"},
+		// not real dates
+		{"31/31/2024", ""},
+		{"02/30/2024", ""},
+		{"2024-13-01", ""},
+		{"Call 555-1234", ""},
+		{"Widget 12.50", ""},
+	}
+	for _, test := range tests {
+		got, ok := findDate(test.line)
+		if test.want == "" {
+			if ok {
+				t.Errorf("findDate(%q) = %s, want no date", test.line, got.Format("2006-01-02"))
+			}
+			continue
+		}
+		if !ok || got.Format("2006-01-02") != test.want || got.Location() != time.UTC {
+			t.Errorf("findDate(%q) = %v, %v, want %s", test.line, got, ok, test.want)
+		}
+	}
+}
+
+func TestFindAmounts(t *testing.T) {
+	tests := []struct {
+		line string
+		want []int64
+	}{
+		{"Widget 12.50", []int64{1250}},
+		{"2 @ 4.99 9.98", []int64{499, 998}},
+		{"Total $1,234.00", []int64{123400}},
+		{"Discount -2.00", []int64{-200}},
+		{"Refund $-3.10", []int64{-310}},
+		{"Euro €7.05", []int64{705}},
+		// quantities, codes and prices without cents aren't amounts
+		{"Qty 3", nil},
+		{"Item WID-100 12", nil},
+		{"Version 1.2.3", nil},
+		{"036000291452", nil},
+	}
+	for _, test := range tests {
+		if got := findAmounts(test.line); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("findAmounts(%q) = %v, want %v", test.line, got, test.want)
+		}
+	}
+}
+
+func TestParseCents(t *testing.T) {
+	tests := []struct {
+		in   string
+		want int64
+	}{
+		{"12.50", 1250},
+		{"1,234.56", 123456},
+		{"0.05", 5},
+		{"4.999", 499},
+		{"7", 700},
+	}
+	for _, test := range tests {
+		if got, ok := parseCents(test.in); !ok || got != test.want {
+			t.Errorf("parseCents(%q) = %d, %v, want %d", test.in, got, ok, test.want)
+		}
+	}
+}
+
+func TestFormatCents(t *testing.T) {
+	for cents, want := range map[int64]string{1250: "12.50", 5: "0.05", 123456: "1234.56", -205: "-2.05", 0: "0.00"} {
+		if got := formatCents(cents); got != want {
+			t.Errorf("formatCents(%d) = %q, want %q", cents, got, want)
+		}
+	}
+}
+
+func TestParseLine(t *testing.T) {
+	tests := []struct {
+		text string
+		want Line
+	}{
+		{"Widget 12.50", Line{Description: "Widget", Quantity: 1, UnitPrice: "12.50", Amount: "12.50"}},
+		{"2 x Widget 25.00", Line{Description: "Widget", Quantity: 2, UnitPrice: "12.50", Amount: "25.00"}},
+		{"Widget x3 7.50", Line{Description: "Widget", Quantity: 3, UnitPrice: "2.50", Amount: "7.50"}},
+		{"Widget Qty: 4 10.00", Line{Description: "Widget", Quantity: 4, UnitPrice: "2.50", Amount: "10.00"}},
+		{"Gadget 2 @ 4.99 9.98", Line{Description: "Gadget", Quantity: 2, UnitPrice: "4.99", Amount: "9.98"}},
+		{"3 Sprockets 12.00", Line{Description: "Sprockets", Quantity: 3, UnitPrice: "4.00", Amount: "12.00"}},
+		// with a unit price and a line total the quantity can be worked out
+		{"Bolts 0.25 5.00", Line{Description: "Bolts", Quantity: 20, UnitPrice: "0.25", Amount: "5.00"}},
+		{"SKU: WID-100 Widget 12.50", Line{Description: "Widget", SKU: "WID-100", Quantity: 1, UnitPrice: "12.50", Amount: "12.50"}},
+		{"Item # AB12 Anchor 3.00", Line{Description: "Anchor", SKU: "AB12", Quantity: 1, UnitPrice: "3.00", Amount: "3.00"}},
+		{"GAD-200 Gadget 8.00", Line{Description: "Gadget", SKU: "GAD-200", Quantity: 1, UnitPrice: "8.00", Amount: "8.00"}},
+		// T-shirt has no digits, so it isn't a SKU
+		{"T-shirt 15.00", Line{Description: "T-shirt", Quantity: 1, UnitPrice: "15.00", Amount: "15.00"}},
+		{"036000291452 Tissues 2.49", Line{Description: "Tissues", UPC: "036000291452", Quantity: 1, UnitPrice: "2.49", Amount: "2.49"}},
+		{"4006381333931 Pencils 1.10", Line{Description: "Pencils", UPC: "4006381333931", Quantity: 1, UnitPrice: "1.10", Amount: "1.10"}},
+		// a bad check digit means it's some other number
+		{"036000291453 Tissues 2.49", Line{Description: "036000291453 Tissues", Quantity: 1, UnitPrice: "2.49", Amount: "2.49"}},
+	}
+	for _, test := range tests {
+		got := parseLine(test.text, findAmounts(test.text))
+		test.want.Text = test.text
+		if got != test.want {
+			t.Errorf("parseLine(%q) =\n%+v\nwant\n%+v", test.text, got, test.want)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	text := `ACME Supplies Ltd
+123 High Street
+Tel 01234 567890
+Invoice #1001
+Date: 05/03/2024
+
+2 x Widget SKU: WID-100 25.00
+036000291452 Tissues 2.49
+Gadget 2 @ 4.99 9.98
+Discount -1.00
+
+Subtotal 36.47
+VAT 20% 7.29
+Total 43.76
+Visa 43.76
+Change 0.00
+Total in EUR 51.00`
+	doc := Parse(text)
+	if doc.Vendor != "ACME Supplies Ltd" {
+		t.Errorf("vendor %q", doc.Vendor)
+	}
+	if doc.Date == nil || doc.Date.Format("2006-01-02") != "2024-05-03" {
+		t.Errorf("date %v, want 2024-05-03 with the month first", doc.Date)
+	}
+	if doc.Subtotal != "36.47" || doc.Tax != "7.29" || doc.Total != "43.76" {
+		t.Errorf("subtotal %s, tax %s, total %s", doc.Subtotal, doc.Tax, doc.Total)
+	}
+	want := []Line{
+		{Text: "2 x Widget SKU: WID-100 25.00", Description: "Widget", SKU: "WID-100", Quantity: 2, UnitPrice: "12.50", Amount: "25.00"},
+		{Text: "036000291452 Tissues 2.49", Description: "Tissues", UPC: "036000291452", Quantity: 1, UnitPrice: "2.49", Amount: "2.49"},
+		{Text: "Gadget 2 @ 4.99 9.98", Description: "Gadget", Quantity: 2, UnitPrice: "4.99", Amount: "9.98"},
+	}
+	if !reflect.DeepEqual(doc.Lines, want) {
+		t.Errorf("lines\n%+v\nwant\n%+v", doc.Lines, want)
+	}
+}
+
+func TestParseEmpty(t *testing.T) {
+	doc := Parse("")
+	if doc.Lines == nil || len(doc.Lines) != 0 || doc.Vendor != "" || doc.Date != nil || doc.Total != "" {
+		t.Errorf("Parse(\"\") = %+v", doc)
+	}
+}
+
+func TestValidUPC(t *testing.T) {
+	for code, want := range map[string]bool{
+		"036000291452":  true,
+		"036000291453":  false,
+		"4006381333931": true,
+		"4006381333932": false,
+		"0036000291452": true,
+	} {
+		if got := validUPC(code); got != want {
+			t.Errorf("validUPC(%s) = %v, want %v", code, got, want)
+		}
+	}
+}
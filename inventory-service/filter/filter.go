@@ -0,0 +1,355 @@
+package filter
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// A small filter language compiled to a parameterized SQL condition, e.g.
+//
+//	quantityOnHand < 10 AND (manufacturer = "acme" OR price >= 20)
+//
+// Comparisons are =, !=, <, <=, >, >=, CONTAINS, STARTSWITH and IN ("a", "b"), combined with AND, OR, NOT and brackets
+// Keywords are case insensitive. Strings are quoted with " or ' and can escape a quote with a backslash
+// Field names are looked up in a whitelist which maps them to columns, so nothing from the expression ends up in the SQL except as an argument
+
+// Limits to stop a huge expression tying up the parser or the database
+const (
+	MaxLength = 2000
+	maxDepth  = 20
+	maxValues = 500
+)
+
+// Field types
+const (
+	String = iota
+	Number
+)
+
+// Field is a column that can be filtered on
+type Field struct {
+	Column string
+	Type   int
+}
+
+// Error describes what's wrong with an expression and where
+type Error struct {
+	Position int
+	Message  string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("filter: %s at position %d", e.Message, e.Position+1)
+}
+
+// Compile parses the expression and returns it as a SQL condition with its arguments
+// Fields are matched by name ignoring case
+func Compile(expression string, fields map[string]Field) (string, []interface{}, error) {
+	if len(expression) > MaxLength {
+		return "", nil, &Error{Position: MaxLength, Message: fmt.Sprintf("expression is longer than %d characters", MaxLength)}
+	}
+	tokens, err := lex(expression)
+	if err != nil {
+		return "", nil, err
+	}
+	byName := make(map[string]Field, len(fields))
+	for name, field := range fields {
+		byName[strings.ToLower(name)] = field
+	}
+	p := &parser{tokens: tokens, fields: byName}
+	sql, err := p.or(0)
+	if err != nil {
+		return "", nil, err
+	}
+	if t := p.peek(); t.kind != tokenEnd {
+		return "", nil, &Error{Position: t.pos, Message: fmt.Sprintf("unexpected %s", t)}
+	}
+	return sql, p.args, nil
+}
+
+type tokenKind int
+
+const (
+	tokenEnd tokenKind = iota
+	tokenIdent
+	tokenString
+	tokenNumber
+	tokenOperator
+	tokenOpen
+	tokenClose
+	tokenComma
+)
+
+type token struct {
+	kind  tokenKind
+	text  string
+	pos   int
+	value interface{}
+}
+
+func (t token) String() string {
+	if t.kind == tokenEnd {
+		return "end of filter"
+	}
+	return fmt.Sprintf("[%s]", t.text)
+}
+
+// keyword reports whether the token is the given keyword, ignoring case
+func (t token) keyword(word string) bool {
+	return t.kind == tokenIdent && strings.EqualFold(t.text, word)
+}
+
+func lex(expression string) ([]token, error) {
+	tokens := make([]token, 0)
+	runes := []rune(expression)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(':
+			tokens = append(tokens, token{kind: tokenOpen, text: "(", pos: i})
+			i++
+		case r == ')':
+			tokens = append(tokens, token{kind: tokenClose, text: ")", pos: i})
+			i++
+		case r == ',':
+			tokens = append(tokens, token{kind: tokenComma, text: ",", pos: i})
+			i++
+		case r == '=' || r == '<' || r == '>' || r == '!':
+			start := i
+			i++
+			if i < len(runes) && runes[i] == '=' {
+				i++
+			}
+			op := string(runes[start:i])
+			if op == "!" {
+				return nil, &Error{Position: start, Message: "expected !="}
+			}
+			tokens = append(tokens, token{kind: tokenOperator, text: op, pos: start})
+		case r == '"' || r == '\'':
+			start := i
+			var value strings.Builder
+			i++
+			for {
+				if i >= len(runes) {
+					return nil, &Error{Position: start, Message: "string is missing its closing quote"}
+				}
+				if runes[i] == '\\' && i+1 < len(runes) {
+					value.WriteRune(runes[i+1])
+					i += 2
+					continue
+				}
+				if runes[i] == r {
+					i++
+					break
+				}
+				value.WriteRune(runes[i])
+				i++
+			}
+			tokens = append(tokens, token{kind: tokenString, text: string(runes[start:i]), pos: start, value: value.String()})
+		case unicode.IsDigit(r) || r == '-' || r == '.':
+			start := i
+			i++
+			for i < len(runes) && (unicode.IsDigit(runes[i]) || runes[i] == '.') {
+				i++
+			}
+			text := string(runes[start:i])
+			n, err := strconv.ParseFloat(text, 64)
+			if err != nil {
+				return nil, &Error{Position: start, Message: fmt.Sprintf("[%s] isn't a number", text)}
+			}
+			tokens = append(tokens, token{kind: tokenNumber, text: text, pos: start, value: n})
+		case unicode.IsLetter(r) || r == '_':
+			start := i
+			for i < len(runes) && (unicode.IsLetter(runes[i]) || unicode.IsDigit(runes[i]) || runes[i] == '_') {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokenIdent, text: string(runes[start:i]), pos: start})
+		default:
+			return nil, &Error{Position: i, Message: fmt.Sprintf("unexpected character [%c]", r)}
+		}
+	}
+	return append(tokens, token{kind: tokenEnd, pos: len(runes)}), nil
+}
+
+type parser struct {
+	tokens []token
+	next   int
+	fields map[string]Field
+	args   []interface{}
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.next]
+}
+
+func (p *parser) take() token {
+	t := p.tokens[p.next]
+	if t.kind != tokenEnd {
+		p.next++
+	}
+	return t
+}
+
+// or := and { OR and }
+func (p *parser) or(depth int) (string, error) {
+	left, err := p.and(depth)
+	if err != nil {
+		return "", err
+	}
+	parts := []string{left}
+	for p.peek().keyword("OR") {
+		p.take()
+		right, err := p.and(depth)
+		if err != nil {
+			return "", err
+		}
+		parts = append(parts, right)
+	}
+	if len(parts) == 1 {
+		return left, nil
+	}
+	return "(" + strings.Join(parts, " OR ") + ")", nil
+}
+
+// and := not { AND not }
+func (p *parser) and(depth int) (string, error) {
+	left, err := p.not(depth)
+	if err != nil {
+		return "", err
+	}
+	parts := []string{left}
+	for p.peek().keyword("AND") {
+		p.take()
+		right, err := p.not(depth)
+		if err != nil {
+			return "", err
+		}
+		parts = append(parts, right)
+	}
+	if len(parts) == 1 {
+		return left, nil
+	}
+	return "(" + strings.Join(parts, " AND ") + ")", nil
+}
+
+// not := NOT not | ( or ) | comparison
+func (p *parser) not(depth int) (string, error) {
+	t := p.peek()
+	if depth > maxDepth {
+		return "", &Error{Position: t.pos, Message: "too deeply nested"}
+	}
+	if t.keyword("NOT") {
+		p.take()
+		inner, err := p.not(depth + 1)
+		if err != nil {
+			return "", err
+		}
+		return "NOT (" + inner + ")", nil
+	}
+	if t.kind == tokenOpen {
+		p.take()
+		inner, err := p.or(depth + 1)
+		if err != nil {
+			return "", err
+		}
+		if c := p.take(); c.kind != tokenClose {
+			return "", &Error{Position: c.pos, Message: fmt.Sprintf("expected ) but found %s", c)}
+		}
+		// AND and OR already bracket what they join, so the brackets themselves aren't needed
+		return inner, nil
+	}
+	return p.comparison()
+}
+
+// comparison := field operator value | field IN ( value {, value} )
+func (p *parser) comparison() (string, error) {
+	name := p.take()
+	if name.kind != tokenIdent {
+		return "", &Error{Position: name.pos, Message: fmt.Sprintf("expected a field name but found %s", name)}
+	}
+	field, ok := p.fields[strings.ToLower(name.text)]
+	if !ok {
+		return "", &Error{Position: name.pos, Message: fmt.Sprintf("unknown field %s", name)}
+	}
+
+	op := p.take()
+	switch {
+	case op.kind == tokenOperator:
+		value, err := p.value(field)
+		if err != nil {
+			return "", err
+		}
+		p.args = append(p.args, value)
+		sqlOp := op.text
+		if sqlOp == "==" {
+			sqlOp = "="
+		}
+		return fmt.Sprintf("%s %s ?", field.Column, sqlOp), nil
+
+	case op.keyword("CONTAINS") || op.keyword("STARTSWITH"):
+		if field.Type != String {
+			return "", &Error{Position: op.pos, Message: fmt.Sprintf("%s only works on text fields", strings.ToUpper(op.text))}
+		}
+		value, err := p.value(field)
+		if err != nil {
+			return "", err
+		}
+		pattern := escapeLike(value.(string)) + "%"
+		if op.keyword("CONTAINS") {
+			pattern = "%" + pattern
+		}
+		p.args = append(p.args, pattern)
+		return fmt.Sprintf("%s LIKE ?", field.Column), nil
+
+	case op.keyword("IN"):
+		if t := p.take(); t.kind != tokenOpen {
+			return "", &Error{Position: t.pos, Message: fmt.Sprintf("expected ( after IN but found %s", t)}
+		}
+		placeholders := make([]string, 0)
+		for {
+			if len(placeholders) >= maxValues {
+				return "", &Error{Position: p.peek().pos, Message: fmt.Sprintf("IN can have at most %d values", maxValues)}
+			}
+			value, err := p.value(field)
+			if err != nil {
+				return "", err
+			}
+			p.args = append(p.args, value)
+			placeholders = append(placeholders, "?")
+			t := p.take()
+			if t.kind == tokenClose {
+				break
+			}
+			if t.kind != tokenComma {
+				return "", &Error{Position: t.pos, Message: fmt.Sprintf("expected , or ) but found %s", t)}
+			}
+		}
+		return fmt.Sprintf("%s IN (%s)", field.Column, strings.Join(placeholders, ", ")), nil
+	}
+	return "", &Error{Position: op.pos, Message: fmt.Sprintf("expected a comparison after %s but found %s", name, op)}
+}
+
+// value reads a literal and checks it suits the field
+func (p *parser) value(field Field) (interface{}, error) {
+	t := p.take()
+	switch field.Type {
+	case Number:
+		if t.kind != tokenNumber {
+			return nil, &Error{Position: t.pos, Message: fmt.Sprintf("expected a number but found %s", t)}
+		}
+	default:
+		if t.kind != tokenString {
+			return nil, &Error{Position: t.pos, Message: fmt.Sprintf("expected a quoted string but found %s", t)}
+		}
+	}
+	return t.value, nil
+}
+
+// escapeLike stops % and _ in a value acting as wildcards
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
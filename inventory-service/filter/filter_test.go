@@ -0,0 +1,115 @@
+package filter
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var testFields = map[string]Field{
+	"name":           {Column: "productName", Type: String},
+	"manufacturer":   {Column: "manufacturer", Type: String},
+	"quantityOnHand": {Column: "quantityOnHand", Type: Number},
+	"price":          {Column: "CAST(pricePerUnit AS DECIMAL(13,2))", Type: Number},
+}
+
+func TestCompile(t *testing.T) {
+	tests := []struct {
+		expression string
+		sql        string
+		args       []interface{}
+	}{
+		{`quantityOnHand < 10`, `quantityOnHand < ?`, []interface{}{10.0}},
+		{`QUANTITYONHAND >= -2.5`, `quantityOnHand >= ?`, []interface{}{-2.5}},
+		{`manufacturer == "acme"`, `manufacturer = ?`, []interface{}{"acme"}},
+		{`name != 'Bob\'s'`, `productName != ?`, []interface{}{"Bob's"}},
+		// AND binds tighter than OR
+		{`quantityOnHand < 10 AND manufacturer = "acme" OR price >= 20`,
+			`((quantityOnHand < ? AND manufacturer = ?) OR CAST(pricePerUnit AS DECIMAL(13,2)) >= ?)`, []interface{}{10.0, "acme", 20.0}},
+		{`quantityOnHand < 10 or manufacturer = "acme" and price >= 20`,
+			`(quantityOnHand < ? OR (manufacturer = ? AND CAST(pricePerUnit AS DECIMAL(13,2)) >= ?))`, []interface{}{10.0, "acme", 20.0}},
+		// unless brackets say otherwise
+		{`quantityOnHand < 10 AND (manufacturer = "acme" OR price >= 20)`,
+			`(quantityOnHand < ? AND (manufacturer = ? OR CAST(pricePerUnit AS DECIMAL(13,2)) >= ?))`, []interface{}{10.0, "acme", 20.0}},
+		{`((quantityOnHand = 1))`, `quantityOnHand = ?`, []interface{}{1.0}},
+		{`NOT manufacturer = "acme" AND not (price > 5 OR price < 1)`,
+			`(NOT (manufacturer = ?) AND NOT ((CAST(pricePerUnit AS DECIMAL(13,2)) > ? OR CAST(pricePerUnit AS DECIMAL(13,2)) < ?)))`, []interface{}{"acme", 5.0, 1.0}},
+		{`manufacturer IN ("acme", 'globex')`, `manufacturer IN (?, ?)`, []interface{}{"acme", "globex"}},
+		{`quantityOnHand in (1)`, `quantityOnHand IN (?)`, []interface{}{1.0}},
+		// LIKE wildcards and the escape character in a value are matched literally
+		{`name CONTAINS "50%_off\\"`, `productName LIKE ?`, []interface{}{`%50\%\_off\\%`}},
+		{`name STARTSWITH "a_b"`, `productName LIKE ?`, []interface{}{`a\_b%`}},
+	}
+	for _, test := range tests {
+		sql, args, err := Compile(test.expression, testFields)
+		if err != nil {
+			t.Errorf("Compile(%s): %v", test.expression, err)
+			continue
+		}
+		if sql != test.sql || !reflect.DeepEqual(args, test.args) {
+			t.Errorf("Compile(%s) =\n%s %#v\nwant\n%s %#v", test.expression, sql, args, test.sql, test.args)
+		}
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	inList := func(n int) string {
+		values := make([]string, n)
+		for i := range values {
+			values[i] = "1"
+		}
+		return "quantityOnHand IN (" + strings.Join(values, ", ") + ")"
+	}
+	nested := func(depth int) string {
+		return strings.Repeat("(", depth) + "quantityOnHand = 1" + strings.Repeat(")", depth)
+	}
+
+	tests := []struct {
+		expression string
+		message    string
+		position   int
+	}{
+		// only whitelisted fields can be used, whatever they look like
+		{`pricePerUnit > 1`, "unknown field [pricePerUnit]", 0},
+		{`name = "a" OR productId = 1`, "unknown field [productId]", 14},
+		{`"name" = "a"`, "expected a field name but found [\"name\"]", 0},
+		// values have to suit the field
+		{`quantityOnHand = "10"`, `expected a number but found ["10"]`, 17},
+		{`name = 10`, "expected a quoted string but found [10]", 7},
+		{`price CONTAINS "1"`, "CONTAINS only works on text fields", 6},
+		{`manufacturer IN ("acme", 2)`, "expected a quoted string but found [2]", 25},
+		// malformed expressions
+		{`name = "acme`, "string is missing its closing quote", 7},
+		{`quantityOnHand ! 1`, "expected !=", 15},
+		{`quantityOnHand = 1 AND`, "expected a field name but found end of filter", 22},
+		{`(quantityOnHand = 1`, "expected ) but found end of filter", 19},
+		{`quantityOnHand = 1)`, "unexpected [)]", 18},
+		{`quantityOnHand IN 1`, "expected ( after IN but found [1]", 18},
+		{`quantityOnHand LIKE 1`, "expected a comparison after [quantityOnHand] but found [LIKE]", 15},
+		{`name = "a"; DROP TABLE products`, "unexpected character [;]", 10},
+		// limits
+		{inList(maxValues), "", 0},
+		{inList(maxValues + 1), "IN can have at most 500 values", len("quantityOnHand IN (") + len("1, ")*maxValues},
+		{nested(maxDepth), "", 0},
+		{nested(maxDepth + 1), "too deeply nested", maxDepth + 1},
+		{strings.Repeat("NOT ", maxDepth+1) + "quantityOnHand = 1", "too deeply nested", 4 * (maxDepth + 1)},
+		{strings.Repeat(" ", MaxLength+1), "expression is longer than 2000 characters", MaxLength},
+	}
+	for _, test := range tests {
+		_, _, err := Compile(test.expression, testFields)
+		if test.message == "" {
+			if err != nil {
+				t.Errorf("Compile(%.40s...): %v", test.expression, err)
+			}
+			continue
+		}
+		filterErr, ok := err.(*Error)
+		if !ok {
+			t.Errorf("Compile(%.40s) = %v, want %q", test.expression, err, test.message)
+			continue
+		}
+		if filterErr.Message != test.message || filterErr.Position != test.position {
+			t.Errorf("Compile(%.40s) = %q at %d, want %q at %d", test.expression, filterErr.Message, filterErr.Position, test.message, test.position)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package forecast
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/jordbick/Golang/inventory-service/database"
+	"github.com/jordbick/Golang/inventory-service/ledger"
+)
+
+// DailyDemand returns the units issued on each of the given number of whole days before now, oldest first
+// days with no issues are zero, productID 0 gets the demand of every product
+// Issues are written when a product's quantity on hand is lowered through the product API, or by an adjustment with reason issue
+func DailyDemand(productID int, days int, now time.Time) (map[int][]float64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	end := now.UTC().Truncate(24 * time.Hour)
+	start := end.AddDate(0, 0, -days)
+
+	var queryBuilder strings.Builder
+	// issues are recorded as negative movements
+	queryBuilder.WriteString(`SELECT productId, DATE(createdAt), CAST(SUM(-quantity) AS SIGNED)
+	FROM stock_movements
+	WHERE reason = ? AND quantity < 0 AND createdAt >= ? AND createdAt < ?`)
+	queryArgs := []interface{}{ledger.ReasonIssue, start, end}
+	if productID != 0 {
+		queryBuilder.WriteString(` AND productId = ?`)
+		queryArgs = append(queryArgs, productID)
+	}
+	queryBuilder.WriteString(` GROUP BY productId, DATE(createdAt)`)
+	results, err := database.DbConn.QueryContext(ctx, queryBuilder.String(), queryArgs...)
+	if err != nil {
+		return nil, err
+	}
+	defer results.Close()
+	demand := make(map[int][]float64)
+	for results.Next() {
+		var id, quantity int
+		var day time.Time
+		if err := results.Scan(&id, &day, &quantity); err != nil {
+			return nil, err
+		}
+		series, ok := demand[id]
+		if !ok {
+			series = make([]float64, days)
+			demand[id] = series
+		}
+		if i := int(day.Sub(start).Hours() / 24); i >= 0 && i < days {
+			series[i] += float64(quantity)
+		}
+	}
+	return demand, results.Err()
+}
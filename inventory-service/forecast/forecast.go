@@ -0,0 +1,195 @@
+package forecast
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+// Demand forecasting from a daily demand history
+// Two methods are available, a moving average of the last few days and simple exponential smoothing
+// Either can be made seasonal, e.g. a season of 7 on daily history picks up that weekends are quieter than weekdays
+
+// Methods
+const (
+	MovingAverage        = "ma"
+	ExponentialSmoothing = "ses"
+)
+
+var ErrNotEnoughHistory = errors.New("not enough demand history to forecast")
+
+// Options control how a forecast is made
+type Options struct {
+	Method string
+	// Window is how many days the moving average covers
+	Window int
+	// Alpha is the smoothing factor for exponential smoothing, higher follows recent demand more closely
+	Alpha float64
+	// Season is the length of the seasonal cycle in days, 0 for no seasonality
+	// it needs at least two full seasons of history to be used
+	Season int
+	// Horizon is how many days ahead to forecast
+	Horizon int
+}
+
+// DefaultOptions forecasts 30 days ahead with exponential smoothing
+var DefaultOptions = Options{Method: ExponentialSmoothing, Window: 28, Alpha: 0.3, Horizon: 30}
+
+// Validate checks the options make sense
+func (o Options) Validate() error {
+	switch o.Method {
+	case MovingAverage:
+		if o.Window < 1 {
+			return fmt.Errorf("window must be at least 1 day")
+		}
+	case ExponentialSmoothing:
+		if o.Alpha <= 0 || o.Alpha > 1 {
+			return fmt.Errorf("alpha must be greater than 0 and at most 1")
+		}
+	default:
+		return fmt.Errorf("method must be %s or %s", MovingAverage, ExponentialSmoothing)
+	}
+	if o.Season < 0 || o.Season > 366 {
+		return fmt.Errorf("season must be from 0 to 366 days")
+	}
+	if o.Horizon < 1 || o.Horizon > 366 {
+		return fmt.Errorf("horizon must be from 1 to 366 days")
+	}
+	return nil
+}
+
+// Result is a forecast of daily demand
+type Result struct {
+	Method string `json:"method"`
+	// Seasonal is false if a season was asked for but there wasn't enough history to use it
+	Seasonal bool `json:"seasonal"`
+	// Daily is the expected demand for each of the next Horizon days
+	Daily []float64 `json:"daily"`
+	// Total is the expected demand over the whole horizon
+	Total float64 `json:"total"`
+	// AverageDaily is Total spread evenly over the horizon
+	AverageDaily float64 `json:"averageDaily"`
+	// StdDev is the standard deviation of the one day ahead errors over the history, used for safety stock
+	StdDev float64 `json:"stdDev"`
+}
+
+// Forecast forecasts daily demand from history, oldest day first
+func Forecast(history []float64, o Options) (Result, error) {
+	if err := o.Validate(); err != nil {
+		return Result{}, err
+	}
+	if len(history) == 0 {
+		return Result{}, ErrNotEnoughHistory
+	}
+	r := Result{Method: o.Method}
+
+	// seasonal forecasts smooth the deseasonalised demand, then put the season back in
+	indices := []float64(nil)
+	series := history
+	if o.Season > 1 && len(history) >= 2*o.Season {
+		indices = seasonalIndices(history, o.Season)
+		if indices != nil {
+			r.Seasonal = true
+			series = make([]float64, len(history))
+			for i, d := range history {
+				series[i] = d / indices[i%o.Season]
+			}
+		}
+	}
+
+	level, residuals := smooth(series, o)
+	r.StdDev = stdDev(residuals)
+	r.Daily = make([]float64, o.Horizon)
+	for i := range r.Daily {
+		d := level
+		if indices != nil {
+			d *= indices[(len(history)+i)%o.Season]
+		}
+		r.Daily[i] = round(d)
+		r.Total += d
+	}
+	r.Total = round(r.Total)
+	r.AverageDaily = round(r.Total / float64(o.Horizon))
+	r.StdDev = round(r.StdDev)
+	return r, nil
+}
+
+// smooth returns the forecast level at the end of the series, along with the one step ahead errors along the way
+func smooth(series []float64, o Options) (float64, []float64) {
+	residuals := make([]float64, 0, len(series))
+	if o.Method == MovingAverage {
+		sum := 0.0
+		for i, d := range series {
+			if i > 0 {
+				n := i
+				if n > o.Window {
+					n = o.Window
+				}
+				residuals = append(residuals, d-sum/float64(n))
+			}
+			sum += d
+			if i >= o.Window {
+				sum -= series[i-o.Window]
+			}
+		}
+		n := len(series)
+		if n > o.Window {
+			n = o.Window
+		}
+		return sum / float64(n), residuals
+	}
+	level := series[0]
+	for _, d := range series[1:] {
+		residuals = append(residuals, d-level)
+		level = o.Alpha*d + (1-o.Alpha)*level
+	}
+	return level, residuals
+}
+
+// seasonalIndices works out how each day of the season compares to the average, using whole seasons only
+// e.g. with a season of 7 an index of 1.2 means that day of the week sees 20% more demand than average
+// it returns nil if there was no demand at all to compare against
+func seasonalIndices(history []float64, season int) []float64 {
+	whole := len(history) / season * season
+	// line the seasons up with the end of the history, which is where the forecast carries on from
+	start := len(history) - whole
+	sums := make([]float64, season)
+	total := 0.0
+	for i := start; i < len(history); i++ {
+		sums[i%season] += history[i]
+		total += history[i]
+	}
+	if total <= 0 {
+		return nil
+	}
+	average := total / float64(season)
+	indices := make([]float64, season)
+	for i, sum := range sums {
+		indices[i] = sum / average
+		// a day with no demand at all would make deseasonalising divide by zero, so floor it
+		if indices[i] < 0.01 {
+			indices[i] = 0.01
+		}
+	}
+	return indices
+}
+
+func stdDev(values []float64) float64 {
+	if len(values) < 2 {
+		return 0
+	}
+	mean := 0.0
+	for _, v := range values {
+		mean += v
+	}
+	mean /= float64(len(values))
+	variance := 0.0
+	for _, v := range values {
+		variance += (v - mean) * (v - mean)
+	}
+	return math.Sqrt(variance / float64(len(values)-1))
+}
+
+func round(f float64) float64 {
+	return math.Round(f*100) / 100
+}
@@ -0,0 +1,85 @@
+package forecast
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSmooth(t *testing.T) {
+	series := []float64{2, 4, 6, 8}
+	tests := []struct {
+		o         Options
+		level     float64
+		residuals []float64
+	}{
+		// the first errors are against the average of what history there is, then of the last two days
+		{Options{Method: MovingAverage, Window: 2}, 7, []float64{2, 3, 3}},
+		{Options{Method: MovingAverage, Window: 10}, 5, []float64{2, 3, 4}},
+		{Options{Method: ExponentialSmoothing, Alpha: 0.5}, 6.25, []float64{2, 3, 3.5}},
+		{Options{Method: ExponentialSmoothing, Alpha: 1}, 8, []float64{2, 2, 2}},
+	}
+	for _, test := range tests {
+		level, residuals := smooth(series, test.o)
+		if level != test.level || !reflect.DeepEqual(residuals, test.residuals) {
+			t.Errorf("smooth(%v, %+v) = %v %v, want %v %v", series, test.o, level, residuals, test.level, test.residuals)
+		}
+	}
+}
+
+func TestSeasonalIndices(t *testing.T) {
+	tests := []struct {
+		history []float64
+		season  int
+		want    []float64
+	}{
+		{[]float64{1, 3, 1, 3}, 2, []float64{0.5, 1.5}},
+		// the odd day at the start isn't part of a whole season, so it's left out
+		{[]float64{9, 3, 1, 3, 1}, 2, []float64{0.5, 1.5}},
+		// a day that never sees demand is floored rather than zero
+		{[]float64{0, 4, 0, 4}, 2, []float64{0.01, 2}},
+		{[]float64{0, 0, 0, 0}, 2, nil},
+	}
+	for _, test := range tests {
+		if got := seasonalIndices(test.history, test.season); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("seasonalIndices(%v, %d) = %v, want %v", test.history, test.season, got, test.want)
+		}
+	}
+}
+
+func TestSuggest(t *testing.T) {
+	f := Result{Daily: []float64{10, 10, 10}, AverageDaily: 10, StdDev: 2}
+	cover := 2.5
+	tests := []struct {
+		f    Result
+		in   ReorderInput
+		want Suggestion
+	}{
+		// a 50% service level needs no safety stock, and lead time demand carries on at the average past the forecast
+		{f, ReorderInput{QuantityOnHand: 20, OnOrder: 5, LeadTimeDays: 5, ReviewDays: 2, ServiceLevel: 0.5},
+			Suggestion{LeadTimeDays: 5, LeadTimeDemand: 50, ReorderPoint: 50, Available: 25, ReorderNow: true, SuggestedQuantity: 45, DaysOfCover: &cover}},
+		// safety stock is z(0.95) * 2 * sqrt(5 + 2) = 8.7
+		{f, ReorderInput{QuantityOnHand: 20, OnOrder: 5, LeadTimeDays: 5, ReviewDays: 2, ServiceLevel: 0.95},
+			Suggestion{LeadTimeDays: 5, LeadTimeDemand: 50, SafetyStock: 8.7, ReorderPoint: 58.7, Available: 25, ReorderNow: true, SuggestedQuantity: 54, DaysOfCover: &cover}},
+		{f, ReorderInput{QuantityOnHand: 100, LeadTimeDays: 5, ServiceLevel: 0.5},
+			Suggestion{LeadTimeDays: 5, LeadTimeDemand: 50, ReorderPoint: 50, Available: 100, DaysOfCover: func() *float64 { d := 10.0; return &d }()}},
+		// no lead time falls back to the default, and no demand means no reorder and no days of cover
+		{Result{}, ReorderInput{ServiceLevel: 0.9},
+			Suggestion{LeadTimeDays: DefaultLeadTimeDays}},
+	}
+	for _, test := range tests {
+		got, err := Suggest(test.f, test.in)
+		if err != nil {
+			t.Errorf("Suggest(%+v): %v", test.in, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("Suggest(%+v) = %+v, want %+v", test.in, got, test.want)
+		}
+	}
+
+	for _, in := range []ReorderInput{{ServiceLevel: 1}, {ServiceLevel: 0.4}, {ServiceLevel: 0.9, ReviewDays: -1}} {
+		if _, err := Suggest(f, in); err == nil {
+			t.Errorf("Suggest(%+v) didn't fail", in)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package forecast
+
+import (
+	"fmt"
+	"math"
+)
+
+// Reorder suggestions use the forecast to work out how much stock is needed to cover the supplier's lead time
+// plus safety stock for the days demand is higher than forecast
+// The reorder point is the lead time demand plus safety stock, and once the stock we have and have on order drops to it
+// the suggestion is to order enough to last until the next review as well
+
+// DefaultLeadTimeDays is used for products whose supplier doesn't have a lead time
+const DefaultLeadTimeDays = 7
+
+// ReorderInput is the stock position of a product
+type ReorderInput struct {
+	QuantityOnHand int
+	// OnOrder is stock on purchase orders that haven't been received yet
+	OnOrder      int
+	LeadTimeDays int
+	// ReviewDays is how often stock is reviewed and reordered, the order has to last until the next review
+	ReviewDays int
+	// ServiceLevel is the chance of not running out during the lead time, e.g. 0.95
+	ServiceLevel float64
+}
+
+// Validate checks the service level and review period
+func (in ReorderInput) Validate() error {
+	if in.ServiceLevel < 0.5 || in.ServiceLevel >= 1 {
+		return fmt.Errorf("serviceLevel must be at least 0.5 and less than 1")
+	}
+	if in.ReviewDays < 0 || in.ReviewDays > 366 {
+		return fmt.Errorf("reviewDays must be from 0 to 366")
+	}
+	return nil
+}
+
+// Suggestion is how much of a product to reorder
+type Suggestion struct {
+	LeadTimeDays   int     `json:"leadTimeDays"`
+	LeadTimeDemand float64 `json:"leadTimeDemand"`
+	SafetyStock    float64 `json:"safetyStock"`
+	ReorderPoint   float64 `json:"reorderPoint"`
+	// Available is the stock on hand plus the stock on order
+	Available         int  `json:"available"`
+	ReorderNow        bool `json:"reorderNow"`
+	SuggestedQuantity int  `json:"suggestedQuantity"`
+	// DaysOfCover is how long the available stock lasts at the forecast demand, nil if there's no demand
+	DaysOfCover *float64 `json:"daysOfCover"`
+}
+
+// Suggest works out the reorder suggestion for a product from its forecast
+func Suggest(f Result, in ReorderInput) (Suggestion, error) {
+	if err := in.Validate(); err != nil {
+		return Suggestion{}, err
+	}
+	if in.LeadTimeDays <= 0 {
+		in.LeadTimeDays = DefaultLeadTimeDays
+	}
+	s := Suggestion{LeadTimeDays: in.LeadTimeDays, Available: in.QuantityOnHand + in.OnOrder}
+	z := math.Sqrt2 * math.Erfinv(2*in.ServiceLevel-1)
+	s.LeadTimeDemand = round(demandOver(f, in.LeadTimeDays))
+	s.SafetyStock = round(z * f.StdDev * math.Sqrt(float64(in.LeadTimeDays+in.ReviewDays)))
+	s.ReorderPoint = round(s.LeadTimeDemand + s.SafetyStock)
+	s.ReorderNow = float64(s.Available) <= s.ReorderPoint && s.ReorderPoint > 0
+	if s.ReorderNow {
+		target := demandOver(f, in.LeadTimeDays+in.ReviewDays) + s.SafetyStock
+		s.SuggestedQuantity = int(math.Ceil(target - float64(s.Available)))
+		if s.SuggestedQuantity < 0 {
+			s.SuggestedQuantity = 0
+		}
+	}
+	if f.AverageDaily > 0 {
+		cover := round(float64(s.Available) / f.AverageDaily)
+		s.DaysOfCover = &cover
+	}
+	return s, nil
+}
+
+// demandOver adds up the forecast for the next number of days, carrying on at the average past the end of the forecast
+func demandOver(f Result, days int) float64 {
+	total := 0.0
+	for i := 0; i < days; i++ {
+		if i < len(f.Daily) {
+			total += f.Daily[i]
+		} else {
+			total += f.AverageDaily
+		}
+	}
+	return total
+}
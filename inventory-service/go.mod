@@ -1,8 +1,8 @@
 module github.com/jordbick/Golang/inventory-service
 
-go 1.14
+go 1.16
 
 require (
-	github.com/go-sql-driver/mysql v1.6.0 // indirect
-	golang.org/x/net v0.0.0-20220809012201-f428fae20770 // indirect
+	github.com/go-sql-driver/mysql v1.6.0
+	golang.org/x/net v0.0.0-20220809012201-f428fae20770
 )
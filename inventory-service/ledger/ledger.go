@@ -0,0 +1,139 @@
+package ledger
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/jordbick/Golang/inventory-service/database"
+)
+
+// The ledger is an append only record of every change to stock
+// Each row is the signed change in quantity for one product at one location
+// A nil LocationID means the product's unallocated stock, i.e. stock that is on hand but hasn't been put away into a location
+// A transfer writes two rows (out of the source and into the destination) so summing a product's rows gives the net change to its stock
+
+// Reasons for a movement
+const (
+	ReasonTransfer   = "transfer"
+	ReasonAdjustment = "adjustment"
+	ReasonReceipt    = "receipt"
+	ReasonIssue      = "issue"
+)
+
+// Movement
+type Movement struct {
+	MovementID int       `json:"movementId"`
+	ProductID  int       `json:"productId"`
+	LocationID *int      `json:"locationId"`
+	Quantity   int       `json:"quantity"`
+	Reason     string    `json:"reason"`
+	Reference  string    `json:"reference"`
+	CreatedAt  time.Time `json:"createdAt"`
+}
+
+// Filter narrows down the movements returned by GetMovements, zero values are ignored
+type Filter struct {
+	ProductID  int
+	LocationID int
+	Reason     string
+	Since      time.Time
+	Limit      int
+}
+
+// ValidReason returns whether the reason is one of the known movement reasons
+func ValidReason(reason string) bool {
+	switch reason {
+	case ReasonTransfer, ReasonAdjustment, ReasonReceipt, ReasonIssue:
+		return true
+	}
+	return false
+}
+
+// Record writes a movement as part of the caller's transaction
+// so the ledger is only updated if the stock change it describes is committed
+func Record(ctx context.Context, tx *sql.Tx, movement Movement) error {
+	_, err := tx.ExecContext(ctx, `INSERT INTO stock_movements (
+	productId,
+	locationId,
+	quantity,
+	reason,
+	reference) VALUES (?, ?, ?, ?, ?)`,
+		movement.ProductID,
+		movement.LocationID,
+		movement.Quantity,
+		movement.Reason,
+		movement.Reference)
+	return err
+}
+
+// GetMovements returns movements matching the filter, newest first
+func GetMovements(filter Filter) ([]Movement, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	var queryArgs = make([]interface{}, 0)
+	var conditions = make([]string, 0)
+	if filter.ProductID != 0 {
+		conditions = append(conditions, "productId = ?")
+		queryArgs = append(queryArgs, filter.ProductID)
+	}
+	if filter.LocationID != 0 {
+		conditions = append(conditions, "locationId = ?")
+		queryArgs = append(queryArgs, filter.LocationID)
+	}
+	if filter.Reason != "" {
+		conditions = append(conditions, "reason = ?")
+		queryArgs = append(queryArgs, filter.Reason)
+	}
+	if !filter.Since.IsZero() {
+		conditions = append(conditions, "createdAt >= ?")
+		queryArgs = append(queryArgs, filter.Since.UTC())
+	}
+
+	var queryBuilder strings.Builder
+	queryBuilder.WriteString(`SELECT movementId,
+	productId,
+	locationId,
+	quantity,
+	reason,
+	reference,
+	createdAt
+	FROM stock_movements `)
+	if len(conditions) > 0 {
+		queryBuilder.WriteString("WHERE " + strings.Join(conditions, " AND ") + " ")
+	}
+	queryBuilder.WriteString("ORDER BY movementId DESC")
+	if filter.Limit > 0 {
+		queryBuilder.WriteString(" LIMIT ?")
+		queryArgs = append(queryArgs, filter.Limit)
+	}
+
+	results, err := database.DbConn.QueryContext(ctx, queryBuilder.String(), queryArgs...)
+	if err != nil {
+		return nil, err
+	}
+	defer results.Close()
+	movements := make([]Movement, 0)
+	for results.Next() {
+		var movement Movement
+		var locationID sql.NullInt64
+		err := results.Scan(&movement.MovementID,
+			&movement.ProductID,
+			&locationID,
+			&movement.Quantity,
+			&movement.Reason,
+			&movement.Reference,
+			&movement.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		if locationID.Valid {
+			id := int(locationID.Int64)
+			movement.LocationID = &id
+		}
+		movements = append(movements, movement)
+	}
+	return movements, nil
+}
@@ -0,0 +1,108 @@
+package locale
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Just enough locale support to format numbers the way people in different countries expect to read them in a spreadsheet
+// e.g. 1,234.50 in the US is 1.234,50 in Germany and 1 234,50 in France
+
+// Locale
+type Locale struct {
+	Tag       string
+	Decimal   string
+	Thousands string
+	// CSVDelimiter is ; wherever the decimal separator is a comma, which is what spreadsheet programs in those locales expect
+	CSVDelimiter rune
+}
+
+// Default is used when no supported locale is asked for
+var Default = Locale{Tag: "en-US", Decimal: ".", Thousands: ",", CSVDelimiter: ','}
+
+var locales = map[string]Locale{
+	"en":    Default,
+	"en-us": Default,
+	"en-gb": {Tag: "en-GB", Decimal: ".", Thousands: ",", CSVDelimiter: ','},
+	"de":    {Tag: "de-DE", Decimal: ",", Thousands: ".", CSVDelimiter: ';'},
+	"de-ch": {Tag: "de-CH", Decimal: ".", Thousands: "'", CSVDelimiter: ';'},
+	"fr":    {Tag: "fr-FR", Decimal: ",", Thousands: " ", CSVDelimiter: ';'},
+	"es":    {Tag: "es-ES", Decimal: ",", Thousands: ".", CSVDelimiter: ';'},
+	"it":    {Tag: "it-IT", Decimal: ",", Thousands: ".", CSVDelimiter: ';'},
+	"nl":    {Tag: "nl-NL", Decimal: ",", Thousands: ".", CSVDelimiter: ';'},
+	"pt":    {Tag: "pt-PT", Decimal: ",", Thousands: " ", CSVDelimiter: ';'},
+	"pt-br": {Tag: "pt-BR", Decimal: ",", Thousands: ".", CSVDelimiter: ';'},
+	"sv":    {Tag: "sv-SE", Decimal: ",", Thousands: " ", CSVDelimiter: ';'},
+	"ja":    {Tag: "ja-JP", Decimal: ".", Thousands: ",", CSVDelimiter: ','},
+}
+
+// Lookup finds a locale by its tag, e.g. "de-DE", falling back to just the language, e.g. "de"
+func Lookup(tag string) (Locale, bool) {
+	tag = strings.ToLower(strings.Replace(strings.TrimSpace(tag), "_", "-", -1))
+	if l, ok := locales[tag]; ok {
+		return l, true
+	}
+	if i := strings.Index(tag, "-"); i > 0 {
+		if l, ok := locales[tag[:i]]; ok {
+			return l, true
+		}
+	}
+	return Default, false
+}
+
+// Negotiate picks the best supported locale from an Accept-Language header such as "de-CH, de;q=0.9, en;q=0.8"
+func Negotiate(acceptLanguage string) Locale {
+	type choice struct {
+		tag     string
+		quality float64
+	}
+	choices := make([]choice, 0)
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		fields := strings.Split(part, ";")
+		c := choice{tag: strings.TrimSpace(fields[0]), quality: 1}
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if q, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					c.quality = q
+				}
+			}
+		}
+		if c.tag != "" && c.quality > 0 {
+			choices = append(choices, c)
+		}
+	}
+	sort.SliceStable(choices, func(i, j int) bool { return choices[i].quality > choices[j].quality })
+	for _, c := range choices {
+		if l, ok := Lookup(c.tag); ok {
+			return l
+		}
+	}
+	return Default
+}
+
+// FormatNumber formats f with the given number of decimal places and the locale's separators
+func (l Locale) FormatNumber(f float64, decimals int) string {
+	s := strconv.FormatFloat(math.Abs(f), 'f', decimals, 64)
+	whole, fraction := s, ""
+	if i := strings.Index(s, "."); i >= 0 {
+		whole, fraction = s[:i], s[i+1:]
+	}
+	var b strings.Builder
+	if f < 0 && strings.Trim(s, "0.") != "" {
+		b.WriteString("-")
+	}
+	for i, digit := range whole {
+		if i > 0 && (len(whole)-i)%3 == 0 {
+			b.WriteString(l.Thousands)
+		}
+		b.WriteRune(digit)
+	}
+	if fraction != "" {
+		b.WriteString(l.Decimal)
+		b.WriteString(fraction)
+	}
+	return b.String()
+}
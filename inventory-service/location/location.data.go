@@ -0,0 +1,339 @@
+package location
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jordbick/Golang/inventory-service/database"
+	"github.com/jordbick/Golang/inventory-service/ledger"
+)
+
+// The locations table is small, so the whole tree is loaded and the paths are worked out in Go
+func getLocationList() ([]Location, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	results, err := database.DbConn.QueryContext(ctx, `SELECT locationId,
+	parentId,
+	locationType,
+	code,
+	name
+	FROM locations
+	ORDER BY locationId`)
+	if err != nil {
+		return nil, err
+	}
+	defer results.Close()
+	locations := make([]Location, 0)
+	for results.Next() {
+		var location Location
+		var parentID sql.NullInt64
+		err := results.Scan(&location.LocationID,
+			&parentID,
+			&location.LocationType,
+			&location.Code,
+			&location.Name)
+		if err != nil {
+			return nil, err
+		}
+		if parentID.Valid {
+			id := int(parentID.Int64)
+			location.ParentID = &id
+		}
+		locations = append(locations, location)
+	}
+	buildPaths(locations)
+	return locations, nil
+}
+
+func getLocation(locationID int) (*Location, error) {
+	locations, err := getLocationList()
+	if err != nil {
+		return nil, err
+	}
+	for _, l := range locations {
+		if l.LocationID == locationID {
+			return &l, nil
+		}
+	}
+	return nil, nil
+}
+
+// Exists is used to check the location of a stock change, 0 (unallocated) is always valid
+func Exists(locationID int) (bool, error) {
+	if locationID == 0 {
+		return true, nil
+	}
+	location, err := getLocation(locationID)
+	return location != nil, err
+}
+
+// duplicateError turns a duplicate key error on a location's code into ErrDuplicateCode
+// The unique key is on COALESCE(parentId, 0) and code, so warehouses' codes are unique among themselves too
+func duplicateError(err error) error {
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) && mysqlErr.Number == 1062 && strings.Contains(mysqlErr.Message, "uq_locations_parent_code") {
+		return ErrDuplicateCode
+	}
+	return err
+}
+
+func insertLocation(location Location) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	result, err := database.DbConn.ExecContext(ctx, `INSERT INTO locations (
+	parentId,
+	locationType,
+	code,
+	name) VALUES (?, ?, ?, ?)`,
+		location.ParentID,
+		location.LocationType,
+		location.Code,
+		location.Name)
+	if err != nil {
+		return 0, duplicateError(err)
+	}
+	insertID, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	return int(insertID), nil
+}
+
+// Only the code and name can be changed, moving a location to another parent isn't supported
+func updateLocation(location Location) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	_, err := database.DbConn.ExecContext(ctx, `UPDATE locations SET
+	code=?,
+	name=?
+	WHERE locationId=?`,
+		location.Code,
+		location.Name,
+		location.LocationID)
+	return duplicateError(err)
+}
+
+// removeLocation deletes a location as long as it has no children and holds no stock
+// The location, its children and its stock levels are locked while they're checked, so a transfer or a new child location
+// can't slip in between the check and the deletes. Only empty stock levels are ever deleted
+func removeLocation(locationID int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	tx, err := database.DbConn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var id int
+	err = tx.QueryRowContext(ctx, `SELECT locationId FROM locations WHERE locationId = ? FOR UPDATE`, locationID).Scan(&id)
+	if err == sql.ErrNoRows {
+		return ErrLocationNotFound
+	} else if err != nil {
+		return err
+	}
+	var children, stock int
+	err = tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM locations WHERE parentId = ? FOR UPDATE`, locationID).Scan(&children)
+	if err != nil {
+		return err
+	}
+	err = tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM stock_levels WHERE locationId = ? AND quantity <> 0 FOR UPDATE`, locationID).Scan(&stock)
+	if err != nil {
+		return err
+	}
+	if children > 0 || stock > 0 {
+		return fmt.Errorf("location [%d] still has child locations or stock", locationID)
+	}
+	_, err = tx.ExecContext(ctx, `DELETE FROM stock_levels WHERE locationId = ? AND quantity = 0`, locationID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `DELETE FROM locations WHERE locationId = ?`, locationID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// getStockLevels returns the stock held in any of the given locations, one row per product and location
+func getStockLevels(column string, ids []int) ([]StockLevel, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	if len(ids) == 0 {
+		return make([]StockLevel, 0), nil
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(ids)), ",")
+	queryArgs := make([]interface{}, 0, len(ids))
+	for _, id := range ids {
+		queryArgs = append(queryArgs, id)
+	}
+	results, err := database.DbConn.QueryContext(ctx, `SELECT productId,
+	locationId,
+	quantity
+	FROM stock_levels
+	WHERE quantity <> 0 AND `+column+` IN (`+placeholders+`)
+	ORDER BY productId, locationId`, queryArgs...)
+	if err != nil {
+		return nil, err
+	}
+	defer results.Close()
+	levels := make([]StockLevel, 0)
+	for results.Next() {
+		var level StockLevel
+		err := results.Scan(&level.ProductID, &level.LocationID, &level.Quantity)
+		if err != nil {
+			return nil, err
+		}
+		levels = append(levels, level)
+	}
+	return levels, nil
+}
+
+// GetProductStock returns the per-location breakdown of a product's stock
+func GetProductStock(productID int) ([]StockLevel, error) {
+	levels, err := getStockLevels("productId", []int{productID})
+	if err != nil {
+		return nil, err
+	}
+	locations, err := getLocationList()
+	if err != nil {
+		return nil, err
+	}
+	paths := make(map[int]string, len(locations))
+	for _, l := range locations {
+		paths[l.LocationID] = l.Path
+	}
+	for i := range levels {
+		levels[i].Path = paths[levels[i].LocationID]
+	}
+	return levels, nil
+}
+
+// AllocatedQuantity returns how much of a product's stock is held in locations, inside the caller's transaction
+func AllocatedQuantity(ctx context.Context, tx *sql.Tx, productID int) (int, error) {
+	var allocated int
+	err := tx.QueryRowContext(ctx, `SELECT COALESCE(SUM(quantity), 0) FROM stock_levels WHERE productId = ?`, productID).Scan(&allocated)
+	return allocated, err
+}
+
+// lockProduct locks the product row for the rest of the transaction and returns its quantity on hand
+func lockProduct(ctx context.Context, tx *sql.Tx, productID int) (int, error) {
+	var quantityOnHand int
+	err := tx.QueryRowContext(ctx, `SELECT quantityOnHand FROM products WHERE productId = ? FOR UPDATE`, productID).Scan(&quantityOnHand)
+	if err == sql.ErrNoRows {
+		return 0, ErrProductNotFound
+	}
+	return quantityOnHand, err
+}
+
+// changeLocationStock adds the (signed) quantity to a product's stock at a location and writes the ledger row
+// locationID 0 is the unallocated stock, which isn't stored anywhere so only the ledger row is written
+func changeLocationStock(ctx context.Context, tx *sql.Tx, productID, locationID, quantity int, reason, reference string) error {
+	var ledgerLocation *int
+	if locationID != 0 {
+		var current int
+		err := tx.QueryRowContext(ctx, `SELECT quantity FROM stock_levels WHERE productId = ? AND locationId = ? FOR UPDATE`, productID, locationID).Scan(&current)
+		if err != nil && err != sql.ErrNoRows {
+			return err
+		}
+		if current+quantity < 0 {
+			return ErrInsufficientStock
+		}
+		_, err = tx.ExecContext(ctx, `INSERT INTO stock_levels (productId, locationId, quantity) VALUES (?, ?, ?)
+		ON DUPLICATE KEY UPDATE quantity = quantity + VALUES(quantity)`, productID, locationID, quantity)
+		if err != nil {
+			return err
+		}
+		ledgerLocation = &locationID
+	}
+	return ledger.Record(ctx, tx, ledger.Movement{
+		ProductID:  productID,
+		LocationID: ledgerLocation,
+		Quantity:   quantity,
+		Reason:     reason,
+		Reference:  reference,
+	})
+}
+
+// transferStock moves stock between two locations in a single transaction
+// The product's quantity on hand doesn't change, only where it is held
+func transferStock(transfer Transfer) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	tx, err := database.DbConn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	// Rollback is a no-op once the transaction has been committed
+	defer tx.Rollback()
+
+	quantityOnHand, err := lockProduct(ctx, tx, transfer.ProductID)
+	if err != nil {
+		return err
+	}
+	if transfer.FromLocationID == 0 {
+		allocated, err := AllocatedQuantity(ctx, tx, transfer.ProductID)
+		if err != nil {
+			return err
+		}
+		if quantityOnHand-allocated < transfer.Quantity {
+			return ErrInsufficientStock
+		}
+	}
+	err = changeLocationStock(ctx, tx, transfer.ProductID, transfer.FromLocationID, -transfer.Quantity, ledger.ReasonTransfer, transfer.Reference)
+	if err != nil {
+		return err
+	}
+	err = changeLocationStock(ctx, tx, transfer.ProductID, transfer.ToLocationID, transfer.Quantity, ledger.ReasonTransfer, transfer.Reference)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// AdjustStock changes a product's quantity on hand along with its stock at a location, in the caller's transaction
+// It's exported so other packages (e.g. goods receiving) can change stock as part of their own transactions
+func AdjustStock(ctx context.Context, tx *sql.Tx, adjustment Adjustment) error {
+	quantityOnHand, err := lockProduct(ctx, tx, adjustment.ProductID)
+	if err != nil {
+		return err
+	}
+	if quantityOnHand+adjustment.Quantity < 0 {
+		return ErrInsufficientStock
+	}
+	if adjustment.LocationID == 0 && adjustment.Quantity < 0 {
+		allocated, err := AllocatedQuantity(ctx, tx, adjustment.ProductID)
+		if err != nil {
+			return err
+		}
+		if quantityOnHand-allocated+adjustment.Quantity < 0 {
+			return ErrInsufficientStock
+		}
+	}
+	err = changeLocationStock(ctx, tx, adjustment.ProductID, adjustment.LocationID, adjustment.Quantity, adjustment.Reason, adjustment.Reference)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `UPDATE products SET quantityOnHand = quantityOnHand + ? WHERE productId = ?`, adjustment.Quantity, adjustment.ProductID)
+	return err
+}
+
+func adjustStock(adjustment Adjustment) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	tx, err := database.DbConn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	err = AdjustStock(ctx, tx, adjustment)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
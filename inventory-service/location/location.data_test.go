@@ -0,0 +1,101 @@
+package location
+
+import (
+	"database/sql/driver"
+	"strings"
+	"testing"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jordbick/Golang/inventory-service/database"
+	"github.com/jordbick/Golang/inventory-service/database/dbtest"
+)
+
+// removalDB answers removeLocation's checks for a location with children and stock, and records the statements
+type removalDB struct {
+	children, stock int64
+	queries         []string
+}
+
+func (d *removalDB) handle(query string, args []driver.Value) (dbtest.Result, error) {
+	d.queries = append(d.queries, query)
+	switch {
+	case strings.HasPrefix(query, "SELECT locationId FROM locations"):
+		return dbtest.Result{Rows: [][]driver.Value{{args[0]}}}, nil
+	case strings.Contains(query, "FROM locations WHERE parentId"):
+		return dbtest.Result{Rows: [][]driver.Value{{d.children}}}, nil
+	case strings.Contains(query, "FROM stock_levels"):
+		return dbtest.Result{Rows: [][]driver.Value{{d.stock}}}, nil
+	}
+	return dbtest.Result{RowsAffected: 1}, nil
+}
+
+func TestRemoveLocation(t *testing.T) {
+	tests := []struct {
+		children, stock int64
+		removed         bool
+	}{
+		{0, 0, true},
+		{1, 0, false},
+		{0, 2, false},
+	}
+	for _, test := range tests {
+		db := &removalDB{children: test.children, stock: test.stock}
+		database.DbConn = dbtest.Open(db.handle)
+		err := removeLocation(4)
+		if (err == nil) != test.removed {
+			t.Errorf("%d children and %d stock levels: removeLocation = %v", test.children, test.stock, err)
+		}
+		if db.queries[0] != "BEGIN" {
+			t.Errorf("%d children and %d stock levels: not in a transaction %q", test.children, test.stock, db.queries)
+		}
+		locks := 0
+		var deletes []string
+		for _, query := range db.queries {
+			if strings.HasSuffix(query, "FOR UPDATE") {
+				locks++
+			}
+			if strings.HasPrefix(query, "DELETE") {
+				deletes = append(deletes, query)
+			}
+		}
+		// the location, its children and its stock are all locked before anything is deleted
+		if locks != 3 {
+			t.Errorf("%d children and %d stock levels: %d locking reads in %q", test.children, test.stock, locks, db.queries)
+		}
+		last := db.queries[len(db.queries)-1]
+		if test.removed {
+			if len(deletes) != 2 || !strings.HasSuffix(deletes[0], "AND quantity = 0") || last != "COMMIT" {
+				t.Errorf("removed: %q", db.queries)
+			}
+		} else if len(deletes) != 0 || last != "ROLLBACK" {
+			t.Errorf("%d children and %d stock levels: %q", test.children, test.stock, db.queries)
+		}
+	}
+
+	database.DbConn = dbtest.Open(func(query string, args []driver.Value) (dbtest.Result, error) {
+		return dbtest.Result{}, nil
+	})
+	if err := removeLocation(4); err != ErrLocationNotFound {
+		t.Errorf("missing location: removeLocation = %v, want ErrLocationNotFound", err)
+	}
+}
+
+func TestDuplicateError(t *testing.T) {
+	tests := []struct {
+		err  error
+		want error
+	}{
+		{&mysql.MySQLError{Number: 1062, Message: "Duplicate entry '0-WH1' for key 'locations.uq_locations_parent_code_all'"}, ErrDuplicateCode},
+		{&mysql.MySQLError{Number: 1062, Message: "Duplicate entry '1' for key 'locations.PRIMARY'"}, nil},
+		{&mysql.MySQLError{Number: 1213, Message: "Deadlock found"}, nil},
+	}
+	for _, test := range tests {
+		want := test.want
+		if want == nil {
+			want = test.err
+		}
+		if got := duplicateError(test.err); got != want {
+			t.Errorf("duplicateError(%v) = %v, want %v", test.err, got, want)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package location
+
+import (
+	"errors"
+	"strings"
+)
+
+// Stock is kept in locations which form a tree: a warehouse contains zones and a zone contains bins
+// products.quantityOnHand stays the aggregate for each product, and stock_levels records how much of it sits in each location
+// Anything on hand that isn't in a location yet is the product's unallocated stock
+
+// Location types, from the top of the tree down
+const (
+	TypeWarehouse = "warehouse"
+	TypeZone      = "zone"
+	TypeBin       = "bin"
+)
+
+var (
+	ErrInsufficientStock = errors.New("insufficient stock at source location")
+	ErrProductNotFound   = errors.New("product not found")
+	ErrLocationNotFound  = errors.New("location not found")
+	ErrDuplicateCode     = errors.New("another location in the same place already has that code")
+)
+
+// Location
+type Location struct {
+	LocationID   int    `json:"locationId"`
+	ParentID     *int   `json:"parentId"`
+	LocationType string `json:"locationType"`
+	Code         string `json:"code"`
+	Name         string `json:"name"`
+	// Path is the codes of the location and its ancestors joined with "/", e.g. "WH1/A/01"
+	Path string `json:"path"`
+}
+
+// StockLevel is the quantity of a product held at a location
+type StockLevel struct {
+	ProductID  int    `json:"productId"`
+	LocationID int    `json:"locationId"`
+	Path       string `json:"path"`
+	Quantity   int    `json:"quantity"`
+}
+
+// Transfer moves stock of a product from one location to another
+// A location ID of 0 means the product's unallocated stock, so putting stock away is a transfer from 0
+type Transfer struct {
+	ProductID      int    `json:"productId"`
+	FromLocationID int    `json:"fromLocationId"`
+	ToLocationID   int    `json:"toLocationId"`
+	Quantity       int    `json:"quantity"`
+	Reference      string `json:"reference"`
+}
+
+// Adjustment changes the quantity on hand of a product, either at a location or in its unallocated stock
+// Quantity is signed, negative values take stock away
+type Adjustment struct {
+	ProductID  int    `json:"productId"`
+	LocationID int    `json:"locationId"`
+	Quantity   int    `json:"quantity"`
+	Reason     string `json:"reason"`
+	Reference  string `json:"reference"`
+}
+
+// parentType returns the type a location's parent must have, or "" if it must be a root
+func parentType(locationType string) (string, bool) {
+	switch locationType {
+	case TypeWarehouse:
+		return "", true
+	case TypeZone:
+		return TypeWarehouse, true
+	case TypeBin:
+		return TypeZone, true
+	}
+	return "", false
+}
+
+// buildPaths fills in the Path of every location from its ancestors' codes
+func buildPaths(locations []Location) {
+	byID := make(map[int]*Location, len(locations))
+	for i := range locations {
+		byID[locations[i].LocationID] = &locations[i]
+	}
+	for i := range locations {
+		codes := make([]string, 0, 3)
+		for l := &locations[i]; l != nil; {
+			codes = append([]string{l.Code}, codes...)
+			if l.ParentID == nil {
+				break
+			}
+			l = byID[*l.ParentID]
+		}
+		locations[i].Path = strings.Join(codes, "/")
+	}
+}
+
+// descendantIDs returns the ID of the location along with the IDs of everything below it
+func descendantIDs(locations []Location, locationID int) []int {
+	children := make(map[int][]int)
+	for _, l := range locations {
+		if l.ParentID != nil {
+			children[*l.ParentID] = append(children[*l.ParentID], l.LocationID)
+		}
+	}
+	ids := []int{locationID}
+	for i := 0; i < len(ids); i++ {
+		ids = append(ids, children[ids[i]]...)
+	}
+	return ids
+}
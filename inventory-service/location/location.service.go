@@ -0,0 +1,336 @@
+package location
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/jordbick/Golang/inventory-service/cors"
+	"github.com/jordbick/Golang/inventory-service/ledger"
+	"github.com/jordbick/Golang/inventory-service/response"
+)
+
+const locationsBasePath = "locations"
+const stockBasePath = "stock"
+
+// SetupRoutes registers the location and stock movement handlers
+func SetupRoutes(apiBasePath string) {
+	handleLocations := http.HandlerFunc(locationsHandler)
+	handleLocation := http.HandlerFunc(locationHandler)
+	handleTransfers := http.HandlerFunc(transfersHandler)
+	handleAdjustments := http.HandlerFunc(adjustmentsHandler)
+	handleMovements := http.HandlerFunc(movementsHandler)
+	http.Handle(fmt.Sprintf("%s/%s", apiBasePath, locationsBasePath), cors.Middleware(handleLocations))
+	http.Handle(fmt.Sprintf("%s/%s/", apiBasePath, locationsBasePath), cors.Middleware(handleLocation))
+	http.Handle(fmt.Sprintf("%s/%s/transfers", apiBasePath, stockBasePath), cors.Middleware(handleTransfers))
+	http.Handle(fmt.Sprintf("%s/%s/adjustments", apiBasePath, stockBasePath), cors.Middleware(handleAdjustments))
+	http.Handle(fmt.Sprintf("%s/%s/movements", apiBasePath, stockBasePath), cors.Middleware(handleMovements))
+}
+
+// stockErrorStatus maps the errors from a stock change onto a response status
+func stockErrorStatus(err error) int {
+	switch err {
+	case ErrInsufficientStock:
+		return http.StatusConflict
+	case ErrProductNotFound, ErrLocationNotFound:
+		return http.StatusNotFound
+	}
+	log.Println(err)
+	return http.StatusInternalServerError
+}
+
+// validateNewLocation checks the location type and that the parent is of the right type
+func validateNewLocation(location Location) error {
+	wantParent, ok := parentType(location.LocationType)
+	if !ok {
+		return fmt.Errorf("locationType must be one of %s, %s or %s", TypeWarehouse, TypeZone, TypeBin)
+	}
+	if strings.TrimSpace(location.Code) == "" || strings.Contains(location.Code, "/") {
+		return fmt.Errorf("code is required and can't contain '/'")
+	}
+	if wantParent == "" {
+		if location.ParentID != nil {
+			return fmt.Errorf("a %s can't have a parent", location.LocationType)
+		}
+		return nil
+	}
+	if location.ParentID == nil {
+		return fmt.Errorf("a %s must have a %s as its parent", location.LocationType, wantParent)
+	}
+	parent, err := getLocation(*location.ParentID)
+	if err != nil {
+		return err
+	}
+	if parent == nil || parent.LocationType != wantParent {
+		return fmt.Errorf("a %s must have a %s as its parent", location.LocationType, wantParent)
+	}
+	return nil
+}
+
+func locationsHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		locations, err := getLocationList()
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		// optionally filter by ?type=warehouse|zone|bin
+		if locationType := r.URL.Query().Get("type"); locationType != "" {
+			filtered := make([]Location, 0)
+			for _, l := range locations {
+				if l.LocationType == locationType {
+					filtered = append(filtered, l)
+				}
+			}
+			locations = filtered
+		}
+		response.WriteJSON(w, locations)
+
+	case http.MethodPost:
+		var newLocation Location
+		bodyBytes, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		err = json.Unmarshal(bodyBytes, &newLocation)
+		if err != nil || newLocation.LocationID != 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if err = validateNewLocation(newLocation); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		locationID, err := insertLocation(newLocation)
+		if err == ErrDuplicateCode {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		} else if err != nil {
+			log.Println(err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(fmt.Sprintf(`{"locationId":%d}`, locationID)))
+
+	case http.MethodOptions:
+		return
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// Handles /locations/{id} and /locations/{id}/stock
+func locationHandler(w http.ResponseWriter, r *http.Request) {
+	urlPathSegments := strings.Split(strings.Trim(strings.SplitN(r.URL.Path, fmt.Sprintf("/%s/", locationsBasePath), 2)[1], "/"), "/")
+	locationID, err := strconv.Atoi(urlPathSegments[0])
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if r.Method == http.MethodOptions {
+		return
+	}
+	location, err := getLocation(locationID)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if location == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if len(urlPathSegments) == 2 && urlPathSegments[1] == "stock" {
+		locationStockHandler(w, r, *location)
+		return
+	}
+	if len(urlPathSegments) != 1 {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		response.WriteJSON(w, location)
+
+	case http.MethodPut:
+		var updatedLocation Location
+		bodyBytes, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		err = json.Unmarshal(bodyBytes, &updatedLocation)
+		if err != nil || updatedLocation.LocationID != locationID {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if strings.TrimSpace(updatedLocation.Code) == "" || strings.Contains(updatedLocation.Code, "/") {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		err = updateLocation(updatedLocation)
+		if err == ErrDuplicateCode {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		} else if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+
+	case http.MethodDelete:
+		err = removeLocation(locationID)
+		if err == ErrLocationNotFound {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// locationStockHandler returns the stock held at a location and everything below it
+func locationStockHandler(w http.ResponseWriter, r *http.Request, location Location) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	locations, err := getLocationList()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	levels, err := getStockLevels("locationId", descendantIDs(locations, location.LocationID))
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	paths := make(map[int]string, len(locations))
+	for _, l := range locations {
+		paths[l.LocationID] = l.Path
+	}
+	for i := range levels {
+		levels[i].Path = paths[levels[i].LocationID]
+	}
+	response.WriteJSON(w, levels)
+}
+
+func transfersHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		var transfer Transfer
+		err := json.NewDecoder(r.Body).Decode(&transfer)
+		if err != nil || transfer.Quantity <= 0 || transfer.FromLocationID == transfer.ToLocationID {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		for _, locationID := range []int{transfer.FromLocationID, transfer.ToLocationID} {
+			exists, err := Exists(locationID)
+			if err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			if !exists {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+		}
+		err = transferStock(transfer)
+		if err != nil {
+			w.WriteHeader(stockErrorStatus(err))
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+
+	case http.MethodOptions:
+		return
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func adjustmentsHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		var adjustment Adjustment
+		err := json.NewDecoder(r.Body).Decode(&adjustment)
+		if err != nil || adjustment.Quantity == 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if adjustment.Reason == "" {
+			adjustment.Reason = ledger.ReasonAdjustment
+		}
+		// transfers have their own endpoint so the ledger always has both sides of one
+		if !ledger.ValidReason(adjustment.Reason) || adjustment.Reason == ledger.ReasonTransfer {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		exists, err := Exists(adjustment.LocationID)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if !exists {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		err = adjustStock(adjustment)
+		if err != nil {
+			w.WriteHeader(stockErrorStatus(err))
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+
+	case http.MethodOptions:
+		return
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// movementsHandler returns the ledger, filtered by ?productId=, ?locationId=, ?reason= and ?limit= (default 100)
+func movementsHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		query := r.URL.Query()
+		filter := ledger.Filter{Reason: query.Get("reason"), Limit: 100}
+		var err error
+		for key, dest := range map[string]*int{"productId": &filter.ProductID, "locationId": &filter.LocationID, "limit": &filter.Limit} {
+			if v := query.Get(key); v != "" {
+				*dest, err = strconv.Atoi(v)
+				if err != nil {
+					w.WriteHeader(http.StatusBadRequest)
+					return
+				}
+			}
+		}
+		movements, err := ledger.GetMovements(filter)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		response.WriteJSON(w, movements)
+
+	case http.MethodOptions:
+		return
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
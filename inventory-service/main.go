@@ -1,12 +1,28 @@
 package main
 
 import (
+	"context"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
+	"github.com/jordbick/Golang/inventory-service/analytics"
+	"github.com/jordbick/Golang/inventory-service/attribute"
+	"github.com/jordbick/Golang/inventory-service/blob"
+	"github.com/jordbick/Golang/inventory-service/category"
 	"github.com/jordbick/Golang/inventory-service/database"
+	"github.com/jordbick/Golang/inventory-service/location"
 	"github.com/jordbick/Golang/inventory-service/product"
+	"github.com/jordbick/Golang/inventory-service/purchaseorder"
 	"github.com/jordbick/Golang/inventory-service/receipt"
+	"github.com/jordbick/Golang/inventory-service/report"
+	"github.com/jordbick/Golang/inventory-service/supplier"
+	"github.com/jordbick/Golang/inventory-service/webhook"
 
 	// use underscore _ because we're not going to referencing the driver explicitly, just importing it for its side effects
 	// and tin this case because we need the driver in order for the Go SQL package to work with our database
@@ -20,11 +36,144 @@ const basePath = "/api"
 func main() {
 	// call the function to create our DB variable
 	database.SetupDatabase()
+	// link any products still holding a free text manufacturer to a supplier
+	err := supplier.DeduplicateManufacturers()
+	if err != nil {
+		log.Fatal(err)
+	}
+	// receipts are kept in the uploads directory unless RECEIPT_STORE is s3 (see blob.S3ConfigFromEnv for its settings),
+	// or memory for trying things out. RECEIPT_LINK_KEY signs download links, and has to be the same for every copy of the service
+	switch store := os.Getenv("RECEIPT_STORE"); store {
+	case "", "local":
+	case "s3":
+		config, err := blob.S3ConfigFromEnv()
+		if err != nil {
+			log.Fatal(err)
+		}
+		receipt.Store, err = blob.NewS3(config)
+		if err != nil {
+			log.Fatal(err)
+		}
+	case "memory":
+		receipt.Store = blob.NewMemory()
+	default:
+		log.Fatalf("RECEIPT_STORE must be local, s3 or memory, not %q", store)
+	}
+	if key := os.Getenv("RECEIPT_LINK_KEY"); key != "" {
+		receipt.LinkKey = []byte(key)
+	}
+	// receipts uploaded before they were recorded in the database are picked up from the receipt directory
+	err = receipt.ImportReceipts()
+	if err != nil {
+		log.Fatal(err)
+	}
+	// report templates are parsed once up front, rather than on every report. Custom templates can only be
+	// uploaded, replaced or deleted with REPORT_TEMPLATE_ADMIN_KEY as a bearer token
+	if key := os.Getenv("REPORT_TEMPLATE_ADMIN_KEY"); key != "" {
+		product.ReportTemplateAdminKey = []byte(key)
+	}
+	err = product.LoadReportTemplates()
+	if err != nil {
+		log.Fatal(err)
+	}
+	// RECEIPT_MAX_SIZE (bytes) and RECEIPT_ALLOWED_TYPES (comma separated) change what can be uploaded as a receipt
+	if size := os.Getenv("RECEIPT_MAX_SIZE"); size != "" {
+		receipt.MaxReceiptSize, err = strconv.ParseInt(size, 10, 64)
+		if err != nil || receipt.MaxReceiptSize < 1 {
+			log.Fatalf("RECEIPT_MAX_SIZE must be a number of bytes")
+		}
+	}
+	if types := os.Getenv("RECEIPT_ALLOWED_TYPES"); types != "" {
+		receipt.AllowedContentTypes = strings.Split(types, ",")
+		for i := range receipt.AllowedContentTypes {
+			receipt.AllowedContentTypes[i] = strings.TrimSpace(receipt.AllowedContentTypes[i])
+		}
+	}
 	product.SetupRoutes(basePath)
 	receipt.SetupRoutes(basePath)
-	err := http.ListenAndServe(":5000", nil)
+	webhook.SetupRoutes(basePath)
+	location.SetupRoutes(basePath)
+	purchaseorder.SetupRoutes(basePath)
+	supplier.SetupRoutes(basePath)
+	category.SetupRoutes(basePath)
+	attribute.SetupRoutes(basePath)
+	report.SetupRoutes(basePath)
+	analytics.SetupRoutes(basePath)
+
+	// background workers run until the shutdown channel is closed, which happens on SIGINT or SIGTERM
+	shutdown := make(chan struct{})
+	webhook.NewDispatcher().Start(shutdown)
+	report.NewScheduler().Start(shutdown)
+	// stock is snapshotted daily, SNAPSHOT_INTERVAL (e.g. "6h") changes how often
+	snapshotter := analytics.NewSnapshotter()
+	if interval := os.Getenv("SNAPSHOT_INTERVAL"); interval != "" {
+		snapshotter.Interval, err = time.ParseDuration(interval)
+		if err != nil || snapshotter.Interval < time.Minute {
+			log.Fatalf("SNAPSHOT_INTERVAL must be a duration of at least a minute, e.g. 24h")
+		}
+	}
+	snapshotter.Start(shutdown)
+	// old receipt versions and deleted receipts are removed by the retention policy, which these can change:
+	// RECEIPT_KEEP_VERSIONS (how many old versions to keep), RECEIPT_VERSION_RETENTION and RECEIPT_DELETED_RETENTION (durations)
+	// 0 turns any of them off, so old versions or deleted receipts are kept for good rather than removed straight away
+	retention := receipt.NewRetention()
+	if keep := os.Getenv("RECEIPT_KEEP_VERSIONS"); keep != "" {
+		retention.Policy.KeepVersions, err = strconv.Atoi(keep)
+		if err != nil || retention.Policy.KeepVersions < 0 {
+			log.Fatalf("RECEIPT_KEEP_VERSIONS must be a number of versions")
+		}
+	}
+	for name, dest := range map[string]*time.Duration{
+		"RECEIPT_VERSION_RETENTION": &retention.Policy.VersionMaxAge,
+		"RECEIPT_DELETED_RETENTION": &retention.Policy.DeletedMaxAge,
+	} {
+		if v := os.Getenv(name); v != "" {
+			*dest, err = time.ParseDuration(v)
+			if err != nil || *dest < 0 {
+				log.Fatalf("%s must be a duration, e.g. 720h", name)
+			}
+		}
+	}
+	retention.Start(shutdown)
+	// receipt previews are made in the background, PREVIEW_WORKERS changes how many at a time
+	if workers := os.Getenv("PREVIEW_WORKERS"); workers != "" {
+		receipt.Previews.Workers, err = strconv.Atoi(workers)
+		if err != nil || receipt.Previews.Workers < 1 {
+			log.Fatalf("PREVIEW_WORKERS must be a number of workers")
+		}
+	}
+	receipt.Previews.Start(shutdown)
+	// set REPORT_TEMPLATE_DIR to the templates directory to see changes to the built in templates without a rebuild
+	if dir := os.Getenv("REPORT_TEMPLATE_DIR"); dir != "" {
+		err = product.WatchReportTemplates(dir, shutdown)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	err = product.StartSearchIndex(shutdown)
 	if err != nil {
 		log.Fatal(err)
 	}
 
+	// on SIGINT or SIGTERM the workers are stopped and requests in progress get 30 seconds to finish
+	server := &http.Server{Addr: ":5000"}
+	stopped := make(chan struct{})
+	go func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		<-signals
+		close(shutdown)
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		if err := server.Shutdown(ctx); err != nil {
+			log.Println(err)
+		}
+		close(stopped)
+	}()
+	err = server.ListenAndServe()
+	if err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
+	<-stopped
+
 }
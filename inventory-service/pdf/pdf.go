@@ -0,0 +1,177 @@
+package pdf
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// A small PDF writer, just enough for printable labels and reports
+// Pages are drawn with filled rectangles, lines and text in the standard Helvetica fonts, which every PDF reader has built in so nothing needs embedding
+// Coordinates are in points (1/72 inch) measured from the top left corner of the page, unlike PDF itself which starts at the bottom left
+
+// Page sizes in points
+const (
+	LetterWidth  = 612.0
+	LetterHeight = 792.0
+	A4Width      = 595.28
+	A4Height     = 841.89
+)
+
+// Fonts
+const (
+	Helvetica     = "F1"
+	HelveticaBold = "F2"
+)
+
+// Document
+type Document struct {
+	width  float64
+	height float64
+	pages  []*Page
+}
+
+// Page
+type Page struct {
+	doc     *Document
+	content bytes.Buffer
+}
+
+// New returns an empty document whose pages are width x height points
+func New(width, height float64) *Document {
+	return &Document{width: width, height: height}
+}
+
+// AddPage adds a blank page to the end of the document and returns it
+func (d *Document) AddPage() *Page {
+	p := &Page{doc: d}
+	d.pages = append(d.pages, p)
+	return p
+}
+
+// Size returns the page width and height
+func (d *Document) Size() (float64, float64) {
+	return d.width, d.height
+}
+
+// SetGray sets the fill and stroke colour, 0 is black and 1 is white
+func (p *Page) SetGray(gray float64) {
+	fmt.Fprintf(&p.content, "%s g %s G\n", num(gray), num(gray))
+}
+
+// Rect fills a rectangle whose top left corner is at x, y
+func (p *Page) Rect(x, y, width, height float64) {
+	fmt.Fprintf(&p.content, "%s %s %s %s re f\n", num(x), num(p.doc.height-y-height), num(width), num(height))
+}
+
+// Line strokes a line from x1, y1 to x2, y2
+func (p *Page) Line(x1, y1, x2, y2, lineWidth float64) {
+	fmt.Fprintf(&p.content, "%s w %s %s m %s %s l S\n", num(lineWidth), num(x1), num(p.doc.height-y1), num(x2), num(p.doc.height-y2))
+}
+
+// Text draws a single line of text with its baseline at y
+func (p *Page) Text(x, y float64, font string, size float64, text string) {
+	fmt.Fprintf(&p.content, "BT /%s %s Tf %s %s Td (%s) Tj ET\n", font, num(size), num(x), num(p.doc.height-y), escape(text))
+}
+
+// Write writes the whole document out
+func (d *Document) Write(w io.Writer) error {
+	pages := d.pages
+	if len(pages) == 0 {
+		pages = []*Page{{doc: d}}
+	}
+
+	// objects 1 and 2 are the catalog and page tree, 3 and 4 the fonts, then each page is followed by its content stream
+	var out bytes.Buffer
+	offsets := make([]int, 0, 4+2*len(pages))
+	startObject := func() int {
+		offsets = append(offsets, out.Len())
+		fmt.Fprintf(&out, "%d 0 obj\n", len(offsets))
+		return len(offsets)
+	}
+
+	out.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+	startObject()
+	out.WriteString("<< /Type /Catalog /Pages 2 0 R >>\nendobj\n")
+	kids := make([]string, len(pages))
+	for i := range pages {
+		kids[i] = fmt.Sprintf("%d 0 R", 5+2*i)
+	}
+	startObject()
+	fmt.Fprintf(&out, "<< /Type /Pages /Kids [%s] /Count %d /MediaBox [0 0 %s %s] >>\nendobj\n",
+		strings.Join(kids, " "), len(pages), num(d.width), num(d.height))
+	startObject()
+	out.WriteString("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>\nendobj\n")
+	startObject()
+	out.WriteString("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>\nendobj\n")
+
+	for _, page := range pages {
+		pageObject := startObject()
+		fmt.Fprintf(&out, "<< /Type /Page /Parent 2 0 R /Resources << /Font << /F1 3 0 R /F2 4 0 R >> >> /Contents %d 0 R >>\nendobj\n", pageObject+1)
+
+		var compressed bytes.Buffer
+		zw := zlib.NewWriter(&compressed)
+		if _, err := zw.Write(page.content.Bytes()); err != nil {
+			return err
+		}
+		if err := zw.Close(); err != nil {
+			return err
+		}
+		startObject()
+		fmt.Fprintf(&out, "<< /Length %d /Filter /FlateDecode >>\nstream\n", compressed.Len())
+		out.Write(compressed.Bytes())
+		out.WriteString("\nendstream\nendobj\n")
+	}
+
+	xref := out.Len()
+	fmt.Fprintf(&out, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&out, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&out, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+
+	_, err := out.WriteTo(w)
+	return err
+}
+
+// num formats a coordinate without trailing zeros
+func num(f float64) string {
+	s := strings.TrimRight(fmt.Sprintf("%.2f", f), "0")
+	return strings.TrimSuffix(s, ".")
+}
+
+// winAnsiCodes is winAnsi the other way round, the byte the fonts' WinAnsiEncoding draws each of those characters with
+var winAnsiCodes = func() map[rune]byte {
+	codes := make(map[rune]byte, len(winAnsi))
+	for c, r := range winAnsi {
+		codes[r] = c
+	}
+	return codes
+}()
+
+// escape makes text safe to put in a PDF string, encoded as WinAnsi for the fonts
+// Latin-1 is the same in WinAnsi apart from 0x80 to 0x9f, where WinAnsi has characters such as € and curly quotes instead,
+// anything WinAnsi doesn't have is replaced with '?'
+func escape(text string) string {
+	var b strings.Builder
+	for _, r := range text {
+		switch {
+		case r == '\\' || r == '(' || r == ')':
+			b.WriteRune('\\')
+			b.WriteRune(r)
+		case r < 32:
+			b.WriteRune(' ')
+		case r < 0x80 || r >= 0xa0 && r < 256:
+			b.WriteByte(byte(r))
+		default:
+			if c, ok := winAnsiCodes[r]; ok {
+				b.WriteByte(c)
+			} else {
+				b.WriteByte('?')
+			}
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,46 @@
+package pdf
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestEscape(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"Widget (large) \\ 2", "Widget \\(large\\) \\\\ 2"},
+		{"tab\there", "tab here"},
+		// Latin-1 letters are the same in WinAnsi
+		{"Café Ø", "Caf\xe9 \xd8"},
+		// WinAnsi puts these where Latin-1 has control characters
+		{"€5 – Bob’s “best” …", "\x805 \x96 Bob\x92s \x93best\x94 \x85"},
+		// the C1 control characters themselves aren't passed through, and nor is anything WinAnsi doesn't have
+		{"a\u0080b\u009fc", "a?b?c"},
+		{"日本 ✓", "?? ?"},
+	}
+	for _, test := range tests {
+		if got := escape(test.text); got != test.want {
+			t.Errorf("escape(%q) = %q, want %q", test.text, got, test.want)
+		}
+	}
+}
+
+// text outside of ASCII comes back out of the PDF as the same characters
+func TestWinAnsiRoundTrip(t *testing.T) {
+	text := "€12.50 – Café “Special” ™"
+	doc := New(A4Width, A4Height)
+	doc.AddPage().Text(72, 72, Helvetica, 10, text)
+	var b bytes.Buffer
+	if err := doc.Write(&b); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ExtractText(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != text {
+		t.Errorf("got %q, want %q", got, text)
+	}
+}
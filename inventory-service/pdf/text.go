@@ -0,0 +1,446 @@
+package pdf
+
+import (
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+// Reading the text back out of a PDF, enough for receipts and invoices printed by accounting and point of sale software
+// Page content streams are found by scanning the file rather than walking the page tree, so text comes out in the order
+// the streams are stored, which is page order for nearly every PDF. Strings are read as WinAnsi, or UTF-16 when they start with a
+// byte order mark. Fonts with their own encodings (CID fonts and ToUnicode maps) aren't decoded, so scanned PDFs and some
+// generated ones give little or no text, and have to go through OCR instead
+
+var ErrNotPDF = errors.New("not a PDF")
+
+// MaxTextLength stops extraction from a large or unusual PDF going on forever, in bytes of text
+var MaxTextLength = 1 << 20
+
+// streamPattern finds the dictionary in front of each stream
+var streamPattern = regexp.MustCompile(`(?s)obj\s*(<<.*?>>)\s*stream\r?\n`)
+
+var lengthPattern = regexp.MustCompile(`/Length\s+(\d+)(\s+\d+\s+R)?`)
+
+// mixedFilterPattern finds a filter array with Flate and something else, which can't be read
+var mixedFilterPattern = regexp.MustCompile(`/Filter\s*\[[^\]]*/FlateDecode\s*/`)
+
+// ExtractText returns the text drawn on a PDF's pages, a line for each line of text
+func ExtractText(r io.Reader) (string, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return "", err
+	}
+	if !bytes.HasPrefix(bytes.TrimLeft(data, "\x00\t\r\n "), []byte("%PDF-")) {
+		return "", ErrNotPDF
+	}
+	var text textWriter
+	for _, match := range streamPattern.FindAllSubmatchIndex(data, -1) {
+		dict := string(data[match[2]:match[3]])
+		if !contentStream(dict) {
+			continue
+		}
+		start := match[1]
+		end := -1
+		// a direct length is trusted, one that refers to another object means looking for endstream instead
+		if m := lengthPattern.FindStringSubmatch(dict); m != nil && m[2] == "" {
+			if n, err := strconv.Atoi(m[1]); err == nil && start+n <= len(data) {
+				end = start + n
+			}
+		}
+		if end < 0 {
+			i := bytes.Index(data[start:], []byte("endstream"))
+			if i < 0 {
+				continue
+			}
+			end = start + i
+		}
+		content := data[start:end]
+		if strings.Contains(dict, "/FlateDecode") {
+			zr, err := zlib.NewReader(bytes.NewReader(content))
+			if err != nil {
+				continue
+			}
+			// keep whatever came out of a stream that's damaged towards the end
+			content, _ = ioutil.ReadAll(io.LimitReader(zr, int64(MaxTextLength)*16))
+			zr.Close()
+		}
+		text.newLine()
+		readContent(content, &text)
+		if text.b.Len() > MaxTextLength {
+			break
+		}
+	}
+	return strings.TrimSpace(text.String()), nil
+}
+
+// contentStream skips the streams that aren't page content: images, fonts, metadata, cross reference and object streams,
+// and anything compressed some other way
+func contentStream(dict string) bool {
+	for _, skip := range []string{"/Image", "/Length1", "/Length2", "/Length3", "/FontFile", "/Metadata", "/XRef", "/ObjStm", "/DCTDecode", "/JPXDecode", "/CCITTFaxDecode", "/JBIG2Decode", "/LZWDecode"} {
+		if strings.Contains(dict, skip) {
+			return false
+		}
+	}
+	if strings.Contains(dict, "/Filter") && !strings.Contains(dict, "/FlateDecode") {
+		return false
+	}
+	return !mixedFilterPattern.MatchString(dict)
+}
+
+// textWriter puts text shown at the same height on the same line
+type textWriter struct {
+	b     strings.Builder
+	lastY float64
+	// moved is set when the text position changes, so the next string starts with a space or a new line
+	moved   bool
+	started bool
+}
+
+func (t *textWriter) newLine() {
+	if t.b.Len() > 0 && !strings.HasSuffix(t.b.String(), "\n") {
+		t.b.WriteByte('\n')
+	}
+	t.started = false
+}
+
+func (t *textWriter) show(s string, y float64) {
+	if s == "" {
+		return
+	}
+	if t.started && math.Abs(y-t.lastY) > 1 {
+		t.newLine()
+	} else if t.started && t.moved && !strings.HasSuffix(t.b.String(), " ") && !strings.HasPrefix(s, " ") {
+		t.b.WriteByte(' ')
+	}
+	t.b.WriteString(s)
+	t.lastY = y
+	t.moved = false
+	t.started = true
+}
+
+func (t *textWriter) String() string {
+	return t.b.String()
+}
+
+// readContent runs through a content stream's operators, keeping track of the text position just well enough to lay the text out in lines
+func readContent(content []byte, text *textWriter) {
+	var operands []interface{}
+	var y, leading float64
+	lex := lexer{data: content}
+	for {
+		token, ok := lex.next()
+		if !ok {
+			return
+		}
+		op, isOp := token.(operator)
+		if !isOp {
+			operands = append(operands, token)
+			continue
+		}
+		switch op {
+		case "BT":
+			y = 0
+			text.moved = true
+		case "Td", "TD":
+			if len(operands) >= 2 {
+				ty := number(operands[len(operands)-1])
+				y += ty
+				if op == "TD" {
+					leading = -ty
+				}
+			}
+			text.moved = true
+		case "Tm":
+			if len(operands) >= 6 {
+				y = number(operands[len(operands)-1])
+			}
+			text.moved = true
+		case "TL":
+			if len(operands) >= 1 {
+				leading = number(operands[len(operands)-1])
+			}
+		case "T*":
+			y -= leading
+			text.moved = true
+		case "Tj":
+			if len(operands) >= 1 {
+				text.show(decodeString(operands[len(operands)-1]), y)
+			}
+		case "'", "\"":
+			y -= leading
+			text.moved = true
+			if len(operands) >= 1 {
+				text.show(decodeString(operands[len(operands)-1]), y)
+			}
+		case "TJ":
+			if len(operands) >= 1 {
+				if array, ok := operands[len(operands)-1].([]interface{}); ok {
+					var s strings.Builder
+					for _, item := range array {
+						if str, ok := item.(pdfString); ok {
+							s.WriteString(decodeString(str))
+						} else if n := number(item); n < -200 {
+							// a big enough gap between strings is a space between words
+							s.WriteByte(' ')
+						}
+					}
+					text.show(s.String(), y)
+				}
+			}
+		}
+		operands = operands[:0]
+	}
+}
+
+// number is an operand as a float, or 0 if it isn't a number
+func number(v interface{}) float64 {
+	if f, ok := v.(float64); ok {
+		return f
+	}
+	return 0
+}
+
+// winAnsi is where the Windows-1252 characters in 0x80 to 0x9f differ from Latin-1
+var winAnsi = map[byte]rune{
+	0x80: '€', 0x82: '‚', 0x83: 'ƒ', 0x84: '„', 0x85: '…', 0x86: '†', 0x87: '‡', 0x88: 'ˆ', 0x89: '‰', 0x8a: 'Š', 0x8b: '‹', 0x8c: 'Œ',
+	0x8e: 'Ž', 0x91: '‘', 0x92: '’', 0x93: '“', 0x94: '”', 0x95: '•', 0x96: '–', 0x97: '—', 0x98: '˜', 0x99: '™', 0x9a: 'š', 0x9b: '›',
+	0x9c: 'œ', 0x9e: 'ž', 0x9f: 'Ÿ',
+}
+
+// decodeString turns a string operand into text
+func decodeString(v interface{}) string {
+	s, ok := v.(pdfString)
+	if !ok {
+		return ""
+	}
+	if len(s) >= 2 && s[0] == 0xfe && s[1] == 0xff {
+		units := make([]uint16, 0, len(s)/2)
+		for i := 2; i+1 < len(s); i += 2 {
+			units = append(units, uint16(s[i])<<8|uint16(s[i+1]))
+		}
+		return string(utf16.Decode(units))
+	}
+	var b strings.Builder
+	for _, c := range []byte(s) {
+		switch {
+		case c < 0x20:
+			b.WriteByte(' ')
+		case c >= 0x80 && c <= 0x9f:
+			if r, ok := winAnsi[c]; ok {
+				b.WriteRune(r)
+			}
+		default:
+			b.WriteRune(rune(c))
+		}
+	}
+	return b.String()
+}
+
+// the kinds of token in a content stream, besides numbers (float64) and arrays ([]interface{})
+type (
+	operator  string
+	name      string
+	pdfString string
+)
+
+// lexer splits a content stream into operands and operators
+type lexer struct {
+	data []byte
+	pos  int
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\r' || c == '\n' || c == '\f' || c == 0
+}
+
+func isDelimiter(c byte) bool {
+	return strings.IndexByte("()<>[]{}/%", c) >= 0
+}
+
+// next returns the next token, or false at the end of the stream
+func (l *lexer) next() (interface{}, bool) {
+	for l.pos < len(l.data) {
+		c := l.data[l.pos]
+		switch {
+		case isSpace(c):
+			l.pos++
+		case c == '%':
+			for l.pos < len(l.data) && l.data[l.pos] != '\n' && l.data[l.pos] != '\r' {
+				l.pos++
+			}
+		case c == '(':
+			l.pos++
+			return l.literal(), true
+		case c == '<' && l.pos+1 < len(l.data) && l.data[l.pos+1] == '<':
+			// dictionaries only turn up as operands to marked content and inline images, they're skipped over
+			l.skipDict()
+		case c == '<':
+			l.pos++
+			return l.hex(), true
+		case c == '[':
+			l.pos++
+			var array []interface{}
+			for {
+				token, ok := l.next()
+				if !ok {
+					return array, true
+				}
+				if token == operator("]") {
+					return array, true
+				}
+				array = append(array, token)
+			}
+		case c == ']':
+			l.pos++
+			return operator("]"), true
+		case c == '/':
+			start := l.pos + 1
+			l.pos++
+			for l.pos < len(l.data) && !isSpace(l.data[l.pos]) && !isDelimiter(l.data[l.pos]) {
+				l.pos++
+			}
+			return name(l.data[start:l.pos]), true
+		default:
+			start := l.pos
+			for l.pos < len(l.data) && !isSpace(l.data[l.pos]) && !isDelimiter(l.data[l.pos]) {
+				l.pos++
+			}
+			if l.pos == start {
+				// a stray delimiter such as > or }
+				l.pos++
+				continue
+			}
+			word := string(l.data[start:l.pos])
+			if f, err := strconv.ParseFloat(word, 64); err == nil {
+				return f, true
+			}
+			if word == "BI" {
+				l.skipInlineImage()
+				continue
+			}
+			return operator(word), true
+		}
+	}
+	return nil, false
+}
+
+// literal reads a (string), which can have balanced brackets and backslash escapes in it
+func (l *lexer) literal() pdfString {
+	var b []byte
+	depth := 1
+	for l.pos < len(l.data) {
+		c := l.data[l.pos]
+		l.pos++
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return pdfString(b)
+			}
+		case '\\':
+			if l.pos >= len(l.data) {
+				return pdfString(b)
+			}
+			c = l.data[l.pos]
+			l.pos++
+			switch c {
+			case 'n':
+				c = '\n'
+			case 'r':
+				c = '\r'
+			case 't':
+				c = '\t'
+			case 'b':
+				c = '\b'
+			case 'f':
+				c = '\f'
+			case '\r', '\n':
+				// a backslash at the end of a line continues the string on the next
+				if c == '\r' && l.pos < len(l.data) && l.data[l.pos] == '\n' {
+					l.pos++
+				}
+				continue
+			default:
+				if c >= '0' && c <= '7' {
+					n := int(c - '0')
+					for i := 0; i < 2 && l.pos < len(l.data) && l.data[l.pos] >= '0' && l.data[l.pos] <= '7'; i++ {
+						n = n*8 + int(l.data[l.pos]-'0')
+						l.pos++
+					}
+					c = byte(n)
+				}
+			}
+		}
+		b = append(b, c)
+	}
+	return pdfString(b)
+}
+
+// hex reads a <hex string>, an odd digit at the end counts as followed by 0
+func (l *lexer) hex() pdfString {
+	var b []byte
+	var digits []byte
+	for l.pos < len(l.data) {
+		c := l.data[l.pos]
+		l.pos++
+		if c == '>' {
+			break
+		}
+		if v, err := strconv.ParseUint(string(c), 16, 8); err == nil {
+			digits = append(digits, byte(v))
+		}
+	}
+	if len(digits)%2 == 1 {
+		digits = append(digits, 0)
+	}
+	for i := 0; i < len(digits); i += 2 {
+		b = append(b, digits[i]<<4|digits[i+1])
+	}
+	return pdfString(b)
+}
+
+// skipDict moves past a << >> dictionary, which can have other dictionaries inside it
+func (l *lexer) skipDict() {
+	depth := 0
+	for l.pos+1 < len(l.data) {
+		switch {
+		case l.data[l.pos] == '<' && l.data[l.pos+1] == '<':
+			depth++
+			l.pos += 2
+		case l.data[l.pos] == '>' && l.data[l.pos+1] == '>':
+			depth--
+			l.pos += 2
+			if depth == 0 {
+				return
+			}
+		case l.data[l.pos] == '(':
+			l.pos++
+			l.literal()
+		default:
+			l.pos++
+		}
+	}
+	l.pos = len(l.data)
+}
+
+// skipInlineImage moves past an inline image's data, up to its EI operator
+func (l *lexer) skipInlineImage() {
+	for l.pos+2 < len(l.data) {
+		if isSpace(l.data[l.pos]) && l.data[l.pos+1] == 'E' && l.data[l.pos+2] == 'I' && (l.pos+3 == len(l.data) || isSpace(l.data[l.pos+3])) {
+			l.pos += 3
+			return
+		}
+		l.pos++
+	}
+	l.pos = len(l.data)
+}
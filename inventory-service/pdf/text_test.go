@@ -0,0 +1,113 @@
+package pdf
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// rawPDF wraps content streams in just enough of a PDF for ExtractText, each stream is given with its dictionary entries
+func rawPDF(streams ...[2]string) []byte {
+	var b bytes.Buffer
+	b.WriteString("%PDF-1.4\n")
+	for i, s := range streams {
+		fmt.Fprintf(&b, "%d 0 obj\n<< %s /Length %d >>\nstream\n%s\nendstream\nendobj\n", i+1, s[0], len(s[1]), s[1])
+	}
+	b.WriteString("%%EOF\n")
+	return b.Bytes()
+}
+
+func deflate(s string) string {
+	var b bytes.Buffer
+	zw := zlib.NewWriter(&b)
+	zw.Write([]byte(s))
+	zw.Close()
+	return b.String()
+}
+
+func TestExtractText(t *testing.T) {
+	tests := []struct {
+		name string
+		pdf  []byte
+		want string
+	}{
+		{
+			name: "strings on the same line are joined, lower lines start new ones",
+			pdf:  rawPDF([2]string{"", "BT /F1 10 Tf 72 700 Td (Widget) Tj 200 0 Td (12.50) Tj ET BT 72 686 Td (Total 12.50) Tj ET"}),
+			want: "Widget 12.50\nTotal 12.50",
+		},
+		{
+			name: "compressed stream",
+			pdf:  rawPDF([2]string{"/Filter /FlateDecode", deflate("BT 72 700 Td (ACME Supplies) Tj ET")}),
+			want: "ACME Supplies",
+		},
+		{
+			name: "TJ gaps, T* and the quote operator",
+			pdf:  rawPDF([2]string{"", "BT 14 TL 72 700 Td [(Sub)-50(total)-1000(9.99)] TJ T* (Tax 0.80) Tj (Total 10.79) ' ET"}),
+			want: "Subtotal 9.99\nTax 0.80\nTotal 10.79",
+		},
+		{
+			name: "escapes, WinAnsi and UTF-16",
+			pdf:  rawPDF([2]string{"", `BT 72 700 Td (Caf\351 \(2\) \200 5.00) Tj 0 -14 Td <FEFF004E00E4006800740065> Tj ET`}),
+			want: "Café (2) € 5.00\nNähte",
+		},
+		{
+			name: "image and font streams are skipped",
+			pdf: rawPDF(
+				[2]string{"/Subtype /Image /Width 1 /Height 1", "BT (not text) Tj ET"},
+				[2]string{"/Length1 10", "BT (font program) Tj ET"},
+				[2]string{"", "BT 72 700 Td (Invoice) Tj ET"},
+			),
+			want: "Invoice",
+		},
+		{
+			name: "each stream starts a new line",
+			pdf:  rawPDF([2]string{"", "BT 72 700 Td (Page one) Tj ET"}, [2]string{"", "BT 72 700 Td (Page two) Tj ET"}),
+			want: "Page one\nPage two",
+		},
+	}
+	for _, test := range tests {
+		got, err := ExtractText(bytes.NewReader(test.pdf))
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("%s: got %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+// text written by the package's own writer comes back out in reading order
+func TestExtractTextRoundTrip(t *testing.T) {
+	doc := New(LetterWidth, LetterHeight)
+	page := doc.AddPage()
+	page.Text(72, 72, HelveticaBold, 14, "Stock report (March)")
+	page.Text(72, 100, Helvetica, 10, "WID-100")
+	page.Text(200, 100, Helvetica, 10, "Widget")
+	page.Text(72, 114, Helvetica, 10, "GAD-200")
+	page.Text(200, 114, Helvetica, 10, "Gadget")
+	doc.AddPage().Text(72, 72, Helvetica, 10, "Page 2")
+	var b bytes.Buffer
+	if err := doc.Write(&b); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ExtractText(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "Stock report (March)\nWID-100 Widget\nGAD-200 Gadget\nPage 2"
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestExtractTextNotPDF(t *testing.T) {
+	for _, data := range []string{"", "hello", "<html>%PDF-1.4</html>"} {
+		if _, err := ExtractText(strings.NewReader(data)); err != ErrNotPDF {
+			t.Errorf("ExtractText(%q) = %v, want ErrNotPDF", data, err)
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package product
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jordbick/Golang/inventory-service/barcode"
+	"github.com/jordbick/Golang/inventory-service/category"
+	"github.com/jordbick/Golang/inventory-service/filter"
+)
+
+// Barcodes and printable labels for products
+// The UPC is drawn as a UPC-A or EAN-13 and the SKU as a Code 128, by default the UPC is used if the product has one
+
+// productBarcode encodes the product's UPC or SKU depending on the symbology, or picks one if symbology is empty
+func productBarcode(product Product, symbology string) (barcode.Barcode, error) {
+	switch symbology {
+	case "":
+		if strings.TrimSpace(product.Upc) != "" {
+			if code, err := barcode.Encode(barcode.UPCA, product.Upc); err == nil {
+				return code, nil
+			}
+		}
+		return barcode.Encode(barcode.Code128, product.Sku)
+	case barcode.UPCA, barcode.EAN13:
+		return barcode.Encode(symbology, product.Upc)
+	}
+	return barcode.Encode(symbology, product.Sku)
+}
+
+// barcodeOptions reads ?moduleWidth=, ?height= and ?text= from the query string
+func barcodeOptions(r *http.Request) (barcode.Options, error) {
+	options := barcode.DefaultOptions
+	query := r.URL.Query()
+	var err error
+	if v := query.Get("moduleWidth"); v != "" {
+		options.ModuleWidth, err = strconv.Atoi(v)
+		if err != nil || options.ModuleWidth < 1 || options.ModuleWidth > 10 {
+			return options, errors.New("moduleWidth must be between 1 and 10")
+		}
+	}
+	if v := query.Get("height"); v != "" {
+		options.Height, err = strconv.Atoi(v)
+		if err != nil || options.Height < 10 || options.Height > 1000 {
+			return options, errors.New("height must be between 10 and 1000")
+		}
+	}
+	if v := query.Get("text"); v != "" {
+		options.ShowText, err = strconv.ParseBool(v)
+		if err != nil {
+			return options, errors.New("text must be true or false")
+		}
+	}
+	return options, nil
+}
+
+// barcodeHandler handles /products/{id}/barcode?type=upca|ean13|code128&format=png|svg
+func barcodeHandler(w http.ResponseWriter, r *http.Request, id string) {
+	productID, err := strconv.Atoi(id)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		product, err := getProduct(productID)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if product == nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		options, err := barcodeOptions(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		code, err := productBarcode(*product, r.URL.Query().Get("type"))
+		if err != nil {
+			// the product's UPC or SKU isn't something the symbology can hold
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		var image bytes.Buffer
+		switch r.URL.Query().Get("format") {
+		case "", "png":
+			w.Header().Set("Content-Type", "image/png")
+			err = code.WritePNG(&image, options)
+		case "svg":
+			w.Header().Set("Content-Type", "image/svg+xml")
+			err = code.WriteSVG(&image, options)
+		default:
+			http.Error(w, "format must be png or svg", http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			log.Println(err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Write(image.Bytes())
+
+	case http.MethodOptions:
+		return
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// handleProductLabels takes the same filter as the report and returns a PDF sheet of labels for the matching products
+func handleProductLabels(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		var productFilter ProductReportFilter
+		err := json.NewDecoder(r.Body).Decode(&productFilter)
+		if err != nil {
+			log.Println(err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		products, err := searchForProductData(productFilter)
+		var filterErr *filter.Error
+		if err == category.ErrCategoryNotFound {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		} else if errors.As(err, &filterErr) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		} else if err != nil {
+			log.Println(err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		symbology := r.URL.Query().Get("type")
+		labels := make([]barcode.Label, 0, len(products))
+		for _, product := range products {
+			code, err := productBarcode(product, symbology)
+			if err != nil {
+				// one bad code shouldn't stop the rest of the sheet printing
+				log.Printf("no label for product %d: %v", product.ProductID, err)
+				continue
+			}
+			labels = append(labels, barcode.Label{Title: product.ProductName, Barcode: code})
+		}
+		if len(labels) == 0 {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		var sheet bytes.Buffer
+		err = barcode.WriteLabelSheet(&sheet, labels)
+		if err != nil {
+			log.Println(err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Disposition", "Attachment")
+		w.Header().Set("Content-Type", "application/pdf")
+		http.ServeContent(w, r, "labels.pdf", time.Now(), bytes.NewReader(sheet.Bytes()))
+
+	case http.MethodOptions:
+		return
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
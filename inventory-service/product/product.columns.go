@@ -0,0 +1,197 @@
+package product
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/jordbick/Golang/inventory-service/locale"
+)
+
+// Reports can be produced as HTML, CSV, XLSX or PDF with a choice of columns and sort order
+// This is what saved searches and scheduled reports run, as well as the report endpoint
+
+// Report formats
+const (
+	ReportFormatHTML = "html"
+	ReportFormatCSV  = "csv"
+	ReportFormatXLSX = "xlsx"
+	ReportFormatPDF  = "pdf"
+)
+
+// columnKind decides how a column's values are formatted in spreadsheets
+type columnKind int
+
+const (
+	textColumn columnKind = iota
+	// idColumn is a number that shouldn't get thousands separators
+	idColumn
+	integerColumn
+	decimalColumn
+)
+
+// reportColumn is a column that can be picked for a report
+type reportColumn struct {
+	name   string
+	header string
+	kind   columnKind
+	// sortSQL is what the report query orders by when sorting on this column
+	sortSQL string
+	// total columns are summed in a totals row at the bottom of spreadsheet reports
+	total bool
+	value func(p Product) string
+}
+
+var reportColumns = []reportColumn{
+	{name: "productId", header: "Product ID", kind: idColumn, sortSQL: "productId", value: func(p Product) string { return strconv.Itoa(p.ProductID) }},
+	{name: "productName", header: "Product Name", sortSQL: "productName", value: func(p Product) string { return p.ProductName }},
+	{name: "manufacturer", header: "Manufacturer", sortSQL: "manufacturer", value: func(p Product) string { return p.Manufacturer }},
+	{name: "sku", header: "SKU", sortSQL: "sku", value: func(p Product) string { return p.Sku }},
+	{name: "upc", header: "UPC", sortSQL: "upc", value: func(p Product) string { return p.Upc }},
+	{name: "pricePerUnit", header: "Price Per Unit", kind: decimalColumn, sortSQL: "pricePerUnit", value: func(p Product) string { return p.PricePerUnit }},
+	{name: "quantityOnHand", header: "Quantity On Hand", kind: integerColumn, sortSQL: "quantityOnHand", total: true, value: func(p Product) string { return strconv.Itoa(p.QuantityOnHand) }},
+	{name: "stockValue", header: "Stock Value", kind: decimalColumn, sortSQL: "pricePerUnit * quantityOnHand", total: true, value: func(p Product) string { return strconv.FormatFloat(stockValue(p), 'f', 2, 64) }},
+}
+
+// DefaultReportColumns are used when a report doesn't pick its own
+var DefaultReportColumns = []string{"productId", "productName", "manufacturer", "sku", "quantityOnHand", "pricePerUnit"}
+
+func stockValue(p Product) float64 {
+	price, _ := strconv.ParseFloat(p.PricePerUnit, 64)
+	return price * float64(p.QuantityOnHand)
+}
+
+// number returns a numeric column's value as a float
+func (c reportColumn) number(p Product) float64 {
+	f, _ := strconv.ParseFloat(c.value(p), 64)
+	return f
+}
+
+func findReportColumn(name string) (reportColumn, bool) {
+	for _, c := range reportColumns {
+		if c.name == name {
+			return c, true
+		}
+	}
+	return reportColumn{}, false
+}
+
+// pickColumns looks up the named columns, or the default columns if none are named
+func pickColumns(names []string) ([]reportColumn, error) {
+	if len(names) == 0 {
+		names = DefaultReportColumns
+	}
+	columns := make([]reportColumn, 0, len(names))
+	for _, name := range names {
+		c, ok := findReportColumn(name)
+		if !ok {
+			return nil, fmt.Errorf("unknown column [%s]", name)
+		}
+		columns = append(columns, c)
+	}
+	return columns, nil
+}
+
+// parseSort reads a sort such as "quantityOnHand desc" into an ORDER BY expression
+// an empty sort leaves the products in the order they were found
+func parseSort(sortBy string) (string, error) {
+	parts := strings.Fields(sortBy)
+	if len(parts) == 0 {
+		return "", nil
+	}
+	c, ok := findReportColumn(parts[0])
+	if !ok || len(parts) > 2 {
+		return "", fmt.Errorf("sort must be a column name optionally followed by asc or desc")
+	}
+	if len(parts) == 2 {
+		switch strings.ToLower(parts[1]) {
+		case "asc":
+		case "desc":
+			return c.sortSQL + " DESC", nil
+		default:
+			return "", fmt.Errorf("sort must be a column name optionally followed by asc or desc")
+		}
+	}
+	return c.sortSQL, nil
+}
+
+// ValidateReportFilter checks a report filter's expression compiles, so a saved search doesn't fail every time it runs
+func ValidateReportFilter(productFilter ProductReportFilter) error {
+	if productFilter.Filter == "" {
+		return nil
+	}
+	_, _, err := compileFilter(productFilter.Filter)
+	return err
+}
+
+// ValidateReportOptions checks the sort, columns and format of a report before it's saved
+func ValidateReportOptions(sortBy string, columns []string, format string) error {
+	if _, err := parseSort(sortBy); err != nil {
+		return err
+	}
+	if _, err := pickColumns(columns); err != nil {
+		return err
+	}
+	switch format {
+	case "", ReportFormatHTML, ReportFormatCSV, ReportFormatXLSX, ReportFormatPDF:
+		return nil
+	}
+	return fmt.Errorf("format must be %s, %s, %s or %s", ReportFormatHTML, ReportFormatCSV, ReportFormatXLSX, ReportFormatPDF)
+}
+
+// ReportContentType returns the content type of a report format
+func ReportContentType(format string) string {
+	switch format {
+	case ReportFormatCSV:
+		return "text/csv; charset=utf-8"
+	case ReportFormatXLSX:
+		return "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+	case ReportFormatPDF:
+		return "application/pdf"
+	}
+	return "text/html; charset=utf-8"
+}
+
+// RunReport finds the products matching the filter and writes them out in the format, returning the content type
+// CSV and XLSX reports are streamed to w as the products are read, so nothing is written if the filter turns out to be bad
+func RunReport(ctx context.Context, w io.Writer, productFilter ProductReportFilter, sortBy string, columnNames []string, format string, loc locale.Locale) (string, error) {
+	contentType, _, err := runReport(ctx, w, productFilter, sortBy, columnNames, format, DefaultReportTemplate, loc)
+	return contentType, err
+}
+
+// runReport also returns how many products were in an HTML or PDF report, so the report endpoint can 404 when there are none
+func runReport(ctx context.Context, w io.Writer, productFilter ProductReportFilter, sortBy string, columnNames []string, format string, templateName string, loc locale.Locale) (string, int, error) {
+	if err := ValidateReportOptions(sortBy, columnNames, format); err != nil {
+		return "", 0, err
+	}
+	orderBy, err := parseSort(sortBy)
+	if err != nil {
+		return "", 0, err
+	}
+	columns, err := pickColumns(columnNames)
+	if err != nil {
+		return "", 0, err
+	}
+	switch format {
+	case ReportFormatCSV:
+		return ReportContentType(format), 0, streamReport(ctx, productFilter, orderBy, newCSVReport(w, columns, loc))
+	case ReportFormatXLSX:
+		return ReportContentType(format), 0, streamReport(ctx, productFilter, orderBy, newXLSXReport(w, columns))
+	}
+	products := make([]Product, 0)
+	err = eachReportProduct(ctx, productFilter, orderBy, func(p Product) error {
+		products = append(products, p)
+		return nil
+	})
+	if err != nil {
+		return "", 0, err
+	}
+	// HTML and PDF reports are laid out from the same table
+	table := buildReportTable(products, columns, productFilter, sortBy, loc)
+	if format == ReportFormatPDF {
+		return ReportContentType(format), len(products), writePDFReport(w, table)
+	}
+	return ReportContentType(format), len(products), writeHTMLReport(w, templateName, table)
+}
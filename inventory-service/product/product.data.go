@@ -3,17 +3,52 @@ package product
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"log"
+	"sort"
 	"strings"
 	"time"
 
+	"github.com/jordbick/Golang/inventory-service/attribute"
+	"github.com/jordbick/Golang/inventory-service/category"
 	"github.com/jordbick/Golang/inventory-service/database"
+	"github.com/jordbick/Golang/inventory-service/ledger"
+	"github.com/jordbick/Golang/inventory-service/location"
+	"github.com/jordbick/Golang/inventory-service/supplier"
+
+	"github.com/go-sql-driver/mysql"
 )
 
 // keep all of our data access separate from the web service code
 // Allow us to easily replace the implementations of these methods once we start working with a DB
 
 func getProduct(productID int) (*Product, error) {
+	return getProductWhere(`productId = ?`, productID)
+}
+
+// getProductBySku returns the product with exactly this SKU
+// The condition is the unique index's expression, so the index is used for it
+func getProductBySku(sku string) (*Product, error) {
+	return getProductWhere(`NULLIF(TRIM(sku), '') = ?`, strings.TrimSpace(sku))
+}
+
+// getProductByUpc returns the product with this UPC, read as either a 12 digit UPC-A or a 13 digit EAN-13 with a leading 0
+func getProductByUpc(upc string) (*Product, error) {
+	return getProductWhere(`NULLIF(TRIM(upc), '') = ?`, normalizeUpc(upc))
+}
+
+// normalizeUpc turns a 13 digit EAN-13 with a leading 0 into the 12 digit UPC-A it's the same code as
+// UPCs are stored this way so the unique index sees the two forms as one
+func normalizeUpc(upc string) string {
+	upc = strings.TrimSpace(upc)
+	if len(upc) == 13 && strings.HasPrefix(upc, "0") && strings.Trim(upc, "0123456789") == "" {
+		return upc[1:]
+	}
+	return upc
+}
+
+// getProductWhere returns the first product matching the condition
+func getProductWhere(condition string, args ...interface{}) (*Product, error) {
 	// if query takes longer than 15s will cancel and return
 	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
 	defer cancel()
@@ -25,9 +60,12 @@ func getProduct(productID int) (*Product, error) {
 	upc,
 	pricePerUnit,
 	quantityOnHand,
-	productName
+	productName,
+	COALESCE(manufacturerId, 0),
+	COALESCE(parentProductId, 0)
 	FROM products
-	WHERE productId = ?`, productID)
+	WHERE `+condition+`
+	LIMIT 1`, args...)
 
 	product := &Product{}
 	err := row.Scan(&product.ProductID,
@@ -36,7 +74,9 @@ func getProduct(productID int) (*Product, error) {
 		&product.Upc,
 		&product.PricePerUnit,
 		&product.QuantityOnHand,
-		&product.ProductName)
+		&product.ProductName,
+		&product.ManufacturerID,
+		&product.ParentProductID)
 
 	// If no rows, return nil as record doesn't exist
 	if err == sql.ErrNoRows {
@@ -51,29 +91,71 @@ func getProduct(productID int) (*Product, error) {
 func removeProduct(productID int) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
 	defer cancel()
-	_, err := database.DbConn.ExecContext(ctx, `DELETE FROM products where productId = ?`, productID)
+	// the product and everything hanging off it go together, or not at all
+	tx, err := database.DbConn.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
-	return nil
+	defer tx.Rollback()
+	_, err = tx.ExecContext(ctx, `DELETE FROM products where productId = ?`, productID)
+	if err != nil {
+		return err
+	}
+	// the ledger is kept as history but the product's stock levels go with it
+	_, err = tx.ExecContext(ctx, `DELETE FROM stock_levels where productId = ?`, productID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `DELETE FROM product_categories where productId = ?`, productID)
+	if err != nil {
+		return err
+	}
+	err = attribute.RemoveProduct(ctx, tx, productID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
 // GET ALL
 // Convert into SELECT statements to query the DB rather than static data
 // Change function to return an error as when we're working with a DB there could be a connection problem
-func getProductList() ([]Product, error) {
+// categoryID optionally restricts the list to products in that category or any of its descendants
+// condition is an optional extra condition such as a compiled filter expression, with its arguments
+func getProductList(categoryID int, condition string, conditionArgs ...interface{}) ([]Product, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
 	defer cancel()
-	// Use DB Query method as we are returning a list
-	// use the DB connection that we created in our main function
-	results, err := database.DbConn.QueryContext(ctx, `SELECT productId,
+	var queryArgs = make([]interface{}, 0)
+	query := `SELECT productId,
 	manufacturer,
 	sku,
 	upc,
 	pricePerUnit,
 	quantityOnHand,
-	productName
-	FROM products`)
+	productName,
+	COALESCE(manufacturerId, 0),
+	COALESCE(parentProductId, 0)
+	FROM products`
+	conditions := make([]string, 0)
+	if categoryID != 0 {
+		categoryIDs, err := category.DescendantIDs(categoryID)
+		if err != nil {
+			return nil, err
+		}
+		in, args := category.InClause(categoryIDs)
+		conditions = append(conditions, `productId IN (SELECT productId FROM product_categories WHERE categoryId `+in+`)`)
+		queryArgs = append(queryArgs, args...)
+	}
+	if condition != "" {
+		conditions = append(conditions, condition)
+		queryArgs = append(queryArgs, conditionArgs...)
+	}
+	if len(conditions) > 0 {
+		query += ` WHERE ` + strings.Join(conditions, ` AND `)
+	}
+	// Use DB Query method as we are returning a list
+	// use the DB connection that we created in our main function
+	results, err := database.DbConn.QueryContext(ctx, query, queryArgs...)
 	if err != nil {
 		return nil, err
 	}
@@ -93,13 +175,82 @@ func getProductList() ([]Product, error) {
 			&product.Upc,
 			&product.PricePerUnit,
 			&product.QuantityOnHand,
-			&product.ProductName)
+			&product.ProductName,
+			&product.ManufacturerID,
+			&product.ParentProductID)
 		// append this object product to our slice of products,
 		products = append(products, product)
 	}
 	return products, nil
 }
 
+// withDetails fills in the CategoryIDs and Attributes of each product
+func withDetails(products []Product) ([]Product, error) {
+	productIDs := make([]int, 0, len(products))
+	for _, p := range products {
+		productIDs = append(productIDs, p.ProductID)
+	}
+	categoryIDs, err := category.GetProductCategoryIDs(productIDs)
+	if err != nil {
+		return nil, err
+	}
+	attributes, err := attribute.GetProductAttributes(productIDs)
+	if err != nil {
+		return nil, err
+	}
+	for i := range products {
+		products[i].CategoryIDs = categoryIDs[products[i].ProductID]
+		if products[i].CategoryIDs == nil {
+			products[i].CategoryIDs = make([]int, 0)
+		}
+		products[i].Attributes = attributes[products[i].ProductID]
+		if products[i].Attributes == nil {
+			products[i].Attributes = make(map[string]interface{})
+		}
+	}
+	return products, nil
+}
+
+// getVariants returns the variants of a parent product
+func getVariants(parentProductID int) ([]Product, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	results, err := database.DbConn.QueryContext(ctx, `SELECT productId,
+	manufacturer,
+	sku,
+	upc,
+	pricePerUnit,
+	quantityOnHand,
+	productName,
+	COALESCE(manufacturerId, 0),
+	COALESCE(parentProductId, 0)
+	FROM products
+	WHERE parentProductId = ?
+	ORDER BY productId`, parentProductID)
+	if err != nil {
+		return nil, err
+	}
+	defer results.Close()
+	products := make([]Product, 0)
+	for results.Next() {
+		var product Product
+		err := results.Scan(&product.ProductID,
+			&product.Manufacturer,
+			&product.Sku,
+			&product.Upc,
+			&product.PricePerUnit,
+			&product.QuantityOnHand,
+			&product.ProductName,
+			&product.ManufacturerID,
+			&product.ParentProductID)
+		if err != nil {
+			return nil, err
+		}
+		products = append(products, product)
+	}
+	return products, nil
+}
+
 // Similar to getProductList but restricting number of products back
 func GetTopTenProducts() ([]Product, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
@@ -111,7 +262,9 @@ func GetTopTenProducts() ([]Product, error) {
 	upc, 
 	pricePerUnit, 
 	quantityOnHand, 
-	productName 
+	productName,
+	COALESCE(manufacturerId, 0),
+	COALESCE(parentProductId, 0)
 	FROM products ORDER BY quantityOnHand DESC LIMIT 10
 	`)
 	if err != nil {
@@ -128,7 +281,9 @@ func GetTopTenProducts() ([]Product, error) {
 			&product.Upc,
 			&product.PricePerUnit,
 			&product.QuantityOnHand,
-			&product.ProductName)
+			&product.ProductName,
+			&product.ManufacturerID,
+			&product.ParentProductID)
 
 		products = append(products, product)
 	}
@@ -138,114 +293,272 @@ func GetTopTenProducts() ([]Product, error) {
 func updateProduct(product Product) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
 	defer cancel()
+	// Use a transaction so a change to quantityOnHand is recorded in the stock ledger along with the update
+	tx, err := database.DbConn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	var oldQuantity int
+	err = tx.QueryRowContext(ctx, `SELECT quantityOnHand FROM products WHERE productId = ? FOR UPDATE`, product.ProductID).Scan(&oldQuantity)
+	if err != nil {
+		return err
+	}
+	if product.QuantityOnHand != oldQuantity {
+		// the quantity can't drop below what is already held in locations
+		allocated, err := location.AllocatedQuantity(ctx, tx, product.ProductID)
+		if err != nil {
+			return err
+		}
+		if product.QuantityOnHand < allocated {
+			return location.ErrInsufficientStock
+		}
+		// stock taken away here has gone out to customers, so it's recorded as issued, which is what demand forecasts count
+		// a correction after a stock count should be made through /stock/adjustments with reason adjustment instead
+		reason := ledger.ReasonAdjustment
+		if product.QuantityOnHand < oldQuantity {
+			reason = ledger.ReasonIssue
+		}
+		err = ledger.Record(ctx, tx, ledger.Movement{
+			ProductID: product.ProductID,
+			Quantity:  product.QuantityOnHand - oldQuantity,
+			Reason:    reason,
+			Reference: "product update",
+		})
+		if err != nil {
+			return err
+		}
+	}
 	// Call to Exec method
-	_, err := database.DbConn.ExecContext(ctx, `UPDATE products SET 
+	_, err = tx.ExecContext(ctx, `UPDATE products SET 
 	manufacturer=?,
 	sku=?,
 	upc=?,
 	pricePerUnit=CAST(? AS DECIMAL(13,2)),
 	quantityOnHand=?,
-	productName=?
+	productName=?,
+	manufacturerId=NULLIF(?, 0),
+	parentProductId=NULLIF(?, 0)
 	WHERE productId=?`,
 		product.Manufacturer,
 		product.Sku,
-		product.Upc,
+		normalizeUpc(product.Upc),
 		product.PricePerUnit,
 		product.QuantityOnHand,
 		product.ProductName,
+		product.ManufacturerID,
+		product.ParentProductID,
 		product.ProductID,
 	)
 	if err != nil {
-		return err
+		return duplicateError(err)
 	}
-	return nil
+	return tx.Commit()
+}
+
+// duplicateError turns a duplicate key error from one of the unique indexes into ErrDuplicateSku or ErrDuplicateUpc
+func duplicateError(err error) error {
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) && mysqlErr.Number == 1062 {
+		switch {
+		case strings.Contains(mysqlErr.Message, "uq_products_sku"):
+			return ErrDuplicateSku
+		case strings.Contains(mysqlErr.Message, "uq_products_upc"):
+			return ErrDuplicateUpc
+		}
+	}
+	return err
 }
 
 func insertProduct(product Product) (int, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
 	defer cancel()
-	result, err := database.DbConn.ExecContext(ctx, `INSERT into products (
+	tx, err := database.DbConn.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	result, err := tx.ExecContext(ctx, `INSERT into products (
 	manufacturer,
 	sku,
 	upc,
 	pricePerUnit,
 	quantityOnHand,
-	productName) VALUES (?, ?, ?, ?, ?, ?)`,
+	productName,
+	manufacturerId,
+	parentProductId) VALUES (?, ?, ?, ?, ?, ?, NULLIF(?, 0), NULLIF(?, 0))`,
 		product.Manufacturer,
 		product.Sku,
-		product.Upc,
+		normalizeUpc(product.Upc),
 		product.PricePerUnit,
 		product.QuantityOnHand,
-		product.ProductName)
+		product.ProductName,
+		product.ManufacturerID,
+		product.ParentProductID)
 
 	if err != nil {
-		return 0, nil
+		return 0, duplicateError(err)
 	}
 	// want to know the last product ID of the record that was inserted
 	insertID, err := result.LastInsertId()
 	if err != nil {
-		return 0, nil
+		return 0, err
 	}
-	return int(insertID), nil
+	// opening stock goes into the ledger as unallocated stock
+	if product.QuantityOnHand != 0 {
+		err = ledger.Record(ctx, tx, ledger.Movement{
+			ProductID: int(insertID),
+			Quantity:  product.QuantityOnHand,
+			Reason:    ledger.ReasonAdjustment,
+			Reference: "opening stock",
+		})
+		if err != nil {
+			return 0, err
+		}
+	}
+	return int(insertID), tx.Commit()
 }
 
-// Building out the WHERE clause using the fields in our productFilter
+// searchForProductData returns all the products matching the report filter
 func searchForProductData(productFilter ProductReportFilter) ([]Product, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
+	products := make([]Product, 0)
+	err := eachReportProduct(ctx, productFilter, "", func(product Product) error {
+		products = append(products, product)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return products, nil
+}
 
+// eachReportProduct calls fn with each product matching the report filter in turn, so exports of a large catalog don't have to hold it all in memory
+// orderBy is an ORDER BY expression from the report columns, without one a full text query orders by relevance
+// Building out the WHERE clause using the fields in our productFilter
+func eachReportProduct(ctx context.Context, productFilter ProductReportFilter, orderBy string, fn func(Product) error) error {
 	var queryArgs = make([]interface{}, 0)
+	// each filter adds a condition, they're joined with AND once they've all been collected
+	conditions := make([]string, 0)
 	var queryBuilder strings.Builder
 	// appending to our query string programmatically
 	queryBuilder.WriteString(`SELECT 
 		productId, 
-		LOWER(manufacturer), 
-		LOWER(sku), 
+		manufacturer, 
+		sku, 
 		upc, 
 		pricePerUnit, 
 		quantityOnHand, 
-		LOWER(productName) 
-		FROM products WHERE `)
+		productName,
+		COALESCE(manufacturerId, 0),
+		COALESCE(parentProductId, 0)
+		FROM products`)
 	if productFilter.NameFilter != "" {
-		queryBuilder.WriteString(`productName LIKE ? `)
+		conditions = append(conditions, `productName LIKE ?`)
 		queryArgs = append(queryArgs, "%"+strings.ToLower(productFilter.NameFilter)+"%")
 	}
+	// manufacturers are matched against the suppliers table so every spelling of a name finds the same products
 	if productFilter.ManufacturerFilter != "" {
-		if len(queryArgs) > 0 {
-			queryBuilder.WriteString(" AND ")
+		conditions = append(conditions, `manufacturerId IN (SELECT supplierId FROM suppliers WHERE name LIKE ? OR normalizedName LIKE ?)`)
+		queryArgs = append(queryArgs,
+			"%"+strings.ToLower(productFilter.ManufacturerFilter)+"%",
+			"%"+supplier.NormalizeName(productFilter.ManufacturerFilter)+"%")
+	}
+	if productFilter.ManufacturerIDFilter != 0 {
+		conditions = append(conditions, `manufacturerId = ?`)
+		queryArgs = append(queryArgs, productFilter.ManufacturerIDFilter)
+	}
+	// a category filter includes the products of all its subcategories
+	if productFilter.CategoryIDFilter != 0 {
+		categoryIDs, err := category.DescendantIDs(productFilter.CategoryIDFilter)
+		if err != nil {
+			return err
 		}
-		queryBuilder.WriteString(`manufacturer LIKE ? `)
-		queryArgs = append(queryArgs, "%"+strings.ToLower(productFilter.ManufacturerFilter)+"%")
+		in, args := category.InClause(categoryIDs)
+		conditions = append(conditions, `productId IN (SELECT productId FROM product_categories WHERE categoryId `+in+`)`)
+		queryArgs = append(queryArgs, args...)
 	}
-	if productFilter.SKUFilter != "" {
-		if len(queryArgs) > 0 {
-			queryBuilder.WriteString(" AND ")
+	// attribute filters match variants (or any product) with exactly that attribute value
+	attributeNames := make([]string, 0, len(productFilter.AttributeFilters))
+	for name := range productFilter.AttributeFilters {
+		attributeNames = append(attributeNames, name)
+	}
+	sort.Strings(attributeNames)
+	for _, name := range attributeNames {
+		condition, args, err := attribute.Condition(name, productFilter.AttributeFilters[name])
+		if err != nil {
+			return err
 		}
-		queryBuilder.WriteString(`sku LIKE ? `)
+		conditions = append(conditions, condition)
+		queryArgs = append(queryArgs, args...)
+	}
+	if productFilter.SKUFilter != "" {
+		conditions = append(conditions, `sku LIKE ?`)
 		queryArgs = append(queryArgs, "%"+strings.ToLower(productFilter.SKUFilter)+"%")
 	}
+	// a filter expression such as `quantityOnHand < 10 AND manufacturer = "acme"`
+	if productFilter.Filter != "" {
+		condition, args, err := compileFilter(productFilter.Filter)
+		if err != nil {
+			return err
+		}
+		conditions = append(conditions, condition)
+		queryArgs = append(queryArgs, args...)
+	}
+	// a full text query narrows the report down to the search hits, which also decide the order
+	var hitIDs []int
+	if productFilter.Query != "" {
+		hits := productIndex.Search(productFilter.Query)
+		if len(hits) == 0 {
+			return nil
+		}
+		hitIDs = make([]int, len(hits))
+		for i, hit := range hits {
+			hitIDs[i] = hit.ID
+		}
+		in, args := category.InClause(hitIDs)
+		conditions = append(conditions, `productId `+in)
+		queryArgs = append(queryArgs, args...)
+	}
+	if len(conditions) > 0 {
+		queryBuilder.WriteString(" WHERE " + strings.Join(conditions, " AND "))
+	}
+	if orderBy != "" {
+		queryBuilder.WriteString(" ORDER BY " + orderBy + ", productId")
+	} else if hitIDs != nil {
+		// FIELD gives each product its position in the list of hits
+		in, args := category.InClause(hitIDs)
+		queryBuilder.WriteString(" ORDER BY FIELD(productId, " + strings.TrimSuffix(strings.TrimPrefix(in, "IN ("), ")") + ")")
+		queryArgs = append(queryArgs, args...)
+	}
 
 	results, err := database.DbConn.QueryContext(ctx, queryBuilder.String(), queryArgs...)
 	if err != nil {
 		log.Println(err.Error())
-		return nil, err
+		return err
 	}
 	defer results.Close()
-	products := make([]Product, 0)
-	// scan over results we get back and return those to a slice, and return it to the caller
+	// scan over results we get back and hand each one to the caller
 	for results.Next() {
 		var product Product
-		results.Scan(&product.ProductID,
+		err := results.Scan(&product.ProductID,
 			&product.Manufacturer,
 			&product.Sku,
 			&product.Upc,
 			&product.PricePerUnit,
 			&product.QuantityOnHand,
-			&product.ProductName)
-
-		products = append(products, product)
+			&product.ProductName,
+			&product.ManufacturerID,
+			&product.ParentProductID)
+		if err != nil {
+			return err
+		}
+		if err = fn(product); err != nil {
+			return err
+		}
 	}
-	return products, nil
+	return results.Err()
 }
 
 // func addOrUpdateProduct(product Product) (int, error) {
@@ -368,3 +681,99 @@ func searchForProductData(productFilter ProductReportFilter) ([]Product, error)
 // 	}
 // 	return nil
 // }
+
+var errDuplicateReportTemplate = errors.New("a report template with that name already exists")
+
+// getReportTemplateList returns the custom report templates, the bodies are only read if they're needed
+func getReportTemplateList(withBody bool) ([]ReportTemplate, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	body := `''`
+	if withBody {
+		body = `body`
+	}
+	results, err := database.DbConn.QueryContext(ctx, `SELECT name, `+body+`, createdAt, updatedAt
+	FROM report_templates
+	ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	defer results.Close()
+	list := make([]ReportTemplate, 0)
+	for results.Next() {
+		var t ReportTemplate
+		var createdAt, updatedAt time.Time
+		if err := results.Scan(&t.Name, &t.Body, &createdAt, &updatedAt); err != nil {
+			return nil, err
+		}
+		t.CreatedAt, t.UpdatedAt = &createdAt, &updatedAt
+		list = append(list, t)
+	}
+	return list, results.Err()
+}
+
+func getReportTemplate(name string) (*ReportTemplate, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	var t ReportTemplate
+	var createdAt, updatedAt time.Time
+	err := database.DbConn.QueryRowContext(ctx, `SELECT name, body, createdAt, updatedAt
+	FROM report_templates
+	WHERE name = ?`, name).Scan(&t.Name, &t.Body, &createdAt, &updatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	t.CreatedAt, t.UpdatedAt = &createdAt, &updatedAt
+	return &t, nil
+}
+
+func insertReportTemplate(t ReportTemplate) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	now := time.Now().UTC()
+	_, err := database.DbConn.ExecContext(ctx, `INSERT INTO report_templates (name, body, createdAt, updatedAt) VALUES (?, ?, ?, ?)`,
+		t.Name, t.Body, now, now)
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) && mysqlErr.Number == 1062 {
+		return errDuplicateReportTemplate
+	}
+	return err
+}
+
+func updateReportTemplate(t ReportTemplate) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	result, err := database.DbConn.ExecContext(ctx, `UPDATE report_templates SET body = ?, updatedAt = ? WHERE name = ?`,
+		t.Body, time.Now().UTC(), t.Name)
+	if err != nil {
+		return err
+	}
+	// with the same body and a second resolution timestamp nothing may change, so check the template exists separately
+	if affected, err := result.RowsAffected(); err != nil || affected > 0 {
+		return err
+	}
+	existing, err := getReportTemplate(t.Name)
+	if err == nil && existing == nil {
+		err = ErrReportTemplateNotFound
+	}
+	return err
+}
+
+func removeReportTemplate(name string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	result, err := database.DbConn.ExecContext(ctx, `DELETE FROM report_templates WHERE name = ?`, name)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrReportTemplateNotFound
+	}
+	return nil
+}
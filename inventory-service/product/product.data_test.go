@@ -0,0 +1,147 @@
+package product
+
+import (
+	"database/sql/driver"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/jordbick/Golang/inventory-service/database"
+	"github.com/jordbick/Golang/inventory-service/database/dbtest"
+)
+
+func TestNormalizeUpc(t *testing.T) {
+	tests := map[string]string{
+		"036000291452":    "036000291452",
+		"0036000291452":   "036000291452",
+		" 0036000291452 ": "036000291452",
+		"4006381333931":   "4006381333931",
+		"0ABCDEFGHIJKL":   "0ABCDEFGHIJKL",
+		"":                "",
+	}
+	for upc, want := range tests {
+		if got := normalizeUpc(upc); got != want {
+			t.Errorf("normalizeUpc(%q) = %q, want %q", upc, got, want)
+		}
+	}
+}
+
+// statements records what's sent to the database, and answers queries with no rows
+type statements struct {
+	queries []string
+	args    [][]driver.Value
+}
+
+func (s *statements) handle(query string, args []driver.Value) (dbtest.Result, error) {
+	s.queries = append(s.queries, query)
+	s.args = append(s.args, args)
+	return dbtest.Result{RowsAffected: 1, LastInsertID: 1}, nil
+}
+
+func TestProductLookups(t *testing.T) {
+	db := &statements{}
+	database.DbConn = dbtest.Open(db.handle)
+	if _, err := getProductByUpc("0036000291452"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := getProductBySku(" WID-100 "); err != nil {
+		t.Fatal(err)
+	}
+	// the conditions have to be the unique indexes' expressions for MySQL to use them
+	if !strings.Contains(db.queries[0], "WHERE NULLIF(TRIM(upc), '') = ?") || db.args[0][0] != "036000291452" {
+		t.Errorf("UPC lookup %q with %v", db.queries[0], db.args[0])
+	}
+	if !strings.Contains(db.queries[1], "WHERE NULLIF(TRIM(sku), '') = ?") || db.args[1][0] != "WID-100" {
+		t.Errorf("SKU lookup %q with %v", db.queries[1], db.args[1])
+	}
+}
+
+func TestRemoveProduct(t *testing.T) {
+	tables := func(queries []string) []string {
+		var tables []string
+		for _, query := range queries {
+			if fields := strings.Fields(query); len(fields) > 2 && fields[0] == "DELETE" {
+				tables = append(tables, fields[2])
+			} else {
+				tables = append(tables, query)
+			}
+		}
+		return tables
+	}
+
+	db := &statements{}
+	database.DbConn = dbtest.Open(db.handle)
+	if err := removeProduct(3); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"BEGIN", "products", "stock_levels", "product_categories", "product_attributes", "COMMIT"}
+	if got := tables(db.queries); !reflect.DeepEqual(got, want) {
+		t.Errorf("removeProduct ran %q, want %q", got, want)
+	}
+
+	// a delete that fails takes the others back with it
+	db = &statements{}
+	database.DbConn = dbtest.Open(func(query string, args []driver.Value) (dbtest.Result, error) {
+		if strings.Contains(query, "product_attributes") {
+			db.queries = append(db.queries, query)
+			return dbtest.Result{}, errors.New("lost connection")
+		}
+		return db.handle(query, args)
+	})
+	if err := removeProduct(3); err == nil {
+		t.Fatal("removeProduct succeeded")
+	}
+	want = []string{"BEGIN", "products", "stock_levels", "product_categories", "product_attributes", "ROLLBACK"}
+	if got := tables(db.queries); !reflect.DeepEqual(got, want) {
+		t.Errorf("failed removeProduct ran %q, want %q", got, want)
+	}
+}
+
+func TestInsertProductNormalizesUpc(t *testing.T) {
+	db := &statements{}
+	database.DbConn = dbtest.Open(db.handle)
+	if _, err := insertProduct(Product{Sku: "WID-100", Upc: "0036000291452", PricePerUnit: "1.00"}); err != nil {
+		t.Fatal(err)
+	}
+	for i, query := range db.queries {
+		if strings.HasPrefix(query, "INSERT into products") {
+			if db.args[i][2] != "036000291452" {
+				t.Errorf("UPC stored as %v", db.args[i][2])
+			}
+			return
+		}
+	}
+	t.Errorf("no insert in %q", db.queries)
+}
+
+func TestUpdateProductLedgerReason(t *testing.T) {
+	tests := []struct {
+		quantity int
+		reason   string
+	}{
+		// stock taken away through the product API is demand, stock added is not
+		{4, "issue"},
+		{15, "adjustment"},
+	}
+	for _, test := range tests {
+		var reason driver.Value
+		database.DbConn = dbtest.Open(func(query string, args []driver.Value) (dbtest.Result, error) {
+			switch {
+			case strings.HasPrefix(query, "SELECT quantityOnHand"):
+				return dbtest.Result{Rows: [][]driver.Value{{int64(10)}}}, nil
+			case strings.HasPrefix(query, "SELECT COALESCE(SUM(quantity), 0) FROM stock_levels"):
+				return dbtest.Result{Rows: [][]driver.Value{{int64(0)}}}, nil
+			case strings.HasPrefix(query, "INSERT INTO stock_movements"):
+				reason = args[3]
+			}
+			return dbtest.Result{RowsAffected: 1}, nil
+		})
+		if err := updateProduct(Product{ProductID: 3, QuantityOnHand: test.quantity, PricePerUnit: "1.00"}); err != nil {
+			t.Fatal(err)
+		}
+		if reason != test.reason {
+			t.Errorf("10 to %d recorded as %v, want %s", test.quantity, reason, test.reason)
+		}
+	}
+}
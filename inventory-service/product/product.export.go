@@ -0,0 +1,227 @@
+package product
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/jordbick/Golang/inventory-service/locale"
+	"github.com/jordbick/Golang/inventory-service/xlsx"
+)
+
+// Spreadsheet exports are written a row at a time as the products come back from the database
+// The header isn't written until the query has succeeded, so a bad filter can still be reported with a proper status code
+
+// reportStream writes a report one product at a time
+type reportStream interface {
+	begin() error
+	row(p Product) error
+	end() error
+}
+
+func streamReport(ctx context.Context, productFilter ProductReportFilter, orderBy string, stream reportStream) error {
+	started := false
+	err := eachReportProduct(ctx, productFilter, orderBy, func(p Product) error {
+		if !started {
+			started = true
+			if err := stream.begin(); err != nil {
+				return err
+			}
+		}
+		return stream.row(p)
+	})
+	if err != nil {
+		return err
+	}
+	if !started {
+		if err = stream.begin(); err != nil {
+			return err
+		}
+	}
+	return stream.end()
+}
+
+// totals adds up the total columns as the rows go by
+type totals struct {
+	columns []reportColumn
+	sums    []float64
+}
+
+func newTotals(columns []reportColumn) totals {
+	return totals{columns: columns, sums: make([]float64, len(columns))}
+}
+
+func (t totals) add(p Product) {
+	for i, c := range t.columns {
+		if c.total {
+			t.sums[i] += c.number(p)
+		}
+	}
+}
+
+// any is true if the report has a column worth totalling
+func (t totals) any() bool {
+	for _, c := range t.columns {
+		if c.total {
+			return true
+		}
+	}
+	return false
+}
+
+// labelColumn is where the "Total" label goes, the first column that doesn't have a total of its own
+func (t totals) labelColumn() int {
+	for i, c := range t.columns {
+		if !c.total {
+			return i
+		}
+	}
+	return -1
+}
+
+// csvFlushRows is how many rows the csv report buffers before sending them on
+const csvFlushRows = 100
+
+// csvReport formats numbers for the locale, and uses its delimiter so the file opens correctly in a spreadsheet there
+type csvReport struct {
+	cw      *csv.Writer
+	columns []reportColumn
+	loc     locale.Locale
+	totals  totals
+	record  []string
+	rows    int
+}
+
+func newCSVReport(w io.Writer, columns []reportColumn, loc locale.Locale) *csvReport {
+	cw := csv.NewWriter(w)
+	cw.Comma = loc.CSVDelimiter
+	return &csvReport{cw: cw, columns: columns, loc: loc, totals: newTotals(columns), record: make([]string, len(columns))}
+}
+
+func (r *csvReport) begin() error {
+	for i, c := range r.columns {
+		r.record[i] = c.header
+	}
+	return r.cw.Write(r.record)
+}
+
+func (r *csvReport) row(p Product) error {
+	r.totals.add(p)
+	for i, c := range r.columns {
+		r.record[i] = formatColumn(c, p, r.loc)
+		if c.kind == textColumn {
+			r.record[i] = escapeFormula(r.record[i])
+		}
+	}
+	if err := r.cw.Write(r.record); err != nil {
+		return err
+	}
+	// csv.Writer buffers, flushing every csvFlushRows rows keeps memory flat and gets rows to the client sooner
+	r.rows++
+	if r.rows%csvFlushRows != 0 {
+		return nil
+	}
+	r.cw.Flush()
+	return r.cw.Error()
+}
+
+// escapeFormula stops a spreadsheet running text that looks like a formula, e.g. a product named =HYPERLINK(...)
+// by putting a ' in front, which spreadsheets take to mean the cell is text
+func escapeFormula(s string) string {
+	if s != "" && strings.ContainsRune("=+-@", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
+
+func (r *csvReport) end() error {
+	if r.totals.any() {
+		for i, c := range r.columns {
+			r.record[i] = ""
+			if c.total {
+				r.record[i] = formatNumber(c, r.totals.sums[i], r.loc)
+			}
+		}
+		if i := r.totals.labelColumn(); i >= 0 {
+			r.record[i] = "Total"
+		}
+		if err := r.cw.Write(r.record); err != nil {
+			return err
+		}
+	}
+	r.cw.Flush()
+	return r.cw.Error()
+}
+
+// xlsxReport writes real numbers with number formats, so it's the spreadsheet that shows them in the reader's locale
+// The totals row uses SUM formulas so it stays right if rows are edited
+type xlsxReport struct {
+	w       io.Writer
+	sheet   *xlsx.Writer
+	columns []reportColumn
+	totals  totals
+	cells   []xlsx.Cell
+}
+
+func newXLSXReport(w io.Writer, columns []reportColumn) *xlsxReport {
+	return &xlsxReport{w: w, columns: columns, totals: newTotals(columns), cells: make([]xlsx.Cell, len(columns))}
+}
+
+func (r *xlsxReport) begin() error {
+	var err error
+	r.sheet, err = xlsx.NewWriter(r.w, "Products")
+	if err != nil {
+		return err
+	}
+	for i, c := range r.columns {
+		r.cells[i] = xlsx.Cell{Text: c.header, Style: xlsx.StyleBold}
+	}
+	return r.sheet.WriteRow(r.cells)
+}
+
+func (r *xlsxReport) row(p Product) error {
+	r.totals.add(p)
+	for i, c := range r.columns {
+		switch c.kind {
+		case idColumn:
+			r.cells[i] = xlsx.Cell{Number: c.number(p), IsNumber: true}
+		case integerColumn:
+			r.cells[i] = xlsx.Cell{Number: c.number(p), IsNumber: true, Style: xlsx.StyleInteger}
+		case decimalColumn:
+			r.cells[i] = xlsx.Cell{Number: c.number(p), IsNumber: true, Style: xlsx.StyleDecimal}
+		default:
+			r.cells[i] = xlsx.Cell{Text: c.value(p)}
+		}
+	}
+	return r.sheet.WriteRow(r.cells)
+}
+
+func (r *xlsxReport) end() error {
+	if r.totals.any() {
+		lastRow := r.sheet.Rows()
+		for i, c := range r.columns {
+			r.cells[i] = xlsx.Cell{}
+			if !c.total {
+				continue
+			}
+			style := xlsx.StyleBoldDecimal
+			if c.kind == integerColumn {
+				style = xlsx.StyleBoldInteger
+			}
+			r.cells[i] = xlsx.Cell{Number: r.totals.sums[i], IsNumber: true, Style: style}
+			// row 1 is the header, with no products there's nothing to sum
+			if lastRow > 1 {
+				r.cells[i].Formula = fmt.Sprintf("SUM(%s:%s)", xlsx.CellRef(i, 2), xlsx.CellRef(i, lastRow))
+			}
+		}
+		if i := r.totals.labelColumn(); i >= 0 {
+			r.cells[i] = xlsx.Cell{Text: "Total", Style: xlsx.StyleBold}
+		}
+		if err := r.sheet.WriteRow(r.cells); err != nil {
+			return err
+		}
+	}
+	return r.sheet.Close()
+}
@@ -0,0 +1,49 @@
+package product
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/jordbick/Golang/inventory-service/locale"
+)
+
+func TestCSVReport(t *testing.T) {
+	var columns []reportColumn
+	for _, name := range []string{"productName", "manufacturer", "sku", "quantityOnHand"} {
+		c, _ := findReportColumn(name)
+		columns = append(columns, c)
+	}
+	var out bytes.Buffer
+	report := newCSVReport(&out, columns, locale.Default)
+	if err := report.begin(); err != nil {
+		t.Fatal(err)
+	}
+	// text that a spreadsheet would run as a formula is escaped, negative numbers are left alone
+	if err := report.row(Product{ProductName: "=HYPERLINK(\"http://example.com\")", Manufacturer: "@acme", Sku: "-1+2", QuantityOnHand: -3}); err != nil {
+		t.Fatal(err)
+	}
+	if out.Len() != 0 {
+		t.Errorf("flushed after the first row: %q", out.String())
+	}
+	for i := 1; i < csvFlushRows; i++ {
+		if err := report.row(Product{ProductName: "Widget", Manufacturer: "Acme", Sku: "WID-100", QuantityOnHand: 1}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if out.Len() == 0 {
+		t.Errorf("nothing flushed after %d rows", csvFlushRows)
+	}
+	if err := report.end(); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(out.String(), "\n")
+	want := `"'=HYPERLINK(""http://example.com"")",'@acme,'-1+2,-3`
+	if lines[1] != want {
+		t.Errorf("first row %s, want %s", lines[1], want)
+	}
+	if lines[2] != "Widget,Acme,WID-100,1" {
+		t.Errorf("second row %s", lines[2])
+	}
+}
@@ -0,0 +1,25 @@
+package product
+
+import (
+	"github.com/jordbick/Golang/inventory-service/filter"
+)
+
+// productFilterFields are the fields that can be used in a ?filter= expression, "price" and "name" are there as shorter names
+var productFilterFields = map[string]filter.Field{
+	"productId":       {Column: "productId", Type: filter.Number},
+	"manufacturer":    {Column: "manufacturer", Type: filter.String},
+	"manufacturerId":  {Column: "manufacturerId", Type: filter.Number},
+	"sku":             {Column: "sku", Type: filter.String},
+	"upc":             {Column: "upc", Type: filter.String},
+	"pricePerUnit":    {Column: "pricePerUnit", Type: filter.Number},
+	"price":           {Column: "pricePerUnit", Type: filter.Number},
+	"quantityOnHand":  {Column: "quantityOnHand", Type: filter.Number},
+	"productName":     {Column: "productName", Type: filter.String},
+	"name":            {Column: "productName", Type: filter.String},
+	"parentProductId": {Column: "COALESCE(parentProductId, 0)", Type: filter.Number},
+}
+
+// compileFilter turns a filter expression into a condition on the products table
+func compileFilter(expression string) (string, []interface{}, error) {
+	return filter.Compile(expression, productFilterFields)
+}
@@ -0,0 +1,264 @@
+package product
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/jordbick/Golang/inventory-service/forecast"
+	"github.com/jordbick/Golang/inventory-service/purchaseorder"
+	"github.com/jordbick/Golang/inventory-service/response"
+	"github.com/jordbick/Golang/inventory-service/supplier"
+)
+
+// Forecasts are made from the units issued per day, taken from the stock movement ledger
+// The lead time comes from the product's supplier, and stock already on order counts towards what's available
+//
+// Query parameters, for both the single product forecast and the reorder suggestions:
+//	method=ses|ma, alpha=0.3, window=28, season=0 (e.g. 7 for a weekly cycle), horizon=30
+//	historyDays=90, serviceLevel=0.95, reviewDays=7
+
+// ProductForecast is a product's demand forecast and reorder suggestion
+type ProductForecast struct {
+	ProductID      int    `json:"productId"`
+	ProductName    string `json:"productName"`
+	Sku            string `json:"sku"`
+	QuantityOnHand int    `json:"quantityOnHand"`
+	OnOrder        int    `json:"onOrder"`
+	HistoryDays    int    `json:"historyDays"`
+	// LeadTimeAssumed is set when the supplier has no lead time and the default was used
+	LeadTimeAssumed bool                `json:"leadTimeAssumed"`
+	Forecast        forecast.Result     `json:"forecast"`
+	Reorder         forecast.Suggestion `json:"reorder"`
+}
+
+// forecastSettings are the options read from the query string
+type forecastSettings struct {
+	options      forecast.Options
+	historyDays  int
+	serviceLevel float64
+	reviewDays   int
+}
+
+func readForecastSettings(r *http.Request) (forecastSettings, error) {
+	query := r.URL.Query()
+	settings := forecastSettings{options: forecast.DefaultOptions, historyDays: 90, serviceLevel: 0.95, reviewDays: 7}
+	if method := query.Get("method"); method != "" {
+		settings.options.Method = method
+	}
+	ints := []struct {
+		name  string
+		value *int
+	}{
+		{"window", &settings.options.Window},
+		{"season", &settings.options.Season},
+		{"horizon", &settings.options.Horizon},
+		{"historyDays", &settings.historyDays},
+		{"reviewDays", &settings.reviewDays},
+	}
+	for _, p := range ints {
+		if s := query.Get(p.name); s != "" {
+			i, err := strconv.Atoi(s)
+			if err != nil {
+				return settings, fmt.Errorf("%s must be a whole number", p.name)
+			}
+			*p.value = i
+		}
+	}
+	floats := []struct {
+		name  string
+		value *float64
+	}{
+		{"alpha", &settings.options.Alpha},
+		{"serviceLevel", &settings.serviceLevel},
+	}
+	for _, p := range floats {
+		if s := query.Get(p.name); s != "" {
+			f, err := strconv.ParseFloat(s, 64)
+			if err != nil {
+				return settings, fmt.Errorf("%s must be a number", p.name)
+			}
+			*p.value = f
+		}
+	}
+	if settings.historyDays < 7 || settings.historyDays > 730 {
+		return settings, fmt.Errorf("historyDays must be from 7 to 730")
+	}
+	if err := settings.options.Validate(); err != nil {
+		return settings, err
+	}
+	return settings, forecast.ReorderInput{ServiceLevel: settings.serviceLevel, ReviewDays: settings.reviewDays}.Validate()
+}
+
+// leadTimes looks up the lead time of each supplier once
+type leadTimes map[int]int
+
+func (l leadTimes) get(supplierID int) (int, error) {
+	if supplierID == 0 {
+		return 0, nil
+	}
+	if days, ok := l[supplierID]; ok {
+		return days, nil
+	}
+	s, err := supplier.GetSupplier(supplierID)
+	if err != nil {
+		return 0, err
+	}
+	if s != nil {
+		l[supplierID] = s.LeadTimeDays
+	}
+	return l[supplierID], nil
+}
+
+func forecastProduct(product Product, history []float64, onOrder int, leadTimeDays int, settings forecastSettings) (ProductForecast, error) {
+	pf := ProductForecast{
+		ProductID:       product.ProductID,
+		ProductName:     product.ProductName,
+		Sku:             product.Sku,
+		QuantityOnHand:  product.QuantityOnHand,
+		OnOrder:         onOrder,
+		HistoryDays:     settings.historyDays,
+		LeadTimeAssumed: leadTimeDays <= 0,
+	}
+	if history == nil {
+		history = make([]float64, settings.historyDays)
+	}
+	var err error
+	pf.Forecast, err = forecast.Forecast(history, settings.options)
+	if err != nil {
+		return pf, err
+	}
+	pf.Reorder, err = forecast.Suggest(pf.Forecast, forecast.ReorderInput{
+		QuantityOnHand: product.QuantityOnHand,
+		OnOrder:        onOrder,
+		LeadTimeDays:   leadTimeDays,
+		ReviewDays:     settings.reviewDays,
+		ServiceLevel:   settings.serviceLevel,
+	})
+	return pf, err
+}
+
+// forecastHandler handles GET /products/{id}/forecast
+func forecastHandler(w http.ResponseWriter, r *http.Request, id string) {
+	productID, err := strconv.Atoi(id)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		settings, err := readForecastSettings(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		product, err := getProduct(productID)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if product == nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		demand, err := forecast.DailyDemand(productID, settings.historyDays, time.Now())
+		if err != nil {
+			log.Println(err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		onOrder, err := purchaseorder.GetOnOrder()
+		if err != nil {
+			log.Println(err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		leadTime, err := leadTimes{}.get(product.ManufacturerID)
+		if err != nil {
+			log.Println(err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		pf, err := forecastProduct(*product, demand[productID], onOrder[productID], leadTime, settings)
+		if err != nil {
+			log.Println(err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		response.WriteJSON(w, pf)
+
+	case http.MethodOptions:
+		return
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// reorderSuggestionsHandler handles GET /products/reorder-suggestions, the products that need reordering now
+// with the most urgent (the fewest days of cover) first. ?all=true includes every product
+func reorderSuggestionsHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		settings, err := readForecastSettings(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		all := r.URL.Query().Get("all") == "true"
+		products, err := getProductList(0, "")
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		demand, err := forecast.DailyDemand(0, settings.historyDays, time.Now())
+		if err != nil {
+			log.Println(err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		onOrder, err := purchaseorder.GetOnOrder()
+		if err != nil {
+			log.Println(err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		suppliers := leadTimes{}
+		suggestions := make([]ProductForecast, 0)
+		for _, product := range products {
+			leadTime, err := suppliers.get(product.ManufacturerID)
+			if err != nil {
+				log.Println(err)
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			pf, err := forecastProduct(product, demand[product.ProductID], onOrder[product.ProductID], leadTime, settings)
+			if err != nil {
+				log.Println(err)
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			if all || pf.Reorder.ReorderNow {
+				suggestions = append(suggestions, pf)
+			}
+		}
+		sort.SliceStable(suggestions, func(i, j int) bool {
+			a, b := suggestions[i].Reorder, suggestions[j].Reorder
+			if a.ReorderNow != b.ReorderNow {
+				return a.ReorderNow
+			}
+			if a.DaysOfCover == nil || b.DaysOfCover == nil {
+				return a.DaysOfCover != nil && b.DaysOfCover == nil
+			}
+			return *a.DaysOfCover < *b.DaysOfCover
+		})
+		response.WriteJSON(w, suggestions)
+
+	case http.MethodOptions:
+		return
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
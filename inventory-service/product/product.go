@@ -1,5 +1,17 @@
 package product
 
+import (
+	"errors"
+
+	"github.com/jordbick/Golang/inventory-service/location"
+)
+
+// SKUs and UPCs are unique across products, these are returned when an insert or update would break that
+var (
+	ErrDuplicateSku = errors.New("another product already has this sku")
+	ErrDuplicateUpc = errors.New("another product already has this upc")
+)
+
 // All product related functionality here
 
 //Product
@@ -11,4 +23,17 @@ type Product struct {
 	PricePerUnit   string `json:"pricePerUnit"`
 	QuantityOnHand int    `json:"quantityOnHand"`
 	ProductName    string `json:"productName"`
+	// ManufacturerID references the suppliers table, Manufacturer is kept in step with that supplier's name
+	ManufacturerID int `json:"manufacturerId"`
+	// CategoryIDs is left unchanged by an update if it's not sent
+	CategoryIDs []int `json:"categoryIds"`
+	// A variant (e.g. a size or colour) points at its parent product, and has its own SKU, UPC, price and stock
+	ParentProductID int `json:"parentProductId"`
+	// Attributes are the custom attribute values keyed by name, also left unchanged by an update if not sent
+	Attributes map[string]interface{} `json:"attributes"`
+	// Variants are only filled in when a single parent product is fetched
+	Variants []Product `json:"variants,omitempty"`
+	// Per-location breakdown of QuantityOnHand, only filled in when a single product is fetched
+	Locations           []location.StockLevel `json:"locations,omitempty"`
+	UnallocatedQuantity *int                  `json:"unallocatedQuantity,omitempty"`
 }
@@ -0,0 +1,151 @@
+package product
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/jordbick/Golang/inventory-service/pdf"
+)
+
+// PDF reports are laid out on landscape Letter pages
+// Every page repeats the title and column headers and gets a "Page n of m" footer, the filter summary goes on the first page and the totals after the last row
+
+const (
+	pdfMargin    = 36.0
+	pdfTitleSize = 14.0
+	pdfFontSize  = 8.0
+	pdfRowHeight = 14.0
+	// pdfPadding is the space either side of the text in a cell
+	pdfPadding = 4.0
+	// pdfMinColumn stops a long text column squeezing the others down to nothing
+	pdfMinColumn = 40.0
+)
+
+func writePDFReport(w io.Writer, table reportTable) error {
+	doc := pdf.New(pdf.LetterHeight, pdf.LetterWidth)
+	width, height := doc.Size()
+	widths := pdfColumnWidths(table, width-2*pdfMargin)
+
+	pages := make([]*pdf.Page, 0)
+	var page *pdf.Page
+	y := 0.0
+	newPage := func() {
+		page = doc.AddPage()
+		pages = append(pages, page)
+		page.Text(pdfMargin, pdfMargin+pdfTitleSize, pdf.HelveticaBold, pdfTitleSize, table.Title)
+		generated := "Generated " + table.Generated
+		page.Text(width-pdfMargin-pdf.TextWidth(pdf.Helvetica, pdfFontSize, generated), pdfMargin+pdfTitleSize, pdf.Helvetica, pdfFontSize, generated)
+		page.Line(pdfMargin, pdfMargin+pdfTitleSize+6, width-pdfMargin, pdfMargin+pdfTitleSize+6, 0.5)
+		y = pdfMargin + pdfTitleSize + 6 + pdfRowHeight
+		if len(pages) == 1 {
+			for _, line := range table.Summary {
+				page.Text(pdfMargin, y, pdf.Helvetica, pdfFontSize, pdf.Fit(pdf.Helvetica, pdfFontSize, line, width-2*pdfMargin))
+				y += pdfRowHeight
+			}
+			y += pdfRowHeight / 2
+		}
+		page.SetGray(0.85)
+		page.Rect(pdfMargin, y-pdfRowHeight+3, width-2*pdfMargin, pdfRowHeight)
+		page.SetGray(0)
+		headers := make([]string, len(table.Columns))
+		for i, c := range table.Columns {
+			headers[i] = c.Header
+		}
+		pdfRow(page, table, widths, y, pdf.HelveticaBold, headers)
+		y += pdfRowHeight
+	}
+	// leave room at the bottom for the footer
+	bottom := height - pdfMargin - pdfRowHeight
+
+	newPage()
+	for i, row := range table.Rows {
+		if y > bottom {
+			newPage()
+		}
+		if i%2 == 1 {
+			page.SetGray(0.95)
+			page.Rect(pdfMargin, y-pdfRowHeight+3, width-2*pdfMargin, pdfRowHeight)
+			page.SetGray(0)
+		}
+		pdfRow(page, table, widths, y, pdf.Helvetica, row)
+		y += pdfRowHeight
+	}
+	if table.Totals != nil {
+		if y > bottom {
+			newPage()
+		}
+		page.Line(pdfMargin, y-pdfRowHeight+3, width-pdfMargin, y-pdfRowHeight+3, 0.5)
+		pdfRow(page, table, widths, y, pdf.HelveticaBold, table.Totals)
+	}
+
+	// the page count is only known once everything is laid out
+	for i, p := range pages {
+		footer := fmt.Sprintf("Page %d of %d", i+1, len(pages))
+		p.Text((width-pdf.TextWidth(pdf.Helvetica, pdfFontSize, footer))/2, height-pdfMargin, pdf.Helvetica, pdfFontSize, footer)
+	}
+	return doc.Write(w)
+}
+
+// pdfRow draws a row of cells, numbers are right aligned and text that doesn't fit is cut short
+func pdfRow(page *pdf.Page, table reportTable, widths []float64, y float64, font string, cells []string) {
+	x := pdfMargin
+	for i, cell := range cells {
+		text := pdf.Fit(font, pdfFontSize, cell, widths[i]-2*pdfPadding)
+		if text == "" {
+			// nothing to draw, e.g. the totals of columns that aren't totalled
+		} else if table.Columns[i].Numeric {
+			page.Text(x+widths[i]-pdfPadding-pdf.TextWidth(font, pdfFontSize, text), y, font, pdfFontSize, text)
+		} else {
+			page.Text(x+pdfPadding, y, font, pdfFontSize, text)
+		}
+		x += widths[i]
+	}
+}
+
+// pdfColumnWidths sizes each column to its widest cell, then shares out the page width
+// number columns keep their width if the page is too narrow, and the text columns are cut down to fit
+func pdfColumnWidths(table reportTable, available float64) []float64 {
+	widths := make([]float64, len(table.Columns))
+	measure := func(i int, font, text string) {
+		if w := pdf.TextWidth(font, pdfFontSize, text) + 2*pdfPadding; w > widths[i] {
+			widths[i] = w
+		}
+	}
+	for i, c := range table.Columns {
+		measure(i, pdf.HelveticaBold, c.Header)
+	}
+	for _, row := range table.Rows {
+		for i, cell := range row {
+			measure(i, pdf.Helvetica, cell)
+		}
+	}
+	for i, cell := range table.Totals {
+		measure(i, pdf.HelveticaBold, cell)
+	}
+
+	total, numeric := 0.0, 0.0
+	for i, c := range table.Columns {
+		total += widths[i]
+		if c.Numeric {
+			numeric += widths[i]
+		}
+	}
+	if total <= available || numeric >= available {
+		// spread the spare room (or the squeeze, if even the numbers don't fit) evenly
+		for i := range widths {
+			widths[i] *= available / total
+		}
+		return widths
+	}
+	text := total - numeric
+	scale := (available - numeric) / text
+	for i, c := range table.Columns {
+		if !c.Numeric {
+			widths[i] *= scale
+			if widths[i] < pdfMinColumn {
+				widths[i] = pdfMinColumn
+			}
+		}
+	}
+	return widths
+}
@@ -3,11 +3,18 @@ package product
 import (
 	"bytes"
 	"encoding/json"
-	"html/template"
+	"errors"
+	"fmt"
+	"io"
 	"log"
 	"net/http"
-	"path"
+	"strconv"
+	"strings"
 	"time"
+
+	"github.com/jordbick/Golang/inventory-service/category"
+	"github.com/jordbick/Golang/inventory-service/filter"
+	"github.com/jordbick/Golang/inventory-service/locale"
 )
 
 // define a new type to hold our filter fields
@@ -15,9 +22,21 @@ type ProductReportFilter struct {
 	NameFilter         string `json:"productName"`
 	ManufacturerFilter string `json:"manufacturer"`
 	SKUFilter          string `json:"sku"`
+	// ManufacturerIDFilter matches an exact supplier, the text filter above matches any supplier with a similar name
+	ManufacturerIDFilter int `json:"manufacturerId"`
+	// CategoryIDFilter includes products in any subcategory of the category
+	CategoryIDFilter int `json:"categoryId"`
+	// AttributeFilters matches custom attribute values exactly, e.g. {"size": "XL", "color": "red"}
+	AttributeFilters map[string]string `json:"attributes"`
+	// Query is a full text search across all the text fields, the report is then ordered by relevance
+	Query string `json:"q"`
+	// Filter is an expression over the product fields, e.g. `quantityOnHand < 10 AND (manufacturer = "acme" OR price >= 20)`
+	Filter string `json:"filter"`
 }
 
 // Handler to handle the incoming request
+// The report comes back as HTML, CSV, XLSX or PDF depending on ?format= or the Accept header
+// ?columns=a,b and ?sort=column desc pick the columns and order, ?template= the HTML template, and ?locale= or Accept-Language decides how CSV numbers look
 func handleProductReport(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	// if type post we need to get the productFilter out of the request body
@@ -33,47 +52,78 @@ func handleProductReport(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		// Define function to get the products from the DB using these filters
-		// The searchForProductData is declared in the product.data file
-		products, err := searchForProductData(productFilter)
-		if err != nil {
-			log.Println(err)
-			w.WriteHeader(http.StatusInternalServerError)
+		query := r.URL.Query()
+		format := query.Get("format")
+		if format == "" {
+			var ok bool
+			format, ok = negotiateReportFormat(r.Header.Get("Accept"))
+			if !ok {
+				http.Error(w, "reports are available as text/html, text/csv, application/pdf or "+ReportContentType(ReportFormatXLSX), http.StatusNotAcceptable)
+				return
+			}
+		}
+		var columns []string
+		if s := query.Get("columns"); s != "" {
+			columns = strings.Split(s, ",")
+		}
+		sortBy := query.Get("sort")
+		if err = ValidateReportOptions(sortBy, columns, format); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		// ?template= picks one of the named templates for an HTML report
+		templateName := query.Get("template")
+		if templateName == "" {
+			templateName = DefaultReportTemplate
+		} else if _, ok := lookupReportTemplate(templateName); !ok {
+			http.Error(w, fmt.Sprintf("unknown report template [%s]", templateName), http.StatusBadRequest)
 			return
 		}
+		loc := locale.Negotiate(r.Header.Get("Accept-Language"))
+		if s := query.Get("locale"); s != "" {
+			var ok bool
+			if loc, ok = locale.Lookup(s); !ok {
+				http.Error(w, fmt.Sprintf("unsupported locale [%s]", s), http.StatusBadRequest)
+				return
+			}
+		}
 
-		// define our template with name to match the file name (which is a formatted HTML document with values for a single product name and QuantityOnHand)
-		// Extending functionality using Funcs method
-		// FuncMap. key is "mod" and value is the atcual function
-		// inline function, to return whether or not the input modulus something, is equal to 0
-		// The mod function is now available to us within the template
-		t := template.New("report.gotmpl").Funcs(template.FuncMap{"mod": func(i, x int) bool { return i%x == 0 }})
-		// Call ParseFiles to Parse a file instead of just a string. Pass path with is directory with file name
-		t, err = t.ParseFiles(path.Join("templates", "report.gotmpl"))
-		if err != nil {
-			log.Println(err)
-			w.WriteHeader(http.StatusInternalServerError)
+		// spreadsheets are streamed straight into the response rather than built up in memory first
+		if format == ReportFormatCSV || format == ReportFormatXLSX {
+			w.Header().Set("Content-Type", ReportContentType(format))
+			w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="products.%s"`, format))
+			out := &reportWriter{ResponseWriter: w}
+			_, _, err = runReport(r.Context(), out, productFilter, sortBy, columns, format, templateName, loc)
+			if err != nil {
+				w.Header().Del("Content-Disposition")
+				reportError(out, err)
+			}
 			return
 		}
 
-		// return the first product from the list
-		// define a new product variable and bytes.Buffer
-		// if we get one product back then we'll call execute on our template
+		// Define function to get the products from the DB using these filters
+		// RunReport runs the query declared in the product.data file
+		// define a new bytes.Buffer and call execute on our template
 		var tmpl bytes.Buffer
-		// var product Product
-		if len(products) > 0 {
-			// product = products[0]
-			// Write the data to our bytes.Buffer variable
-			err = t.Execute(&tmpl, products)
-		} else {
+		contentType, count, err := runReport(r.Context(), &tmpl, productFilter, sortBy, columns, format, templateName, loc)
+		if err != nil {
+			reportError(w, err)
+			return
+		} else if count == 0 {
 			w.WriteHeader(http.StatusNotFound)
+			return
 		}
 
 		// In order to send this back as a file we need to define a NewReader to read the byte data into the response thats sent back to the client using http.ServeContent
 		// Takes a responseWriter, a request, a file name, a modified time and our reader
 		rdr := bytes.NewReader(tmpl.Bytes())
+		name := "report.html"
+		if format == ReportFormatPDF {
+			name = "report.pdf"
+		}
+		w.Header().Set("Content-Type", contentType)
 		w.Header().Set("Content-Disposition", "Attachment")
-		http.ServeContent(w, r, "report.html", time.Now(), rdr)
+		http.ServeContent(w, r, name, time.Now(), rdr)
 
 	case http.MethodOptions:
 		return
@@ -85,4 +135,96 @@ func handleProductReport(w http.ResponseWriter, r *http.Request) {
 
 }
 
+// reportWriter notes whether any of a streamed report has been sent
+type reportWriter struct {
+	http.ResponseWriter
+	written bool
+}
+
+func (w *reportWriter) Write(p []byte) (int, error) {
+	if len(p) > 0 {
+		w.written = true
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+// reportError sends the status for an error running a report
+// once a streamed report has started the status has already gone, so the error is logged and the connection is cut off
+// rather than ending the response cleanly, otherwise the client would take what it got for the whole report
+func reportError(w http.ResponseWriter, err error) {
+	if out, ok := w.(*reportWriter); ok && out.written {
+		log.Println(err)
+		panic(http.ErrAbortHandler)
+	}
+	var filterErr *filter.Error
+	if err == category.ErrCategoryNotFound {
+		w.WriteHeader(http.StatusNotFound)
+	} else if errors.As(err, &filterErr) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	} else {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+// reportMediaTypes are the formats a client can ask for with an Accept header, in the order we prefer them
+var reportMediaTypes = []struct{ mediaType, format string }{
+	{"text/html", ReportFormatHTML},
+	{"text/csv", ReportFormatCSV},
+	{ReportContentType(ReportFormatXLSX), ReportFormatXLSX},
+	{"application/pdf", ReportFormatPDF},
+}
+
+// negotiateReportFormat picks the report format the Accept header likes best, ties go to the order of reportMediaTypes
+// no Accept header at all gets the HTML report
+func negotiateReportFormat(accept string) (string, bool) {
+	if strings.TrimSpace(accept) == "" {
+		return ReportFormatHTML, true
+	}
+	best, bestQuality := "", 0.0
+	for _, m := range reportMediaTypes {
+		quality, specific := 0.0, -1
+		for _, part := range strings.Split(accept, ",") {
+			fields := strings.Split(part, ";")
+			mediaType := strings.ToLower(strings.TrimSpace(fields[0]))
+			q := 1.0
+			for _, param := range fields[1:] {
+				param = strings.TrimSpace(param)
+				if strings.HasPrefix(param, "q=") {
+					if f, err := strconv.ParseFloat(param[2:], 64); err == nil {
+						q = f
+					}
+				}
+			}
+			// the most specific match decides the quality, so "*/*, text/csv;q=0" rules out CSV
+			level := -1
+			switch {
+			case mediaType == m.mediaType:
+				level = 2
+			case mediaType == m.mediaType[:strings.Index(m.mediaType, "/")]+"/*":
+				level = 1
+			case mediaType == "*/*":
+				level = 0
+			}
+			if level > specific {
+				specific, quality = level, q
+			}
+		}
+		if quality > bestQuality {
+			best, bestQuality = m.format, quality
+		}
+	}
+	return best, best != ""
+}
+
+// writeHTMLReport executes the named report template with the report table
+// the templates are parsed once at startup, see product.templates.go
+func writeHTMLReport(w io.Writer, templateName string, table reportTable) error {
+	t, ok := lookupReportTemplate(templateName)
+	if !ok {
+		return ErrReportTemplateNotFound
+	}
+	return t.Execute(w, table)
+}
+
 // Need to add to our SetupRoutes function in our product.service file
@@ -0,0 +1,28 @@
+package product
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestReportError(t *testing.T) {
+	// nothing sent yet, the status says what went wrong
+	w := httptest.NewRecorder()
+	reportError(&reportWriter{ResponseWriter: w}, errors.New("lost connection"))
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("before the report started: %d, want 500", w.Code)
+	}
+
+	// part way through a streamed report, the response is cut off instead of being finished
+	w = httptest.NewRecorder()
+	out := &reportWriter{ResponseWriter: w}
+	out.Write([]byte("productId,productName\n"))
+	defer func() {
+		if recovered := recover(); recovered != http.ErrAbortHandler {
+			t.Errorf("part way through the report: recovered %v, want http.ErrAbortHandler", recovered)
+		}
+	}()
+	reportError(out, errors.New("lost connection"))
+}
@@ -0,0 +1,151 @@
+package product
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jordbick/Golang/inventory-service/attribute"
+	"github.com/jordbick/Golang/inventory-service/search"
+)
+
+// Full text search over products with ?q=
+// The index is held in memory, it's built at startup, kept up to date by the product handlers, and rebuilt now and again to pick up
+// changes made elsewhere such as a supplier being renamed
+
+// searchRefreshInterval is how often the whole index is rebuilt from the database
+const searchRefreshInterval = 10 * time.Minute
+
+// A match on a code is the strongest signal, then the name, then the manufacturer and attribute values
+var productIndex = search.New(map[string]float64{
+	"sku":          4,
+	"upc":          4,
+	"productName":  3,
+	"manufacturer": 2,
+	"attributes":   1,
+})
+
+// SearchResult is a product with how well it matched the query and the matching text highlighted
+type SearchResult struct {
+	Product
+	Score      float64           `json:"score"`
+	Highlights map[string]string `json:"highlights"`
+}
+
+// searchFields returns the text of a product that is searchable
+func searchFields(product Product) map[string]string {
+	attributeNames := make([]string, 0, len(product.Attributes))
+	for name := range product.Attributes {
+		attributeNames = append(attributeNames, name)
+	}
+	sort.Strings(attributeNames)
+	values := make([]string, 0, len(attributeNames))
+	for _, name := range attributeNames {
+		if s, ok := product.Attributes[name].(string); ok {
+			values = append(values, s)
+		}
+	}
+	return map[string]string{
+		"sku":          product.Sku,
+		"upc":          product.Upc,
+		"productName":  product.ProductName,
+		"manufacturer": product.Manufacturer,
+		"attributes":   strings.Join(values, ", "),
+	}
+}
+
+// StartSearchIndex builds the search index and then rebuilds it every searchRefreshInterval until shutdown is closed
+func StartSearchIndex(shutdown <-chan struct{}) error {
+	err := rebuildSearchIndex()
+	if err != nil {
+		return err
+	}
+	go func() {
+		ticker := time.NewTicker(searchRefreshInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-shutdown:
+				return
+			case <-ticker.C:
+				if err := rebuildSearchIndex(); err != nil {
+					log.Println(err)
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+func rebuildSearchIndex() error {
+	products, err := getProductList(0, "")
+	if err != nil {
+		return err
+	}
+	productIDs := make([]int, 0, len(products))
+	for _, p := range products {
+		productIDs = append(productIDs, p.ProductID)
+	}
+	attributes, err := attribute.GetProductAttributes(productIDs)
+	if err != nil {
+		return err
+	}
+	docs := make(map[int]map[string]string, len(products))
+	for _, p := range products {
+		p.Attributes = attributes[p.ProductID]
+		docs[p.ProductID] = searchFields(p)
+	}
+	productIndex.Replace(docs)
+	return nil
+}
+
+// indexProduct adds or refreshes a single product in the search index after it has been saved
+// If it fails the next full rebuild will catch up, so the error is only logged
+func indexProduct(productID int) {
+	product, err := getProduct(productID)
+	if err == nil && product != nil {
+		var withDetail []Product
+		withDetail, err = withDetails([]Product{*product})
+		if err == nil {
+			productIndex.Add(productID, searchFields(withDetail[0]))
+			return
+		}
+	}
+	if err != nil {
+		log.Println(err)
+	}
+	productIndex.Remove(productID)
+}
+
+// searchProducts runs the query against the index, keeping only the products in the candidates
+// candidates are the products the other filters allow, the results come back in order of relevance
+func searchProducts(query string, candidates []Product) []SearchResult {
+	byID := make(map[int]Product, len(candidates))
+	for _, p := range candidates {
+		byID[p.ProductID] = p
+	}
+	results := make([]SearchResult, 0)
+	for _, hit := range productIndex.Search(query) {
+		product, ok := byID[hit.ID]
+		if !ok {
+			continue
+		}
+		results = append(results, SearchResult{Product: product, Score: hit.Score, Highlights: hit.Highlights})
+	}
+	return results
+}
+
+// searchLimit reads ?limit= for search results
+func searchLimit(value string) (int, error) {
+	if value == "" {
+		return 50, nil
+	}
+	limit, err := strconv.Atoi(value)
+	if err != nil || limit < 1 || limit > 1000 {
+		return 0, fmt.Errorf("limit must be between 1 and 1000")
+	}
+	return limit, nil
+}
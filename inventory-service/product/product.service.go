@@ -4,11 +4,17 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"log"
 	"net/http"
 	"strconv"
 	"strings"
 
+	"github.com/jordbick/Golang/inventory-service/attribute"
+	"github.com/jordbick/Golang/inventory-service/category"
 	"github.com/jordbick/Golang/inventory-service/cors"
+	"github.com/jordbick/Golang/inventory-service/location"
+	"github.com/jordbick/Golang/inventory-service/supplier"
+	"github.com/jordbick/Golang/inventory-service/webhook"
 	"golang.org/x/net/websocket"
 )
 
@@ -24,17 +30,38 @@ func SetupRoutes(apiBasePath string) {
 	handleProducts := http.HandlerFunc(productsHandler)
 	handleProduct := http.HandlerFunc(productHandler)
 	handleReports := http.HandlerFunc(handleProductReport)
+	handleLabels := http.HandlerFunc(handleProductLabels)
 	// string argument to take a base route path from the main function
 	// wrap our handler setup with a new middleware function
 	http.Handle(fmt.Sprintf("%s/%s", apiBasePath, productsBasePath), cors.Middleware(handleProducts))
 	http.Handle(fmt.Sprintf("%s/%s/", apiBasePath, productsBasePath), cors.Middleware(handleProduct))
 	http.Handle("/websocket", websocket.Handler(productSocket))
 	http.Handle(fmt.Sprintf("%s/%s/reports", apiBasePath, productsBasePath), cors.Middleware(handleReports))
+	http.Handle(fmt.Sprintf("%s/%s/labels", apiBasePath, productsBasePath), cors.Middleware(handleLabels))
+	setupReportTemplateRoutes(apiBasePath)
 }
 
 func productHandler(w http.ResponseWriter, r *http.Request) {
 	urlPathSegments := strings.Split(r.URL.Path, "/products/")
-	productID, err := strconv.Atoi(urlPathSegments[len(urlPathSegments)-1])
+	// /products/by-sku/{sku} and /products/by-upc/{upc} let barcode scanners look a product up directly
+	lastSegment := urlPathSegments[len(urlPathSegments)-1]
+	if strings.HasPrefix(lastSegment, "by-sku/") || strings.HasPrefix(lastSegment, "by-upc/") {
+		productLookupHandler(w, r, lastSegment)
+		return
+	}
+	if strings.HasSuffix(lastSegment, "/barcode") {
+		barcodeHandler(w, r, strings.TrimSuffix(lastSegment, "/barcode"))
+		return
+	}
+	if strings.HasSuffix(lastSegment, "/forecast") {
+		forecastHandler(w, r, strings.TrimSuffix(lastSegment, "/forecast"))
+		return
+	}
+	if lastSegment == "reorder-suggestions" {
+		reorderSuggestionsHandler(w, r)
+		return
+	}
+	productID, err := strconv.Atoi(lastSegment)
 	if err != nil {
 		w.WriteHeader(http.StatusNotFound)
 		return
@@ -52,7 +79,35 @@ func productHandler(w http.ResponseWriter, r *http.Request) {
 
 	switch r.Method {
 	case http.MethodGet:
-		productJSON, err := json.Marshal(product)
+		// include where the stock is held, anything not in a location is unallocated
+		product.Locations, err = location.GetProductStock(productID)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		unallocated := product.QuantityOnHand
+		for _, level := range product.Locations {
+			unallocated -= level.Quantity
+		}
+		product.UnallocatedQuantity = &unallocated
+		if product.ParentProductID == 0 {
+			variants, err := getVariants(productID)
+			if err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			product.Variants, err = withDetails(variants)
+			if err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+		}
+		withDetail, err := withDetails([]Product{*product})
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		productJSON, err := json.Marshal(withDetail[0])
 		if err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
 			return
@@ -76,16 +131,54 @@ func productHandler(w http.ResponseWriter, r *http.Request) {
 			w.WriteHeader(http.StatusBadRequest)
 			return
 		}
+		if err = resolveManufacturer(&updatedProduct); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err = validateDetails(&updatedProduct); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 		// Update our code to replace the item in the slice with our call to the addOrUpdateProduct function
 		err = updateProduct(updatedProduct)
-		if err != nil {
+		if err == location.ErrInsufficientStock {
+			// can't reduce the quantity below what is held in locations
+			w.WriteHeader(http.StatusConflict)
+			return
+		} else if err == ErrDuplicateSku || err == ErrDuplicateUpc {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		} else if err != nil {
 			w.WriteHeader(http.StatusBadRequest)
 			return
 		}
+		err = saveDetails(updatedProduct)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		indexProduct(productID)
+		publishEvent(webhook.EventProductUpdated, updatedProduct)
 		w.WriteHeader(http.StatusOK)
 
 	case http.MethodDelete:
-		removeProduct(productID)
+		// a parent can't be removed while it still has variants
+		variants, err := getVariants(productID)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if len(variants) > 0 {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		err = removeProduct(productID)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		productIndex.Remove(productID)
+		publishEvent(webhook.EventProductDeleted, product)
 		w.WriteHeader(http.StatusAccepted)
 
 	case http.MethodOptions:
@@ -98,11 +191,117 @@ func productHandler(w http.ResponseWriter, r *http.Request) {
 
 }
 
+// productLookupHandler finds a product by its SKU or UPC rather than its ID
+func productLookupHandler(w http.ResponseWriter, r *http.Request, lookup string) {
+	switch r.Method {
+	case http.MethodGet:
+		var product *Product
+		var err error
+		code := strings.SplitN(lookup, "/", 2)[1]
+		if code == "" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if strings.HasPrefix(lookup, "by-sku/") {
+			product, err = getProductBySku(code)
+		} else {
+			product, err = getProductByUpc(code)
+		}
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if product == nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		withDetail, err := withDetails([]Product{*product})
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		productJSON, err := json.Marshal(withDetail[0])
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(productJSON)
+
+	case http.MethodOptions:
+		return
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
 func productsHandler(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case http.MethodGet:
+		// ?categoryId= lists the products in a category and all its subcategories
+		categoryID := 0
+		if c := r.URL.Query().Get("categoryId"); c != "" {
+			var err error
+			categoryID, err = strconv.Atoi(c)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+		}
+		// ?filter= takes an expression such as quantityOnHand < 10 AND manufacturer = "acme"
+		var condition string
+		var conditionArgs []interface{}
+		if f := r.URL.Query().Get("filter"); f != "" {
+			var err error
+			condition, conditionArgs, err = compileFilter(f)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
 		// Need to add err in the return for the getProductList function now
-		productList, err := getProductList()
+		productList, err := getProductList(categoryID, condition, conditionArgs...)
+		if err == category.ErrCategoryNotFound {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		} else if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		// ?q= searches the products instead, returning the best matches first with the matching text highlighted
+		if q := r.URL.Query().Get("q"); q != "" {
+			limit, err := searchLimit(r.URL.Query().Get("limit"))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			results := searchProducts(q, productList)
+			if len(results) > limit {
+				results = results[:limit]
+			}
+			matches := make([]Product, len(results))
+			for i := range results {
+				matches[i] = results[i].Product
+			}
+			matches, err = withDetails(matches)
+			if err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			for i := range results {
+				results[i].Product = matches[i]
+			}
+			resultsJSON, err := json.Marshal(results)
+			if err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.Write(resultsJSON)
+			return
+		}
+		productList, err = withDetails(productList)
 		if err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
 			return
@@ -132,12 +331,31 @@ func productsHandler(w http.ResponseWriter, r *http.Request) {
 			w.WriteHeader(http.StatusBadRequest)
 			return
 		}
+		if err = resolveManufacturer(&newProduct); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err = validateDetails(&newProduct); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 		// Logic to getNextID is now handled in our data access layer using addOrUpdateProduct function
-		_, err = insertProduct(newProduct)
+		productID, err := insertProduct(newProduct)
+		if err == ErrDuplicateSku || err == ErrDuplicateUpc {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		} else if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		newProduct.ProductID = productID
+		err = saveDetails(newProduct)
 		if err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
+		indexProduct(productID)
+		publishEvent(webhook.EventProductCreated, newProduct)
 		w.WriteHeader(http.StatusCreated)
 		return
 
@@ -149,3 +367,115 @@ func productsHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 }
+
+// resolveManufacturer links the product to a supplier
+// Clients can send a manufacturerId, or just the manufacturer name in which case the matching supplier is found or created
+func resolveManufacturer(product *Product) error {
+	if product.ManufacturerID != 0 {
+		manufacturer, err := supplier.GetSupplier(product.ManufacturerID)
+		if err != nil {
+			return err
+		}
+		if manufacturer == nil {
+			return fmt.Errorf("manufacturer [%d] doesn't exist", product.ManufacturerID)
+		}
+		product.Manufacturer = manufacturer.Name
+		return nil
+	}
+	if supplier.NormalizeName(product.Manufacturer) == "" {
+		return nil
+	}
+	manufacturer, err := supplier.Resolve(product.Manufacturer)
+	if err != nil {
+		return err
+	}
+	product.ManufacturerID = manufacturer.SupplierID
+	product.Manufacturer = manufacturer.Name
+	return nil
+}
+
+// validateDetails checks the product's parent, categories and attributes before anything is saved
+func validateDetails(product *Product) error {
+	if product.ParentProductID != 0 {
+		if product.ParentProductID == product.ProductID {
+			return fmt.Errorf("a product can't be its own parent")
+		}
+		parent, err := getProduct(product.ParentProductID)
+		if err != nil {
+			return err
+		}
+		if parent == nil {
+			return fmt.Errorf("parent product [%d] doesn't exist", product.ParentProductID)
+		}
+		if parent.ParentProductID != 0 {
+			return fmt.Errorf("a variant can't have variants of its own")
+		}
+		if product.ProductID != 0 {
+			variants, err := getVariants(product.ProductID)
+			if err != nil {
+				return err
+			}
+			if len(variants) > 0 {
+				return fmt.Errorf("a product with variants can't become a variant")
+			}
+		}
+		// a new variant without categories takes its parent's, so it can use the same attributes
+		if product.ProductID == 0 && product.CategoryIDs == nil {
+			categoryIDs, err := category.GetProductCategoryIDs([]int{parent.ProductID})
+			if err != nil {
+				return err
+			}
+			product.CategoryIDs = categoryIDs[parent.ProductID]
+		}
+	}
+	if err := category.ValidateIDs(product.CategoryIDs); err != nil {
+		return err
+	}
+	if product.Attributes != nil {
+		categoryIDs, err := effectiveCategories(*product)
+		if err != nil {
+			return err
+		}
+		return attribute.ValidateProductAttributes(categoryIDs, product.Attributes)
+	}
+	return nil
+}
+
+// effectiveCategories is the categories being sent, or the ones already saved if they're not being changed
+func effectiveCategories(product Product) ([]int, error) {
+	if product.CategoryIDs != nil || product.ProductID == 0 {
+		return product.CategoryIDs, nil
+	}
+	categoryIDs, err := category.GetProductCategoryIDs([]int{product.ProductID})
+	if err != nil {
+		return nil, err
+	}
+	return categoryIDs[product.ProductID], nil
+}
+
+// saveDetails writes the categories and attributes that were sent with the product
+func saveDetails(product Product) error {
+	if product.CategoryIDs != nil {
+		err := category.SetProductCategories(product.ProductID, product.CategoryIDs)
+		if err != nil {
+			return err
+		}
+	}
+	if product.Attributes != nil {
+		categoryIDs, err := effectiveCategories(product)
+		if err != nil {
+			return err
+		}
+		return attribute.SetProductAttributes(product.ProductID, categoryIDs, product.Attributes)
+	}
+	return nil
+}
+
+// publishEvent queues a webhook event for the product change
+// A failure here is only logged, the change itself has already been saved
+func publishEvent(eventType string, product interface{}) {
+	err := webhook.Publish(eventType, product)
+	if err != nil {
+		log.Println(err)
+	}
+}
@@ -0,0 +1,123 @@
+package product
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jordbick/Golang/inventory-service/locale"
+)
+
+// reportTable is a report laid out as rows of formatted text
+// The HTML template and the PDF writer both render it, so they always show the same columns, numbers and totals
+type reportTable struct {
+	Title     string
+	Generated string
+	// Summary describes the filter and sort the report was run with, one line each
+	Summary []string
+	Columns []reportTableColumn
+	Rows    [][]string
+	// Totals is nil when none of the columns are totalled
+	Totals []string
+}
+
+type reportTableColumn struct {
+	Header  string
+	Numeric bool
+}
+
+func buildReportTable(products []Product, columns []reportColumn, productFilter ProductReportFilter, sortBy string, loc locale.Locale) reportTable {
+	table := reportTable{
+		Title:     "Product Summary Report",
+		Generated: time.Now().UTC().Format("2006-01-02 15:04 MST"),
+		Summary:   reportSummary(productFilter, sortBy),
+		Columns:   make([]reportTableColumn, len(columns)),
+		Rows:      make([][]string, len(products)),
+	}
+	for i, c := range columns {
+		table.Columns[i] = reportTableColumn{Header: c.header, Numeric: c.kind != textColumn}
+	}
+	sums := newTotals(columns)
+	for i, p := range products {
+		sums.add(p)
+		row := make([]string, len(columns))
+		for j, c := range columns {
+			row[j] = formatColumn(c, p, loc)
+		}
+		table.Rows[i] = row
+	}
+	if sums.any() {
+		table.Totals = make([]string, len(columns))
+		for i, c := range columns {
+			if c.total {
+				table.Totals[i] = formatNumber(c, sums.sums[i], loc)
+			}
+		}
+		if i := sums.labelColumn(); i >= 0 {
+			table.Totals[i] = "Total"
+		}
+	}
+	return table
+}
+
+// formatColumn formats a product's value in the column for people to read
+func formatColumn(c reportColumn, p Product, loc locale.Locale) string {
+	if c.kind == integerColumn || c.kind == decimalColumn {
+		return formatNumber(c, c.number(p), loc)
+	}
+	return c.value(p)
+}
+
+func formatNumber(c reportColumn, f float64, loc locale.Locale) string {
+	switch c.kind {
+	case integerColumn:
+		return loc.FormatNumber(f, 0)
+	case decimalColumn:
+		return loc.FormatNumber(f, 2)
+	}
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+// reportSummary describes the filter in words, e.g. "Product name contains: widget"
+func reportSummary(productFilter ProductReportFilter, sortBy string) []string {
+	summary := make([]string, 0)
+	add := func(label, value string) {
+		if value != "" {
+			summary = append(summary, label+": "+value)
+		}
+	}
+	add("Search", productFilter.Query)
+	add("Product name contains", productFilter.NameFilter)
+	add("Manufacturer contains", productFilter.ManufacturerFilter)
+	add("SKU contains", productFilter.SKUFilter)
+	if productFilter.ManufacturerIDFilter != 0 {
+		add("Manufacturer ID", strconv.Itoa(productFilter.ManufacturerIDFilter))
+	}
+	if productFilter.CategoryIDFilter != 0 {
+		add("Category ID (and subcategories)", strconv.Itoa(productFilter.CategoryIDFilter))
+	}
+	names := make([]string, 0, len(productFilter.AttributeFilters))
+	for name := range productFilter.AttributeFilters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		add("Attribute "+name, productFilter.AttributeFilters[name])
+	}
+	add("Filter", productFilter.Filter)
+	if parts := strings.Fields(sortBy); len(parts) > 0 {
+		if c, ok := findReportColumn(parts[0]); ok {
+			order := "ascending"
+			if len(parts) == 2 && strings.EqualFold(parts[1], "desc") {
+				order = "descending"
+			}
+			add("Sorted by", fmt.Sprintf("%s, %s", c.header, order))
+		}
+	}
+	if len(summary) == 0 {
+		summary = append(summary, "All products")
+	}
+	return summary
+}
@@ -0,0 +1,399 @@
+package product
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jordbick/Golang/inventory-service/cors"
+	"github.com/jordbick/Golang/inventory-service/response"
+	"github.com/jordbick/Golang/inventory-service/templates"
+)
+
+// HTML reports can use any of a set of named templates, picked with ?template= on the report endpoint
+// The built in templates are embedded in the binary and parsed once at startup, custom ones are uploaded through /api/reporttemplates and kept in the database
+// For working on the built in templates, WatchReportTemplates reloads them from a directory whenever a file changes
+
+// DefaultReportTemplate is the template used when a report doesn't ask for one
+const DefaultReportTemplate = "report"
+
+// MaxReportTemplateSize limits the size of an uploaded template
+const MaxReportTemplateSize = 256 << 10
+
+// ReportTemplateAdminKey has to be sent as "Authorization: Bearer <key>" to add, replace or delete a custom template,
+// templates run on the server and are shown to everyone who opens a report. With no key set templates can only be read
+var ReportTemplateAdminKey []byte
+
+var (
+	ErrReportTemplateNotFound = errors.New("report template not found")
+	ErrBuiltInReportTemplate  = errors.New("built in report templates can't be changed or deleted")
+)
+
+// ReportTemplate is a custom template uploaded through the API
+type ReportTemplate struct {
+	Name      string     `json:"name"`
+	Body      string     `json:"body,omitempty"`
+	BuiltIn   bool       `json:"builtIn"`
+	CreatedAt *time.Time `json:"createdAt,omitempty"`
+	UpdatedAt *time.Time `json:"updatedAt,omitempty"`
+}
+
+// reportTemplates holds every parsed template by name
+var reportTemplates = struct {
+	sync.RWMutex
+	builtIn map[string]*template.Template
+	custom  map[string]*template.Template
+}{builtIn: map[string]*template.Template{}, custom: map[string]*template.Template{}}
+
+// reportTemplateFuncs are available to every report template
+// mod is for striping rows, e.g. {{if mod $index 2}}
+var reportTemplateFuncs = template.FuncMap{"mod": func(i, x int) bool { return i%x == 0 }}
+
+var templateName = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,63}$`)
+
+// parseReportTemplate parses and test runs a template, so a template that fails on real data is rejected up front
+func parseReportTemplate(name, body string) (*template.Template, error) {
+	if !templateName.MatchString(name) {
+		return nil, fmt.Errorf("template name must be up to 64 lower case letters, digits, - and _")
+	}
+	if len(body) > MaxReportTemplateSize {
+		return nil, fmt.Errorf("template can't be more than %d bytes", MaxReportTemplateSize)
+	}
+	t, err := template.New(name).Funcs(reportTemplateFuncs).Parse(body)
+	if err != nil {
+		return nil, err
+	}
+	if err = t.Execute(ioutil.Discard, sampleReportTable()); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// sampleReportTable is what templates are test run against, it has a row and totals so every part of the template gets used
+func sampleReportTable() reportTable {
+	return reportTable{
+		Title:     "Sample Report",
+		Generated: "2006-01-02 15:04 UTC",
+		Summary:   []string{"All products"},
+		Columns:   []reportTableColumn{{Header: "Product Name"}, {Header: "Quantity On Hand", Numeric: true}},
+		Rows:      [][]string{{"Sample product", "1"}},
+		Totals:    []string{"Total", "1"},
+	}
+}
+
+// LoadReportTemplates parses the embedded templates and the custom templates in the database
+func LoadReportTemplates() error {
+	builtIn, err := parseTemplateFiles(templates.FS)
+	if err != nil {
+		return err
+	}
+	saved, err := getReportTemplateList(true)
+	if err != nil {
+		return err
+	}
+	custom := make(map[string]*template.Template, len(saved))
+	for _, t := range saved {
+		parsed, err := parseReportTemplate(t.Name, t.Body)
+		if err != nil {
+			// one bad template shouldn't stop the service starting, it just won't be available
+			log.Printf("report template %s: %v", t.Name, err)
+			continue
+		}
+		custom[t.Name] = parsed
+	}
+	reportTemplates.Lock()
+	defer reportTemplates.Unlock()
+	reportTemplates.builtIn = builtIn
+	reportTemplates.custom = custom
+	return nil
+}
+
+// parseTemplateFiles parses each .gotmpl file, named after the file without the extension
+func parseTemplateFiles(fsys fs.FS) (map[string]*template.Template, error) {
+	names, err := fs.Glob(fsys, "*.gotmpl")
+	if err != nil {
+		return nil, err
+	}
+	parsed := make(map[string]*template.Template, len(names))
+	for _, file := range names {
+		body, err := fs.ReadFile(fsys, file)
+		if err != nil {
+			return nil, err
+		}
+		name := strings.TrimSuffix(file, path.Ext(file))
+		if parsed[name], err = parseReportTemplate(name, string(body)); err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+	}
+	if parsed[DefaultReportTemplate] == nil {
+		return nil, fmt.Errorf("missing %s.gotmpl", DefaultReportTemplate)
+	}
+	return parsed, nil
+}
+
+func lookupReportTemplate(name string) (*template.Template, bool) {
+	reportTemplates.RLock()
+	defer reportTemplates.RUnlock()
+	if t, ok := reportTemplates.builtIn[name]; ok {
+		return t, true
+	}
+	t, ok := reportTemplates.custom[name]
+	return t, ok
+}
+
+func isBuiltInReportTemplate(name string) bool {
+	reportTemplates.RLock()
+	defer reportTemplates.RUnlock()
+	_, ok := reportTemplates.builtIn[name]
+	return ok
+}
+
+func setCustomReportTemplate(name string, t *template.Template) {
+	reportTemplates.Lock()
+	defer reportTemplates.Unlock()
+	if t == nil {
+		delete(reportTemplates.custom, name)
+		return
+	}
+	reportTemplates.custom[name] = t
+}
+
+// WatchReportTemplates reloads the built in templates from dir whenever one of the files changes, until done is closed
+// It's meant for template development, so the service doesn't need rebuilding to see a change
+func WatchReportTemplates(dir string, done <-chan struct{}) error {
+	fsys := os.DirFS(dir)
+	reload := func() error {
+		builtIn, err := parseTemplateFiles(fsys)
+		if err != nil {
+			return err
+		}
+		reportTemplates.Lock()
+		reportTemplates.builtIn = builtIn
+		reportTemplates.Unlock()
+		return nil
+	}
+	if err := reload(); err != nil {
+		return err
+	}
+	last := templatesModified(fsys)
+	go func() {
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+			modified := templatesModified(fsys)
+			if modified.Equal(last) {
+				continue
+			}
+			last = modified
+			// keep the templates we've got if the change doesn't parse, it's probably only half saved
+			if err := reload(); err != nil {
+				log.Printf("reloading report templates: %v", err)
+				continue
+			}
+			log.Printf("reloaded report templates from %s", dir)
+		}
+	}()
+	return nil
+}
+
+// templatesModified returns the latest modification time of the template files
+func templatesModified(fsys fs.FS) time.Time {
+	var latest time.Time
+	names, _ := fs.Glob(fsys, "*.gotmpl")
+	for _, name := range names {
+		info, err := fs.Stat(fsys, name)
+		if err == nil && info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest
+}
+
+// listReportTemplates returns the built in and custom templates, without their bodies
+func listReportTemplates() ([]ReportTemplate, error) {
+	custom, err := getReportTemplateList(false)
+	if err != nil {
+		return nil, err
+	}
+	reportTemplates.RLock()
+	list := make([]ReportTemplate, 0, len(reportTemplates.builtIn)+len(custom))
+	for name := range reportTemplates.builtIn {
+		list = append(list, ReportTemplate{Name: name, BuiltIn: true})
+	}
+	reportTemplates.RUnlock()
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return append(list, custom...), nil
+}
+
+// canChangeReportTemplates checks the request carries the admin key
+func canChangeReportTemplates(r *http.Request) bool {
+	if len(ReportTemplateAdminKey) == 0 {
+		return false
+	}
+	authorization := r.Header.Get("Authorization")
+	if !strings.HasPrefix(authorization, "Bearer ") {
+		return false
+	}
+	key := strings.TrimPrefix(authorization, "Bearer ")
+	return subtle.ConstantTimeCompare([]byte(key), ReportTemplateAdminKey) == 1
+}
+
+// /api/reporttemplates lists the templates and uploads new custom ones
+func reportTemplatesHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		list, err := listReportTemplates()
+		if err != nil {
+			log.Println(err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		response.WriteJSON(w, list)
+
+	case http.MethodPost:
+		if !canChangeReportTemplates(r) {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		var newTemplate ReportTemplate
+		err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 2*MaxReportTemplateSize)).Decode(&newTemplate)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		saveReportTemplate(w, newTemplate, true)
+
+	case http.MethodOptions:
+		return
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// /api/reporttemplates/{name} gets, replaces or deletes a custom template, built in templates can only be read
+func reportTemplateHandler(w http.ResponseWriter, r *http.Request) {
+	urlPathSegments := strings.Split(r.URL.Path, "reporttemplates/")
+	name := urlPathSegments[len(urlPathSegments)-1]
+	switch r.Method {
+	case http.MethodGet:
+		if isBuiltInReportTemplate(name) {
+			body, err := fs.ReadFile(templates.FS, name+".gotmpl")
+			if err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			response.WriteJSON(w, ReportTemplate{Name: name, Body: string(body), BuiltIn: true})
+			return
+		}
+		t, err := getReportTemplate(name)
+		if err != nil {
+			log.Println(err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if t == nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		response.WriteJSON(w, t)
+
+	case http.MethodPut:
+		if !canChangeReportTemplates(r) {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		var updatedTemplate ReportTemplate
+		err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 2*MaxReportTemplateSize)).Decode(&updatedTemplate)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if updatedTemplate.Name != "" && updatedTemplate.Name != name {
+			http.Error(w, "template name doesn't match the URL", http.StatusBadRequest)
+			return
+		}
+		updatedTemplate.Name = name
+		saveReportTemplate(w, updatedTemplate, false)
+
+	case http.MethodDelete:
+		if !canChangeReportTemplates(r) {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		if isBuiltInReportTemplate(name) {
+			http.Error(w, ErrBuiltInReportTemplate.Error(), http.StatusConflict)
+			return
+		}
+		err := removeReportTemplate(name)
+		if err == ErrReportTemplateNotFound {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		} else if err != nil {
+			log.Println(err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		setCustomReportTemplate(name, nil)
+
+	case http.MethodOptions:
+		return
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// saveReportTemplate validates a template and stores it, POST creates a new template and PUT replaces an existing one
+func saveReportTemplate(w http.ResponseWriter, t ReportTemplate, create bool) {
+	if isBuiltInReportTemplate(t.Name) {
+		http.Error(w, ErrBuiltInReportTemplate.Error(), http.StatusConflict)
+		return
+	}
+	parsed, err := parseReportTemplate(t.Name, t.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if create {
+		err = insertReportTemplate(t)
+	} else {
+		err = updateReportTemplate(t)
+	}
+	if err == ErrReportTemplateNotFound {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	} else if err == errDuplicateReportTemplate {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	} else if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	setCustomReportTemplate(t.Name, parsed)
+	if create {
+		w.WriteHeader(http.StatusCreated)
+	}
+}
+
+func setupReportTemplateRoutes(apiBasePath string) {
+	http.Handle(fmt.Sprintf("%s/reporttemplates", apiBasePath), cors.Middleware(http.HandlerFunc(reportTemplatesHandler)))
+	http.Handle(fmt.Sprintf("%s/reporttemplates/", apiBasePath), cors.Middleware(http.HandlerFunc(reportTemplateHandler)))
+}
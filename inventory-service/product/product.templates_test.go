@@ -0,0 +1,45 @@
+package product
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestReportTemplateChangesNeedAdminKey(t *testing.T) {
+	defer func(key []byte) { ReportTemplateAdminKey = key }(ReportTemplateAdminKey)
+
+	tests := []struct {
+		key           string
+		authorization string
+		allowed       bool
+	}{
+		// with no key configured nobody can change templates
+		{"", "", false},
+		{"", "Bearer ", false},
+		{"secret", "", false},
+		{"secret", "Bearer wrong", false},
+		{"secret", "secret", false},
+		{"secret", "Bearer secret", true},
+	}
+	for _, test := range tests {
+		ReportTemplateAdminKey = []byte(test.key)
+		// both are rejected after the key check, for the bad body and for being built in
+		for _, method := range []string{http.MethodPost, http.MethodPut} {
+			handler, url := reportTemplatesHandler, "/api/reporttemplates"
+			if method == http.MethodPut {
+				handler, url = reportTemplateHandler, "/api/reporttemplates/report"
+			}
+			r := httptest.NewRequest(method, url, strings.NewReader("{"))
+			if test.authorization != "" {
+				r.Header.Set("Authorization", test.authorization)
+			}
+			w := httptest.NewRecorder()
+			handler(w, r)
+			if (w.Code != http.StatusForbidden) != test.allowed {
+				t.Errorf("%s %s with key %q and Authorization %q: %d", method, url, test.key, test.authorization, w.Code)
+			}
+		}
+	}
+}
@@ -10,8 +10,8 @@ import (
 
 // Message type
 type message struct {
-	Data string `json: "data"`
-	Type string `json: "type"`
+	Data string `json:"data"`
+	Type string `json:"type"`
 }
 
 // websocket handler that accepts a websocket connection
@@ -0,0 +1,415 @@
+package purchaseorder
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jordbick/Golang/inventory-service/database"
+	"github.com/jordbick/Golang/inventory-service/ledger"
+	"github.com/jordbick/Golang/inventory-service/location"
+)
+
+// queryer is satisfied by both *sql.DB and *sql.Tx so the same lookups can be used inside and outside a transaction
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+func getHeader(ctx context.Context, q queryer, purchaseOrderID int, lock bool) (*PurchaseOrder, error) {
+	query := `SELECT purchaseOrderId,
+	COALESCE(supplierId, 0),
+	supplier,
+	status,
+	notes,
+	createdAt,
+	orderedAt
+	FROM purchase_orders
+	WHERE purchaseOrderId = ?`
+	if lock {
+		query += ` FOR UPDATE`
+	}
+	purchaseOrder := &PurchaseOrder{}
+	var orderedAt sql.NullTime
+	err := q.QueryRowContext(ctx, query, purchaseOrderID).Scan(&purchaseOrder.PurchaseOrderID,
+		&purchaseOrder.SupplierID,
+		&purchaseOrder.Supplier,
+		&purchaseOrder.Status,
+		&purchaseOrder.Notes,
+		&purchaseOrder.CreatedAt,
+		&orderedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if orderedAt.Valid {
+		purchaseOrder.OrderedAt = &orderedAt.Time
+	}
+	return purchaseOrder, nil
+}
+
+func getLines(ctx context.Context, q queryer, purchaseOrderID int, lock bool) ([]Line, error) {
+	query := `SELECT lineId,
+	productId,
+	quantityOrdered,
+	quantityReceived,
+	unitCost
+	FROM purchase_order_lines
+	WHERE purchaseOrderId = ?
+	ORDER BY lineId`
+	if lock {
+		query += ` FOR UPDATE`
+	}
+	results, err := q.QueryContext(ctx, query, purchaseOrderID)
+	if err != nil {
+		return nil, err
+	}
+	defer results.Close()
+	lines := make([]Line, 0)
+	for results.Next() {
+		var line Line
+		err := results.Scan(&line.LineID,
+			&line.ProductID,
+			&line.QuantityOrdered,
+			&line.QuantityReceived,
+			&line.UnitCost)
+		if err != nil {
+			return nil, err
+		}
+		lines = append(lines, line)
+	}
+	return lines, nil
+}
+
+func getReceipts(ctx context.Context, q queryer, purchaseOrderID int) ([]string, error) {
+	results, err := q.QueryContext(ctx, `SELECT receiptName FROM purchase_order_receipts WHERE purchaseOrderId = ? ORDER BY attachedAt`, purchaseOrderID)
+	if err != nil {
+		return nil, err
+	}
+	defer results.Close()
+	receipts := make([]string, 0)
+	for results.Next() {
+		var receiptName string
+		if err := results.Scan(&receiptName); err != nil {
+			return nil, err
+		}
+		receipts = append(receipts, receiptName)
+	}
+	return receipts, nil
+}
+
+func getPurchaseOrder(purchaseOrderID int) (*PurchaseOrder, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	purchaseOrder, err := getHeader(ctx, database.DbConn, purchaseOrderID, false)
+	if err != nil || purchaseOrder == nil {
+		return nil, err
+	}
+	purchaseOrder.Lines, err = getLines(ctx, database.DbConn, purchaseOrderID, false)
+	if err != nil {
+		return nil, err
+	}
+	purchaseOrder.Receipts, err = getReceipts(ctx, database.DbConn, purchaseOrderID)
+	if err != nil {
+		return nil, err
+	}
+	return purchaseOrder, nil
+}
+
+// getPurchaseOrderList returns the purchase orders, optionally only those with the given status
+// Lines are included but attached receipts are only returned when fetching a single PO
+func getPurchaseOrderList(status string) ([]PurchaseOrder, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	queryArgs := make([]interface{}, 0)
+	query := `SELECT purchaseOrderId FROM purchase_orders `
+	if status != "" {
+		query += `WHERE status = ? `
+		queryArgs = append(queryArgs, status)
+	}
+	query += `ORDER BY purchaseOrderId DESC`
+	results, err := database.DbConn.QueryContext(ctx, query, queryArgs...)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]int, 0)
+	for results.Next() {
+		var id int
+		if err := results.Scan(&id); err != nil {
+			results.Close()
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	results.Close()
+
+	purchaseOrders := make([]PurchaseOrder, 0, len(ids))
+	for _, id := range ids {
+		purchaseOrder, err := getHeader(ctx, database.DbConn, id, false)
+		if err != nil {
+			return nil, err
+		}
+		if purchaseOrder == nil {
+			continue
+		}
+		purchaseOrder.Lines, err = getLines(ctx, database.DbConn, id, false)
+		if err != nil {
+			return nil, err
+		}
+		purchaseOrders = append(purchaseOrders, *purchaseOrder)
+	}
+	return purchaseOrders, nil
+}
+
+func insertLines(ctx context.Context, tx *sql.Tx, purchaseOrderID int, lines []Line) error {
+	for _, line := range lines {
+		_, err := tx.ExecContext(ctx, `INSERT INTO purchase_order_lines (
+		purchaseOrderId,
+		productId,
+		quantityOrdered,
+		unitCost) VALUES (?, ?, ?, CAST(? AS DECIMAL(13,2)))`,
+			purchaseOrderID,
+			line.ProductID,
+			line.QuantityOrdered,
+			line.UnitCost)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// insertPurchaseOrder creates a new draft PO along with its lines
+func insertPurchaseOrder(purchaseOrder PurchaseOrder) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	tx, err := database.DbConn.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	result, err := tx.ExecContext(ctx, `INSERT INTO purchase_orders (
+	supplierId,
+	supplier,
+	status,
+	notes) VALUES (NULLIF(?, 0), ?, ?, ?)`,
+		purchaseOrder.SupplierID,
+		purchaseOrder.Supplier,
+		StatusDraft,
+		purchaseOrder.Notes)
+	if err != nil {
+		return 0, err
+	}
+	insertID, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	err = insertLines(ctx, tx, int(insertID), purchaseOrder.Lines)
+	if err != nil {
+		return 0, err
+	}
+	return int(insertID), tx.Commit()
+}
+
+// updateDraft replaces the supplier, notes and lines of a draft PO
+func updateDraft(purchaseOrder PurchaseOrder) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	tx, err := database.DbConn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	current, err := getHeader(ctx, tx, purchaseOrder.PurchaseOrderID, true)
+	if err != nil {
+		return err
+	}
+	if current == nil {
+		return ErrPurchaseNotFound
+	}
+	if current.Status != StatusDraft {
+		return ErrInvalidStatus
+	}
+	_, err = tx.ExecContext(ctx, `UPDATE purchase_orders SET
+	supplierId=NULLIF(?, 0),
+	supplier=?,
+	notes=?
+	WHERE purchaseOrderId=?`,
+		purchaseOrder.SupplierID,
+		purchaseOrder.Supplier,
+		purchaseOrder.Notes,
+		purchaseOrder.PurchaseOrderID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `DELETE FROM purchase_order_lines WHERE purchaseOrderId = ?`, purchaseOrder.PurchaseOrderID)
+	if err != nil {
+		return err
+	}
+	err = insertLines(ctx, tx, purchaseOrder.PurchaseOrderID, purchaseOrder.Lines)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// removeDraft deletes a PO, only drafts can be deleted, anything else has to be cancelled
+func removeDraft(purchaseOrderID int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	tx, err := database.DbConn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	current, err := getHeader(ctx, tx, purchaseOrderID, true)
+	if err != nil {
+		return err
+	}
+	if current == nil {
+		return ErrPurchaseNotFound
+	}
+	if current.Status != StatusDraft {
+		return ErrInvalidStatus
+	}
+	_, err = tx.ExecContext(ctx, `DELETE FROM purchase_order_lines WHERE purchaseOrderId = ?`, purchaseOrderID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `DELETE FROM purchase_orders WHERE purchaseOrderId = ?`, purchaseOrderID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// changeStatus moves a PO to a new status as long as it's currently in one of the allowed statuses
+func changeStatus(purchaseOrderID int, status string, allowedFrom ...string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	tx, err := database.DbConn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	current, err := getHeader(ctx, tx, purchaseOrderID, true)
+	if err != nil {
+		return err
+	}
+	if current == nil {
+		return ErrPurchaseNotFound
+	}
+	allowed := false
+	for _, from := range allowedFrom {
+		if current.Status == from {
+			allowed = true
+		}
+	}
+	if !allowed {
+		return ErrInvalidStatus
+	}
+	if status == StatusOrdered {
+		_, err = tx.ExecContext(ctx, `UPDATE purchase_orders SET status=?, orderedAt=? WHERE purchaseOrderId=?`, status, time.Now().UTC(), purchaseOrderID)
+	} else {
+		_, err = tx.ExecContext(ctx, `UPDATE purchase_orders SET status=? WHERE purchaseOrderId=?`, status, purchaseOrderID)
+	}
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// receiveGoods books received quantities against the PO lines and increases stock, all in one transaction
+// so a failure part way through (e.g. over-receiving the last line) leaves nothing changed
+func receiveGoods(purchaseOrderID int, receiving Receiving) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	tx, err := database.DbConn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	current, err := getHeader(ctx, tx, purchaseOrderID, true)
+	if err != nil {
+		return err
+	}
+	if current == nil {
+		return ErrPurchaseNotFound
+	}
+	if current.Status != StatusOrdered && current.Status != StatusPartiallyReceived {
+		return ErrInvalidStatus
+	}
+	lines, err := getLines(ctx, tx, purchaseOrderID, true)
+	if err != nil {
+		return err
+	}
+	byID := make(map[int]*Line, len(lines))
+	for i := range lines {
+		byID[lines[i].LineID] = &lines[i]
+	}
+
+	reference := fmt.Sprintf("po:%d", purchaseOrderID)
+	for _, received := range receiving.Lines {
+		line, ok := byID[received.LineID]
+		if !ok {
+			return ErrUnknownLine
+		}
+		if line.QuantityReceived+received.Quantity > line.QuantityOrdered {
+			return ErrOverReceipt
+		}
+		line.QuantityReceived += received.Quantity
+		_, err = tx.ExecContext(ctx, `UPDATE purchase_order_lines SET quantityReceived=? WHERE lineId=?`, line.QuantityReceived, line.LineID)
+		if err != nil {
+			return err
+		}
+		err = location.AdjustStock(ctx, tx, location.Adjustment{
+			ProductID:  line.ProductID,
+			LocationID: receiving.LocationID,
+			Quantity:   received.Quantity,
+			Reason:     ledger.ReasonReceipt,
+			Reference:  reference,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE purchase_orders SET status=? WHERE purchaseOrderId=?`, receivedStatus(lines), purchaseOrderID)
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(receiving.ReceiptName) != "" {
+		_, err = tx.ExecContext(ctx, `INSERT IGNORE INTO purchase_order_receipts (purchaseOrderId, receiptName) VALUES (?, ?)`, purchaseOrderID, receiving.ReceiptName)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// GetOnOrder returns how much of each product is on purchase orders that have been ordered but not fully received
+func GetOnOrder() (map[int]int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	results, err := database.DbConn.QueryContext(ctx, `SELECT l.productId, CAST(SUM(l.quantityOrdered - l.quantityReceived) AS SIGNED)
+	FROM purchase_order_lines l
+	JOIN purchase_orders po ON po.purchaseOrderId = l.purchaseOrderId
+	WHERE po.status IN (?, ?) AND l.quantityReceived < l.quantityOrdered
+	GROUP BY l.productId`, StatusOrdered, StatusPartiallyReceived)
+	if err != nil {
+		return nil, err
+	}
+	defer results.Close()
+	onOrder := make(map[int]int)
+	for results.Next() {
+		var productID, quantity int
+		if err := results.Scan(&productID, &quantity); err != nil {
+			return nil, err
+		}
+		onOrder[productID] = quantity
+	}
+	return onOrder, results.Err()
+}
@@ -0,0 +1,84 @@
+package purchaseorder
+
+import (
+	"errors"
+	"time"
+)
+
+// A purchase order lists the products we've ordered from a supplier
+// Receiving goods against it increases the products' stock and writes receipt movements to the ledger
+// A PO is only editable while it's a draft, after that it moves through the statuses below
+
+// Statuses
+const (
+	StatusDraft             = "draft"
+	StatusOrdered           = "ordered"
+	StatusPartiallyReceived = "partially_received"
+	StatusReceived          = "received"
+	StatusCancelled         = "cancelled"
+)
+
+var (
+	ErrInvalidStatus    = errors.New("purchase order is not in a valid status for this action")
+	ErrOverReceipt      = errors.New("quantity received would exceed quantity ordered")
+	ErrUnknownLine      = errors.New("line does not belong to this purchase order")
+	ErrReceiptNotFound  = errors.New("receipt not found")
+	ErrPurchaseNotFound = errors.New("purchase order not found")
+)
+
+// PurchaseOrder
+type PurchaseOrder struct {
+	PurchaseOrderID int        `json:"purchaseOrderId"`
+	SupplierID      int        `json:"supplierId"`
+	Supplier        string     `json:"supplier"`
+	Status          string     `json:"status"`
+	Notes           string     `json:"notes"`
+	CreatedAt       time.Time  `json:"createdAt"`
+	OrderedAt       *time.Time `json:"orderedAt"`
+	Lines           []Line     `json:"lines"`
+	Receipts        []string   `json:"receipts"`
+}
+
+// Line is a single product on a purchase order
+type Line struct {
+	LineID           int    `json:"lineId"`
+	ProductID        int    `json:"productId"`
+	QuantityOrdered  int    `json:"quantityOrdered"`
+	QuantityReceived int    `json:"quantityReceived"`
+	UnitCost         string `json:"unitCost"`
+}
+
+// Receiving is the body of a receive action
+// LocationID is where the goods are put away, 0 leaves them as unallocated stock
+// ReceiptName optionally attaches an already uploaded receipt to the PO, by its receipt ID
+type Receiving struct {
+	Lines       []ReceivedLine `json:"lines"`
+	LocationID  int            `json:"locationId"`
+	ReceiptName string         `json:"receiptName"`
+}
+
+// ReceivedLine
+type ReceivedLine struct {
+	LineID   int `json:"lineId"`
+	Quantity int `json:"quantity"`
+}
+
+// receivedStatus works out the status of a PO from how much of each line has been received
+func receivedStatus(lines []Line) string {
+	anyReceived, allReceived := false, true
+	for _, line := range lines {
+		if line.QuantityReceived > 0 {
+			anyReceived = true
+		}
+		if line.QuantityReceived < line.QuantityOrdered {
+			allReceived = false
+		}
+	}
+	switch {
+	case allReceived:
+		return StatusReceived
+	case anyReceived:
+		return StatusPartiallyReceived
+	}
+	return StatusOrdered
+}
@@ -0,0 +1,245 @@
+package purchaseorder
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/jordbick/Golang/inventory-service/cors"
+	"github.com/jordbick/Golang/inventory-service/location"
+	"github.com/jordbick/Golang/inventory-service/receipt"
+	"github.com/jordbick/Golang/inventory-service/response"
+	"github.com/jordbick/Golang/inventory-service/supplier"
+)
+
+const purchaseOrdersBasePath = "purchaseorders"
+
+// SetupRoutes registers the purchase order handlers
+func SetupRoutes(apiBasePath string) {
+	handlePurchaseOrders := http.HandlerFunc(purchaseOrdersHandler)
+	handlePurchaseOrder := http.HandlerFunc(purchaseOrderHandler)
+	http.Handle(fmt.Sprintf("%s/%s", apiBasePath, purchaseOrdersBasePath), cors.Middleware(handlePurchaseOrders))
+	http.Handle(fmt.Sprintf("%s/%s/", apiBasePath, purchaseOrdersBasePath), cors.Middleware(handlePurchaseOrder))
+}
+
+// errorStatus maps errors from the data layer onto a response status
+func errorStatus(err error) int {
+	switch err {
+	case ErrPurchaseNotFound, location.ErrProductNotFound:
+		return http.StatusNotFound
+	case ErrInvalidStatus, ErrOverReceipt, location.ErrInsufficientStock:
+		return http.StatusConflict
+	case ErrUnknownLine:
+		return http.StatusBadRequest
+	}
+	log.Println(err)
+	return http.StatusInternalServerError
+}
+
+func validatePurchaseOrder(purchaseOrder PurchaseOrder) error {
+	if purchaseOrder.SupplierID == 0 && supplier.NormalizeName(purchaseOrder.Supplier) == "" {
+		return fmt.Errorf("supplierId or supplier is required")
+	}
+	if len(purchaseOrder.Lines) == 0 {
+		return fmt.Errorf("at least one line is required")
+	}
+	for _, line := range purchaseOrder.Lines {
+		if line.ProductID <= 0 || line.QuantityOrdered <= 0 {
+			return fmt.Errorf("every line needs a productId and a quantityOrdered greater than 0")
+		}
+		if cost, err := strconv.ParseFloat(line.UnitCost, 64); err != nil || cost < 0 {
+			return fmt.Errorf("unitCost [%s] is not a valid amount", line.UnitCost)
+		}
+	}
+	return nil
+}
+
+func readPurchaseOrder(r *http.Request) (PurchaseOrder, error) {
+	var purchaseOrder PurchaseOrder
+	bodyBytes, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return purchaseOrder, err
+	}
+	err = json.Unmarshal(bodyBytes, &purchaseOrder)
+	if err != nil {
+		return purchaseOrder, err
+	}
+	err = validatePurchaseOrder(purchaseOrder)
+	if err != nil {
+		return purchaseOrder, err
+	}
+	return purchaseOrder, resolveSupplier(&purchaseOrder)
+}
+
+// resolveSupplier links the PO to a supplier by ID, or by name if only the name was sent
+func resolveSupplier(purchaseOrder *PurchaseOrder) error {
+	var s *supplier.Supplier
+	var err error
+	if purchaseOrder.SupplierID != 0 {
+		s, err = supplier.GetSupplier(purchaseOrder.SupplierID)
+	} else {
+		s, err = supplier.Resolve(purchaseOrder.Supplier)
+	}
+	if err != nil {
+		return err
+	}
+	if s == nil {
+		return fmt.Errorf("supplier [%d] doesn't exist", purchaseOrder.SupplierID)
+	}
+	purchaseOrder.SupplierID = s.SupplierID
+	purchaseOrder.Supplier = s.Name
+	return nil
+}
+
+func purchaseOrdersHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		purchaseOrders, err := getPurchaseOrderList(r.URL.Query().Get("status"))
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		response.WriteJSON(w, purchaseOrders)
+
+	case http.MethodPost:
+		newPurchaseOrder, err := readPurchaseOrder(r)
+		if err != nil || newPurchaseOrder.PurchaseOrderID != 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		purchaseOrderID, err := insertPurchaseOrder(newPurchaseOrder)
+		if err != nil {
+			w.WriteHeader(errorStatus(err))
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(fmt.Sprintf(`{"purchaseOrderId":%d}`, purchaseOrderID)))
+
+	case http.MethodOptions:
+		return
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// Handles /purchaseorders/{id} and the /order, /cancel and /receive actions below it
+func purchaseOrderHandler(w http.ResponseWriter, r *http.Request) {
+	urlPathSegments := strings.Split(strings.Trim(strings.SplitN(r.URL.Path, fmt.Sprintf("/%s/", purchaseOrdersBasePath), 2)[1], "/"), "/")
+	purchaseOrderID, err := strconv.Atoi(urlPathSegments[0])
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if r.Method == http.MethodOptions {
+		return
+	}
+	if len(urlPathSegments) == 2 {
+		actionHandler(w, r, purchaseOrderID, urlPathSegments[1])
+		return
+	}
+	if len(urlPathSegments) != 1 {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		purchaseOrder, err := getPurchaseOrder(purchaseOrderID)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if purchaseOrder == nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		response.WriteJSON(w, purchaseOrder)
+
+	case http.MethodPut:
+		updatedPurchaseOrder, err := readPurchaseOrder(r)
+		if err != nil || updatedPurchaseOrder.PurchaseOrderID != purchaseOrderID {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		err = updateDraft(updatedPurchaseOrder)
+		if err != nil {
+			w.WriteHeader(errorStatus(err))
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+
+	case http.MethodDelete:
+		err = removeDraft(purchaseOrderID)
+		if err != nil {
+			w.WriteHeader(errorStatus(err))
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// actionHandler handles the status changes, all of which are POSTs
+func actionHandler(w http.ResponseWriter, r *http.Request, purchaseOrderID int, action string) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	var err error
+	switch action {
+	case "order":
+		err = changeStatus(purchaseOrderID, StatusOrdered, StatusDraft)
+	case "cancel":
+		err = changeStatus(purchaseOrderID, StatusCancelled, StatusDraft, StatusOrdered, StatusPartiallyReceived)
+	case "receive":
+		var receiving Receiving
+		err = json.NewDecoder(r.Body).Decode(&receiving)
+		if err != nil || len(receiving.Lines) == 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		for _, line := range receiving.Lines {
+			if line.Quantity <= 0 {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+		}
+		exists, existsErr := location.Exists(receiving.LocationID)
+		if existsErr != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if !exists {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if receiving.ReceiptName != "" {
+			// the receipt has to have been uploaded already
+			found, foundErr := receipt.Exists(receiving.ReceiptName)
+			if foundErr != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			if !found {
+				http.Error(w, ErrReceiptNotFound.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		err = receiveGoods(purchaseOrderID, receiving)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		w.WriteHeader(errorStatus(err))
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
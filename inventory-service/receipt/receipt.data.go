@@ -0,0 +1,943 @@
+package receipt
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jordbick/Golang/inventory-service/database"
+)
+
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+const receiptColumns = `receiptId,
+	name,
+	contentType,
+	size,
+	checksum,
+	uploadedBy,
+	uploadedAt,
+	version`
+
+func scanReceipt(scan func(dest ...interface{}) error) (*Receipt, error) {
+	receipt := &Receipt{}
+	err := scan(&receipt.ID,
+		&receipt.ReceiptName,
+		&receipt.ContentType,
+		&receipt.Size,
+		&receipt.Checksum,
+		&receipt.UploadedBy,
+		&receipt.UploadDate,
+		&receipt.Version)
+	if err != nil {
+		return nil, err
+	}
+	return receipt, nil
+}
+
+// getReceipt returns the current version of a receipt, deleted receipts aren't returned
+func getReceipt(receiptID string) (*Receipt, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	row := database.DbConn.QueryRowContext(ctx, `SELECT `+receiptColumns+`
+	FROM receipts
+	WHERE receiptId = ? AND deletedAt IS NULL`, receiptID)
+	receipt, err := scanReceipt(row.Scan)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	receipts := []Receipt{*receipt}
+	if err = addLinks(ctx, database.DbConn, receipts); err != nil {
+		return nil, err
+	}
+	return &receipts[0], nil
+}
+
+// receiptConditions turns the filter into a WHERE clause, deleted receipts are always left out
+func receiptConditions(filter ReceiptFilter) (string, []interface{}) {
+	var queryArgs = make([]interface{}, 0)
+	var conditions = []string{"deletedAt IS NULL"}
+	if filter.Name != "" {
+		conditions = append(conditions, "name LIKE ?")
+		queryArgs = append(queryArgs, "%"+escapeLike(filter.Name)+"%")
+	}
+	if filter.ContentType != "" {
+		if strings.HasSuffix(filter.ContentType, "/") {
+			conditions = append(conditions, "contentType LIKE ?")
+			queryArgs = append(queryArgs, escapeLike(filter.ContentType)+"%")
+		} else {
+			// stored content types can have parameters, e.g. text/plain; charset=utf-8
+			conditions = append(conditions, "(contentType = ? OR contentType LIKE ?)")
+			queryArgs = append(queryArgs, filter.ContentType, escapeLike(filter.ContentType)+";%")
+		}
+	}
+	if filter.UploadedBy != "" {
+		conditions = append(conditions, "uploadedBy = ?")
+		queryArgs = append(queryArgs, filter.UploadedBy)
+	}
+	if filter.Tag != "" {
+		conditions = append(conditions, "receiptId IN (SELECT receiptId FROM receipt_tags WHERE tag = ?)")
+		queryArgs = append(queryArgs, filter.Tag)
+	}
+	if filter.ProductID != 0 {
+		conditions = append(conditions, "receiptId IN (SELECT receiptId FROM receipt_products WHERE productId = ?)")
+		queryArgs = append(queryArgs, filter.ProductID)
+	}
+	if filter.PurchaseOrderID != 0 {
+		conditions = append(conditions, "receiptId IN (SELECT receiptName FROM purchase_order_receipts WHERE purchaseOrderId = ?)")
+		queryArgs = append(queryArgs, filter.PurchaseOrderID)
+	}
+	if !filter.From.IsZero() {
+		conditions = append(conditions, "uploadedAt >= ?")
+		queryArgs = append(queryArgs, filter.From.UTC())
+	}
+	if !filter.To.IsZero() {
+		conditions = append(conditions, "uploadedAt < ?")
+		queryArgs = append(queryArgs, filter.To.UTC())
+	}
+	return "WHERE " + strings.Join(conditions, " AND ") + " ", queryArgs
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
+
+// receiptOrder turns the sort into an ORDER BY, with the ID last so pages don't overlap
+func receiptOrder(sortBy string) (string, error) {
+	parts := strings.Fields(sortBy)
+	if len(parts) == 0 {
+		return "uploadedAt DESC, receiptId", nil
+	}
+	column, ok := receiptSortFields[parts[0]]
+	if !ok || len(parts) > 2 {
+		return "", fmt.Errorf("sort must be a field optionally followed by asc or desc")
+	}
+	if len(parts) == 2 {
+		switch strings.ToLower(parts[1]) {
+		case "asc":
+		case "desc":
+			column += " DESC"
+		default:
+			return "", fmt.Errorf("sort must be a field optionally followed by asc or desc")
+		}
+	}
+	return column + ", receiptId", nil
+}
+
+func getReceiptList(filter ReceiptFilter) ([]Receipt, int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	orderBy, err := receiptOrder(filter.Sort)
+	if err != nil {
+		return nil, 0, err
+	}
+	where, queryArgs := receiptConditions(filter)
+
+	var total int
+	err = database.DbConn.QueryRowContext(ctx, `SELECT COUNT(*) FROM receipts `+where, queryArgs...).Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = DefaultReceiptLimit
+	}
+	results, err := database.DbConn.QueryContext(ctx, `SELECT `+receiptColumns+`
+	FROM receipts `+where+`ORDER BY `+orderBy+` LIMIT ? OFFSET ?`, append(queryArgs, limit, filter.Offset)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer results.Close()
+	receipts := make([]Receipt, 0)
+	for results.Next() {
+		receipt, err := scanReceipt(results.Scan)
+		if err != nil {
+			return nil, 0, err
+		}
+		receipts = append(receipts, *receipt)
+	}
+	if err = results.Err(); err != nil {
+		return nil, 0, err
+	}
+	results.Close()
+	if err = addLinks(ctx, database.DbConn, receipts); err != nil {
+		return nil, 0, err
+	}
+	return receipts, total, nil
+}
+
+// addLinks fills in the tags, products and purchase orders of a page of receipts
+func addLinks(ctx context.Context, q queryer, receipts []Receipt) error {
+	if len(receipts) == 0 {
+		return nil
+	}
+	index := make(map[string]int, len(receipts))
+	ids := make([]interface{}, 0, len(receipts))
+	for i := range receipts {
+		receipts[i].Tags = make([]string, 0)
+		receipts[i].ProductIDs = make([]int, 0)
+		receipts[i].PurchaseOrderIDs = make([]int, 0)
+		index[receipts[i].ID] = i
+		ids = append(ids, receipts[i].ID)
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(ids)), ",")
+	queries := []struct {
+		query string
+		add   func(r *Receipt, value string)
+	}{
+		{`SELECT receiptId, tag FROM receipt_tags WHERE receiptId IN (` + placeholders + `) ORDER BY tag`,
+			func(r *Receipt, value string) { r.Tags = append(r.Tags, value) }},
+		{`SELECT receiptId, productId FROM receipt_products WHERE receiptId IN (` + placeholders + `) ORDER BY productId`,
+			func(r *Receipt, value string) {
+				id, _ := strconv.Atoi(value)
+				r.ProductIDs = append(r.ProductIDs, id)
+			}},
+		{`SELECT receiptName, purchaseOrderId FROM purchase_order_receipts WHERE receiptName IN (` + placeholders + `) ORDER BY purchaseOrderId`,
+			func(r *Receipt, value string) {
+				id, _ := strconv.Atoi(value)
+				r.PurchaseOrderIDs = append(r.PurchaseOrderIDs, id)
+			}},
+	}
+	for _, q2 := range queries {
+		results, err := q.QueryContext(ctx, q2.query, ids...)
+		if err != nil {
+			return err
+		}
+		for results.Next() {
+			var receiptID, value string
+			if err := results.Scan(&receiptID, &value); err != nil {
+				results.Close()
+				return err
+			}
+			if i, ok := index[receiptID]; ok {
+				q2.add(&receipts[i], value)
+			}
+		}
+		err = results.Err()
+		results.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// countExisting returns how many of the IDs are in the table
+func countExisting(ctx context.Context, table, column string, ids []int) (int, error) {
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+	var count int
+	err := database.DbConn.QueryRowContext(ctx, fmt.Sprintf(`SELECT COUNT(*) FROM %s WHERE %s IN (%s)`,
+		table, column, strings.TrimSuffix(strings.Repeat("?,", len(ids)), ",")), args...).Scan(&count)
+	return count, err
+}
+
+// insertReceipt records a stored receipt as version 1, along with its tags and links
+// The products and purchase orders it's linked to have to exist
+func insertReceipt(receipt Receipt) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	for _, check := range []struct {
+		table, column string
+		ids           []int
+		err           error
+	}{
+		{"products", "productId", receipt.ProductIDs, ErrProductNotFound},
+		{"purchase_orders", "purchaseOrderId", receipt.PurchaseOrderIDs, ErrPurchaseOrderNotFound},
+	} {
+		count, err := countExisting(ctx, check.table, check.column, check.ids)
+		if err != nil {
+			return err
+		}
+		if count != len(check.ids) {
+			return check.err
+		}
+	}
+
+	tx, err := database.DbConn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	_, err = tx.ExecContext(ctx, `INSERT INTO receipts
+	(receiptId, name, contentType, size, checksum, uploadedBy, uploadedAt)
+	VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		receipt.ID,
+		receipt.ReceiptName,
+		receipt.ContentType,
+		receipt.Size,
+		receipt.Checksum,
+		receipt.UploadedBy,
+		receipt.UploadDate.UTC())
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `INSERT INTO receipt_versions
+	(receiptId, version, blobKey, name, contentType, size, checksum, uploadedBy, uploadedAt)
+	VALUES (?, 1, ?, ?, ?, ?, ?, ?, ?)`,
+		receipt.ID,
+		receipt.ID,
+		receipt.ReceiptName,
+		receipt.ContentType,
+		receipt.Size,
+		receipt.Checksum,
+		receipt.UploadedBy,
+		receipt.UploadDate.UTC())
+	if err != nil {
+		return err
+	}
+	if err = insertAudit(ctx, tx, AuditEntry{ReceiptID: receipt.ID, Version: 1, Action: AuditUpload, Actor: receipt.UploadedBy, Detail: receipt.ReceiptName}); err != nil {
+		return err
+	}
+	for _, tag := range receipt.Tags {
+		if _, err = tx.ExecContext(ctx, `INSERT IGNORE INTO receipt_tags (receiptId, tag) VALUES (?, ?)`, receipt.ID, tag); err != nil {
+			return err
+		}
+	}
+	for _, productID := range receipt.ProductIDs {
+		if _, err = tx.ExecContext(ctx, `INSERT IGNORE INTO receipt_products (receiptId, productId) VALUES (?, ?)`, receipt.ID, productID); err != nil {
+			return err
+		}
+	}
+	for _, purchaseOrderID := range receipt.PurchaseOrderIDs {
+		if _, err = tx.ExecContext(ctx, `INSERT IGNORE INTO purchase_order_receipts (purchaseOrderId, receiptName) VALUES (?, ?)`, purchaseOrderID, receipt.ID); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// getReceiptIDs returns the IDs of every recorded receipt
+func getReceiptIDs() (map[string]bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	results, err := database.DbConn.QueryContext(ctx, `SELECT receiptId FROM receipts`)
+	if err != nil {
+		return nil, err
+	}
+	defer results.Close()
+	ids := make(map[string]bool)
+	for results.Next() {
+		var id string
+		if err := results.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids[id] = true
+	}
+	return ids, results.Err()
+}
+
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+func insertAudit(ctx context.Context, e execer, entry AuditEntry) error {
+	var version interface{}
+	if entry.Version != 0 {
+		version = entry.Version
+	}
+	if len(entry.Detail) > 1024 {
+		entry.Detail = entry.Detail[:1024]
+	}
+	_, err := e.ExecContext(ctx, `INSERT INTO receipt_audit (receiptId, version, action, actor, detail, createdAt) VALUES (?, ?, ?, ?, ?, ?)`,
+		entry.ReceiptID, version, entry.Action, entry.Actor, entry.Detail, time.Now().UTC())
+	return err
+}
+
+// getAudit returns a receipt's audit log, oldest first. It's kept after the receipt is deleted
+func getAudit(receiptID string) ([]AuditEntry, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	results, err := database.DbConn.QueryContext(ctx, `SELECT auditId,
+	receiptId,
+	version,
+	action,
+	actor,
+	detail,
+	createdAt
+	FROM receipt_audit
+	WHERE receiptId = ?
+	ORDER BY auditId`, receiptID)
+	if err != nil {
+		return nil, err
+	}
+	defer results.Close()
+	entries := make([]AuditEntry, 0)
+	for results.Next() {
+		var entry AuditEntry
+		var version sql.NullInt64
+		err = results.Scan(&entry.AuditID, &entry.ReceiptID, &version, &entry.Action, &entry.Actor, &entry.Detail, &entry.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		entry.Version = int(version.Int64)
+		entries = append(entries, entry)
+	}
+	return entries, results.Err()
+}
+
+const versionColumns = `version,
+	name,
+	contentType,
+	size,
+	checksum,
+	uploadedBy,
+	uploadedAt,
+	supersededAt,
+	expiredAt`
+
+func scanVersion(scan func(dest ...interface{}) error, extra ...interface{}) (*ReceiptVersion, error) {
+	v := &ReceiptVersion{}
+	var supersededAt, expiredAt sql.NullTime
+	dest := append([]interface{}{&v.Version,
+		&v.ReceiptName,
+		&v.ContentType,
+		&v.Size,
+		&v.Checksum,
+		&v.UploadedBy,
+		&v.UploadDate,
+		&supersededAt,
+		&expiredAt}, extra...)
+	if err := scan(dest...); err != nil {
+		return nil, err
+	}
+	if supersededAt.Valid {
+		v.SupersededAt = &supersededAt.Time
+	}
+	if expiredAt.Valid {
+		v.ExpiredAt = &expiredAt.Time
+	}
+	return v, nil
+}
+
+// getVersions returns every version of a receipt, newest first
+func getVersions(receiptID string) ([]ReceiptVersion, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	results, err := database.DbConn.QueryContext(ctx, `SELECT `+versionColumns+`
+	FROM receipt_versions
+	WHERE receiptId = ?
+	ORDER BY version DESC`, receiptID)
+	if err != nil {
+		return nil, err
+	}
+	defer results.Close()
+	versions := make([]ReceiptVersion, 0)
+	for results.Next() {
+		v, err := scanVersion(results.Scan)
+		if err != nil {
+			return nil, err
+		}
+		versions = append(versions, *v)
+	}
+	return versions, results.Err()
+}
+
+// getVersion returns a version of a receipt along with the key its file is stored under
+func getVersion(receiptID string, version int) (*ReceiptVersion, string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	var key string
+	row := database.DbConn.QueryRowContext(ctx, `SELECT `+versionColumns+`,
+	blobKey
+	FROM receipt_versions
+	WHERE receiptId = ? AND version = ?`, receiptID, version)
+	v, err := scanVersion(row.Scan, &key)
+	if err == sql.ErrNoRows {
+		return nil, "", nil
+	} else if err != nil {
+		return nil, "", err
+	}
+	return v, key, nil
+}
+
+// insertVersion makes a stored file the receipt's current version, the receipt row is locked so two replacements can't
+// both take the same version number
+func insertVersion(stored Receipt, key, actor string) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	tx, err := database.DbConn.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	var current int
+	err = tx.QueryRowContext(ctx, `SELECT version FROM receipts WHERE receiptId = ? AND deletedAt IS NULL FOR UPDATE`, stored.ID).Scan(&current)
+	if err == sql.ErrNoRows {
+		return 0, ErrReceiptNotFound
+	} else if err != nil {
+		return 0, err
+	}
+	version := current + 1
+	now := stored.UploadDate.UTC()
+	if _, err = tx.ExecContext(ctx, `UPDATE receipt_versions SET supersededAt = ? WHERE receiptId = ? AND version = ?`, now, stored.ID, current); err != nil {
+		return 0, err
+	}
+	_, err = tx.ExecContext(ctx, `INSERT INTO receipt_versions
+	(receiptId, version, blobKey, name, contentType, size, checksum, uploadedBy, uploadedAt)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		stored.ID, version, key, stored.ReceiptName, stored.ContentType, stored.Size, stored.Checksum, stored.UploadedBy, now)
+	if err != nil {
+		return 0, err
+	}
+	_, err = tx.ExecContext(ctx, `UPDATE receipts SET
+	name = ?,
+	contentType = ?,
+	size = ?,
+	checksum = ?,
+	uploadedBy = ?,
+	uploadedAt = ?,
+	version = ?
+	WHERE receiptId = ?`,
+		stored.ReceiptName, stored.ContentType, stored.Size, stored.Checksum, stored.UploadedBy, now, version, stored.ID)
+	if err != nil {
+		return 0, err
+	}
+	if err = insertAudit(ctx, tx, AuditEntry{ReceiptID: stored.ID, Version: version, Action: AuditReplace, Actor: actor, Detail: stored.ReceiptName}); err != nil {
+		return 0, err
+	}
+	return version, tx.Commit()
+}
+
+// markDeleted hides a receipt, its files are kept until retention purges them
+func markDeleted(receiptID, actor string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	tx, err := database.DbConn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	result, err := tx.ExecContext(ctx, `UPDATE receipts SET deletedAt = ? WHERE receiptId = ? AND deletedAt IS NULL`, time.Now().UTC(), receiptID)
+	if err != nil {
+		return err
+	}
+	if affected, err := result.RowsAffected(); err != nil {
+		return err
+	} else if affected == 0 {
+		return ErrReceiptNotFound
+	}
+	if err = insertAudit(ctx, tx, AuditEntry{ReceiptID: receiptID, Action: AuditDelete, Actor: actor}); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// storedVersion is a version whose file is still in the store
+type storedVersion struct {
+	receiptID string
+	version   int
+	key       string
+}
+
+func queryStoredVersions(ctx context.Context, query string, args ...interface{}) ([]storedVersion, error) {
+	results, err := database.DbConn.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer results.Close()
+	versions := make([]storedVersion, 0)
+	for results.Next() {
+		var v storedVersion
+		if err := results.Scan(&v.receiptID, &v.version, &v.key); err != nil {
+			return nil, err
+		}
+		versions = append(versions, v)
+	}
+	return versions, results.Err()
+}
+
+// getExpiredVersions returns the old versions of receipts that the policy says should go: those replaced before the cutoff,
+// and those beyond the newest keep old versions. Current versions and deleted receipts are left alone
+func getExpiredVersions(policy RetentionPolicy, now time.Time) ([]storedVersion, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+	conditions := make([]string, 0, 2)
+	args := make([]interface{}, 0, 2)
+	if policy.VersionMaxAge > 0 {
+		conditions = append(conditions, "supersededAt < ?")
+		args = append(args, now.Add(-policy.VersionMaxAge).UTC())
+	}
+	if policy.KeepVersions > 0 {
+		conditions = append(conditions, "age > ?")
+		args = append(args, policy.KeepVersions)
+	}
+	if len(conditions) == 0 {
+		return []storedVersion{}, nil
+	}
+	return queryStoredVersions(ctx, `SELECT receiptId, version, blobKey FROM (
+		SELECT v.receiptId, v.version, v.blobKey, v.supersededAt, v.expiredAt,
+		ROW_NUMBER() OVER (PARTITION BY v.receiptId ORDER BY v.version DESC) AS age
+		FROM receipt_versions v
+		JOIN receipts r ON r.receiptId = v.receiptId
+		WHERE v.supersededAt IS NOT NULL AND r.deletedAt IS NULL) old
+	WHERE expiredAt IS NULL AND (`+strings.Join(conditions, " OR ")+`)
+	ORDER BY receiptId, version`, args...)
+}
+
+// markExpired records that an old version's file has been removed
+func markExpired(v storedVersion, detail string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	tx, err := database.DbConn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err = tx.ExecContext(ctx, `UPDATE receipt_versions SET expiredAt = ? WHERE receiptId = ? AND version = ?`, time.Now().UTC(), v.receiptID, v.version); err != nil {
+		return err
+	}
+	if err = insertAudit(ctx, tx, AuditEntry{ReceiptID: v.receiptID, Version: v.version, Action: AuditExpire, Actor: "retention", Detail: detail}); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// getPurgeable returns the IDs of receipts deleted before the cutoff
+func getPurgeable(cutoff time.Time) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	results, err := database.DbConn.QueryContext(ctx, `SELECT receiptId FROM receipts WHERE deletedAt IS NOT NULL AND deletedAt <= ? ORDER BY deletedAt`, cutoff.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer results.Close()
+	ids := make([]string, 0)
+	for results.Next() {
+		var id string
+		if err := results.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, results.Err()
+}
+
+// getStoredVersions returns the versions of a receipt that still have a file
+func getStoredVersions(receiptID string) ([]storedVersion, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	return queryStoredVersions(ctx, `SELECT receiptId, version, blobKey FROM receipt_versions WHERE receiptId = ? AND expiredAt IS NULL ORDER BY version`, receiptID)
+}
+
+// purgeReceipt removes everything about a deleted receipt except its audit log and the purchase orders it was attached to
+func purgeReceipt(receiptID string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	tx, err := database.DbConn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, table := range []string{"receipt_extractions", "receipt_versions", "receipt_tags", "receipt_products", "receipts"} {
+		if _, err = tx.ExecContext(ctx, `DELETE FROM `+table+` WHERE receiptId = ?`, receiptID); err != nil {
+			return err
+		}
+	}
+	if err = insertAudit(ctx, tx, AuditEntry{ReceiptID: receiptID, Action: AuditPurge, Actor: "retention"}); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// uploadDetails are the receipt's details given when an upload starts, stored as JSON until it's finished
+type uploadDetails struct {
+	UploadedBy       string   `json:"uploadedBy"`
+	Tags             []string `json:"tags"`
+	ProductIDs       []int    `json:"productIds"`
+	PurchaseOrderIDs []int    `json:"purchaseOrderIds"`
+}
+
+func insertUpload(session UploadSession) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	details, err := json.Marshal(uploadDetails{session.UploadedBy, session.Tags, session.ProductIDs, session.PurchaseOrderIDs})
+	if err != nil {
+		return err
+	}
+	_, err = database.DbConn.ExecContext(ctx, `INSERT INTO receipt_uploads
+	(uploadId, receiptId, filename, size, checksum, details, status, createdAt, expiresAt)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		session.UploadID,
+		session.ReceiptID,
+		session.Filename,
+		session.Size,
+		session.Checksum,
+		string(details),
+		session.Status,
+		session.CreatedAt.UTC(),
+		session.ExpiresAt.UTC())
+	return err
+}
+
+func getUpload(uploadID string) (*UploadSession, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	row := database.DbConn.QueryRowContext(ctx, `SELECT uploadId,
+	receiptId,
+	filename,
+	size,
+	uploadOffset,
+	checksum,
+	details,
+	status,
+	createdAt,
+	expiresAt
+	FROM receipt_uploads
+	WHERE uploadId = ?`, uploadID)
+	session := &UploadSession{}
+	var details string
+	err := row.Scan(&session.UploadID,
+		&session.ReceiptID,
+		&session.Filename,
+		&session.Size,
+		&session.Offset,
+		&session.Checksum,
+		&details,
+		&session.Status,
+		&session.CreatedAt,
+		&session.ExpiresAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var d uploadDetails
+	if err = json.Unmarshal([]byte(details), &d); err != nil {
+		return nil, err
+	}
+	session.UploadedBy, session.Tags, session.ProductIDs, session.PurchaseOrderIDs = d.UploadedBy, d.Tags, d.ProductIDs, d.PurchaseOrderIDs
+	return session, nil
+}
+
+// addChunk records a stored chunk and moves the upload's offset on, as long as nothing else has written at the offset first
+func addChunk(uploadID string, chunk uploadChunk, expiresAt time.Time) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	tx, err := database.DbConn.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	var offset int64
+	var status string
+	err = tx.QueryRowContext(ctx, `SELECT uploadOffset, status FROM receipt_uploads WHERE uploadId = ? FOR UPDATE`, uploadID).Scan(&offset, &status)
+	if err == sql.ErrNoRows {
+		return 0, ErrUploadNotFound
+	} else if err != nil {
+		return 0, err
+	}
+	if status != UploadOpen {
+		return 0, ErrUploadNotOpen
+	}
+	if offset != chunk.offset {
+		return offset, ErrOffsetMismatch
+	}
+	_, err = tx.ExecContext(ctx, `INSERT INTO receipt_upload_chunks (uploadId, chunkOffset, length, blobKey, checksum) VALUES (?, ?, ?, ?, ?)`,
+		uploadID, chunk.offset, chunk.length, chunk.key, chunk.checksum)
+	if err != nil {
+		return 0, err
+	}
+	offset += chunk.length
+	if _, err = tx.ExecContext(ctx, `UPDATE receipt_uploads SET uploadOffset = ?, expiresAt = ? WHERE uploadId = ?`, offset, expiresAt.UTC(), uploadID); err != nil {
+		return 0, err
+	}
+	return offset, tx.Commit()
+}
+
+func getChunks(uploadID string) ([]uploadChunk, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	results, err := database.DbConn.QueryContext(ctx, `SELECT chunkOffset, length, blobKey, checksum FROM receipt_upload_chunks WHERE uploadId = ? ORDER BY chunkOffset`, uploadID)
+	if err != nil {
+		return nil, err
+	}
+	defer results.Close()
+	chunks := make([]uploadChunk, 0)
+	for results.Next() {
+		var chunk uploadChunk
+		if err := results.Scan(&chunk.offset, &chunk.length, &chunk.key, &chunk.checksum); err != nil {
+			return nil, err
+		}
+		chunks = append(chunks, chunk)
+	}
+	return chunks, results.Err()
+}
+
+// setUploadStatus moves an upload from one status to another, returning false if it wasn't in the from status
+func setUploadStatus(uploadID, from, to, receiptID string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	result, err := database.DbConn.ExecContext(ctx, `UPDATE receipt_uploads SET status = ?, receiptId = IF(? = '', receiptId, ?) WHERE uploadId = ? AND status = ?`,
+		to, receiptID, receiptID, uploadID, from)
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	return affected == 1, err
+}
+
+// removeChunks forgets an upload's chunks, and the upload itself too when all is true
+func removeChunks(uploadID string, all bool) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	tx, err := database.DbConn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err = tx.ExecContext(ctx, `DELETE FROM receipt_upload_chunks WHERE uploadId = ?`, uploadID); err != nil {
+		return err
+	}
+	if all {
+		if _, err = tx.ExecContext(ctx, `DELETE FROM receipt_uploads WHERE uploadId = ?`, uploadID); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// getExpiredUploads returns the uploads whose sessions have run out, finished or not
+func getExpiredUploads(now time.Time) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	results, err := database.DbConn.QueryContext(ctx, `SELECT uploadId FROM receipt_uploads WHERE expiresAt < ?`, now.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer results.Close()
+	ids := make([]string, 0)
+	for results.Next() {
+		var id string
+		if err := results.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, results.Err()
+}
+
+// getExtraction returns what was extracted from a version of a receipt, nil if it hasn't been yet
+func getExtraction(receiptID string, version int) (*Extraction, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	row := database.DbConn.QueryRowContext(ctx, `SELECT method,
+	text,
+	document,
+	extractedAt
+	FROM receipt_extractions
+	WHERE receiptId = ? AND version = ?`, receiptID, version)
+	extraction := &Extraction{ReceiptID: receiptID, Version: version}
+	var document string
+	err := row.Scan(&extraction.Method, &extraction.Text, &document, &extraction.ExtractedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal([]byte(document), &extraction.Document); err != nil {
+		return nil, err
+	}
+	return extraction, nil
+}
+
+// saveExtraction records what was extracted from a version, replacing what was extracted before
+func saveExtraction(extraction Extraction) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	document, err := json.Marshal(extraction.Document)
+	if err != nil {
+		return err
+	}
+	_, err = database.DbConn.ExecContext(ctx, `INSERT INTO receipt_extractions (receiptId, version, method, text, document, extractedAt)
+	VALUES (?, ?, ?, ?, ?, ?)
+	ON DUPLICATE KEY UPDATE method = VALUES(method), text = VALUES(text), document = VALUES(document), extractedAt = VALUES(extractedAt)`,
+		extraction.ReceiptID,
+		extraction.Version,
+		extraction.Method,
+		extraction.Text,
+		string(document),
+		extraction.ExtractedAt.UTC())
+	return err
+}
+
+// productMatch is a product a receipt line was matched to
+type productMatch struct {
+	productID   int
+	productName string
+	sku         string
+}
+
+// matchProductByUpc finds the product with a UPC, as a 12 digit UPC-A or the same code as a 13 digit EAN-13 with a leading 0
+// Products keep the UPC-A form, and the conditions are the unique indexes' expressions so the indexes are used
+func matchProductByUpc(upc string) (*productMatch, error) {
+	if len(upc) == 13 && strings.HasPrefix(upc, "0") {
+		upc = upc[1:]
+	}
+	return matchProduct(`NULLIF(TRIM(upc), '') = ?`, upc)
+}
+
+func matchProductBySku(sku string) (*productMatch, error) {
+	return matchProduct(`NULLIF(TRIM(sku), '') = ?`, strings.TrimSpace(sku))
+}
+
+func matchProduct(condition string, args ...interface{}) (*productMatch, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	match := &productMatch{}
+	err := database.DbConn.QueryRowContext(ctx, `SELECT productId, productName, sku FROM products WHERE `+condition+` LIMIT 1`, args...).
+		Scan(&match.productID, &match.productName, &match.sku)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return match, nil
+}
+
+// openPurchaseOrderLine is a purchase order line still waiting for goods
+type openPurchaseOrderLine struct {
+	lineID    int
+	productID int
+	remaining int
+}
+
+// getOpenPurchaseOrderLines returns the lines of a purchase order that can still be received against,
+// none if the purchase order isn't waiting for goods
+func getOpenPurchaseOrderLines(purchaseOrderID int) ([]openPurchaseOrderLine, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	results, err := database.DbConn.QueryContext(ctx, `SELECT l.lineId,
+	l.productId,
+	l.quantityOrdered - l.quantityReceived
+	FROM purchase_order_lines l
+	JOIN purchase_orders p ON p.purchaseOrderId = l.purchaseOrderId
+	WHERE l.purchaseOrderId = ? AND p.status IN ('ordered', 'partially_received') AND l.quantityReceived < l.quantityOrdered
+	ORDER BY l.lineId`, purchaseOrderID)
+	if err != nil {
+		return nil, err
+	}
+	defer results.Close()
+	lines := make([]openPurchaseOrderLine, 0)
+	for results.Next() {
+		var line openPurchaseOrderLine
+		if err := results.Scan(&line.lineID, &line.productID, &line.remaining); err != nil {
+			return nil, err
+		}
+		lines = append(lines, line)
+	}
+	return lines, results.Err()
+}
@@ -0,0 +1,252 @@
+package receipt
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jordbick/Golang/inventory-service/extract"
+	"github.com/jordbick/Golang/inventory-service/response"
+)
+
+// A receipt's text is extracted and parsed the first time it's asked for, and kept for that version, see the extract package
+// The receiving draft is worked out fresh each time from the parsed lines, since products and purchase orders change:
+// lines are matched to products by UPC and then SKU, and to the purchase order the receipt is linked to,
+// so a clerk can check it and send it on to the purchase order's receive action
+
+// Extractor gets the text out of receipts. Plain text and PDFs are read as they are, setting an OCR engine at start up adds images
+var Extractor = extract.NewPipeline(nil)
+
+var ErrCannotExtract = errors.New("text can't be extracted from this type of receipt")
+
+// Extraction is what was read from a version of a receipt
+type Extraction struct {
+	ReceiptID   string           `json:"receiptId"`
+	Version     int              `json:"version"`
+	Method      string           `json:"method"`
+	Text        string           `json:"text"`
+	Document    extract.Document `json:"document"`
+	ExtractedAt time.Time        `json:"extractedAt"`
+}
+
+// ReceivingDraft suggests what to receive from a receipt. Nothing is received until someone sends Receiving
+// to POST /api/purchaseorders/{purchaseOrderId}/receive, after checking and changing it as needed
+type ReceivingDraft struct {
+	ReceiptID       string          `json:"receiptId"`
+	Version         int             `json:"version"`
+	Vendor          string          `json:"vendor"`
+	Date            *time.Time      `json:"date"`
+	Total           string          `json:"total,omitempty"`
+	PurchaseOrderID int             `json:"purchaseOrderId,omitempty"`
+	Lines           []DraftLine     `json:"lines"`
+	Receiving       *DraftReceiving `json:"receiving,omitempty"`
+	Warnings        []string        `json:"warnings"`
+}
+
+// DraftLine is a receipt line and what it was matched to. MatchedBy is upc or sku, or empty if no product matched
+// PurchaseOrderLineID is the purchase order line it's received against, if the product is on the purchase order
+type DraftLine struct {
+	extract.Line
+	ProductID           int    `json:"productId,omitempty"`
+	ProductName         string `json:"productName,omitempty"`
+	MatchedBy           string `json:"matchedBy,omitempty"`
+	PurchaseOrderLineID int    `json:"purchaseOrderLineId,omitempty"`
+}
+
+// DraftReceiving has the same shape as a purchase order's receive action
+type DraftReceiving struct {
+	Lines       []DraftReceivedLine `json:"lines"`
+	LocationID  int                 `json:"locationId"`
+	ReceiptName string              `json:"receiptName"`
+}
+
+// DraftReceivedLine is a quantity to receive against a purchase order line, capped at what the line is still waiting for
+type DraftReceivedLine struct {
+	LineID   int `json:"lineId"`
+	Quantity int `json:"quantity"`
+}
+
+// ExtractReceipt returns what was extracted from the receipt's current version, extracting it first if it hasn't been,
+// or again if refresh is true
+func ExtractReceipt(ctx context.Context, id string, refresh bool) (*Extraction, error) {
+	receipt, err := GetReceipt(id)
+	if err != nil {
+		return nil, err
+	}
+	if !refresh {
+		extraction, err := getExtraction(receipt.ID, receipt.Version)
+		if err != nil || extraction != nil {
+			return extraction, err
+		}
+	}
+	if !Extractor.Supports(receipt.ContentType) {
+		return nil, ErrCannotExtract
+	}
+	receipt, file, err := OpenReceiptVersion(ctx, receipt.ID, receipt.Version)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	result, err := Extractor.Run(ctx, file, receipt.ContentType)
+	if err == extract.ErrUnsupported {
+		return nil, ErrCannotExtract
+	}
+	if err != nil {
+		return nil, err
+	}
+	extraction := &Extraction{
+		ReceiptID:   receipt.ID,
+		Version:     receipt.Version,
+		Method:      result.Method,
+		Text:        result.Text,
+		Document:    result.Document,
+		ExtractedAt: time.Now().UTC(),
+	}
+	if err = saveExtraction(*extraction); err != nil {
+		return nil, err
+	}
+	return extraction, nil
+}
+
+// BuildReceivingDraft matches an extracted receipt's lines to products, and to the lines of a purchase order
+// purchaseOrderID picks the purchase order, 0 uses the one the receipt is linked to if there's only one
+func BuildReceivingDraft(ctx context.Context, id string, purchaseOrderID int) (*ReceivingDraft, error) {
+	extraction, err := ExtractReceipt(ctx, id, false)
+	if err != nil {
+		return nil, err
+	}
+	receipt, err := GetReceipt(id)
+	if err != nil {
+		return nil, err
+	}
+	if purchaseOrderID == 0 && len(receipt.PurchaseOrderIDs) == 1 {
+		purchaseOrderID = receipt.PurchaseOrderIDs[0]
+	}
+	doc := extraction.Document
+	draft := &ReceivingDraft{
+		ReceiptID:       receipt.ID,
+		Version:         extraction.Version,
+		Vendor:          doc.Vendor,
+		Date:            doc.Date,
+		Total:           doc.Total,
+		PurchaseOrderID: purchaseOrderID,
+		Lines:           make([]DraftLine, 0, len(doc.Lines)),
+		Warnings:        make([]string, 0),
+	}
+	if len(doc.Lines) == 0 {
+		draft.Warnings = append(draft.Warnings, "no line items could be read from the receipt")
+	}
+
+	// the purchase order's open lines by product, with what's still to come
+	var poLines map[int]*openPurchaseOrderLine
+	if purchaseOrderID != 0 {
+		lines, err := getOpenPurchaseOrderLines(purchaseOrderID)
+		if err != nil {
+			return nil, err
+		}
+		if len(lines) == 0 {
+			draft.Warnings = append(draft.Warnings, "the purchase order has nothing left to receive")
+		}
+		poLines = make(map[int]*openPurchaseOrderLine)
+		for i := range lines {
+			if poLines[lines[i].productID] == nil {
+				poLines[lines[i].productID] = &lines[i]
+			}
+		}
+		draft.Receiving = &DraftReceiving{Lines: make([]DraftReceivedLine, 0), ReceiptName: receipt.ID}
+	} else if len(receipt.PurchaseOrderIDs) > 1 {
+		draft.Warnings = append(draft.Warnings, "the receipt is linked to more than one purchase order, pick one with purchaseOrderId")
+	}
+
+	for _, line := range doc.Lines {
+		draftLine := DraftLine{Line: line}
+		match, matchedBy, err := matchLine(line)
+		if err != nil {
+			return nil, err
+		}
+		if match == nil {
+			draft.Warnings = append(draft.Warnings, "no product matches "+strconv.Quote(line.Text))
+			draft.Lines = append(draft.Lines, draftLine)
+			continue
+		}
+		draftLine.ProductID, draftLine.ProductName, draftLine.MatchedBy = match.productID, match.productName, matchedBy
+		if poLines != nil {
+			poLine := poLines[match.productID]
+			switch {
+			case poLine == nil:
+				draft.Warnings = append(draft.Warnings, match.productName+" isn't waiting to be received on the purchase order")
+			case poLine.remaining <= 0:
+				draft.Warnings = append(draft.Warnings, match.productName+" has already been received in full")
+			default:
+				quantity := line.Quantity
+				if quantity > poLine.remaining {
+					draft.Warnings = append(draft.Warnings, "more "+match.productName+" on the receipt than the purchase order is still waiting for, only "+strconv.Itoa(poLine.remaining)+" suggested")
+					quantity = poLine.remaining
+				}
+				poLine.remaining -= quantity
+				draftLine.PurchaseOrderLineID = poLine.lineID
+				draft.Receiving.Lines = append(draft.Receiving.Lines, DraftReceivedLine{LineID: poLine.lineID, Quantity: quantity})
+			}
+		}
+		draft.Lines = append(draft.Lines, draftLine)
+	}
+	return draft, nil
+}
+
+// matchLine finds the product for a receipt line, by UPC first since it's the more exact of the two
+func matchLine(line extract.Line) (*productMatch, string, error) {
+	if line.UPC != "" {
+		match, err := matchProductByUpc(line.UPC)
+		if err != nil || match != nil {
+			return match, "upc", err
+		}
+	}
+	if line.SKU != "" {
+		match, err := matchProductBySku(line.SKU)
+		if err != nil || match != nil {
+			return match, "sku", err
+		}
+	}
+	return nil, "", nil
+}
+
+// handleExtraction handles GET /receipts/{id}/extraction, ?refresh=true extracts the text again
+// and /receipts/{id}/draft, ?purchaseOrderId= picks the purchase order to receive against
+func handleExtraction(w http.ResponseWriter, r *http.Request, receiptID, what string) {
+	switch r.Method {
+	case http.MethodGet:
+		query := r.URL.Query()
+		var result interface{}
+		var err error
+		if what == "extraction" {
+			result, err = ExtractReceipt(r.Context(), receiptID, query.Get("refresh") == "true")
+		} else {
+			purchaseOrderID := 0
+			if v := query.Get("purchaseOrderId"); v != "" {
+				purchaseOrderID, err = strconv.Atoi(v)
+				if err != nil || purchaseOrderID < 1 {
+					http.Error(w, "purchaseOrderId must be a purchase order ID", http.StatusBadRequest)
+					return
+				}
+			}
+			result, err = BuildReceivingDraft(r.Context(), receiptID, purchaseOrderID)
+		}
+		if err == ErrCannotExtract {
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		if err != nil {
+			receiptError(w, err)
+			return
+		}
+		response.WriteJSON(w, result)
+
+	case http.MethodOptions:
+		return
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
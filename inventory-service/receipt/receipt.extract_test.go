@@ -0,0 +1,159 @@
+package receipt
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jordbick/Golang/inventory-service/database"
+	"github.com/jordbick/Golang/inventory-service/database/dbtest"
+	"github.com/jordbick/Golang/inventory-service/extract"
+)
+
+const testReceiptID = "0123456789abcdef0123456789abcdef"
+
+// draftDB answers the queries BuildReceivingDraft makes for a receipt that's already been extracted
+type draftDB struct {
+	document       extract.Document
+	purchaseOrders []int
+	// products by UPC and SKU
+	products map[string][]driver.Value
+	// open purchase order lines by purchase order: lineId, productId, remaining
+	lines map[int][][]driver.Value
+}
+
+func (d *draftDB) handle(query string, args []driver.Value) (dbtest.Result, error) {
+	switch {
+	case strings.Contains(query, "FROM receipts"):
+		return dbtest.Result{Rows: [][]driver.Value{{testReceiptID, "receipt.txt", "text/plain", int64(100), "abc", "clerk", time.Now(), int64(1)}}}, nil
+	case strings.Contains(query, "FROM receipt_tags"), strings.Contains(query, "FROM receipt_products"):
+		return dbtest.Result{}, nil
+	case strings.Contains(query, "FROM purchase_order_receipts"):
+		var rows [][]driver.Value
+		for _, id := range d.purchaseOrders {
+			rows = append(rows, []driver.Value{testReceiptID, int64(id)})
+		}
+		return dbtest.Result{Rows: rows}, nil
+	case strings.Contains(query, "FROM receipt_extractions"):
+		document, err := json.Marshal(d.document)
+		if err != nil {
+			return dbtest.Result{}, err
+		}
+		return dbtest.Result{Rows: [][]driver.Value{{"text", "", string(document), time.Now()}}}, nil
+	case strings.Contains(query, "FROM products"):
+		for _, arg := range args {
+			if product, ok := d.products[arg.(string)]; ok {
+				return dbtest.Result{Rows: [][]driver.Value{product}}, nil
+			}
+		}
+		return dbtest.Result{}, nil
+	case strings.Contains(query, "FROM purchase_order_lines"):
+		return dbtest.Result{Rows: d.lines[int(args[0].(int64))]}, nil
+	}
+	return dbtest.Result{}, errors.New("unexpected query " + query)
+}
+
+func TestBuildReceivingDraft(t *testing.T) {
+	db := &draftDB{
+		document: extract.Document{Vendor: "ACME", Total: "60.00", Lines: []extract.Line{
+			{Text: "WID-100 Widget x10", SKU: "WID-100", Quantity: 10, Amount: "50.00"},
+			{Text: "036000291452 Tissues", UPC: "036000291452", Quantity: 2, Amount: "5.00"},
+			{Text: "WID-100 Widget", SKU: "WID-100", Quantity: 1, Amount: "5.00"},
+			{Text: "Mystery item", Quantity: 1, Amount: "0.00"},
+		}},
+		purchaseOrders: []int{5},
+		products: map[string][]driver.Value{
+			"WID-100":      {int64(1), "Widget", "WID-100"},
+			"036000291452": {int64(2), "Tissues", "TIS-1"},
+		},
+		lines: map[int][][]driver.Value{
+			5: {{int64(50), int64(1), int64(4)}},
+		},
+	}
+	database.DbConn = dbtest.Open(db.handle)
+
+	draft, err := BuildReceivingDraft(context.Background(), testReceiptID, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if draft.PurchaseOrderID != 5 || draft.Vendor != "ACME" || draft.Total != "60.00" {
+		t.Errorf("draft for purchase order %d, vendor %q, total %q", draft.PurchaseOrderID, draft.Vendor, draft.Total)
+	}
+	// only the 4 widgets still to come are suggested, the second widget line has nothing left to receive against
+	wantReceiving := []DraftReceivedLine{{LineID: 50, Quantity: 4}}
+	if draft.Receiving == nil || !reflect.DeepEqual(draft.Receiving.Lines, wantReceiving) || draft.Receiving.ReceiptName != testReceiptID {
+		t.Fatalf("receiving %+v, want %+v", draft.Receiving, wantReceiving)
+	}
+	matched := make([]string, len(draft.Lines))
+	for i, line := range draft.Lines {
+		matched[i] = line.MatchedBy
+	}
+	if !reflect.DeepEqual(matched, []string{"sku", "upc", "sku", ""}) || draft.Lines[0].PurchaseOrderLineID != 50 || draft.Lines[1].ProductID != 2 {
+		t.Errorf("lines %+v", draft.Lines)
+	}
+	wantWarnings := []string{
+		"more Widget on the receipt than the purchase order is still waiting for, only 4 suggested",
+		"Tissues isn't waiting to be received on the purchase order",
+		"Widget has already been received in full",
+		`no product matches "Mystery item"`,
+	}
+	if !reflect.DeepEqual(draft.Warnings, wantWarnings) {
+		t.Errorf("warnings\n%q\nwant\n%q", draft.Warnings, wantWarnings)
+	}
+}
+
+func TestBuildReceivingDraftPurchaseOrders(t *testing.T) {
+	db := &draftDB{
+		document: extract.Document{Lines: []extract.Line{{Text: "WID-100 Widget", SKU: "WID-100", Quantity: 3, Amount: "15.00"}}},
+		products: map[string][]driver.Value{"WID-100": {int64(1), "Widget", "WID-100"}},
+		lines: map[int][][]driver.Value{
+			5: {{int64(50), int64(1), int64(10)}},
+			6: {{int64(60), int64(1), int64(10)}},
+		},
+	}
+	database.DbConn = dbtest.Open(db.handle)
+
+	// linked to more than one purchase order, none is picked
+	db.purchaseOrders = []int{5, 6}
+	draft, err := BuildReceivingDraft(context.Background(), testReceiptID, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if draft.PurchaseOrderID != 0 || draft.Receiving != nil || len(draft.Warnings) != 1 || !strings.Contains(draft.Warnings[0], "more than one purchase order") {
+		t.Errorf("ambiguous: purchase order %d, receiving %+v, warnings %q", draft.PurchaseOrderID, draft.Receiving, draft.Warnings)
+	}
+
+	// picking one receives against it
+	draft, err = BuildReceivingDraft(context.Background(), testReceiptID, 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if draft.Receiving == nil || !reflect.DeepEqual(draft.Receiving.Lines, []DraftReceivedLine{{LineID: 60, Quantity: 3}}) || len(draft.Warnings) != 0 {
+		t.Errorf("picked: receiving %+v, warnings %q", draft.Receiving, draft.Warnings)
+	}
+
+	// no purchase order at all just matches products
+	db.purchaseOrders = nil
+	draft, err = BuildReceivingDraft(context.Background(), testReceiptID, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if draft.Receiving != nil || draft.Lines[0].ProductID != 1 || len(draft.Warnings) != 0 {
+		t.Errorf("unlinked: receiving %+v, lines %+v, warnings %q", draft.Receiving, draft.Lines, draft.Warnings)
+	}
+
+	// a purchase order with nothing left to come
+	db.lines[7] = nil
+	draft, err = BuildReceivingDraft(context.Background(), testReceiptID, 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(draft.Receiving.Lines) != 0 || len(draft.Warnings) != 2 || draft.Warnings[0] != "the purchase order has nothing left to receive" {
+		t.Errorf("closed: receiving %+v, warnings %q", draft.Receiving, draft.Warnings)
+	}
+}
@@ -1,31 +1,123 @@
 package receipt
 
 import (
-	"io/ioutil"
+	"errors"
 	"path/filepath"
 	"time"
+
+	"github.com/jordbick/Golang/inventory-service/blob"
 )
 
 // create a variable to point to our recipt directory, which is where we will store the files on disk
 // Directory called uploads
 var ReceiptDirectory string = filepath.Join("uploads")
 
-// Receipt type with 2 fields
+// Store is where receipt files are kept, the receipt directory unless it's changed at start up, e.g. to S3 so several copies of the service can share receipts
+var Store blob.Store = blob.NewLocal(ReceiptDirectory)
+
+// MaxReceiptSize is the largest receipt that can be uploaded, in bytes
+var MaxReceiptSize int64 = 10 << 20
+
+// AllowedContentTypes are the kinds of file that can be uploaded as a receipt, worked out from the file's content
+// rather than trusting the name or what the client says it is
+var AllowedContentTypes = []string{"application/pdf", "image/jpeg", "image/png", "image/gif", "image/webp", "text/plain"}
+
+var (
+	ErrReceiptNotFound       = errors.New("receipt not found")
+	ErrReceiptTooLarge       = errors.New("receipt is too large")
+	ErrContentTypeNotAllowed = errors.New("receipt file type is not allowed")
+	ErrProductNotFound       = errors.New("linked product not found")
+	ErrPurchaseOrderNotFound = errors.New("linked purchase order not found")
+	ErrVersionNotFound       = errors.New("receipt version not found")
+	ErrVersionExpired        = errors.New("receipt version has expired")
+)
+
+// Receipt type describing an uploaded file
+// The ID is generated when the receipt is uploaded and is what the file is stored under
+// Name is the uploader's file name, cleaned up, and is only used when downloading
+// Checksum is the hex SHA-256 of the file
+// Replacing the file makes a new version, the receipt's details are always those of the current version
 type Receipt struct {
-	ReceiptName string    `json:"name"`
-	UploadDate  time.Time `json:"uploadDate"`
+	ID               string    `json:"id"`
+	ReceiptName      string    `json:"name"`
+	ContentType      string    `json:"contentType"`
+	Size             int64     `json:"size"`
+	Checksum         string    `json:"checksum"`
+	UploadedBy       string    `json:"uploadedBy"`
+	UploadDate       time.Time `json:"uploadDate"`
+	Version          int       `json:"version"`
+	Tags             []string  `json:"tags"`
+	ProductIDs       []int     `json:"productIds"`
+	PurchaseOrderIDs []int     `json:"purchaseOrderIds"`
+}
+
+// ReceiptVersion is one version of a receipt's file
+// SupersededAt is when the next version replaced it, and ExpiredAt when retention removed its file
+type ReceiptVersion struct {
+	Version      int        `json:"version"`
+	ReceiptName  string     `json:"name"`
+	ContentType  string     `json:"contentType"`
+	Size         int64      `json:"size"`
+	Checksum     string     `json:"checksum"`
+	UploadedBy   string     `json:"uploadedBy"`
+	UploadDate   time.Time  `json:"uploadDate"`
+	SupersededAt *time.Time `json:"supersededAt"`
+	ExpiredAt    *time.Time `json:"expiredAt"`
+}
+
+// Audit actions
+const (
+	AuditUpload  = "upload"
+	AuditReplace = "replace"
+	AuditDelete  = "delete"
+	AuditExpire  = "expire"
+	AuditPurge   = "purge"
+)
+
+// AuditEntry records something that happened to a receipt, Version is 0 when the action was on the whole receipt
+type AuditEntry struct {
+	AuditID   int       `json:"auditId"`
+	ReceiptID string    `json:"receiptId"`
+	Version   int       `json:"version,omitempty"`
+	Action    string    `json:"action"`
+	Actor     string    `json:"actor"`
+	Detail    string    `json:"detail"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// ReceiptFilter narrows down the receipt list, zero values match everything
+// ContentType matches either a whole media type (image/png) or a family of them (image/)
+type ReceiptFilter struct {
+	Name            string
+	ContentType     string
+	UploadedBy      string
+	Tag             string
+	ProductID       int
+	PurchaseOrderID int
+	From            time.Time
+	To              time.Time
+	// Sort is a field optionally followed by asc or desc, e.g. "size desc". Newest first by default
+	Sort   string
+	Limit  int
+	Offset int
+}
+
+// DefaultReceiptLimit and MaxReceiptLimit are the default and largest page sizes of the receipt list
+const (
+	DefaultReceiptLimit = 50
+	MaxReceiptLimit     = 500
+)
+
+// receiptSortFields maps the fields the list can be sorted by to their columns
+var receiptSortFields = map[string]string{
+	"uploadDate":  "uploadedAt",
+	"name":        "name",
+	"size":        "size",
+	"contentType": "contentType",
+	"uploadedBy":  "uploadedBy",
 }
 
-// function that will go through the list of files in our uploads folder and create a slice of receipt objects
-func GetReceipts() ([]Receipt, error) {
-	receipts := make([]Receipt, 0)
-	files, err := ioutil.ReadDir(ReceiptDirectory)
-	if err != nil {
-		return nil, err
-	}
-	// loop over files returned and append those to the slice as receipt objects
-	for _, f := range files {
-		receipts = append(receipts, Receipt{ReceiptName: f.Name(), UploadDate: f.ModTime()})
-	}
-	return receipts, nil
+// GetReceipts returns a page of the receipts matching the filter, along with how many match altogether
+func GetReceipts(filter ReceiptFilter) ([]Receipt, int, error) {
+	return getReceiptList(filter)
 }
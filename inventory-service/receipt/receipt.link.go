@@ -0,0 +1,118 @@
+package receipt
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/jordbick/Golang/inventory-service/blob"
+)
+
+// Download links let a receipt be fetched for a limited time without anything else
+// Stores that can presign, like S3, hand out links straight to the store, so the download doesn't go through the service
+// Otherwise the link is back to the receipt's download URL with an expiry time and an HMAC signature of the ID and expiry
+
+// DefaultLinkExpiry and MaxLinkExpiry are how long download links last by default and at most
+const (
+	DefaultLinkExpiry = 15 * time.Minute
+	MaxLinkExpiry     = 7 * 24 * time.Hour
+)
+
+// LinkKey signs download links to the service. Every copy of the service needs the same key for a link to work on all of them,
+// if it's not set a random key is made, and links stop working when the service restarts
+var LinkKey []byte
+
+var linkKeyOnce sync.Once
+
+func linkKey() []byte {
+	linkKeyOnce.Do(func() {
+		if len(LinkKey) == 0 {
+			LinkKey = make([]byte, 32)
+			if _, err := rand.Read(LinkKey); err != nil {
+				panic(err)
+			}
+		}
+	})
+	return LinkKey
+}
+
+// downloadPath is where receipts are downloaded from, set up by SetupRoutes
+var downloadPath = "/api/receipts/"
+
+var (
+	errLinkExpiry  = fmt.Errorf("links can last from a second to %s", MaxLinkExpiry)
+	errLinkExpired = errors.New("download link has expired")
+	errLinkInvalid = errors.New("download link is not valid")
+)
+
+// Link is a link to download a receipt
+type Link struct {
+	URL       string    `json:"url"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// DownloadLink returns a link to download the receipt that lasts as long as expires
+func DownloadLink(id string, expires time.Duration) (Link, error) {
+	if expires < time.Second || expires > MaxLinkExpiry {
+		return Link{}, errLinkExpiry
+	}
+	receipt, err := GetReceipt(id)
+	if err != nil {
+		return Link{}, err
+	}
+	now := time.Now().UTC()
+	link := Link{ExpiresAt: now.Add(expires).Truncate(time.Second)}
+	if presigner, ok := Store.(blob.Presigner); ok {
+		// the link is to the current version's file
+		v, key, err := getVersion(receipt.ID, receipt.Version)
+		if err != nil {
+			return Link{}, err
+		}
+		if v == nil || v.ExpiredAt != nil {
+			return Link{}, ErrReceiptNotFound
+		}
+		link.URL, err = presigner.PresignGet(key, expires, blob.ResponseHeaders{
+			ContentType:        receipt.ContentType,
+			ContentDisposition: attachment(receipt.ReceiptName),
+		})
+		return link, err
+	}
+	expiry := strconv.FormatInt(link.ExpiresAt.Unix(), 10)
+	query := url.Values{"expires": {expiry}, "signature": {linkSignature(receipt.ID, expiry)}}
+	link.URL = downloadPath + url.PathEscape(receipt.ID) + "?" + query.Encode()
+	return link, nil
+}
+
+func linkSignature(id, expiry string) string {
+	mac := hmac.New(sha256.New, linkKey())
+	mac.Write([]byte(id + "\n" + expiry))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// verifyLink checks a download link's signature and that it hasn't expired
+func verifyLink(id string, query url.Values, now time.Time) error {
+	expiry := query.Get("expires")
+	seconds, err := strconv.ParseInt(expiry, 10, 64)
+	if err != nil {
+		return errLinkInvalid
+	}
+	signature, err := hex.DecodeString(query.Get("signature"))
+	if err != nil {
+		return errLinkInvalid
+	}
+	expected, _ := hex.DecodeString(linkSignature(id, expiry))
+	if !hmac.Equal(signature, expected) {
+		return errLinkInvalid
+	}
+	if now.Unix() > seconds {
+		return errLinkExpired
+	}
+	return nil
+}
@@ -0,0 +1,413 @@
+package receipt
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"log"
+	"mime"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/jordbick/Golang/inventory-service/blob"
+)
+
+// Previews are small versions of receipts the client can show in a list without downloading the receipts themselves
+// JPEG, PNG and GIF receipts get a thumbnail no bigger than PreviewSize on either side, and plain text receipts their first few lines
+// Previews are made by a pool of workers as soon as a receipt or new version is recorded, and kept in Store next to the receipts
+// under previews/{id}/{version}. One that's asked for before it's ready is made straight away, or the client is told to try again shortly
+
+// PreviewSize is the longest side of a thumbnail, in pixels
+var PreviewSize = 320
+
+// MaxPreviewPixels stops an image that's small on disk but huge once decoded from using up the service's memory
+var MaxPreviewPixels = 50 * 1000 * 1000
+
+// preview text is cut off after this many lines or bytes, whichever comes first
+const (
+	previewTextLines = 20
+	previewTextBytes = 2048
+)
+
+var (
+	ErrNoPreview       = errors.New("there's no preview for this type of receipt")
+	ErrPreviewTooLarge = errors.New("receipt image is too large to preview")
+	ErrBadPreviewImage = errors.New("receipt image can't be decoded")
+)
+
+// previewKey is where a version's preview is kept, the slashes keep it from being taken for a receipt ID
+func previewKey(receiptID string, version int) string {
+	return fmt.Sprintf("previews/%s/%d", receiptID, version)
+}
+
+// previewType is the content type of the preview made for a receipt, empty if there isn't one
+func previewType(contentType string) string {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch strings.ToLower(mediaType) {
+	case "image/jpeg":
+		return "image/jpeg"
+	case "image/png", "image/gif":
+		return "image/png"
+	case "text/plain":
+		return "text/plain; charset=utf-8"
+	}
+	return ""
+}
+
+// makePreview makes and stores the preview for a version of a receipt, one that's already been made is left as it is
+func makePreview(ctx context.Context, receiptID string, version int) error {
+	receipt, file, err := OpenReceiptVersion(ctx, receiptID, version)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	contentType := previewType(receipt.ContentType)
+	if contentType == "" {
+		return ErrNoPreview
+	}
+	var preview bytes.Buffer
+	if strings.HasPrefix(contentType, "text/") {
+		err = textPreview(file, &preview)
+	} else {
+		err = imagePreview(file, &preview, contentType)
+	}
+	if err != nil {
+		return err
+	}
+	_, err = Store.Put(ctx, previewKey(receipt.ID, receipt.Version), &preview, contentType)
+	if err == blob.ErrExists {
+		return nil
+	}
+	return err
+}
+
+// textPreview writes the first lines of a text receipt as UTF-8
+func textPreview(r io.Reader, w io.Writer) error {
+	b, err := ioutil.ReadAll(io.LimitReader(r, previewTextBytes))
+	if err != nil {
+		return err
+	}
+	// don't leave half a character at the end
+	for i := 0; i < utf8.UTFMax-1 && len(b) == previewTextBytes-i; i++ {
+		if r, size := utf8.DecodeLastRune(b); r != utf8.RuneError || size != 1 {
+			break
+		}
+		b = b[:len(b)-1]
+	}
+	text := strings.ToValidUTF8(strings.NewReplacer("\r\n", "\n", "\r", "\n").Replace(string(b)), "\uFFFD")
+	lines := strings.SplitN(text, "\n", previewTextLines+1)
+	if len(lines) > previewTextLines {
+		lines = lines[:previewTextLines]
+	}
+	_, err = io.WriteString(w, strings.Join(lines, "\n"))
+	return err
+}
+
+// imageReader remembers the last error reading a receipt, so one that can't be read isn't mistaken for one that can't be decoded
+type imageReader struct {
+	r   io.Reader
+	err error
+}
+
+func (r *imageReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if err != nil && err != io.EOF {
+		r.err = err
+	}
+	return n, err
+}
+
+// decodeError is the read error if there was one, otherwise the image is bad and ErrBadPreviewImage wraps why
+func (r *imageReader) decodeError(err error) error {
+	if r.err != nil {
+		return r.err
+	}
+	return fmt.Errorf("%w: %v", ErrBadPreviewImage, err)
+}
+
+// imagePreview decodes an image and writes it scaled down to fit in PreviewSize, as a JPEG or PNG
+func imagePreview(r io.Reader, w io.Writer, contentType string) error {
+	source := &imageReader{r: r}
+	var head bytes.Buffer
+	config, _, err := image.DecodeConfig(io.TeeReader(source, &head))
+	if err != nil {
+		return source.decodeError(err)
+	}
+	if config.Width*config.Height > MaxPreviewPixels {
+		return ErrPreviewTooLarge
+	}
+	img, _, err := image.Decode(io.MultiReader(&head, source))
+	if err != nil {
+		return source.decodeError(err)
+	}
+	thumbnail := scaleDown(img, PreviewSize)
+	if contentType == "image/jpeg" {
+		return jpeg.Encode(w, thumbnail, &jpeg.Options{Quality: 80})
+	}
+	// a GIF only has its first frame previewed
+	return png.Encode(w, thumbnail)
+}
+
+// scaleDown shrinks an image to fit in a square of size pixels, each pixel of the thumbnail is the average of the pixels it covers
+// An image that already fits is copied as it is
+func scaleDown(src image.Image, size int) *image.RGBA {
+	bounds := src.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	newWidth, newHeight := width, height
+	if width > size || height > size {
+		if width >= height {
+			newWidth, newHeight = size, height*size/width
+		} else {
+			newWidth, newHeight = width*size/height, size
+		}
+		if newWidth < 1 {
+			newWidth = 1
+		}
+		if newHeight < 1 {
+			newHeight = 1
+		}
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, newWidth, newHeight))
+	if newWidth == width && newHeight == height {
+		draw.Draw(dst, dst.Bounds(), src, bounds.Min, draw.Src)
+		return dst
+	}
+	// add each source pixel to the thumbnail pixel it falls in, the colours are alpha premultiplied so transparency averages properly
+	sums := make([][4]uint64, newWidth*newHeight)
+	counts := make([]uint64, newWidth*newHeight)
+	for y := 0; y < height; y++ {
+		dy := y * newHeight / height
+		for x := 0; x < width; x++ {
+			i := dy*newWidth + x*newWidth/width
+			r, g, b, a := src.At(bounds.Min.X+x, bounds.Min.Y+y).RGBA()
+			sums[i][0] += uint64(r)
+			sums[i][1] += uint64(g)
+			sums[i][2] += uint64(b)
+			sums[i][3] += uint64(a)
+			counts[i]++
+		}
+	}
+	for i, sum := range sums {
+		n := counts[i]
+		if n == 0 {
+			continue
+		}
+		offset := (i/newWidth)*dst.Stride + (i%newWidth)*4
+		for c := 0; c < 4; c++ {
+			dst.Pix[offset+c] = uint8(sum[c] / n >> 8)
+		}
+	}
+	return dst
+}
+
+// previewJob is a preview waiting to be made, err is set once it's done is closed
+type previewJob struct {
+	receiptID string
+	version   int
+	done      chan struct{}
+	err       error
+}
+
+// PreviewWorkers make previews in the background, Workers at a time
+// A version that's asked for again while it's waiting shares the job that's already queued,
+// and one whose image is too large or can't be decoded isn't tried again, it's answered with the same error
+type PreviewWorkers struct {
+	Workers int
+	// Wait is how long a request for a preview that isn't ready waits for it before being told to come back
+	Wait   time.Duration
+	queue  chan *previewJob
+	mu     sync.Mutex
+	jobs   map[string]*previewJob
+	failed map[string]error
+}
+
+// Previews is the pool uploads queue previews on, started from main
+var Previews = NewPreviewWorkers()
+
+// NewPreviewWorkers makes previews two at a time, with room for 500 waiting
+func NewPreviewWorkers() *PreviewWorkers {
+	return &PreviewWorkers{
+		Workers: 2,
+		Wait:    10 * time.Second,
+		queue:   make(chan *previewJob, 500),
+		jobs:    make(map[string]*previewJob),
+		failed:  make(map[string]error),
+	}
+}
+
+// Start starts the Workers, each takes jobs off the queue until done is closed. Jobs still queued then are dropped
+func (p *PreviewWorkers) Start(done <-chan struct{}) {
+	for i := 0; i < p.Workers; i++ {
+		go func() {
+			for {
+				select {
+				case <-done:
+					return
+				case job := <-p.queue:
+					p.run(job)
+				}
+			}
+		}()
+	}
+}
+
+func (p *PreviewWorkers) run(job *previewJob) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+	job.err = makePreview(ctx, job.receiptID, job.version)
+	if job.err != nil && job.err != ErrNoPreview && job.err != ErrReceiptNotFound {
+		log.Printf("receipt preview for %s version %d: %v\n", job.receiptID, job.version, job.err)
+	}
+	key := previewKey(job.receiptID, job.version)
+	p.mu.Lock()
+	delete(p.jobs, key)
+	// a version's file never changes, so making its preview would only fail the same way again
+	if job.err == ErrPreviewTooLarge || errors.Is(job.err, ErrBadPreviewImage) {
+		p.failed[key] = job.err
+	}
+	p.mu.Unlock()
+	close(job.done)
+}
+
+// failure is why a version's preview couldn't be made, nil if it hasn't failed
+func (p *PreviewWorkers) failure(key string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.failed[key]
+}
+
+// forget drops a failure once its version is removed
+func (p *PreviewWorkers) forget(key string) {
+	p.mu.Lock()
+	delete(p.failed, key)
+	p.mu.Unlock()
+}
+
+// enqueue queues a preview to be made, or returns the job already queued for it
+// If the queue is full the job is dropped and finishes straight away, the preview is made when it's next asked for instead
+func (p *PreviewWorkers) enqueue(receiptID string, version int) *previewJob {
+	key := previewKey(receiptID, version)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if job, ok := p.jobs[key]; ok {
+		return job
+	}
+	job := &previewJob{receiptID: receiptID, version: version, done: make(chan struct{})}
+	select {
+	case p.queue <- job:
+		p.jobs[key] = job
+	default:
+		log.Printf("receipt preview queue is full, skipping %s version %d\n", receiptID, version)
+		close(job.done)
+	}
+	return job
+}
+
+// OpenPreview opens the preview of a receipt's current version, waiting for it to be made if it hasn't been
+// ok is false if it still isn't ready after the pool's Wait
+func OpenPreview(ctx context.Context, id string) (receipt *Receipt, preview io.ReadCloser, ok bool, err error) {
+	receipt, err = GetReceipt(id)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	if previewType(receipt.ContentType) == "" {
+		return nil, nil, false, ErrNoPreview
+	}
+	key := previewKey(receipt.ID, receipt.Version)
+	preview, _, err = Store.Get(ctx, key)
+	if err == nil {
+		return receipt, preview, true, nil
+	}
+	if err != blob.ErrNotFound {
+		return nil, nil, false, err
+	}
+	if err = Previews.failure(key); err != nil {
+		return nil, nil, false, err
+	}
+	job := Previews.enqueue(receipt.ID, receipt.Version)
+	timer := time.NewTimer(Previews.Wait)
+	defer timer.Stop()
+	select {
+	case <-job.done:
+	case <-timer.C:
+		return receipt, nil, false, nil
+	case <-ctx.Done():
+		return nil, nil, false, ctx.Err()
+	}
+	if job.err != nil {
+		return nil, nil, false, job.err
+	}
+	preview, _, err = Store.Get(ctx, key)
+	if err == blob.ErrNotFound {
+		// the job was dropped from a full queue
+		return receipt, nil, false, nil
+	}
+	if err != nil {
+		return nil, nil, false, err
+	}
+	return receipt, preview, true, nil
+}
+
+// removePreview deletes a version's preview, one that was never made is fine
+func removePreview(ctx context.Context, receiptID string, version int) error {
+	key := previewKey(receiptID, version)
+	Previews.forget(key)
+	return deleteBlob(ctx, key)
+}
+
+// handlePreview handles GET /receipts/{id}/preview, a thumbnail for an image or the first lines of a text receipt
+// 202 with Retry-After means the preview is still being made
+func handlePreview(w http.ResponseWriter, r *http.Request, receiptID string) {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		receipt, preview, ok, err := OpenPreview(r.Context(), receiptID)
+		if err == ErrNoPreview {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err == ErrPreviewTooLarge || errors.Is(err, ErrBadPreviewImage) {
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		if err != nil {
+			receiptError(w, err)
+			return
+		}
+		if !ok {
+			w.Header().Set("Retry-After", "2")
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+		// previews are small, reading them in means any store's can be served with ServeContent
+		data, err := ioutil.ReadAll(preview)
+		preview.Close()
+		if err != nil {
+			receiptError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", previewType(receipt.ContentType))
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.Header().Set("Cache-Control", "private, no-cache")
+		if receipt.Checksum != "" {
+			w.Header().Set("ETag", fmt.Sprintf(`"%s-preview"`, receipt.Checksum))
+		}
+		http.ServeContent(w, r, "", receipt.UploadDate, bytes.NewReader(data))
+
+	case http.MethodOptions:
+		return
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
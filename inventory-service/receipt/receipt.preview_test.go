@@ -0,0 +1,57 @@
+package receipt
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jordbick/Golang/inventory-service/blob"
+	"github.com/jordbick/Golang/inventory-service/database"
+	"github.com/jordbick/Golang/inventory-service/database/dbtest"
+)
+
+func TestOpenPreviewFailureIsKept(t *testing.T) {
+	database.DbConn = dbtest.Open(func(query string, args []driver.Value) (dbtest.Result, error) {
+		switch {
+		case strings.Contains(query, "FROM receipts"):
+			return dbtest.Result{Rows: [][]driver.Value{{testReceiptID, "receipt.png", "image/png", int64(9), "abc", "clerk", time.Now(), int64(1)}}}, nil
+		case strings.Contains(query, "FROM receipt_versions"):
+			return dbtest.Result{Rows: [][]driver.Value{{int64(1), "receipt.png", "image/png", int64(9), "abc", "clerk", time.Now(), nil, nil, "stored"}}}, nil
+		case strings.Contains(query, "receiptId IN"), strings.Contains(query, "receiptName IN"):
+			return dbtest.Result{}, nil
+		}
+		return dbtest.Result{}, errors.New("unexpected query " + query)
+	})
+	store := &countingStore{Memory: blob.NewMemory()}
+	if _, err := store.Put(context.Background(), "stored", strings.NewReader("not a png"), "image/png"); err != nil {
+		t.Fatal(err)
+	}
+	defer func(store blob.Store, previews *PreviewWorkers) { Store, Previews = store, previews }(Store, Previews)
+	Store = store
+	Previews = NewPreviewWorkers()
+	done := make(chan struct{})
+	defer close(done)
+	Previews.Start(done)
+
+	for i := 0; i < 3; i++ {
+		_, _, _, err := OpenPreview(context.Background(), testReceiptID)
+		if !errors.Is(err, ErrBadPreviewImage) {
+			t.Fatalf("OpenPreview %d = %v, want ErrBadPreviewImage", i, err)
+		}
+	}
+	// the receipt is only read the first time, each request just looks for the preview in the store
+	if store.gets != 1+3 {
+		t.Errorf("store read %d times, want the receipt once and the preview 3 times", store.gets)
+	}
+
+	// removing the version forgets why it failed
+	if err := removePreview(context.Background(), testReceiptID, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := Previews.failure(previewKey(testReceiptID, 1)); err != nil {
+		t.Errorf("failure after removing the preview = %v", err)
+	}
+}
@@ -0,0 +1,120 @@
+package receipt
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/jordbick/Golang/inventory-service/blob"
+)
+
+// RetentionPolicy decides how long receipt files are kept once they're no longer current
+// Old versions go once there are more than KeepVersions of them, or once they were replaced more than VersionMaxAge ago,
+// a zero turns either limit off. Deleted receipts are purged DeletedMaxAge after they were deleted, a zero keeps them forever
+type RetentionPolicy struct {
+	KeepVersions  int
+	VersionMaxAge time.Duration
+	DeletedMaxAge time.Duration
+}
+
+// Retention applies the policy every Interval
+type Retention struct {
+	Policy   RetentionPolicy
+	Interval time.Duration
+}
+
+// NewRetention keeps the last 10 old versions of a receipt for up to a year, and deleted receipts for 30 days, checking hourly
+func NewRetention() *Retention {
+	return &Retention{
+		Policy:   RetentionPolicy{KeepVersions: 10, VersionMaxAge: 365 * 24 * time.Hour, DeletedMaxAge: 30 * 24 * time.Hour},
+		Interval: time.Hour,
+	}
+}
+
+// Start applies the policy at startup and every Interval after, stopping when done is closed
+func (rt *Retention) Start(done <-chan struct{}) {
+	go func() {
+		ticker := time.NewTicker(rt.Interval)
+		defer ticker.Stop()
+		for {
+			if err := rt.apply(time.Now()); err != nil {
+				log.Println(err)
+			}
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// apply expires old versions, purges deleted receipts and removes upload sessions that have run out
+// files are removed before the database is updated, so anything that fails part way is picked up again next time
+func (rt *Retention) apply(now time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+	expired, err := getExpiredVersions(rt.Policy, now)
+	if err != nil {
+		return err
+	}
+	for _, v := range expired {
+		if err = deleteBlob(ctx, v.key); err != nil {
+			return err
+		}
+		if err = removePreview(ctx, v.receiptID, v.version); err != nil {
+			return err
+		}
+		if err = markExpired(v, "expired by the retention policy"); err != nil {
+			return err
+		}
+	}
+
+	ids := []string{}
+	if rt.Policy.DeletedMaxAge > 0 {
+		ids, err = getPurgeable(now.Add(-rt.Policy.DeletedMaxAge))
+		if err != nil {
+			return err
+		}
+	}
+	for _, id := range ids {
+		versions, err := getStoredVersions(id)
+		if err != nil {
+			return err
+		}
+		for _, v := range versions {
+			if err = deleteBlob(ctx, v.key); err != nil {
+				return err
+			}
+			if err = removePreview(ctx, v.receiptID, v.version); err != nil {
+				return err
+			}
+		}
+		if err = purgeReceipt(id); err != nil {
+			return err
+		}
+	}
+
+	uploads, err := getExpiredUploads(now)
+	if err != nil {
+		return err
+	}
+	for _, id := range uploads {
+		if err = removeUpload(ctx, id, true); err != nil {
+			return err
+		}
+	}
+	if len(expired) > 0 || len(ids) > 0 || len(uploads) > 0 {
+		log.Printf("receipt retention expired %d old versions, purged %d deleted receipts and removed %d expired uploads\n", len(expired), len(ids), len(uploads))
+	}
+	return nil
+}
+
+// deleteBlob removes a file, one that's already gone is fine
+func deleteBlob(ctx context.Context, key string) error {
+	err := Store.Delete(ctx, key)
+	if err == blob.ErrNotFound {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,274 @@
+package receipt
+
+import (
+	"context"
+	"database/sql/driver"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jordbick/Golang/inventory-service/blob"
+	"github.com/jordbick/Golang/inventory-service/database"
+	"github.com/jordbick/Golang/inventory-service/database/dbtest"
+)
+
+// versionRow is a receipt version as it's stored in receipt_versions
+type versionRow struct {
+	key, name               string
+	uploadedAt              time.Time
+	supersededAt, expiredAt interface{}
+	size                    int64
+}
+
+// versionsDB keeps receipts and their versions, enough for replacing, deleting and purging them
+type versionsDB struct {
+	current   map[string]int64
+	deletedAt map[string]time.Time
+	versions  map[string][]*versionRow
+	queries   []string
+}
+
+func newVersionsDB() *versionsDB {
+	return &versionsDB{current: make(map[string]int64), deletedAt: make(map[string]time.Time), versions: make(map[string][]*versionRow)}
+}
+
+// add stores a receipt with a single version
+func (d *versionsDB) add(t *testing.T, id, content string) {
+	if _, err := Store.Put(context.Background(), id, strings.NewReader(content), "application/pdf"); err != nil {
+		t.Fatal(err)
+	}
+	d.current[id] = 1
+	d.versions[id] = []*versionRow{{key: id, name: id + ".pdf", uploadedAt: time.Now().UTC(), size: int64(len(content))}}
+}
+
+func (d *versionsDB) version(id string, version interface{}) *versionRow {
+	v := int(version.(int64))
+	if v < 1 || v > len(d.versions[id]) {
+		return nil
+	}
+	return d.versions[id][v-1]
+}
+
+func (d *versionsDB) handle(query string, args []driver.Value) (dbtest.Result, error) {
+	d.queries = append(d.queries, query)
+	switch {
+	case strings.HasPrefix(query, "SELECT version FROM receipts"):
+		id := args[0].(string)
+		if _, deleted := d.deletedAt[id]; deleted || d.current[id] == 0 {
+			return dbtest.Result{}, nil
+		}
+		return dbtest.Result{Rows: [][]driver.Value{{d.current[id]}}}, nil
+	case strings.HasPrefix(query, "SELECT receiptId,\n\tname"):
+		id := args[0].(string)
+		if _, deleted := d.deletedAt[id]; deleted || d.current[id] == 0 {
+			return dbtest.Result{}, nil
+		}
+		v := d.version(id, d.current[id])
+		return dbtest.Result{Rows: [][]driver.Value{{id, v.name, "application/pdf", v.size, "", "", v.uploadedAt, d.current[id]}}}, nil
+	case strings.HasPrefix(query, "SELECT version,\n\tname"):
+		v := d.version(args[0].(string), args[1])
+		if v == nil {
+			return dbtest.Result{}, nil
+		}
+		return dbtest.Result{Rows: [][]driver.Value{{args[1], v.name, "application/pdf", v.size, "", "", v.uploadedAt, v.supersededAt, v.expiredAt, v.key}}}, nil
+	case strings.HasPrefix(query, "UPDATE receipt_versions SET supersededAt"):
+		d.version(args[1].(string), args[2]).supersededAt = args[0]
+	case strings.HasPrefix(query, "UPDATE receipt_versions SET expiredAt"):
+		d.version(args[1].(string), args[2]).expiredAt = args[0]
+	case strings.HasPrefix(query, "INSERT INTO receipt_versions"):
+		id := args[0].(string)
+		d.versions[id] = append(d.versions[id], &versionRow{key: args[2].(string), name: args[3].(string), size: args[5].(int64), uploadedAt: args[8].(time.Time)})
+	case strings.HasPrefix(query, "UPDATE receipts SET\n\tname"):
+		d.current[args[7].(string)] = args[6].(int64)
+	case strings.HasPrefix(query, "UPDATE receipts SET deletedAt"):
+		id := args[1].(string)
+		if _, deleted := d.deletedAt[id]; deleted || d.current[id] == 0 {
+			return dbtest.Result{}, nil
+		}
+		d.deletedAt[id] = args[0].(time.Time)
+	case strings.HasPrefix(query, "SELECT receiptId FROM receipts WHERE deletedAt"):
+		rows := [][]driver.Value{}
+		for id, deletedAt := range d.deletedAt {
+			if !deletedAt.After(args[0].(time.Time)) {
+				rows = append(rows, []driver.Value{id})
+			}
+		}
+		return dbtest.Result{Rows: rows}, nil
+	case strings.HasPrefix(query, "SELECT receiptId, version, blobKey FROM receipt_versions"):
+		id := args[0].(string)
+		rows := [][]driver.Value{}
+		for i, v := range d.versions[id] {
+			if v.expiredAt == nil {
+				rows = append(rows, []driver.Value{id, int64(i + 1), v.key})
+			}
+		}
+		return dbtest.Result{Rows: rows}, nil
+	case strings.HasPrefix(query, "DELETE FROM receipt_versions"):
+		delete(d.versions, args[0].(string))
+	case strings.HasPrefix(query, "DELETE FROM receipts"):
+		id := args[0].(string)
+		delete(d.current, id)
+		delete(d.deletedAt, id)
+	}
+	// everything else, such as the audit log and the receipt's links, just succeeds
+	return dbtest.Result{RowsAffected: 1}, nil
+}
+
+func setupVersions(t *testing.T) *versionsDB {
+	db := newVersionsDB()
+	database.DbConn = dbtest.Open(db.handle)
+	store := Store
+	t.Cleanup(func() { Store = store })
+	Store = blob.NewMemory()
+	return db
+}
+
+func readVersion(t *testing.T, id string, version int) (string, error) {
+	_, file, err := OpenReceiptVersion(context.Background(), id, version)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	content, err := ioutil.ReadAll(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(content), nil
+}
+
+func TestExpiredVersionsQuery(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		policy     RetentionPolicy
+		conditions string
+		args       []driver.Value
+	}{
+		{RetentionPolicy{KeepVersions: 10, VersionMaxAge: 24 * time.Hour}, "(supersededAt < ? OR age > ?)", []driver.Value{now.Add(-24 * time.Hour), int64(10)}},
+		{RetentionPolicy{KeepVersions: 3}, "(age > ?)", []driver.Value{int64(3)}},
+		{RetentionPolicy{VersionMaxAge: time.Hour}, "(supersededAt < ?)", []driver.Value{now.Add(-time.Hour)}},
+		// zero turns both limits off, so nothing is even looked for
+		{RetentionPolicy{DeletedMaxAge: time.Hour}, "", nil},
+	}
+	for _, test := range tests {
+		var queries []string
+		var args []driver.Value
+		database.DbConn = dbtest.Open(func(query string, queryArgs []driver.Value) (dbtest.Result, error) {
+			queries, args = append(queries, query), queryArgs
+			return dbtest.Result{}, nil
+		})
+		expired, err := getExpiredVersions(test.policy, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(expired) != 0 {
+			t.Errorf("%+v: expired %v", test.policy, expired)
+		}
+		if test.conditions == "" {
+			if len(queries) != 0 {
+				t.Errorf("%+v: queried %q", test.policy, queries)
+			}
+			continue
+		}
+		// old versions are numbered newest first within each receipt, leaving out current versions and deleted receipts
+		if len(queries) != 1 || !strings.Contains(queries[0], "ROW_NUMBER() OVER (PARTITION BY v.receiptId ORDER BY v.version DESC) AS age") ||
+			!strings.Contains(queries[0], "WHERE v.supersededAt IS NOT NULL AND r.deletedAt IS NULL") ||
+			!strings.Contains(queries[0], "WHERE expiredAt IS NULL AND "+test.conditions) {
+			t.Errorf("%+v: queried %q", test.policy, queries)
+		}
+		if !reflect.DeepEqual(args, test.args) {
+			t.Errorf("%+v: args %v, want %v", test.policy, args, test.args)
+		}
+	}
+}
+
+func TestReplaceKeepsOldVersion(t *testing.T) {
+	db := setupVersions(t)
+	db.add(t, "r1", "first")
+	key := versionKey("r1", "b2")
+	if _, err := Store.Put(context.Background(), key, strings.NewReader("second"), "application/pdf"); err != nil {
+		t.Fatal(err)
+	}
+	replaced, err := replaceReceipt(&Receipt{ID: "r1", ReceiptName: "r1-new.pdf", ContentType: "application/pdf", Size: 6, UploadDate: time.Now()}, key, "bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if replaced.Version != 2 || replaced.ReceiptName != "r1-new.pdf" {
+		t.Errorf("replaced receipt %+v", replaced)
+	}
+
+	tests := []struct {
+		version int
+		content string
+		err     error
+	}{
+		{0, "second", nil},
+		{2, "second", nil},
+		{1, "first", nil},
+		{3, "", ErrVersionNotFound},
+	}
+	for _, test := range tests {
+		if content, err := readVersion(t, "r1", test.version); content != test.content || err != test.err {
+			t.Errorf("version %d: %q %v, want %q %v", test.version, content, err, test.content, test.err)
+		}
+	}
+	if db.version("r1", int64(1)).supersededAt == nil {
+		t.Error("version 1 wasn't marked superseded")
+	}
+
+	// once retention has expired the old version it can't be downloaded, but the current one still can
+	if err := markExpired(storedVersion{receiptID: "r1", version: 1, key: "r1"}, "test"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readVersion(t, "r1", 1); err != ErrVersionExpired {
+		t.Errorf("expired version 1: %v, want ErrVersionExpired", err)
+	}
+	if content, err := readVersion(t, "r1", 0); content != "second" || err != nil {
+		t.Errorf("current version after expiry: %q %v", content, err)
+	}
+}
+
+func TestRetentionPurgesDeletedReceipts(t *testing.T) {
+	tests := []struct {
+		maxAge time.Duration
+		after  time.Duration
+		purged bool
+	}{
+		{30 * 24 * time.Hour, time.Hour, false},
+		{30 * 24 * time.Hour, 31 * 24 * time.Hour, true},
+		// zero keeps deleted receipts rather than purging them straight away
+		{0, time.Hour, false},
+		{0, 10 * 365 * 24 * time.Hour, false},
+	}
+	for _, test := range tests {
+		db := setupVersions(t)
+		db.add(t, "r1", "first")
+		db.add(t, "r2", "other")
+		if err := DeleteReceipt("r1", "bob"); err != nil {
+			t.Fatal(err)
+		}
+		// deleted receipts are gone straight away, but their files stay until they're purged
+		if _, err := GetReceipt("r1"); err != ErrReceiptNotFound {
+			t.Errorf("deleted receipt: %v, want ErrReceiptNotFound", err)
+		}
+		if err := DeleteReceipt("r1", "bob"); err != ErrReceiptNotFound {
+			t.Errorf("deleting twice: %v, want ErrReceiptNotFound", err)
+		}
+
+		rt := &Retention{Policy: RetentionPolicy{DeletedMaxAge: test.maxAge}}
+		if err := rt.apply(time.Now().Add(test.after)); err != nil {
+			t.Fatal(err)
+		}
+		_, _, err := Store.Get(context.Background(), "r1")
+		if test.purged != (err == blob.ErrNotFound) {
+			t.Errorf("kept for %v, %v later: file %v", test.maxAge, test.after, err)
+		}
+		if _, ok := db.versions["r1"]; ok == test.purged {
+			t.Errorf("kept for %v, %v later: versions %v", test.maxAge, test.after, db.versions["r1"])
+		}
+		if _, err := readVersion(t, "r2", 0); err != nil {
+			t.Errorf("receipt that wasn't deleted: %v", err)
+		}
+	}
+}
@@ -1,66 +1,218 @@
 package receipt
 
 import (
-	"encoding/json"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"log"
 	"net/http"
-	"os"
-	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/jordbick/Golang/inventory-service/cors"
+	"github.com/jordbick/Golang/inventory-service/response"
+	"github.com/jordbick/Golang/inventory-service/webhook"
 )
 
 // create path variable
 const receiptPath = "receipts"
 
-// Handler to retrieve a list of files
+// maxFormOverhead allows for the rest of the multipart form on top of the receipt itself
+const maxFormOverhead = 1 << 20
+
+// readReceiptFilter reads the receipt list's query parameters: name, contentType, uploadedBy, tag, productId,
+// purchaseOrderId, from and to (2006-01-02 or RFC 3339), sort (e.g. "size desc"), limit and offset
+func readReceiptFilter(r *http.Request) (ReceiptFilter, error) {
+	query := r.URL.Query()
+	filter := ReceiptFilter{
+		Name:        query.Get("name"),
+		ContentType: query.Get("contentType"),
+		UploadedBy:  query.Get("uploadedBy"),
+		Tag:         strings.ToLower(query.Get("tag")),
+		Sort:        query.Get("sort"),
+		Limit:       DefaultReceiptLimit,
+	}
+	for key, dest := range map[string]*int{"productId": &filter.ProductID, "purchaseOrderId": &filter.PurchaseOrderID, "limit": &filter.Limit, "offset": &filter.Offset} {
+		if v := query.Get(key); v != "" {
+			i, err := strconv.Atoi(v)
+			if err != nil || i < 0 {
+				return filter, fmt.Errorf("%s must be a whole number", key)
+			}
+			*dest = i
+		}
+	}
+	if filter.Limit < 1 || filter.Limit > MaxReceiptLimit {
+		return filter, fmt.Errorf("limit must be from 1 to %d", MaxReceiptLimit)
+	}
+	for key, dest := range map[string]*time.Time{"from": &filter.From, "to": &filter.To} {
+		if v := query.Get(key); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				t, err = time.Parse("2006-01-02", v)
+			}
+			if err != nil {
+				return filter, fmt.Errorf("%s must be a date (2006-01-02) or an RFC 3339 time", key)
+			}
+			*dest = t
+		}
+	}
+	if _, err := receiptOrder(filter.Sort); err != nil {
+		return filter, err
+	}
+	return filter, nil
+}
+
+// actor is who's making the request, there's no sign in so it's whoever the client says in the X-User header
+func actor(r *http.Request) string {
+	return strings.TrimSpace(r.Header.Get("X-User"))
+}
+
+// upload is a receipt file that's been stored but not recorded yet
+type upload struct {
+	receipt *Receipt
+	key     string
+	details Receipt
+}
+
+// discard removes the file of an upload that's been rejected
+func (u *upload) discard() {
+	if u.receipt == nil {
+		return
+	}
+	if err := removeReceiptFile(u.key); err != nil {
+		log.Println(err)
+	}
+}
+
+// readUpload reads the multipart upload form, storing the receipt part as it goes
+// receiptID is empty for a new receipt, or the receipt a new version is for
+// The other fields, uploadedBy, tags (comma separated or repeated), productIds and purchaseOrderIds, can come before or after it
+// uploadedBy defaults to the X-User header
+func readUpload(r *http.Request, receiptID string) (*upload, error) {
+	u := &upload{details: Receipt{UploadedBy: actor(r)}}
+	details := &u.details
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return u, errBadUpload
+	}
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return u, partError(err)
+		}
+		if part.FormName() == "receipt" && part.FileName() != "" {
+			if u.receipt != nil {
+				part.Close()
+				return u, badUploadError("only one receipt can be uploaded at a time")
+			}
+			u.receipt, u.key, err = storeReceipt(r.Context(), receiptID, part, part.FileName())
+			part.Close()
+			if err != nil {
+				return u, err
+			}
+			continue
+		}
+		// the other fields are small, anything long is a mistake
+		value, err := ioutil.ReadAll(io.LimitReader(part, 4096))
+		part.Close()
+		if err != nil {
+			return u, partError(err)
+		}
+		values := strings.Split(string(value), ",")
+		switch part.FormName() {
+		case "uploadedBy":
+			details.UploadedBy = string(value)
+		case "tags":
+			details.Tags = append(details.Tags, values...)
+		case "productIds", "purchaseOrderIds":
+			for _, v := range values {
+				if v = strings.TrimSpace(v); v == "" {
+					continue
+				}
+				id, err := strconv.Atoi(v)
+				if err != nil {
+					return u, badUploadError(part.FormName() + " must be a list of IDs")
+				}
+				if part.FormName() == "productIds" {
+					details.ProductIDs = append(details.ProductIDs, id)
+				} else {
+					details.PurchaseOrderIDs = append(details.PurchaseOrderIDs, id)
+				}
+			}
+		}
+	}
+	if u.receipt == nil {
+		return u, errBadUpload
+	}
+	if err = validateDetails(details); err != nil {
+		return u, badUploadError(err.Error())
+	}
+	u.receipt.UploadedBy = details.UploadedBy
+	return u, nil
+}
+
+// badUploadError is a problem with the upload form rather than the service
+type badUploadError string
+
+func (e badUploadError) Error() string {
+	return string(e)
+}
+
+const errBadUpload = badUploadError("the upload must be a multipart form with the file in a receipt field")
+
+// partError reports a form that couldn't be read as a bad upload, unless it was cut off for being too large
+func partError(err error) error {
+	if strings.Contains(err.Error(), "request body too large") {
+		return ErrReceiptTooLarge
+	}
+	return badUploadError(err.Error())
+}
+
+// Handler to retrieve a list of receipts, or upload a new one
 func handleReceipts(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	// If get method called, use the GetReceipts function we made, Marshal the data and return to the client
+	// the total number of matching receipts, ignoring limit and offset, goes in the X-Total-Count header
 	case http.MethodGet:
-		receiptList, err := GetReceipts()
+		filter, err := readReceiptFilter(r)
 		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
+			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
-		j, err := json.Marshal(receiptList)
-		if err != nil {
-			log.Fatal(err)
-		}
-		_, err = w.Write(j)
+		receiptList, total, err := GetReceipts(filter)
 		if err != nil {
-			log.Fatal(err)
+			log.Println(err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
 		}
+		w.Header().Set("X-Total-Count", strconv.Itoa(total))
+		response.WriteJSON(w, receiptList)
 
 	// If Post is called, then upload our file to the service
+	// the form is read as a stream, so the receipt goes straight to disk and a file that's too big is stopped part way
 	case http.MethodPost:
-		r.ParseMultipartForm(5 << 20) // 5Mb, limit size of in-memory data
-		// Grab receipt out of our HTTP multipart form by using FormFile method
-		file, handler, err := r.FormFile("receipt")
+		r.Body = http.MaxBytesReader(w, r.Body, MaxReceiptSize+maxFormOverhead)
+		upload, err := readUpload(r, "")
 		if err != nil {
-			log.Print(err)
-			w.WriteHeader(http.StatusBadRequest)
+			upload.discard()
+			uploadError(w, err)
 			return
 		}
-		defer file.Close()
-
-		// create a file on disk using the os.OpenFile func
-		// Pass in the file path, built use the .Join function, using the ReceiptDirectory constant and the file name (which we can get from the filehandler)
-		// Flags to specify write only and create with bitmask of 0666
-		f, err := os.OpenFile(filepath.Join(ReceiptDirectory, handler.Filename), os.O_WRONLY|os.O_CREATE, 0666)
+		receipt, err := recordReceipt(upload.receipt, upload.details)
 		if err != nil {
-			w.WriteHeader(http.StatusBadRequest)
+			uploadError(w, err)
 			return
 		}
-		defer f.Close()
-
-		// io.Copy func to copy the byte data from the file that was passed in through the HTTP POST into the new file we created on our system
-		io.Copy(f, file)
-		w.WriteHeader(http.StatusCreated)
+		// let any webhook subscribers know a new receipt has arrived
+		err = webhook.Publish(webhook.EventReceiptUploaded, receipt)
+		if err != nil {
+			log.Println(err)
+		}
+		response.WriteJSONStatus(w, http.StatusCreated, receipt)
 
 		// Implement CORS headers
 	case http.MethodOptions:
@@ -72,51 +224,289 @@ func handleReceipts(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-// Parses URL to get the filename
-// Pass in the receipt's URL and the file name as the last part of the URL path
-func handleDownload(w http.ResponseWriter, r *http.Request) {
-	urlPathSegments := strings.Split(r.URL.Path, fmt.Sprintf("%s/", receiptPath))
-	if len(urlPathSegments[1:]) > 1 {
+// uploadError sends the status for a rejected upload
+func uploadError(w http.ResponseWriter, err error) {
+	if _, ok := err.(badUploadError); ok {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	switch err {
+	case ErrReceiptTooLarge:
+		http.Error(w, fmt.Sprintf("%s, the limit is %d bytes", err, MaxReceiptSize), http.StatusRequestEntityTooLarge)
+	case ErrContentTypeNotAllowed:
+		http.Error(w, fmt.Sprintf("%s, allowed types are %s", err, strings.Join(AllowedContentTypes, ", ")), http.StatusUnsupportedMediaType)
+	case ErrProductNotFound, ErrPurchaseOrderNotFound:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		// the body running past the MaxBytesReader limit shows up as a read error
+		log.Println(err)
+		if strings.Contains(err.Error(), "request body too large") {
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+// Parses URL to get the receipt ID, the last part of the URL path, or {id}/link, {id}/versions, {id}/audit, {id}/extraction, {id}/draft or {id}/preview
+// IDs are checked against idPattern before going anywhere near the store, so they can't name a file outside it
+//
+// GET downloads the receipt, ?version= picks an earlier version. A download link's expires and signature are checked when they're there
+// PUT uploads a new version, the same way as a new receipt is uploaded, but only the file changes
+// DELETE deletes the receipt
+func handleReceipt(w http.ResponseWriter, r *http.Request) {
+	urlPathSegments := strings.Split(strings.SplitN(r.URL.Path, fmt.Sprintf("%s/", receiptPath), 2)[1], "/")
+	if len(urlPathSegments) == 2 {
+		switch urlPathSegments[1] {
+		case "link":
+			handleLink(w, r, urlPathSegments[0])
+			return
+		case "versions", "audit":
+			handleHistory(w, r, urlPathSegments[0], urlPathSegments[1])
+			return
+		case "extraction", "draft":
+			handleExtraction(w, r, urlPathSegments[0], urlPathSegments[1])
+			return
+		case "preview":
+			handlePreview(w, r, urlPathSegments[0])
+			return
+		}
+	}
+	if len(urlPathSegments) > 1 {
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
-	fileName := urlPathSegments[1:][0]
-	file, err := os.Open(filepath.Join(ReceiptDirectory, fileName))
-	if err != nil {
+	receiptID := urlPathSegments[0]
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		handleDownload(w, r, receiptID)
+
+	case http.MethodPut:
+		if _, err := GetReceipt(receiptID); err != nil {
+			receiptError(w, err)
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, MaxReceiptSize+maxFormOverhead)
+		upload, err := readUpload(r, receiptID)
+		if err != nil {
+			upload.discard()
+			uploadError(w, err)
+			return
+		}
+		receipt, err := replaceReceipt(upload.receipt, upload.key, upload.details.UploadedBy)
+		if err == ErrReceiptNotFound {
+			// deleted while the new version was uploading
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			uploadError(w, err)
+			return
+		}
+		response.WriteJSON(w, receipt)
+
+	case http.MethodDelete:
+		if err := DeleteReceipt(receiptID, actor(r)); err != nil {
+			receiptError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	case http.MethodOptions:
+		return
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// receiptError sends the status for an error looking up a receipt
+func receiptError(w http.ResponseWriter, err error) {
+	switch err {
+	case ErrReceiptNotFound, ErrVersionNotFound:
 		w.WriteHeader(http.StatusNotFound)
+	case ErrVersionExpired:
+		http.Error(w, err.Error(), http.StatusGone)
+	default:
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+// handleDownload sends a receipt's file
+// Byte ranges are supported, so PDF viewers can fetch just the pages they need and broken downloads can be resumed
+// The ETag is the file's checksum and Last-Modified when it was uploaded, so If-None-Match and If-Modified-Since get a 304 when it hasn't changed
+// ?disposition=inline shows the file in the browser rather than downloading it, for the AllowedContentTypes only.
+// Anything else, such as an imported receipt recorded as text/html, is always downloaded, and inline files are sandboxed
+// so one that's got past the checks can't run scripts as the service
+func handleDownload(w http.ResponseWriter, r *http.Request, receiptID string) {
+	query := r.URL.Query()
+	if query.Get("signature") != "" {
+		if err := verifyLink(receiptID, query, time.Now()); err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+	}
+	version := 0
+	if v := query.Get("version"); v != "" {
+		var err error
+		version, err = strconv.Atoi(v)
+		if err != nil || version < 1 {
+			http.Error(w, "version must be a version number", http.StatusBadRequest)
+			return
+		}
+	}
+	disposition := query.Get("disposition")
+	switch disposition {
+	case "":
+		disposition = "attachment"
+	case "inline", "attachment":
+	default:
+		http.Error(w, "disposition must be inline or attachment", http.StatusBadRequest)
+		return
+	}
+	receipt, file, err := OpenReceiptVersion(r.Context(), receiptID, version)
+	if err != nil {
+		receiptError(w, err)
 		return
 	}
 	defer file.Close()
 
-	// Set header info on response - Response might be processed differently dependent on client that is calling the code
-	fHeader := make([]byte, 512)
-	file.Read(fHeader)
-	// http.DetectContentType function to determine what kind of file this is and use it to set the Content-Type header in our response
-	fContentType := http.DetectContentType(fHeader)
+	if disposition == "inline" {
+		if contentTypeAllowed(receipt.ContentType) {
+			w.Header().Set("Content-Security-Policy", "sandbox")
+		} else {
+			disposition = "attachment"
+		}
+	}
+	// set response header using the name the receipt was uploaded with, encoded as needed
+	w.Header().Set("Content-Disposition", contentDisposition(disposition, receipt.ReceiptName))
+	w.Header().Set("Content-Type", receipt.ContentType)
+	// the content type was checked on upload, don't let the browser second guess it
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	// a version never changes, but the current file can be replaced, so it has to be checked each time
+	if version > 0 {
+		w.Header().Set("Cache-Control", "private, max-age=31536000, immutable")
+	} else {
+		w.Header().Set("Cache-Control", "private, no-cache")
+	}
 
-	// check file size so that the client knows how much data it's going to be downloading in the response = file.Stat()
-	stat, err := file.Stat()
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+	// an older receipt recorded without a checksum has no ETag, and falls back on Last-Modified
+	if receipt.Checksum != "" {
+		w.Header().Set("ETag", `"`+receipt.Checksum+`"`)
+	}
+	// ServeContent handles the conditional and range headers, and sets Content-Length
+	http.ServeContent(w, r, "", receipt.UploadDate, file.(io.ReadSeeker))
+}
+
+// attachment is the Content-Disposition that downloads a file under the name
+func attachment(name string) string {
+	return contentDisposition("attachment", name)
+}
+
+// contentDisposition follows RFC 6266: the name goes in filename as plain ASCII for older clients,
+// and if it has any other characters it's percent encoded as UTF-8 in filename* as well, which newer clients use instead
+func contentDisposition(disposition, name string) string {
+	fallback := make([]byte, 0, len(name))
+	plain := true
+	for _, r := range name {
+		switch {
+		case r == '"' || r == '\\':
+			fallback = append(fallback, '_')
+		case r < 0x20 || r > 0x7e:
+			fallback = append(fallback, '_')
+			plain = false
+		default:
+			fallback = append(fallback, byte(r))
+		}
+	}
+	value := fmt.Sprintf(`%s; filename="%s"`, disposition, fallback)
+	if plain {
+		return value
+	}
+	var encoded strings.Builder
+	for _, b := range []byte(name) {
+		// attr-char from RFC 5987, everything else is percent encoded
+		if b < 0x80 && (b >= 'a' && b <= 'z' || b >= 'A' && b <= 'Z' || b >= '0' && b <= '9' || strings.IndexByte("!#$&+-.^_`|~", b) >= 0) {
+			encoded.WriteByte(b)
+		} else {
+			fmt.Fprintf(&encoded, "%%%02X", b)
+		}
+	}
+	return value + "; filename*=UTF-8''" + encoded.String()
+}
+
+// handleHistory handles GET /receipts/{id}/versions and /receipts/{id}/audit
+func handleHistory(w http.ResponseWriter, r *http.Request, receiptID, history string) {
+	switch r.Method {
+	case http.MethodGet:
+		var list interface{}
+		var err error
+		if history == "versions" {
+			list, err = GetVersions(receiptID)
+		} else {
+			list, err = GetAudit(receiptID)
+		}
+		if err != nil {
+			receiptError(w, err)
+			return
+		}
+		response.WriteJSON(w, list)
+
+	case http.MethodOptions:
 		return
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
 	}
-	fSize := strconv.FormatInt(stat.Size(), 10)
-	// set response header to
-	w.Header().Set("Content-Disposition", "attachment; filename="+fileName)
-	w.Header().Set("Content-Type", fContentType)
-	w.Header().Set("Content-Length", fSize)
+}
+
+// handleLink handles GET /receipts/{id}/link, a link to download the receipt that lasts ?expires= seconds (15 minutes by default)
+func handleLink(w http.ResponseWriter, r *http.Request, receiptID string) {
+	switch r.Method {
+	case http.MethodGet:
+		expires := DefaultLinkExpiry
+		if v := r.URL.Query().Get("expires"); v != "" {
+			seconds, err := strconv.Atoi(v)
+			if err != nil {
+				http.Error(w, "expires must be a number of seconds", http.StatusBadRequest)
+				return
+			}
+			expires = time.Duration(seconds) * time.Second
+		}
+		link, err := DownloadLink(receiptID, expires)
+		if err == ErrReceiptNotFound {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if err == errLinkExpiry {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			log.Println(err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		response.WriteJSON(w, link)
 
-	// Because we have already read the file we can use the file.Seek() function to set the seeker back to the start of the file
-	file.Seek(0, 0)
+	case http.MethodOptions:
+		return
 
-	// Copy byte data from the file to our ResponseWriter, which will return the file to the client
-	io.Copy(w, file)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
 }
 
 // SetupRoutes which will be called from the main
 func SetupRoutes(apiBasePath string) {
+	downloadPath = fmt.Sprintf("%s/%s/", apiBasePath, receiptPath)
 	receiptHandler := http.HandlerFunc(handleReceipts)
-	downloadHandler := http.HandlerFunc(handleDownload)
+	itemHandler := http.HandlerFunc(handleReceipt)
+	uploadsHandler := http.HandlerFunc(handleUploads)
+	uploadHandler := http.HandlerFunc(handleUpload)
 	http.Handle(fmt.Sprintf("%s/%s", apiBasePath, receiptPath), cors.Middleware(receiptHandler))
-	http.Handle(fmt.Sprintf("%s/%s/", apiBasePath, receiptPath), cors.Middleware(downloadHandler))
+	http.Handle(fmt.Sprintf("%s/%s/", apiBasePath, receiptPath), cors.Middleware(itemHandler))
+	http.Handle(fmt.Sprintf("%s/%s/uploads", apiBasePath, receiptPath), cors.Middleware(uploadsHandler))
+	http.Handle(fmt.Sprintf("%s/%s/uploads/", apiBasePath, receiptPath), cors.Middleware(uploadHandler))
 }
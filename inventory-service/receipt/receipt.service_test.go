@@ -0,0 +1,76 @@
+package receipt
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jordbick/Golang/inventory-service/blob"
+	"github.com/jordbick/Golang/inventory-service/database"
+	"github.com/jordbick/Golang/inventory-service/database/dbtest"
+)
+
+func TestDownloadDisposition(t *testing.T) {
+	contentType := ""
+	database.DbConn = dbtest.Open(func(query string, args []driver.Value) (dbtest.Result, error) {
+		switch {
+		case strings.Contains(query, "FROM receipts"):
+			return dbtest.Result{Rows: [][]driver.Value{{testReceiptID, "receipt", contentType, int64(5), "abc", "clerk", time.Now(), int64(1)}}}, nil
+		case strings.Contains(query, "FROM receipt_versions"):
+			return dbtest.Result{Rows: [][]driver.Value{{int64(1), "receipt", contentType, int64(5), "abc", "clerk", time.Now(), nil, nil, "stored"}}}, nil
+		case strings.Contains(query, "receiptId IN"), strings.Contains(query, "receiptName IN"):
+			return dbtest.Result{}, nil
+		}
+		return dbtest.Result{}, errors.New("unexpected query " + query)
+	})
+	defer func(store blob.Store) { Store = store }(Store)
+	Store = blob.NewMemory()
+	if _, err := Store.Put(context.Background(), "stored", strings.NewReader("hello"), ""); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		contentType string
+		query       string
+		disposition string
+		sandboxed   bool
+	}{
+		{"application/pdf", "", "attachment", false},
+		{"application/pdf", "?disposition=inline", "inline", true},
+		{"image/png", "?disposition=inline", "inline", true},
+		{"text/plain; charset=utf-8", "?disposition=inline", "inline", true},
+		// an imported receipt can have any type, those are never shown in the browser
+		{"text/html; charset=utf-8", "?disposition=inline", "attachment", false},
+		{"image/svg+xml", "?disposition=inline", "attachment", false},
+		{"text/html", "?disposition=attachment", "attachment", false},
+	}
+	for _, test := range tests {
+		contentType = test.contentType
+		w := httptest.NewRecorder()
+		handleDownload(w, httptest.NewRequest(http.MethodGet, "/api/receipts/"+testReceiptID+"/file"+test.query, nil), testReceiptID)
+		if w.Code != http.StatusOK || w.Body.String() != "hello" {
+			t.Errorf("%s %s: %d %q", test.contentType, test.query, w.Code, w.Body.String())
+			continue
+		}
+		if got := w.Header().Get("Content-Disposition"); !strings.HasPrefix(got, test.disposition+";") {
+			t.Errorf("%s %s: Content-Disposition %q, want %s", test.contentType, test.query, got, test.disposition)
+		}
+		if got := w.Header().Get("Content-Security-Policy"); (got == "sandbox") != test.sandboxed {
+			t.Errorf("%s %s: Content-Security-Policy %q", test.contentType, test.query, got)
+		}
+		if w.Header().Get("X-Content-Type-Options") != "nosniff" {
+			t.Errorf("%s %s: no nosniff", test.contentType, test.query)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	handleDownload(w, httptest.NewRequest(http.MethodGet, "/api/receipts/"+testReceiptID+"/file?disposition=open", nil), testReceiptID)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("disposition=open: %d, want 400", w.Code)
+	}
+}
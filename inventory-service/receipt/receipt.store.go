@@ -0,0 +1,444 @@
+package receipt
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/jordbick/Golang/inventory-service/blob"
+)
+
+// Receipts are kept in Store under a random ID, never under the name they were uploaded with,
+// so an upload can't choose where it's written or replace another receipt
+// The receipt's details, its original name, content type, checksum and so on, are kept in the receipts table
+// Receipts from before IDs were generated keep their file name as their ID
+
+// metadataSuffix is the metadata file receipts used to have next to them, see ImportReceipts
+const metadataSuffix = ".json"
+
+// idPattern matches generated IDs and the names of older receipts, it can't contain a path separator or start with a dot
+var idPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,127}$`)
+
+func validID(id string) bool {
+	return idPattern.MatchString(id) && !strings.Contains(id, "..")
+}
+
+// newReceiptID returns 16 random bytes as hex
+func newReceiptID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// SanitizeFilename makes an uploaded file name safe to keep and to send back in a Content-Disposition header
+// Any directories are dropped, along with control characters and characters that aren't allowed in Windows file names
+func SanitizeFilename(name string) string {
+	// browsers on Windows have been known to send the full path
+	if i := strings.LastIndexAny(name, `/\`); i >= 0 {
+		name = name[i+1:]
+	}
+	name = strings.Map(func(r rune) rune {
+		if r == utf8.RuneError || unicode.IsControl(r) || strings.ContainsRune(`<>:"|?*`, r) {
+			return -1
+		}
+		if unicode.IsSpace(r) {
+			return ' '
+		}
+		return r
+	}, name)
+	name = strings.Join(strings.Fields(name), " ")
+	name = strings.Trim(name, " .")
+	// keep names to 255 bytes without cutting a character in half, keeping the extension where there is one
+	if len(name) > 255 {
+		ext := filepath.Ext(name)
+		if len(ext) > 16 {
+			ext = ""
+		}
+		base := name[:255-len(ext)]
+		for !utf8.ValidString(base) {
+			base = base[:len(base)-1]
+		}
+		name = strings.TrimRight(base, " .") + ext
+	}
+	if name == "" {
+		return "receipt"
+	}
+	return name
+}
+
+// contentTypeAllowed checks the media type, ignoring any parameters such as charset, against AllowedContentTypes
+func contentTypeAllowed(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	for _, allowed := range AllowedContentTypes {
+		if strings.EqualFold(mediaType, allowed) {
+			return true
+		}
+	}
+	return false
+}
+
+// sizeLimiter fails the read with err once more than limit bytes have come through, so the store never keeps a receipt that's too big
+type sizeLimiter struct {
+	r     io.Reader
+	limit int64
+	n     int64
+	err   error
+}
+
+func (l *sizeLimiter) Read(p []byte) (int, error) {
+	n, err := l.r.Read(p)
+	l.n += int64(n)
+	if l.n > l.limit {
+		return n, l.err
+	}
+	return n, err
+}
+
+// storeReceipt puts an uploaded receipt in the store under a new ID, working out its size and checksum on the way
+// The content type is sniffed from the start of the file and has to be one of AllowedContentTypes,
+// and the file can't be bigger than MaxReceiptSize. Nothing is left behind if the upload is rejected
+// receiptID is empty for a new receipt, or the receipt a new version is for
+func storeReceipt(ctx context.Context, receiptID string, file io.Reader, filename string) (*Receipt, string, error) {
+	// sniff the content type before storing anything
+	head := make([]byte, 512)
+	n, err := io.ReadFull(file, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return nil, "", err
+	}
+	head = head[:n]
+	receipt := Receipt{ReceiptName: SanitizeFilename(filename), ContentType: http.DetectContentType(head), Version: 1}
+	if !contentTypeAllowed(receipt.ContentType) {
+		return nil, "", ErrContentTypeNotAllowed
+	}
+	receipt.ID, err = newReceiptID()
+	if err != nil {
+		return nil, "", err
+	}
+	// a new version of an existing receipt gets its own key, so the ID is the new blob's name rather than the receipt's
+	key := receipt.ID
+	if receiptID != "" {
+		key = versionKey(receiptID, receipt.ID)
+		receipt.ID = receiptID
+	}
+
+	// the store refuses to replace a blob, so even the tiny chance of an ID clash can't lose a receipt
+	hash := sha256.New()
+	limited := &sizeLimiter{r: io.MultiReader(bytes.NewReader(head), file), limit: MaxReceiptSize, err: ErrReceiptTooLarge}
+	info, err := Store.Put(ctx, key, io.TeeReader(limited, hash), receipt.ContentType)
+	if err != nil {
+		return nil, "", err
+	}
+	receipt.Size = info.Size
+	receipt.Checksum = hex.EncodeToString(hash.Sum(nil))
+	receipt.UploadDate = time.Now().UTC()
+	return &receipt, key, nil
+}
+
+// removeReceiptFile deletes a stored file, used when it couldn't be recorded
+func removeReceiptFile(key string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	return Store.Delete(ctx, key)
+}
+
+// SaveReceipt stores an uploaded receipt and records it. The receipt's uploader, tags and links are taken from details
+func SaveReceipt(ctx context.Context, file io.Reader, filename string, details Receipt) (*Receipt, error) {
+	if err := validateDetails(&details); err != nil {
+		return nil, badUploadError(err.Error())
+	}
+	receipt, _, err := storeReceipt(ctx, "", file, filename)
+	if err != nil {
+		return nil, err
+	}
+	return recordReceipt(receipt, details)
+}
+
+// recordReceipt adds the details to a stored receipt and records it, removing the file if it can't be recorded
+func recordReceipt(receipt *Receipt, details Receipt) (*Receipt, error) {
+	receipt.UploadedBy = details.UploadedBy
+	receipt.Tags = details.Tags
+	receipt.ProductIDs = details.ProductIDs
+	receipt.PurchaseOrderIDs = details.PurchaseOrderIDs
+	if err := insertReceipt(*receipt); err != nil {
+		if removeErr := removeReceiptFile(receipt.ID); removeErr != nil {
+			log.Println(removeErr)
+		}
+		return nil, err
+	}
+	if previewType(receipt.ContentType) != "" {
+		Previews.enqueue(receipt.ID, receipt.Version)
+	}
+	return receipt, nil
+}
+
+// validateDetails tidies up and checks the uploader and tags, and drops repeated links
+func validateDetails(details *Receipt) error {
+	details.UploadedBy = strings.TrimSpace(details.UploadedBy)
+	if len(details.UploadedBy) > 255 {
+		return fmt.Errorf("uploadedBy must be at most 255 characters")
+	}
+	tags := make([]string, 0, len(details.Tags))
+	seen := make(map[string]bool)
+	for _, tag := range details.Tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || seen[tag] {
+			continue
+		}
+		if len(tag) > 64 {
+			return fmt.Errorf("tags must be at most 64 characters")
+		}
+		seen[tag] = true
+		tags = append(tags, tag)
+	}
+	if len(tags) > 20 {
+		return fmt.Errorf("a receipt can have at most 20 tags")
+	}
+	details.Tags = tags
+	details.ProductIDs = uniqueIDs(details.ProductIDs)
+	details.PurchaseOrderIDs = uniqueIDs(details.PurchaseOrderIDs)
+	return nil
+}
+
+func uniqueIDs(ids []int) []int {
+	unique := make([]int, 0, len(ids))
+	seen := make(map[int]bool)
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			unique = append(unique, id)
+		}
+	}
+	return unique
+}
+
+// GetReceipt returns the receipt with the ID, or ErrReceiptNotFound
+func GetReceipt(id string) (*Receipt, error) {
+	if !validID(id) {
+		return nil, ErrReceiptNotFound
+	}
+	receipt, err := getReceipt(id)
+	if err != nil {
+		return nil, err
+	}
+	if receipt == nil {
+		return nil, ErrReceiptNotFound
+	}
+	return receipt, nil
+}
+
+// OpenReceipt opens the current version of a receipt's file for reading, the caller has to close it
+func OpenReceipt(ctx context.Context, id string) (*Receipt, io.ReadCloser, error) {
+	return OpenReceiptVersion(ctx, id, 0)
+}
+
+// OpenReceiptVersion opens a version of a receipt's file, 0 for the current one
+// The receipt returned has the details of that version, and the file is an io.ReadSeeker too, so it can be served in ranges
+// Nothing is fetched from the store until the file is read, so a download answered from its headers alone costs nothing
+func OpenReceiptVersion(ctx context.Context, id string, version int) (*Receipt, io.ReadCloser, error) {
+	receipt, err := GetReceipt(id)
+	if err != nil {
+		return nil, nil, err
+	}
+	if version == 0 {
+		version = receipt.Version
+	}
+	v, key, err := getVersion(receipt.ID, version)
+	if err != nil {
+		return nil, nil, err
+	}
+	if v == nil {
+		return nil, nil, ErrVersionNotFound
+	}
+	if v.ExpiredAt != nil {
+		return nil, nil, ErrVersionExpired
+	}
+	file := &seekableFile{ctx: ctx, key: key, size: v.Size}
+	receipt.ReceiptName, receipt.ContentType, receipt.Size, receipt.Checksum = v.ReceiptName, v.ContentType, v.Size, v.Checksum
+	receipt.UploadedBy, receipt.UploadDate, receipt.Version = v.UploadedBy, v.UploadDate, v.Version
+	return receipt, file, nil
+}
+
+// seekableFile is a receipt's file in the store, opened on the first read from wherever it's been seeked to
+// Seeking only moves the offset, so working out the size by seeking to the end and back doesn't touch the store.
+// The file is only opened again if a read comes from somewhere other than where the open one is,
+// with a range request if the store can do them, by seeking if its files can, and by skipping up to the offset if neither
+// The size is the one recorded for the receipt, which is all seeking from the end needs
+type seekableFile struct {
+	ctx    context.Context
+	key    string
+	size   int64
+	offset int64
+	// file is read from when it isn't nil, and is at at
+	file io.ReadCloser
+	at   int64
+}
+
+func (f *seekableFile) Read(p []byte) (int, error) {
+	if f.file != nil && f.at != f.offset {
+		f.Close()
+	}
+	if f.file == nil {
+		if f.offset >= f.size {
+			return 0, io.EOF
+		}
+		if err := f.open(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := f.file.Read(p)
+	f.offset += int64(n)
+	f.at += int64(n)
+	return n, err
+}
+
+// open opens the file at offset
+func (f *seekableFile) open() error {
+	var file io.ReadCloser
+	var err error
+	if ranger, ok := Store.(blob.Ranger); ok && f.offset > 0 {
+		file, err = ranger.GetRange(f.ctx, f.key, f.offset)
+	} else if file, _, err = Store.Get(f.ctx, f.key); err == nil && f.offset > 0 {
+		if seeker, ok := file.(io.Seeker); ok {
+			_, err = seeker.Seek(f.offset, io.SeekStart)
+		} else {
+			_, err = io.CopyN(ioutil.Discard, file, f.offset)
+		}
+		if err != nil {
+			file.Close()
+		}
+	}
+	if err == blob.ErrNotFound {
+		return ErrReceiptNotFound
+	}
+	if err != nil {
+		return err
+	}
+	f.file, f.at = file, f.offset
+	return nil
+}
+
+func (f *seekableFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += f.size
+	}
+	if offset < 0 {
+		return f.offset, fmt.Errorf("seek before the start of the file")
+	}
+	f.offset = offset
+	return offset, nil
+}
+
+func (f *seekableFile) Close() error {
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
+
+// Exists reports whether there's a receipt with the ID
+func Exists(id string) (bool, error) {
+	_, err := GetReceipt(id)
+	if err == ErrReceiptNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// ImportReceipts records any files in the store that aren't in the database yet
+// These are receipts stored under their own name before IDs were generated, and ones that kept their metadata in an {id}.json file,
+// which is removed once it's been imported. Stores that can't list their contents are skipped
+func ImportReceipts() error {
+	lister, ok := Store.(blob.Lister)
+	if !ok {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+	known, err := getReceiptIDs()
+	if err != nil {
+		return err
+	}
+	files, err := lister.List(ctx, "")
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		id := f.Key
+		if strings.HasSuffix(id, metadataSuffix) || !validID(id) || known[id] {
+			continue
+		}
+		receipt, err := describeFile(ctx, f)
+		if err != nil {
+			return err
+		}
+		if err = insertReceipt(*receipt); err != nil {
+			return fmt.Errorf("importing receipt %s: %w", id, err)
+		}
+		if err = Store.Delete(ctx, id+metadataSuffix); err != nil && err != blob.ErrNotFound {
+			log.Println(err)
+		}
+		log.Printf("imported receipt %s\n", id)
+	}
+	return nil
+}
+
+// describeFile works out a receipt's details from its file, and from its metadata file if it has one
+func describeFile(ctx context.Context, info blob.Info) (*Receipt, error) {
+	receipt := &Receipt{ID: info.Key, ReceiptName: info.Key, Size: info.Size, UploadDate: info.ModTime}
+	metadata, _, err := Store.Get(ctx, info.Key+metadataSuffix)
+	if err == nil {
+		err = json.NewDecoder(io.LimitReader(metadata, 64<<10)).Decode(receipt)
+		metadata.Close()
+		if err != nil {
+			return nil, err
+		}
+		receipt.ID = info.Key
+	} else if err != blob.ErrNotFound {
+		return nil, err
+	}
+	f, _, err := Store.Get(ctx, info.Key)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	head := make([]byte, 512)
+	n, err := io.ReadFull(f, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return nil, err
+	}
+	if receipt.ContentType == "" {
+		receipt.ContentType = http.DetectContentType(head[:n])
+	}
+	hash := sha256.New()
+	hash.Write(head[:n])
+	if _, err = io.Copy(hash, f); err != nil {
+		return nil, err
+	}
+	receipt.Checksum = hex.EncodeToString(hash.Sum(nil))
+	return receipt, nil
+}
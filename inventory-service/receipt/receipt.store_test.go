@@ -0,0 +1,97 @@
+package receipt
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jordbick/Golang/inventory-service/blob"
+)
+
+// countingStore counts what's fetched from a store, and hides that its files can seek, like S3
+type countingStore struct {
+	*blob.Memory
+	gets   int
+	ranges []int64
+}
+
+func (s *countingStore) Get(ctx context.Context, key string) (io.ReadCloser, blob.Info, error) {
+	s.gets++
+	file, info, err := s.Memory.Get(ctx, key)
+	if err != nil {
+		return nil, info, err
+	}
+	return ioutil.NopCloser(file), info, nil
+}
+
+// rangingStore can also fetch from part way through
+type rangingStore struct {
+	*countingStore
+}
+
+func (s rangingStore) GetRange(ctx context.Context, key string, offset int64) (io.ReadCloser, error) {
+	s.ranges = append(s.ranges, offset)
+	file, _, err := s.Memory.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	file.(io.Seeker).Seek(offset, io.SeekStart)
+	return file, nil
+}
+
+func TestSeekableFile(t *testing.T) {
+	const content = "0123456789abcdefghij"
+	counting := &countingStore{Memory: blob.NewMemory()}
+	if _, err := counting.Put(context.Background(), "receipt", strings.NewReader(content), "text/plain"); err != nil {
+		t.Fatal(err)
+	}
+	defer func(store blob.Store) { Store = store }(Store)
+
+	serve := func(header http.Header) *httptest.ResponseRecorder {
+		file := &seekableFile{ctx: context.Background(), key: "receipt", size: int64(len(content))}
+		defer file.Close()
+		r := httptest.NewRequest(http.MethodGet, "/receipts/x/file", nil)
+		r.Header = header
+		w := httptest.NewRecorder()
+		w.Header().Set("ETag", `"abc"`)
+		w.Header().Set("Content-Type", "text/plain")
+		http.ServeContent(w, r, "", time.Now(), file)
+		return w
+	}
+
+	for _, store := range []blob.Store{counting, rangingStore{counting}} {
+		Store = store
+		counting.gets, counting.ranges = 0, nil
+
+		// a conditional request that's answered with 304 never fetches the file
+		if w := serve(http.Header{"If-None-Match": {`"abc"`}}); w.Code != http.StatusNotModified || counting.gets != 0 {
+			t.Errorf("%T: 304 answered with %d, after %d gets", store, w.Code, counting.gets)
+		}
+
+		// a whole download fetches it once, even though ServeContent seeks to the end and back for the size
+		w := serve(http.Header{})
+		if w.Code != http.StatusOK || w.Body.String() != content || counting.gets != 1 || len(counting.ranges) != 0 {
+			t.Errorf("%T: 200 answered with %d %q, after %d gets and ranges %v", store, w.Code, w.Body.String(), counting.gets, counting.ranges)
+		}
+
+		// a range is fetched from where it starts
+		w = serve(http.Header{"Range": {"bytes=10-14"}})
+		if w.Code != http.StatusPartialContent || w.Body.String() != "abcde" {
+			t.Errorf("%T: range answered with %d %q", store, w.Code, w.Body.String())
+		}
+		if _, ok := store.(blob.Ranger); ok && (len(counting.ranges) != 1 || counting.ranges[0] != 10 || counting.gets != 1) {
+			t.Errorf("%T: range fetched with gets %d and ranges %v", store, counting.gets, counting.ranges)
+		}
+	}
+
+	// a file that's gone from the store is reported as a missing receipt when it's read
+	file := &seekableFile{ctx: context.Background(), key: "missing", size: 10}
+	if _, err := file.Read(make([]byte, 10)); err != ErrReceiptNotFound {
+		t.Errorf("reading a missing file = %v, want ErrReceiptNotFound", err)
+	}
+}
@@ -0,0 +1,512 @@
+package receipt
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jordbick/Golang/inventory-service/response"
+	"github.com/jordbick/Golang/inventory-service/webhook"
+)
+
+// A receipt too big to upload in one go over a poor connection can be sent in chunks instead
+// POST /api/receipts/uploads starts an upload session with the file's name and size, then each chunk is sent with PATCH
+// along with the Upload-Offset it starts at. If the connection drops, GET or HEAD on the session gives the offset to carry on from
+// Once everything has arrived, POST .../complete puts the chunks together into a receipt, checked the same way as any other upload
+// Chunks are kept in Store under uploads/, and a session that isn't finished within UploadSessionTTL is removed by retention
+
+// upload session statuses
+const (
+	UploadOpen       = "open"
+	UploadCompleting = "completing"
+	UploadCompleted  = "completed"
+)
+
+// UploadSessionTTL is how long an upload session lasts after it's started or last had a chunk
+var UploadSessionTTL = 24 * time.Hour
+
+// MaxChunkSize is the most that can be sent in one chunk
+var MaxChunkSize int64 = 5 << 20
+
+// completedRetries is how many times marking an upload completed is tried, completedRetryWait longer between each one
+var (
+	completedRetries   = 3
+	completedRetryWait = 500 * time.Millisecond
+)
+
+var (
+	ErrUploadNotFound   = errors.New("upload not found")
+	ErrUploadNotOpen    = errors.New("upload is already being completed")
+	ErrOffsetMismatch   = errors.New("Upload-Offset doesn't match the upload's offset")
+	ErrUploadIncomplete = errors.New("upload hasn't received all of the file yet")
+	ErrChunkTooLarge    = errors.New("chunk runs past the end of the upload or is too large")
+	ErrChecksumMismatch = errors.New("checksum doesn't match")
+)
+
+// UploadSession is a receipt being uploaded in chunks
+// ReceiptID is the receipt a new version is for when it's started, and the receipt that was made once it's completed
+// Checksum is the hex SHA-256 the finished file must have, if the client gave one
+type UploadSession struct {
+	UploadID         string    `json:"uploadId"`
+	ReceiptID        string    `json:"receiptId,omitempty"`
+	Filename         string    `json:"filename"`
+	Size             int64     `json:"size"`
+	Offset           int64     `json:"offset"`
+	Checksum         string    `json:"checksum,omitempty"`
+	Status           string    `json:"status"`
+	CreatedAt        time.Time `json:"createdAt"`
+	ExpiresAt        time.Time `json:"expiresAt"`
+	UploadedBy       string    `json:"uploadedBy"`
+	Tags             []string  `json:"tags"`
+	ProductIDs       []int     `json:"productIds"`
+	PurchaseOrderIDs []int     `json:"purchaseOrderIds"`
+}
+
+// uploadChunk is one stored chunk of an upload
+type uploadChunk struct {
+	offset   int64
+	length   int64
+	key      string
+	checksum string
+}
+
+// chunkKey is where a chunk is stored, under a random ID so a retried chunk never clashes with one that was left behind
+func chunkKey(uploadID string, offset int64, blobID string) string {
+	return fmt.Sprintf("uploads/%s/%d-%s", uploadID, offset, blobID)
+}
+
+// CreateUpload starts an upload session, checking what can be checked before any of the file is sent
+func CreateUpload(session UploadSession) (*UploadSession, error) {
+	if strings.TrimSpace(session.Filename) == "" {
+		return nil, badUploadError("filename is required")
+	}
+	session.Filename = SanitizeFilename(session.Filename)
+	if session.Size < 1 {
+		return nil, badUploadError("size must be the file's size in bytes")
+	}
+	if session.Size > MaxReceiptSize {
+		return nil, ErrReceiptTooLarge
+	}
+	session.Checksum = strings.ToLower(strings.TrimSpace(session.Checksum))
+	if session.Checksum != "" {
+		if b, err := hex.DecodeString(session.Checksum); err != nil || len(b) != sha256.Size {
+			return nil, badUploadError("checksum must be a hex SHA-256")
+		}
+	}
+	if session.ReceiptID != "" {
+		if _, err := GetReceipt(session.ReceiptID); err != nil {
+			return nil, err
+		}
+	}
+	details := Receipt{UploadedBy: session.UploadedBy, Tags: session.Tags, ProductIDs: session.ProductIDs, PurchaseOrderIDs: session.PurchaseOrderIDs}
+	if err := validateDetails(&details); err != nil {
+		return nil, badUploadError(err.Error())
+	}
+	session.UploadedBy, session.Tags, session.ProductIDs, session.PurchaseOrderIDs = details.UploadedBy, details.Tags, details.ProductIDs, details.PurchaseOrderIDs
+
+	var err error
+	session.UploadID, err = newReceiptID()
+	if err != nil {
+		return nil, err
+	}
+	session.Offset = 0
+	session.Status = UploadOpen
+	session.CreatedAt = time.Now().UTC()
+	session.ExpiresAt = session.CreatedAt.Add(UploadSessionTTL)
+	if err = insertUpload(session); err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// GetUpload returns an upload session, one that has expired is treated as gone
+func GetUpload(uploadID string) (*UploadSession, error) {
+	if !validID(uploadID) {
+		return nil, ErrUploadNotFound
+	}
+	session, err := getUpload(uploadID)
+	if err != nil {
+		return nil, err
+	}
+	if session == nil || (session.Status != UploadCompleted && time.Now().After(session.ExpiresAt)) {
+		return nil, ErrUploadNotFound
+	}
+	return session, nil
+}
+
+// parseChecksumHeader reads an Upload-Checksum header, "sha256 " then the base64 digest, returning nil if there isn't one
+func parseChecksumHeader(value string) ([]byte, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return nil, nil
+	}
+	parts := strings.Fields(value)
+	if len(parts) != 2 || !strings.EqualFold(parts[0], "sha256") {
+		return nil, badUploadError("Upload-Checksum must be sha256 followed by the base64 digest")
+	}
+	sum, err := base64.StdEncoding.DecodeString(parts[1])
+	if err != nil || len(sum) != sha256.Size {
+		return nil, badUploadError("Upload-Checksum must be sha256 followed by the base64 digest")
+	}
+	return sum, nil
+}
+
+// WriteChunk stores a chunk of an upload starting at offset, which has to be where the upload has got to,
+// and returns the new offset. If checksum isn't nil the chunk's SHA-256 has to match it
+// A chunk that can't be recorded, because another request wrote at the same offset first, is removed again
+func WriteChunk(ctx context.Context, uploadID string, offset int64, r io.Reader, checksum []byte) (int64, error) {
+	session, err := GetUpload(uploadID)
+	if err != nil {
+		return 0, err
+	}
+	if session.Status != UploadOpen {
+		return session.Offset, ErrUploadNotOpen
+	}
+	// checked again when the chunk is recorded, this just saves storing one that's bound to be refused
+	if offset != session.Offset {
+		return session.Offset, ErrOffsetMismatch
+	}
+	limit := session.Size - offset
+	if limit > MaxChunkSize {
+		limit = MaxChunkSize
+	}
+	blobID, err := newReceiptID()
+	if err != nil {
+		return 0, err
+	}
+	chunk := uploadChunk{offset: offset, key: chunkKey(uploadID, offset, blobID)}
+	hash := sha256.New()
+	limited := &sizeLimiter{r: r, limit: limit, err: ErrChunkTooLarge}
+	info, err := Store.Put(ctx, chunk.key, io.TeeReader(limited, hash), "application/octet-stream")
+	if err != nil {
+		return session.Offset, err
+	}
+	sum := hash.Sum(nil)
+	if info.Size == 0 || (checksum != nil && !bytes.Equal(sum, checksum)) {
+		if err := deleteBlob(ctx, chunk.key); err != nil {
+			log.Println(err)
+		}
+		if info.Size == 0 {
+			return session.Offset, nil
+		}
+		return session.Offset, ErrChecksumMismatch
+	}
+	chunk.length = info.Size
+	chunk.checksum = hex.EncodeToString(sum)
+	newOffset, err := addChunk(uploadID, chunk, time.Now().Add(UploadSessionTTL))
+	if err != nil {
+		if err := deleteBlob(ctx, chunk.key); err != nil {
+			log.Println(err)
+		}
+		return newOffset, err
+	}
+	return newOffset, nil
+}
+
+// chunkReader reads an upload's chunks one after the other, opening each one only when it's reached
+type chunkReader struct {
+	ctx     context.Context
+	chunks  []uploadChunk
+	current io.ReadCloser
+}
+
+func (c *chunkReader) Read(p []byte) (int, error) {
+	for {
+		if c.current == nil {
+			if len(c.chunks) == 0 {
+				return 0, io.EOF
+			}
+			file, _, err := Store.Get(c.ctx, c.chunks[0].key)
+			if err != nil {
+				return 0, err
+			}
+			c.current = file
+			c.chunks = c.chunks[1:]
+		}
+		n, err := c.current.Read(p)
+		if err == io.EOF {
+			c.current.Close()
+			c.current = nil
+			if n > 0 {
+				return n, nil
+			}
+			continue
+		}
+		return n, err
+	}
+}
+
+func (c *chunkReader) Close() error {
+	if c.current == nil {
+		return nil
+	}
+	return c.current.Close()
+}
+
+// CompleteUpload puts an upload's chunks together into a receipt, or a new version of one, and removes the chunks
+// Completing an upload that's already been completed returns the receipt it made
+// If anything goes wrong before the receipt is made the session is left open, so the upload can be completed again or aborted.
+// If the receipt is made but the session can't be marked completed, the receipt is returned along with the error
+// and the chunks are left for retention to remove once the session expires
+func CompleteUpload(ctx context.Context, uploadID string) (*Receipt, bool, error) {
+	session, err := GetUpload(uploadID)
+	if err != nil {
+		return nil, false, err
+	}
+	if session.Status == UploadCompleted {
+		receipt, err := GetReceipt(session.ReceiptID)
+		return receipt, false, err
+	}
+	if session.Offset != session.Size {
+		return nil, false, ErrUploadIncomplete
+	}
+	ok, err := setUploadStatus(uploadID, UploadOpen, UploadCompleting, "")
+	if err != nil {
+		return nil, false, err
+	}
+	if !ok {
+		return nil, false, ErrUploadNotOpen
+	}
+	receipt, err := assembleUpload(ctx, session)
+	if err != nil {
+		if _, reopenErr := setUploadStatus(uploadID, UploadCompleting, UploadOpen, ""); reopenErr != nil {
+			log.Println(reopenErr)
+		}
+		return nil, false, err
+	}
+	// the chunks only go once the session records the receipt, so a retried complete can always find it
+	if err = markUploadCompleted(uploadID, receipt.ID); err != nil {
+		return receipt, session.ReceiptID == "", fmt.Errorf("upload %s made receipt %s but wasn't marked completed: %w", uploadID, receipt.ID, err)
+	}
+	if err = removeUpload(ctx, uploadID, false); err != nil {
+		// retention will have another go once the session expires
+		log.Println(err)
+	}
+	return receipt, session.ReceiptID == "", nil
+}
+
+// markUploadCompleted records the receipt a completing upload made, trying again if the database is briefly unavailable
+func markUploadCompleted(uploadID, receiptID string) error {
+	for attempt := 1; ; attempt++ {
+		ok, err := setUploadStatus(uploadID, UploadCompleting, UploadCompleted, receiptID)
+		if err == nil && !ok {
+			// nothing else moves a session on from completing
+			err = fmt.Errorf("upload %s is no longer completing", uploadID)
+		}
+		if err == nil || attempt == completedRetries {
+			return err
+		}
+		log.Println(err)
+		time.Sleep(time.Duration(attempt) * completedRetryWait)
+	}
+}
+
+// assembleUpload stores the upload's chunks as one receipt file and records it
+func assembleUpload(ctx context.Context, session *UploadSession) (*Receipt, error) {
+	chunks, err := getChunks(session.UploadID)
+	if err != nil {
+		return nil, err
+	}
+	file := &chunkReader{ctx: ctx, chunks: chunks}
+	defer file.Close()
+	stored, key, err := storeReceipt(ctx, session.ReceiptID, file, session.Filename)
+	if err != nil {
+		return nil, err
+	}
+	if stored.Size != session.Size || (session.Checksum != "" && stored.Checksum != session.Checksum) {
+		if err := removeReceiptFile(key); err != nil {
+			log.Println(err)
+		}
+		return nil, ErrChecksumMismatch
+	}
+	if session.ReceiptID != "" {
+		return replaceReceipt(stored, key, session.UploadedBy)
+	}
+	return recordReceipt(stored, Receipt{UploadedBy: session.UploadedBy, Tags: session.Tags, ProductIDs: session.ProductIDs, PurchaseOrderIDs: session.PurchaseOrderIDs})
+}
+
+// AbortUpload stops an upload and removes what's been sent so far
+func AbortUpload(ctx context.Context, uploadID string) error {
+	session, err := GetUpload(uploadID)
+	if err != nil {
+		return err
+	}
+	if session.Status == UploadCompleting {
+		return ErrUploadNotOpen
+	}
+	return removeUpload(ctx, uploadID, true)
+}
+
+// removeUpload deletes an upload's chunks, and the session too when all is true
+// the chunk files go first, so a failure part way leaves the rows to try again with
+func removeUpload(ctx context.Context, uploadID string, all bool) error {
+	chunks, err := getChunks(uploadID)
+	if err != nil {
+		return err
+	}
+	for _, chunk := range chunks {
+		if err = deleteBlob(ctx, chunk.key); err != nil {
+			return err
+		}
+	}
+	return removeChunks(uploadID, all)
+}
+
+// handleUploads starts a chunked upload, the body is the session as JSON with at least filename and size
+// uploadedBy defaults to the X-User header
+func handleUploads(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		var session UploadSession
+		if err := json.NewDecoder(io.LimitReader(r.Body, maxFormOverhead)).Decode(&session); err != nil {
+			http.Error(w, "the upload must be described in JSON", http.StatusBadRequest)
+			return
+		}
+		if session.UploadedBy == "" {
+			session.UploadedBy = actor(r)
+		}
+		created, err := CreateUpload(session)
+		if err == ErrReceiptNotFound {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			uploadError(w, err)
+			return
+		}
+		w.Header().Set("Location", downloadPath+"uploads/"+created.UploadID)
+		w.Header().Set("Upload-Offset", "0")
+		w.Header().Set("Upload-Length", strconv.FormatInt(created.Size, 10))
+		response.WriteJSONStatus(w, http.StatusCreated, created)
+
+	case http.MethodOptions:
+		return
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// handleUpload is an upload session at uploads/{id}, or uploads/{id}/complete to finish it
+// GET returns the session and HEAD just its Upload-Offset and Upload-Length,
+// PATCH sends the next chunk, DELETE aborts the upload
+func handleUpload(w http.ResponseWriter, r *http.Request) {
+	urlPathSegments := strings.Split(strings.SplitN(r.URL.Path, fmt.Sprintf("%s/uploads/", receiptPath), 2)[1], "/")
+	uploadID := urlPathSegments[0]
+	if len(urlPathSegments) == 2 && urlPathSegments[1] == "complete" {
+		handleComplete(w, r, uploadID)
+		return
+	}
+	if len(urlPathSegments) > 1 {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		session, err := GetUpload(uploadID)
+		if err != nil {
+			uploadSessionError(w, err, 0)
+			return
+		}
+		w.Header().Set("Upload-Offset", strconv.FormatInt(session.Offset, 10))
+		w.Header().Set("Upload-Length", strconv.FormatInt(session.Size, 10))
+		w.Header().Set("Cache-Control", "no-store")
+		if r.Method == http.MethodHead {
+			return
+		}
+		response.WriteJSON(w, session)
+
+	case http.MethodPatch:
+		offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+		if err != nil || offset < 0 {
+			http.Error(w, "Upload-Offset must be the offset the chunk starts at", http.StatusBadRequest)
+			return
+		}
+		checksum, err := parseChecksumHeader(r.Header.Get("Upload-Checksum"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		newOffset, err := WriteChunk(r.Context(), uploadID, offset, r.Body, checksum)
+		if err != nil {
+			uploadSessionError(w, err, newOffset)
+			return
+		}
+		w.Header().Set("Upload-Offset", strconv.FormatInt(newOffset, 10))
+		w.WriteHeader(http.StatusNoContent)
+
+	case http.MethodDelete:
+		if err := AbortUpload(r.Context(), uploadID); err != nil {
+			uploadSessionError(w, err, 0)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	case http.MethodOptions:
+		return
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// handleComplete finishes an upload, sending back the receipt it made
+func handleComplete(w http.ResponseWriter, r *http.Request, uploadID string) {
+	switch r.Method {
+	case http.MethodPost:
+		receipt, created, err := CompleteUpload(r.Context(), uploadID)
+		if err != nil && receipt == nil {
+			uploadSessionError(w, err, 0)
+			return
+		}
+		if err != nil {
+			// the receipt was made, so the client still needs to hear about it
+			log.Println(err)
+		}
+		status := http.StatusOK
+		if created {
+			// let any webhook subscribers know a new receipt has arrived
+			if err = webhook.Publish(webhook.EventReceiptUploaded, receipt); err != nil {
+				log.Println(err)
+			}
+			status = http.StatusCreated
+		}
+		response.WriteJSONStatus(w, status, receipt)
+
+	case http.MethodOptions:
+		return
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// uploadSessionError sends the status for an upload session error, with the offset to carry on from when the offset was wrong
+func uploadSessionError(w http.ResponseWriter, err error, offset int64) {
+	switch err {
+	case ErrUploadNotFound, ErrReceiptNotFound:
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case ErrOffsetMismatch:
+		w.Header().Set("Upload-Offset", strconv.FormatInt(offset, 10))
+		http.Error(w, err.Error(), http.StatusConflict)
+	case ErrUploadNotOpen, ErrUploadIncomplete:
+		http.Error(w, err.Error(), http.StatusConflict)
+	case ErrChunkTooLarge:
+		http.Error(w, fmt.Sprintf("%s, chunks can be up to %d bytes", err, MaxChunkSize), http.StatusRequestEntityTooLarge)
+	case ErrChecksumMismatch:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		uploadError(w, err)
+	}
+}
@@ -0,0 +1,265 @@
+package receipt
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"database/sql/driver"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jordbick/Golang/inventory-service/blob"
+	"github.com/jordbick/Golang/inventory-service/database"
+	"github.com/jordbick/Golang/inventory-service/database/dbtest"
+)
+
+// uploadRow is an upload session as it's stored in receipt_uploads
+type uploadRow struct {
+	receiptID, filename, checksum, details, status string
+	size, offset                                   int64
+	createdAt, expiresAt                           time.Time
+}
+
+// uploadDB keeps upload sessions, their chunks and the receipts they make, enough for the chunked upload protocol
+type uploadDB struct {
+	uploads  map[string]*uploadRow
+	chunks   map[string][][]driver.Value
+	receipts map[string][]driver.Value
+	// failCompleted is how many times marking an upload completed fails before it works
+	failCompleted int
+}
+
+func newUploadDB() *uploadDB {
+	return &uploadDB{uploads: make(map[string]*uploadRow), chunks: make(map[string][][]driver.Value), receipts: make(map[string][]driver.Value)}
+}
+
+func (d *uploadDB) handle(query string, args []driver.Value) (dbtest.Result, error) {
+	switch {
+	case strings.HasPrefix(query, "INSERT INTO receipt_uploads"):
+		d.uploads[args[0].(string)] = &uploadRow{receiptID: args[1].(string), filename: args[2].(string), size: args[3].(int64), checksum: args[4].(string),
+			details: args[5].(string), status: args[6].(string), createdAt: args[7].(time.Time), expiresAt: args[8].(time.Time)}
+	case strings.HasPrefix(query, "SELECT uploadId,"):
+		u, ok := d.uploads[args[0].(string)]
+		if !ok {
+			return dbtest.Result{}, nil
+		}
+		return dbtest.Result{Rows: [][]driver.Value{{args[0], u.receiptID, u.filename, u.size, u.offset, u.checksum, u.details, u.status, u.createdAt, u.expiresAt}}}, nil
+	case strings.HasPrefix(query, "SELECT uploadOffset, status"):
+		u, ok := d.uploads[args[0].(string)]
+		if !ok {
+			return dbtest.Result{}, nil
+		}
+		return dbtest.Result{Rows: [][]driver.Value{{u.offset, u.status}}}, nil
+	case strings.HasPrefix(query, "INSERT INTO receipt_upload_chunks"):
+		id := args[0].(string)
+		d.chunks[id] = append(d.chunks[id], args[1:])
+	case strings.HasPrefix(query, "UPDATE receipt_uploads SET uploadOffset"):
+		d.uploads[args[2].(string)].offset = args[0].(int64)
+	case strings.HasPrefix(query, "SELECT chunkOffset"):
+		return dbtest.Result{Rows: d.chunks[args[0].(string)]}, nil
+	case strings.HasPrefix(query, "UPDATE receipt_uploads SET status"):
+		to, receiptID, u := args[0].(string), args[1].(string), d.uploads[args[3].(string)]
+		if to == UploadCompleted && d.failCompleted > 0 {
+			d.failCompleted--
+			return dbtest.Result{}, errors.New("lost connection")
+		}
+		if u == nil || u.status != args[4].(string) {
+			return dbtest.Result{}, nil
+		}
+		u.status = to
+		if receiptID != "" {
+			u.receiptID = receiptID
+		}
+		return dbtest.Result{RowsAffected: 1}, nil
+	case strings.HasPrefix(query, "DELETE FROM receipt_upload_chunks"):
+		delete(d.chunks, args[0].(string))
+	case strings.HasPrefix(query, "DELETE FROM receipt_uploads"):
+		delete(d.uploads, args[0].(string))
+	case strings.HasPrefix(query, "INSERT INTO receipts"):
+		d.receipts[args[0].(string)] = []driver.Value{args[0], args[1], args[2], args[3], args[4], args[5], args[6], int64(1)}
+	case strings.Contains(query, "FROM receipts"):
+		if row, ok := d.receipts[args[0].(string)]; ok {
+			return dbtest.Result{Rows: [][]driver.Value{row}}, nil
+		}
+	}
+	// everything else, such as the receipt's versions and audit log, just succeeds
+	return dbtest.Result{RowsAffected: 1}, nil
+}
+
+// uploadClient drives the upload handlers the way a client would
+type uploadClient struct {
+	t *testing.T
+}
+
+func (c uploadClient) do(method, path string, body []byte, header http.Header) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, "/api/receipts/uploads"+path, bytes.NewReader(body))
+	for name, values := range header {
+		r.Header[name] = values
+	}
+	w := httptest.NewRecorder()
+	if path == "" {
+		handleUploads(w, r)
+	} else {
+		handleUpload(w, r)
+	}
+	return w
+}
+
+func (c uploadClient) create(file []byte, checksum string) string {
+	body, _ := json.Marshal(UploadSession{Filename: "receipt.txt", Size: int64(len(file)), Checksum: checksum})
+	w := c.do(http.MethodPost, "", body, nil)
+	var session UploadSession
+	if w.Code != http.StatusCreated || json.Unmarshal(w.Body.Bytes(), &session) != nil {
+		c.t.Fatalf("creating an upload: %d %s", w.Code, w.Body.String())
+	}
+	return session.UploadID
+}
+
+func (c uploadClient) patch(uploadID string, offset int, chunk []byte, checksum string) *httptest.ResponseRecorder {
+	header := http.Header{"Upload-Offset": {strconv.Itoa(offset)}}
+	if checksum != "" {
+		header.Set("Upload-Checksum", checksum)
+	}
+	return c.do(http.MethodPatch, "/"+uploadID, chunk, header)
+}
+
+func (c uploadClient) complete(uploadID string) (*httptest.ResponseRecorder, Receipt) {
+	w := c.do(http.MethodPost, "/"+uploadID+"/complete", nil, nil)
+	var receipt Receipt
+	json.Unmarshal(w.Body.Bytes(), &receipt)
+	return w, receipt
+}
+
+func chunkChecksum(chunk []byte) string {
+	sum := sha256.Sum256(chunk)
+	return "sha256 " + base64.StdEncoding.EncodeToString(sum[:])
+}
+
+func fileChecksum(file []byte) string {
+	sum := sha256.Sum256(file)
+	return hex.EncodeToString(sum[:])
+}
+
+func setupUploads(t *testing.T) (*uploadDB, uploadClient) {
+	db := newUploadDB()
+	database.DbConn = dbtest.Open(db.handle)
+	store, chunkSize, wait := Store, MaxChunkSize, completedRetryWait
+	t.Cleanup(func() { Store, MaxChunkSize, completedRetryWait = store, chunkSize, wait })
+	Store = blob.NewMemory()
+	MaxChunkSize = 8
+	completedRetryWait = 0
+	return db, uploadClient{t}
+}
+
+func TestChunkedUpload(t *testing.T) {
+	db, client := setupUploads(t)
+	file := []byte("ACME receipt total 12.50\n")
+	uploadID := client.create(file, fileChecksum(file))
+
+	// a chunk sent from the wrong offset is refused with the offset to send from
+	if w := client.patch(uploadID, 8, file[8:16], ""); w.Code != http.StatusConflict || w.Header().Get("Upload-Offset") != "0" {
+		t.Errorf("wrong offset: %d with Upload-Offset %q", w.Code, w.Header().Get("Upload-Offset"))
+	}
+	// a chunk that doesn't match its checksum isn't kept
+	if w := client.patch(uploadID, 0, file[:8], chunkChecksum(file[1:9])); w.Code != http.StatusBadRequest {
+		t.Errorf("wrong chunk checksum: %d", w.Code)
+	}
+	// nor is one bigger than MaxChunkSize
+	if w := client.patch(uploadID, 0, file[:9], ""); w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("chunk too large: %d", w.Code)
+	}
+	if len(db.chunks[uploadID]) != 0 || db.uploads[uploadID].offset != 0 {
+		t.Fatalf("refused chunks were recorded: %v, offset %d", db.chunks[uploadID], db.uploads[uploadID].offset)
+	}
+	// completing before everything has arrived
+	if w, _ := client.complete(uploadID); w.Code != http.StatusConflict {
+		t.Errorf("complete with nothing sent: %d", w.Code)
+	}
+
+	if w := client.patch(uploadID, 0, file[:8], chunkChecksum(file[:8])); w.Code != http.StatusNoContent || w.Header().Get("Upload-Offset") != "8" {
+		t.Fatalf("first chunk: %d with Upload-Offset %q", w.Code, w.Header().Get("Upload-Offset"))
+	}
+	// the connection drops, HEAD says where to carry on from
+	for {
+		w := client.do(http.MethodHead, "/"+uploadID, nil, nil)
+		offset, err := strconv.Atoi(w.Header().Get("Upload-Offset"))
+		if w.Code != http.StatusOK || err != nil || w.Header().Get("Upload-Length") != strconv.Itoa(len(file)) {
+			t.Fatalf("HEAD: %d with Upload-Offset %q and Upload-Length %q", w.Code, w.Header().Get("Upload-Offset"), w.Header().Get("Upload-Length"))
+		}
+		if offset == len(file) {
+			break
+		}
+		end := offset + 8
+		if end > len(file) {
+			end = len(file)
+		}
+		if w = client.patch(uploadID, offset, file[offset:end], ""); w.Code != http.StatusNoContent {
+			t.Fatalf("chunk at %d: %d %s", offset, w.Code, w.Body.String())
+		}
+	}
+
+	w, receipt := client.complete(uploadID)
+	if w.Code != http.StatusCreated || receipt.ID == "" || receipt.Size != int64(len(file)) || receipt.Checksum != fileChecksum(file) {
+		t.Fatalf("complete: %d %s", w.Code, w.Body.String())
+	}
+	if len(db.chunks[uploadID]) != 0 || db.uploads[uploadID].status != UploadCompleted {
+		t.Errorf("after completing, chunks %v and status %q", db.chunks[uploadID], db.uploads[uploadID].status)
+	}
+	// completing again, say because the response was lost, gives the same receipt
+	if w, again := client.complete(uploadID); w.Code != http.StatusOK || again.ID != receipt.ID {
+		t.Errorf("complete again: %d with receipt %q, want %q", w.Code, again.ID, receipt.ID)
+	}
+}
+
+func TestCompleteUploadChecksumMismatch(t *testing.T) {
+	db, client := setupUploads(t)
+	file := []byte("ACME receipt")
+	uploadID := client.create(file, fileChecksum([]byte("something else")))
+	client.patch(uploadID, 0, file[:8], "")
+	client.patch(uploadID, 8, file[8:], "")
+
+	if w, _ := client.complete(uploadID); w.Code != http.StatusBadRequest {
+		t.Errorf("complete with the wrong checksum: %d %s", w.Code, w.Body.String())
+	}
+	// the session is left open for the client to abort, and the assembled file isn't kept
+	if db.uploads[uploadID].status != UploadOpen || len(db.receipts) != 0 {
+		t.Errorf("status %q with receipts %v", db.uploads[uploadID].status, db.receipts)
+	}
+	if w := client.do(http.MethodDelete, "/"+uploadID, nil, nil); w.Code != http.StatusNoContent || db.uploads[uploadID] != nil {
+		t.Errorf("abort: %d", w.Code)
+	}
+}
+
+func TestCompleteUploadNotMarkedCompleted(t *testing.T) {
+	db, client := setupUploads(t)
+	file := []byte("ACME receipt")
+	uploadID := client.create(file, "")
+	client.patch(uploadID, 0, file[:8], "")
+	client.patch(uploadID, 8, file[8:], "")
+
+	// a failure or two is retried
+	db.failCompleted = completedRetries - 1
+	if w, receipt := client.complete(uploadID); w.Code != http.StatusCreated || db.uploads[uploadID].receiptID != receipt.ID {
+		t.Errorf("complete after retrying: %d, session has receipt %q", w.Code, db.uploads[uploadID].receiptID)
+	}
+
+	uploadID = client.create(file, "")
+	client.patch(uploadID, 0, file[:8], "")
+	client.patch(uploadID, 8, file[8:], "")
+	// when it keeps failing the client still hears about the receipt, and the chunks wait for retention
+	db.failCompleted = completedRetries
+	w, receipt := client.complete(uploadID)
+	if w.Code != http.StatusCreated || receipt.ID == "" || db.receipts[receipt.ID] == nil {
+		t.Errorf("complete that couldn't be marked: %d %s", w.Code, w.Body.String())
+	}
+	if len(db.chunks[uploadID]) != 2 {
+		t.Errorf("chunks %v were removed from a session that isn't marked completed", db.chunks[uploadID])
+	}
+}
@@ -0,0 +1,55 @@
+package receipt
+
+import (
+	"log"
+)
+
+// Replacing a receipt's file keeps the old one as a previous version, which can still be downloaded by its number
+// until retention expires it. Deleting a receipt hides it straight away, and retention removes its files later
+// Every upload, replacement, deletion and expiry goes in the receipt's audit log
+
+// versionKey is where a version after the first is stored, under its own random ID
+// the slashes keep it from ever being taken for a receipt ID
+func versionKey(receiptID, blobID string) string {
+	return "versions/" + receiptID + "/" + blobID
+}
+
+// replaceReceipt makes a stored file the receipt's new current version, removing the file if it can't be recorded
+func replaceReceipt(stored *Receipt, key, actor string) (*Receipt, error) {
+	version, err := insertVersion(*stored, key, actor)
+	if err != nil {
+		if removeErr := removeReceiptFile(key); removeErr != nil {
+			log.Println(removeErr)
+		}
+		return nil, err
+	}
+	if previewType(stored.ContentType) != "" {
+		Previews.enqueue(stored.ID, version)
+	}
+	return GetReceipt(stored.ID)
+}
+
+// DeleteReceipt deletes a receipt, it's gone from the list and downloads straight away
+func DeleteReceipt(id, actor string) error {
+	if !validID(id) {
+		return ErrReceiptNotFound
+	}
+	return markDeleted(id, actor)
+}
+
+// GetVersions returns every version of a receipt, newest first
+func GetVersions(id string) ([]ReceiptVersion, error) {
+	receipt, err := GetReceipt(id)
+	if err != nil {
+		return nil, err
+	}
+	return getVersions(receipt.ID)
+}
+
+// GetAudit returns a receipt's audit log, which is still there once the receipt has been deleted
+func GetAudit(id string) ([]AuditEntry, error) {
+	if !validID(id) {
+		return nil, ErrReceiptNotFound
+	}
+	return getAudit(id)
+}
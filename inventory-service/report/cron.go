@@ -0,0 +1,141 @@
+package report
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedules use the standard five field cron format: minute hour day-of-month month day-of-week
+// Each field can be *, a number, a range like 1-5, a step like */15 or 1-30/2, or a comma separated list of those
+// As in cron, if both day-of-month and day-of-week are restricted a day matching either of them will do.
+// A field starting with *, such as */2, doesn't count as restricted for that, so "0 0 */2 * 5" is Fridays on odd days of the month
+
+// cronSpec holds the allowed values of each field
+type cronSpec struct {
+	minutes, hours, days, months, weekdays map[int]bool
+	anyDay, anyWeekday                     bool
+}
+
+// parseCron parses a cron expression
+func parseCron(expression string) (*cronSpec, error) {
+	fields := strings.Fields(expression)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("cron expression needs 5 fields (minute hour day-of-month month day-of-week)")
+	}
+	var spec cronSpec
+	var err error
+	if spec.minutes, err = parseCronField(fields[0], 0, 59); err != nil {
+		return nil, fmt.Errorf("minute: %w", err)
+	}
+	if spec.hours, err = parseCronField(fields[1], 0, 23); err != nil {
+		return nil, fmt.Errorf("hour: %w", err)
+	}
+	if spec.days, err = parseCronField(fields[2], 1, 31); err != nil {
+		return nil, fmt.Errorf("day-of-month: %w", err)
+	}
+	if spec.months, err = parseCronField(fields[3], 1, 12); err != nil {
+		return nil, fmt.Errorf("month: %w", err)
+	}
+	// 7 is also Sunday
+	if spec.weekdays, err = parseCronField(fields[4], 0, 7); err != nil {
+		return nil, fmt.Errorf("day-of-week: %w", err)
+	}
+	if spec.weekdays[7] {
+		spec.weekdays[0] = true
+	}
+	spec.anyDay = strings.HasPrefix(fields[2], "*")
+	spec.anyWeekday = strings.HasPrefix(fields[4], "*")
+	return &spec, nil
+}
+
+func parseCronField(field string, min, max int) (map[int]bool, error) {
+	values := make(map[int]bool)
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			var err error
+			step, err = strconv.Atoi(part[i+1:])
+			if err != nil || step < 1 {
+				return nil, fmt.Errorf("bad step in [%s]", part)
+			}
+			part = part[:i]
+		}
+		from, to := min, max
+		if part != "*" {
+			bounds := strings.SplitN(part, "-", 2)
+			var err error
+			from, err = strconv.Atoi(bounds[0])
+			if err != nil {
+				return nil, fmt.Errorf("bad value [%s]", part)
+			}
+			to = from
+			if len(bounds) == 2 {
+				to, err = strconv.Atoi(bounds[1])
+				if err != nil {
+					return nil, fmt.Errorf("bad range [%s]", part)
+				}
+			} else if step > 1 {
+				// 5/15 means every 15 starting at 5
+				to = max
+			}
+		}
+		if from < min || to > max || from > to {
+			return nil, fmt.Errorf("[%s] must be between %d and %d", part, min, max)
+		}
+		for v := from; v <= to; v += step {
+			values[v] = true
+		}
+	}
+	return values, nil
+}
+
+// dayMatches applies both day fields, which only have to agree when one of them starts with *
+func (s *cronSpec) dayMatches(t time.Time) bool {
+	day := s.days[t.Day()]
+	weekday := s.weekdays[int(t.Weekday())]
+	if s.anyDay || s.anyWeekday {
+		return day && weekday
+	}
+	return day || weekday
+}
+
+// next returns the first time after t that matches, or the zero time if nothing matches within the next few years (e.g. 30 February)
+func (s *cronSpec) next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		if !s.months[int(t.Month())] {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !s.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !s.hours[t.Hour()] {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+		if !s.minutes[t.Minute()] {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+// NextRun returns when a cron expression next fires after t
+func NextRun(expression string, t time.Time) (time.Time, error) {
+	spec, err := parseCron(expression)
+	if err != nil {
+		return time.Time{}, err
+	}
+	next := spec.next(t)
+	if next.IsZero() {
+		return next, fmt.Errorf("cron expression [%s] never fires", expression)
+	}
+	return next, nil
+}
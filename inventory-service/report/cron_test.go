@@ -0,0 +1,107 @@
+package report
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestParseCronField(t *testing.T) {
+	tests := []struct {
+		field    string
+		min, max int
+		want     []int
+	}{
+		{"*", 0, 6, []int{0, 1, 2, 3, 4, 5, 6}},
+		{"5", 0, 59, []int{5}},
+		{"1-5", 0, 6, []int{1, 2, 3, 4, 5}},
+		{"*/15", 0, 59, []int{0, 15, 30, 45}},
+		{"1-10/3", 1, 31, []int{1, 4, 7, 10}},
+		{"50/5", 0, 59, []int{50, 55}},
+		{"1,3,5-6", 0, 7, []int{1, 3, 5, 6}},
+		{"0,30,*/20", 0, 59, []int{0, 20, 30, 40}},
+	}
+	for _, test := range tests {
+		values, err := parseCronField(test.field, test.min, test.max)
+		if err != nil {
+			t.Errorf("parseCronField(%q): %v", test.field, err)
+			continue
+		}
+		got := make([]int, 0, len(values))
+		for v := range values {
+			got = append(got, v)
+		}
+		sort.Ints(got)
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("parseCronField(%q) = %v, want %v", test.field, got, test.want)
+		}
+	}
+
+	for _, field := range []string{"", "60", "-1", "5-1", "*/0", "*/x", "a", "1-", "1,,2"} {
+		if _, err := parseCronField(field, 0, 59); err == nil {
+			t.Errorf("parseCronField(%q) succeeded", field)
+		}
+	}
+}
+
+func TestParseCron(t *testing.T) {
+	for _, expression := range []string{"", "* * * *", "* * * * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "* * * 13 *", "* * * * 8"} {
+		if _, err := parseCron(expression); err == nil {
+			t.Errorf("parseCron(%q) succeeded", expression)
+		}
+	}
+	// 7 is Sunday as well as 0
+	spec, err := parseCron("0 0 * * 7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !spec.weekdays[0] {
+		t.Errorf("7 isn't Sunday")
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	// 2024-01-01 is a Monday
+	from := time.Date(2024, 1, 1, 10, 7, 30, 0, time.UTC)
+	tests := []struct {
+		expression string
+		want       []string
+	}{
+		{"* * * * *", []string{"2024-01-01 10:08", "2024-01-01 10:09"}},
+		{"*/15 * * * *", []string{"2024-01-01 10:15", "2024-01-01 10:30", "2024-01-01 10:45", "2024-01-01 11:00"}},
+		{"0 9-10 * * *", []string{"2024-01-02 09:00", "2024-01-02 10:00", "2024-01-03 09:00"}},
+		{"30 8 1,15 * *", []string{"2024-01-15 08:30", "2024-02-01 08:30", "2024-02-15 08:30"}},
+		{"0 0 * * 1-5", []string{"2024-01-02 00:00", "2024-01-03 00:00", "2024-01-04 00:00", "2024-01-05 00:00", "2024-01-08 00:00"}},
+		{"0 0 * * 7", []string{"2024-01-07 00:00", "2024-01-14 00:00"}},
+		{"0 0 * * 0", []string{"2024-01-07 00:00", "2024-01-14 00:00"}},
+		{"0 12 29 2 *", []string{"2024-02-29 12:00", "2028-02-29 12:00"}},
+		// both days restricted, either one will do: the 13th and every Friday
+		{"0 0 13 * 5", []string{"2024-01-05 00:00", "2024-01-12 00:00", "2024-01-13 00:00", "2024-01-19 00:00"}},
+		// a step on one of them doesn't count as restricted, so only the other one applies
+		{"0 0 */2 * 5", []string{"2024-01-05 00:00", "2024-01-19 00:00", "2024-02-09 00:00"}},
+		{"0 0 13 * */2", []string{"2024-01-13 00:00", "2024-02-13 00:00"}},
+	}
+	for _, test := range tests {
+		spec, err := parseCron(test.expression)
+		if err != nil {
+			t.Errorf("parseCron(%q): %v", test.expression, err)
+			continue
+		}
+		got := make([]string, 0, len(test.want))
+		next := from
+		for range test.want {
+			next = spec.next(next)
+			got = append(got, next.Format("2006-01-02 15:04"))
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s fires at %q, want %q", test.expression, got, test.want)
+		}
+	}
+}
+
+func TestNextRunNever(t *testing.T) {
+	if next, err := NextRun("0 0 30 2 *", time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)); err == nil {
+		t.Errorf("30 February fires at %s", next)
+	}
+}
@@ -0,0 +1,408 @@
+package report
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/jordbick/Golang/inventory-service/database"
+)
+
+// The filter is stored as JSON and the columns as a comma separated list
+func scanSavedSearch(scan func(dest ...interface{}) error) (*SavedSearch, error) {
+	savedSearch := &SavedSearch{}
+	var filter, columns string
+	err := scan(&savedSearch.SavedSearchID,
+		&savedSearch.Name,
+		&filter,
+		&savedSearch.Sort,
+		&columns,
+		&savedSearch.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal([]byte(filter), &savedSearch.Filter); err != nil {
+		return nil, err
+	}
+	savedSearch.Columns = make([]string, 0)
+	if columns != "" {
+		savedSearch.Columns = strings.Split(columns, ",")
+	}
+	return savedSearch, nil
+}
+
+func getSavedSearch(savedSearchID int) (*SavedSearch, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	row := database.DbConn.QueryRowContext(ctx, `SELECT savedSearchId,
+	name,
+	filter,
+	sortBy,
+	columns,
+	createdAt
+	FROM saved_searches
+	WHERE savedSearchId = ?`, savedSearchID)
+	savedSearch, err := scanSavedSearch(row.Scan)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return savedSearch, nil
+}
+
+func getSavedSearchList() ([]SavedSearch, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	results, err := database.DbConn.QueryContext(ctx, `SELECT savedSearchId,
+	name,
+	filter,
+	sortBy,
+	columns,
+	createdAt
+	FROM saved_searches
+	ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	defer results.Close()
+	savedSearches := make([]SavedSearch, 0)
+	for results.Next() {
+		savedSearch, err := scanSavedSearch(results.Scan)
+		if err != nil {
+			return nil, err
+		}
+		savedSearches = append(savedSearches, *savedSearch)
+	}
+	return savedSearches, nil
+}
+
+func insertSavedSearch(savedSearch SavedSearch) (int, error) {
+	filter, err := json.Marshal(savedSearch.Filter)
+	if err != nil {
+		return 0, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	result, err := database.DbConn.ExecContext(ctx, `INSERT INTO saved_searches (
+	name,
+	filter,
+	sortBy,
+	columns) VALUES (?, ?, ?, ?)`,
+		strings.TrimSpace(savedSearch.Name),
+		string(filter),
+		savedSearch.Sort,
+		strings.Join(savedSearch.Columns, ","))
+	if err != nil {
+		return 0, err
+	}
+	insertID, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	return int(insertID), nil
+}
+
+func updateSavedSearch(savedSearch SavedSearch) error {
+	filter, err := json.Marshal(savedSearch.Filter)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	_, err = database.DbConn.ExecContext(ctx, `UPDATE saved_searches SET
+	name=?,
+	filter=?,
+	sortBy=?,
+	columns=?
+	WHERE savedSearchId=?`,
+		strings.TrimSpace(savedSearch.Name),
+		string(filter),
+		savedSearch.Sort,
+		strings.Join(savedSearch.Columns, ","),
+		savedSearch.SavedSearchID)
+	return err
+}
+
+// removeSavedSearch refuses to remove a saved search that schedules still run
+func removeSavedSearch(savedSearchID int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	var schedules int
+	err := database.DbConn.QueryRowContext(ctx, `SELECT COUNT(*) FROM report_schedules WHERE savedSearchId = ?`, savedSearchID).Scan(&schedules)
+	if err != nil {
+		return err
+	}
+	if schedules > 0 {
+		return ErrSavedSearchInUse
+	}
+	_, err = database.DbConn.ExecContext(ctx, `DELETE FROM saved_searches WHERE savedSearchId = ?`, savedSearchID)
+	return err
+}
+
+func scanSchedule(scan func(dest ...interface{}) error) (*Schedule, error) {
+	schedule := &Schedule{}
+	var nextRunAt, lastRunAt sql.NullTime
+	err := scan(&schedule.ScheduleID,
+		&schedule.SavedSearchID,
+		&schedule.Cron,
+		&schedule.Format,
+		&schedule.Sink,
+		&schedule.Target,
+		&schedule.Enabled,
+		&nextRunAt,
+		&lastRunAt)
+	if err != nil {
+		return nil, err
+	}
+	if nextRunAt.Valid {
+		schedule.NextRunAt = &nextRunAt.Time
+	}
+	if lastRunAt.Valid {
+		schedule.LastRunAt = &lastRunAt.Time
+	}
+	return schedule, nil
+}
+
+const scheduleColumns = `scheduleId,
+	savedSearchId,
+	cron,
+	format,
+	sink,
+	target,
+	enabled,
+	nextRunAt,
+	lastRunAt`
+
+func getSchedule(scheduleID int) (*Schedule, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	row := database.DbConn.QueryRowContext(ctx, `SELECT `+scheduleColumns+`
+	FROM report_schedules
+	WHERE scheduleId = ?`, scheduleID)
+	schedule, err := scanSchedule(row.Scan)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return schedule, nil
+}
+
+// getScheduleList returns every schedule, or only those running the saved search if savedSearchID isn't 0
+func getScheduleList(savedSearchID int) ([]Schedule, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	query := `SELECT ` + scheduleColumns + ` FROM report_schedules`
+	queryArgs := make([]interface{}, 0)
+	if savedSearchID != 0 {
+		query += ` WHERE savedSearchId = ?`
+		queryArgs = append(queryArgs, savedSearchID)
+	}
+	query += ` ORDER BY scheduleId`
+	return querySchedules(ctx, query, queryArgs...)
+}
+
+// getDueSchedules returns the enabled schedules whose next run time has passed
+func getDueSchedules(now time.Time) ([]Schedule, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	return querySchedules(ctx, `SELECT `+scheduleColumns+`
+	FROM report_schedules
+	WHERE enabled = 1 AND nextRunAt <= ?
+	ORDER BY nextRunAt`, now.UTC())
+}
+
+func querySchedules(ctx context.Context, query string, args ...interface{}) ([]Schedule, error) {
+	results, err := database.DbConn.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer results.Close()
+	schedules := make([]Schedule, 0)
+	for results.Next() {
+		schedule, err := scanSchedule(results.Scan)
+		if err != nil {
+			return nil, err
+		}
+		schedules = append(schedules, *schedule)
+	}
+	return schedules, nil
+}
+
+func insertSchedule(schedule Schedule) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	result, err := database.DbConn.ExecContext(ctx, `INSERT INTO report_schedules (
+	savedSearchId,
+	cron,
+	format,
+	sink,
+	target,
+	enabled,
+	nextRunAt) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		schedule.SavedSearchID,
+		schedule.Cron,
+		schedule.Format,
+		schedule.Sink,
+		schedule.Target,
+		schedule.Enabled,
+		utc(schedule.NextRunAt))
+	if err != nil {
+		return 0, err
+	}
+	insertID, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	return int(insertID), nil
+}
+
+func updateSchedule(schedule Schedule) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	_, err := database.DbConn.ExecContext(ctx, `UPDATE report_schedules SET
+	savedSearchId=?,
+	cron=?,
+	format=?,
+	sink=?,
+	target=?,
+	enabled=?,
+	nextRunAt=?
+	WHERE scheduleId=?`,
+		schedule.SavedSearchID,
+		schedule.Cron,
+		schedule.Format,
+		schedule.Sink,
+		schedule.Target,
+		schedule.Enabled,
+		utc(schedule.NextRunAt),
+		schedule.ScheduleID)
+	return err
+}
+
+func removeSchedule(scheduleID int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	_, err := database.DbConn.ExecContext(ctx, `DELETE FROM report_runs WHERE scheduleId = ?`, scheduleID)
+	if err != nil {
+		return err
+	}
+	_, err = database.DbConn.ExecContext(ctx, `DELETE FROM report_schedules WHERE scheduleId = ?`, scheduleID)
+	return err
+}
+
+// claimSchedule moves a due schedule on to its next run time
+// It only succeeds if nextRunAt hasn't been moved by someone else in the meantime, so if several instances of the service
+// are running only one of them runs each report
+func claimSchedule(schedule Schedule, nextRunAt time.Time, now time.Time) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	result, err := database.DbConn.ExecContext(ctx, `UPDATE report_schedules SET
+	nextRunAt=?,
+	lastRunAt=?
+	WHERE scheduleId=? AND nextRunAt=?`,
+		utc(&nextRunAt),
+		now.UTC(),
+		schedule.ScheduleID,
+		utc(schedule.NextRunAt))
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rows == 1, nil
+}
+
+func insertRun(run Run) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	result, err := database.DbConn.ExecContext(ctx, `INSERT INTO report_runs (
+	scheduleId,
+	status,
+	startedAt) VALUES (?, ?, ?)`,
+		run.ScheduleID,
+		run.Status,
+		run.StartedAt.UTC())
+	if err != nil {
+		return 0, err
+	}
+	insertID, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	return int(insertID), nil
+}
+
+func finishRun(run Run) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	if len(run.Error) > 1024 {
+		run.Error = run.Error[:1024]
+	}
+	_, err := database.DbConn.ExecContext(ctx, `UPDATE report_runs SET
+	status=?,
+	finishedAt=?,
+	location=?,
+	error=?
+	WHERE runId=?`,
+		run.Status,
+		utc(run.FinishedAt),
+		run.Location,
+		run.Error,
+		run.RunID)
+	return err
+}
+
+// getRuns returns the most recent runs of a schedule, newest first
+func getRuns(scheduleID int, limit int) ([]Run, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	results, err := database.DbConn.QueryContext(ctx, `SELECT runId,
+	scheduleId,
+	status,
+	startedAt,
+	finishedAt,
+	location,
+	error
+	FROM report_runs
+	WHERE scheduleId = ?
+	ORDER BY startedAt DESC, runId DESC
+	LIMIT ?`, scheduleID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer results.Close()
+	runs := make([]Run, 0)
+	for results.Next() {
+		var run Run
+		var finishedAt sql.NullTime
+		err := results.Scan(&run.RunID,
+			&run.ScheduleID,
+			&run.Status,
+			&run.StartedAt,
+			&finishedAt,
+			&run.Location,
+			&run.Error)
+		if err != nil {
+			return nil, err
+		}
+		if finishedAt.Valid {
+			run.FinishedAt = &finishedAt.Time
+		}
+		runs = append(runs, run)
+	}
+	return runs, nil
+}
+
+// utc converts an optional time to UTC for storing
+func utc(t *time.Time) interface{} {
+	if t == nil {
+		return nil
+	}
+	return t.UTC()
+}
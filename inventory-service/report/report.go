@@ -0,0 +1,64 @@
+package report
+
+import (
+	"errors"
+	"time"
+
+	"github.com/jordbick/Golang/inventory-service/product"
+)
+
+// Saved searches keep a product report filter along with its sort order and columns, so it doesn't have to be typed in every time
+// A schedule runs a saved search on a cron expression and hands the output to a sink, e.g. a file in the reports directory
+// Every scheduled run is recorded so there's a history of what was sent and what failed
+
+// Run statuses
+const (
+	RunRunning   = "running"
+	RunSucceeded = "succeeded"
+	RunFailed    = "failed"
+)
+
+var (
+	ErrSavedSearchNotFound = errors.New("saved search not found")
+	ErrScheduleNotFound    = errors.New("schedule not found")
+	ErrSavedSearchInUse    = errors.New("saved search still has schedules")
+)
+
+// SavedSearch is a named product report query, its filter, sort and columns, that schedules run
+type SavedSearch struct {
+	SavedSearchID int                         `json:"savedSearchId"`
+	Name          string                      `json:"name"`
+	Filter        product.ProductReportFilter `json:"filter"`
+	// Sort is a column name optionally followed by asc or desc, e.g. "quantityOnHand desc"
+	Sort      string    `json:"sort"`
+	Columns   []string  `json:"columns"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// Schedule runs a saved search on a cron schedule and sends the report in Format to a Sink
+type Schedule struct {
+	ScheduleID    int    `json:"scheduleId"`
+	SavedSearchID int    `json:"savedSearchId"`
+	Cron          string `json:"cron"`
+	// Format is html, csv, xlsx or pdf
+	Format string `json:"format"`
+	// Sink is where the output goes, file or http unless more are registered, and Target is the sink specific destination:
+	// a sub directory of the reports directory for file, the URL to post to for http
+	Sink      string     `json:"sink"`
+	Target    string     `json:"target"`
+	Enabled   bool       `json:"enabled"`
+	NextRunAt *time.Time `json:"nextRunAt"`
+	LastRunAt *time.Time `json:"lastRunAt"`
+}
+
+// Run is one run of a schedule
+type Run struct {
+	RunID      int        `json:"runId"`
+	ScheduleID int        `json:"scheduleId"`
+	Status     string     `json:"status"`
+	StartedAt  time.Time  `json:"startedAt"`
+	FinishedAt *time.Time `json:"finishedAt"`
+	// Location is where the sink put the output
+	Location string `json:"location"`
+	Error    string `json:"error"`
+}
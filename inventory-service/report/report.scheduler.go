@@ -0,0 +1,139 @@
+package report
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/jordbick/Golang/inventory-service/locale"
+	"github.com/jordbick/Golang/inventory-service/product"
+)
+
+// Scheduler checks for due report schedules and runs them
+type Scheduler struct {
+	PollInterval time.Duration
+	// RunTimeout limits how long a single report can take to build and deliver
+	RunTimeout time.Duration
+}
+
+// NewScheduler returns a scheduler that checks for due reports every 30 seconds
+func NewScheduler() *Scheduler {
+	return &Scheduler{PollInterval: 30 * time.Second, RunTimeout: 5 * time.Minute}
+}
+
+// Start checks for due schedules every PollInterval in the background, a schedule that's part way through a run when done is closed isn't waited for
+func (s *Scheduler) Start(done <-chan struct{}) {
+	go func() {
+		ticker := time.NewTicker(s.PollInterval)
+		defer ticker.Stop()
+		for {
+			s.runDue()
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+func (s *Scheduler) runDue() {
+	now := time.Now()
+	schedules, err := getDueSchedules(now)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	for _, schedule := range schedules {
+		// move the schedule on before running it, so a slow or failing report isn't run again on the next poll
+		next, err := NextRun(schedule.Cron, now)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		claimed, err := claimSchedule(schedule, next, now)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		if !claimed {
+			continue
+		}
+		if _, err := s.Run(schedule); err != nil {
+			log.Printf("report schedule %d: %v", schedule.ScheduleID, err)
+		}
+	}
+}
+
+// Run runs a schedule straight away, recording the run in its history
+func (s *Scheduler) Run(schedule Schedule) (Run, error) {
+	run := Run{ScheduleID: schedule.ScheduleID, Status: RunRunning, StartedAt: time.Now()}
+	var err error
+	run.RunID, err = insertRun(run)
+	if err != nil {
+		return run, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.RunTimeout)
+	defer cancel()
+	run.Location, err = deliver(ctx, schedule, run.StartedAt)
+	finished := time.Now()
+	run.FinishedAt = &finished
+	run.Status = RunSucceeded
+	if err != nil {
+		run.Status = RunFailed
+		run.Error = err.Error()
+	}
+	if finishErr := finishRun(run); finishErr != nil {
+		log.Println(finishErr)
+	}
+	return run, err
+}
+
+// deliver builds the schedule's report and hands it to the sink
+func deliver(ctx context.Context, schedule Schedule, startedAt time.Time) (string, error) {
+	savedSearch, err := getSavedSearch(schedule.SavedSearchID)
+	if err != nil {
+		return "", err
+	}
+	if savedSearch == nil {
+		return "", ErrSavedSearchNotFound
+	}
+	sink, ok := getSink(schedule.Sink)
+	if !ok {
+		return "", fmt.Errorf("unknown sink [%s]", schedule.Sink)
+	}
+	var data bytes.Buffer
+	contentType, err := product.RunReport(ctx, &data, savedSearch.Filter, savedSearch.Sort, savedSearch.Columns, schedule.Format, locale.Default)
+	if err != nil {
+		return "", err
+	}
+	output := Output{
+		Name:        fmt.Sprintf("%s-%s.%s", fileName(savedSearch.Name), startedAt.Format("20060102-150405"), formatOrDefault(schedule.Format)),
+		ContentType: contentType,
+		Data:        data.Bytes(),
+	}
+	return sink.Deliver(ctx, schedule.Target, output)
+}
+
+func formatOrDefault(format string) string {
+	if format == "" {
+		return product.ReportFormatHTML
+	}
+	return format
+}
+
+var unsafeFileChars = regexp.MustCompile(`[^a-z0-9]+`)
+
+// fileName turns a saved search name like "Low stock (daily)" into "low-stock-daily"
+func fileName(name string) string {
+	name = strings.Trim(unsafeFileChars.ReplaceAllString(strings.ToLower(name), "-"), "-")
+	if name == "" {
+		return "report"
+	}
+	return name
+}
@@ -0,0 +1,398 @@
+package report
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jordbick/Golang/inventory-service/cors"
+	"github.com/jordbick/Golang/inventory-service/locale"
+	"github.com/jordbick/Golang/inventory-service/product"
+	"github.com/jordbick/Golang/inventory-service/response"
+)
+
+const (
+	savedSearchesBasePath = "savedsearches"
+	schedulesBasePath     = "reportschedules"
+)
+
+// SetupRoutes registers the saved search and report schedule handlers
+func SetupRoutes(apiBasePath string) {
+	handleSavedSearches := http.HandlerFunc(savedSearchesHandler)
+	handleSavedSearch := http.HandlerFunc(savedSearchHandler)
+	handleSchedules := http.HandlerFunc(schedulesHandler)
+	handleSchedule := http.HandlerFunc(scheduleHandler)
+	http.Handle(fmt.Sprintf("%s/%s", apiBasePath, savedSearchesBasePath), cors.Middleware(handleSavedSearches))
+	http.Handle(fmt.Sprintf("%s/%s/", apiBasePath, savedSearchesBasePath), cors.Middleware(handleSavedSearch))
+	http.Handle(fmt.Sprintf("%s/%s", apiBasePath, schedulesBasePath), cors.Middleware(handleSchedules))
+	http.Handle(fmt.Sprintf("%s/%s/", apiBasePath, schedulesBasePath), cors.Middleware(handleSchedule))
+}
+
+func errorStatus(err error) int {
+	switch err {
+	case ErrSavedSearchNotFound, ErrScheduleNotFound:
+		return http.StatusNotFound
+	case ErrSavedSearchInUse:
+		return http.StatusConflict
+	}
+	log.Println(err)
+	return http.StatusInternalServerError
+}
+
+// pathSegments returns the parts of the path after the base path, e.g. ["3", "runs"]
+func pathSegments(r *http.Request, basePath string) []string {
+	return strings.Split(strings.Trim(strings.SplitN(r.URL.Path, fmt.Sprintf("/%s/", basePath), 2)[1], "/"), "/")
+}
+
+func validateSavedSearch(savedSearch SavedSearch) error {
+	if strings.TrimSpace(savedSearch.Name) == "" {
+		return fmt.Errorf("name is required")
+	}
+	for _, c := range savedSearch.Columns {
+		if strings.Contains(c, ",") {
+			return fmt.Errorf("unknown column [%s]", c)
+		}
+	}
+	if err := product.ValidateReportFilter(savedSearch.Filter); err != nil {
+		return err
+	}
+	return product.ValidateReportOptions(savedSearch.Sort, savedSearch.Columns, "")
+}
+
+// validateSchedule checks the schedule and works out when it should next run
+func validateSchedule(schedule *Schedule) error {
+	savedSearch, err := getSavedSearch(schedule.SavedSearchID)
+	if err != nil {
+		return err
+	}
+	if savedSearch == nil {
+		return fmt.Errorf("saved search [%d] doesn't exist", schedule.SavedSearchID)
+	}
+	if err = product.ValidateReportOptions("", nil, schedule.Format); err != nil {
+		return err
+	}
+	sink, ok := getSink(schedule.Sink)
+	if !ok {
+		return fmt.Errorf("unknown sink [%s]", schedule.Sink)
+	}
+	if err = sink.Validate(schedule.Target); err != nil {
+		return err
+	}
+	next, err := NextRun(schedule.Cron, time.Now())
+	if err != nil {
+		return err
+	}
+	schedule.NextRunAt = nil
+	if schedule.Enabled {
+		schedule.NextRunAt = &next
+	}
+	return nil
+}
+
+// readSchedule reads a schedule from the request body
+func readSchedule(r *http.Request) (Schedule, error) {
+	var schedule Schedule
+	bodyBytes, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return schedule, err
+	}
+	err = json.Unmarshal(bodyBytes, &schedule)
+	return schedule, err
+}
+
+func savedSearchesHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		savedSearches, err := getSavedSearchList()
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		response.WriteJSON(w, savedSearches)
+
+	case http.MethodPost:
+		var newSavedSearch SavedSearch
+		bodyBytes, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		err = json.Unmarshal(bodyBytes, &newSavedSearch)
+		if err != nil || newSavedSearch.SavedSearchID != 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if err = validateSavedSearch(newSavedSearch); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		savedSearchID, err := insertSavedSearch(newSavedSearch)
+		if err != nil {
+			// most likely another saved search already has this name
+			log.Println(err)
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(fmt.Sprintf(`{"savedSearchId":%d}`, savedSearchID)))
+
+	case http.MethodOptions:
+		return
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// Handles /savedsearches/{id} and /savedsearches/{id}/run
+func savedSearchHandler(w http.ResponseWriter, r *http.Request) {
+	urlPathSegments := pathSegments(r, savedSearchesBasePath)
+	if r.Method == http.MethodOptions {
+		return
+	}
+	savedSearchID, err := strconv.Atoi(urlPathSegments[0])
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	savedSearch, err := getSavedSearch(savedSearchID)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if savedSearch == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if len(urlPathSegments) == 2 && urlPathSegments[1] == "run" {
+		runSavedSearchHandler(w, r, *savedSearch)
+		return
+	}
+	if len(urlPathSegments) != 1 {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		response.WriteJSON(w, savedSearch)
+
+	case http.MethodPut:
+		var updatedSavedSearch SavedSearch
+		bodyBytes, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		err = json.Unmarshal(bodyBytes, &updatedSavedSearch)
+		if err != nil || updatedSavedSearch.SavedSearchID != savedSearchID {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if err = validateSavedSearch(updatedSavedSearch); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		err = updateSavedSearch(updatedSavedSearch)
+		if err != nil {
+			log.Println(err)
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+
+	case http.MethodDelete:
+		err = removeSavedSearch(savedSearchID)
+		if err != nil {
+			w.WriteHeader(errorStatus(err))
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// runSavedSearchHandler runs a saved search straight away and returns the report, ?format= picks html, csv, xlsx or pdf
+func runSavedSearchHandler(w http.ResponseWriter, r *http.Request, savedSearch SavedSearch) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	format := r.URL.Query().Get("format")
+	if err := product.ValidateReportOptions("", nil, format); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var data bytes.Buffer
+	contentType, err := product.RunReport(r.Context(), &data, savedSearch.Filter, savedSearch.Sort, savedSearch.Columns, format, locale.Negotiate(r.Header.Get("Accept-Language")))
+	if err != nil {
+		w.WriteHeader(errorStatus(err))
+		return
+	}
+	name := fmt.Sprintf("%s.%s", fileName(savedSearch.Name), formatOrDefault(format))
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, name))
+	http.ServeContent(w, r, name, time.Now(), bytes.NewReader(data.Bytes()))
+}
+
+func schedulesHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		// ?savedSearchId= lists only the schedules of one saved search
+		savedSearchID := 0
+		if s := r.URL.Query().Get("savedSearchId"); s != "" {
+			var err error
+			savedSearchID, err = strconv.Atoi(s)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+		}
+		schedules, err := getScheduleList(savedSearchID)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		response.WriteJSON(w, schedules)
+
+	case http.MethodPost:
+		newSchedule, err := readSchedule(r)
+		if err != nil || newSchedule.ScheduleID != 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if err = validateSchedule(&newSchedule); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		scheduleID, err := insertSchedule(newSchedule)
+		if err != nil {
+			w.WriteHeader(errorStatus(err))
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(fmt.Sprintf(`{"scheduleId":%d}`, scheduleID)))
+
+	case http.MethodOptions:
+		return
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// Handles /reportschedules/{id}, /reportschedules/{id}/runs and /reportschedules/{id}/run
+func scheduleHandler(w http.ResponseWriter, r *http.Request) {
+	urlPathSegments := pathSegments(r, schedulesBasePath)
+	if r.Method == http.MethodOptions {
+		return
+	}
+	scheduleID, err := strconv.Atoi(urlPathSegments[0])
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	schedule, err := getSchedule(scheduleID)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if schedule == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if len(urlPathSegments) == 2 {
+		switch urlPathSegments[1] {
+		case "runs":
+			runsHandler(w, r, scheduleID)
+		case "run":
+			runNowHandler(w, r, *schedule)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+		return
+	}
+	if len(urlPathSegments) != 1 {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		response.WriteJSON(w, schedule)
+
+	case http.MethodPut:
+		updatedSchedule, err := readSchedule(r)
+		if err != nil || updatedSchedule.ScheduleID != scheduleID {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if err = validateSchedule(&updatedSchedule); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		err = updateSchedule(updatedSchedule)
+		if err != nil {
+			w.WriteHeader(errorStatus(err))
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+
+	case http.MethodDelete:
+		err = removeSchedule(scheduleID)
+		if err != nil {
+			w.WriteHeader(errorStatus(err))
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// runsHandler returns the run history of a schedule, newest first, ?limit= defaults to 50
+func runsHandler(w http.ResponseWriter, r *http.Request, scheduleID int) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	limit := 50
+	if l := r.URL.Query().Get("limit"); l != "" {
+		var err error
+		limit, err = strconv.Atoi(l)
+		if err != nil || limit < 1 || limit > 1000 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
+	runs, err := getRuns(scheduleID, limit)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	response.WriteJSON(w, runs)
+}
+
+// runNowHandler runs a schedule outside of its cron times, e.g. to try out a new schedule, and returns the run
+func runNowHandler(w http.ResponseWriter, r *http.Request, schedule Schedule) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	run, err := NewScheduler().Run(schedule)
+	if err != nil && run.RunID == 0 {
+		w.WriteHeader(errorStatus(err))
+		return
+	}
+	// a failed report is still a completed run, the error is in the run itself
+	response.WriteJSON(w, run)
+}
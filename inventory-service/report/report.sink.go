@@ -0,0 +1,126 @@
+package report
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// Output is a finished report ready to be delivered
+type Output struct {
+	// Name is a suggested file name, e.g. "low-stock-20220812-0700.csv"
+	Name        string
+	ContentType string
+	Data        []byte
+}
+
+// Sink delivers the output of a scheduled report somewhere, returning where it ended up
+// New kinds of sink (email, S3 and so on) can be added with RegisterSink
+type Sink interface {
+	// Validate checks a schedule's target when the schedule is saved
+	Validate(target string) error
+	Deliver(ctx context.Context, target string, output Output) (string, error)
+}
+
+var (
+	sinksMutex sync.RWMutex
+	sinks      = map[string]Sink{
+		"file": DirectorySink{Dir: "reports"},
+		"http": HTTPSink{Client: &http.Client{Timeout: 30 * time.Second}},
+	}
+)
+
+// RegisterSink makes a sink available to schedules under the given name
+func RegisterSink(name string, sink Sink) {
+	sinksMutex.Lock()
+	defer sinksMutex.Unlock()
+	sinks[name] = sink
+}
+
+func getSink(name string) (Sink, bool) {
+	sinksMutex.RLock()
+	defer sinksMutex.RUnlock()
+	sink, ok := sinks[name]
+	return sink, ok
+}
+
+// DirectorySink writes each report to a new file in Dir
+// The target is an optional sub directory so different schedules can keep their reports apart
+type DirectorySink struct {
+	Dir string
+}
+
+var safeName = regexp.MustCompile(`^[A-Za-z0-9_-]*$`)
+
+func (s DirectorySink) Validate(target string) error {
+	if !safeName.MatchString(target) {
+		return fmt.Errorf("file target can only contain letters, digits, - and _")
+	}
+	return nil
+}
+
+func (s DirectorySink) Deliver(ctx context.Context, target string, output Output) (string, error) {
+	if err := s.Validate(target); err != nil {
+		return "", err
+	}
+	dir := filepath.Join(s.Dir, target)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	name := filepath.Join(dir, filepath.Base(output.Name))
+	// never overwrite an earlier report
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return "", err
+	}
+	_, err = f.Write(output.Data)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(name)
+		return "", err
+	}
+	return name, nil
+}
+
+// HTTPSink POSTs each report to the target URL
+type HTTPSink struct {
+	Client *http.Client
+}
+
+func (s HTTPSink) Validate(target string) error {
+	u, err := url.Parse(target)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("http target must be an absolute http or https URL")
+	}
+	return nil
+}
+
+func (s HTTPSink) Deliver(ctx context.Context, target string, output Output) (string, error) {
+	req, err := http.NewRequest(http.MethodPost, target, bytes.NewReader(output.Data))
+	if err != nil {
+		return "", err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", output.ContentType)
+	req.Header.Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, output.Name))
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	ioutil.ReadAll(resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return "", fmt.Errorf("%s responded with %s", target, resp.Status)
+	}
+	return target, nil
+}
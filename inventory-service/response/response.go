@@ -0,0 +1,26 @@
+// Package response has the helpers the handlers share for writing responses
+package response
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+)
+
+// WriteJSON writes v as the JSON response, a value that can't be encoded is logged and answered with a 500
+func WriteJSON(w http.ResponseWriter, v interface{}) {
+	WriteJSONStatus(w, http.StatusOK, v)
+}
+
+// WriteJSONStatus writes v as the JSON response with the given status, such as 201 for something that's been created
+func WriteJSONStatus(w http.ResponseWriter, status int, v interface{}) {
+	j, err := json.Marshal(v)
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(j)
+}
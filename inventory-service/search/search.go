@@ -0,0 +1,329 @@
+package search
+
+import (
+	"html"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// An in-memory inverted index for full text search
+// Documents are made up of named fields, e.g. a product's name and sku, and each field has a weight so a match on a sku counts for more than one in a description
+// Every word of a query has to match somewhere in a document for it to be a hit, either exactly, as the start of a longer word, or within a typo or two
+
+// How much each kind of match counts towards the score, relative to an exact match
+const (
+	prefixMatch = 0.6
+	fuzzyMatch  = 0.3
+)
+
+// Hit is a matching document
+type Hit struct {
+	ID    int
+	Score float64
+	// Highlights has the text of each field that matched, with the matching words wrapped in <mark></mark> and the rest HTML escaped
+	Highlights map[string]string
+}
+
+// Index is an inverted index of documents that's safe to search while it's being changed
+type Index struct {
+	mu      sync.RWMutex
+	weights map[string]float64
+	docs    map[int]map[string]string
+	// postings maps a term to the documents and fields it appears in, with how many times it appears
+	postings map[string]map[int]map[string]int
+	// terms is every term in sorted order for finding prefix matches, kept up to date as documents are added and removed
+	terms []string
+}
+
+// New returns an empty index, fields without a weight count as 1
+func New(weights map[string]float64) *Index {
+	return &Index{
+		weights:  weights,
+		docs:     make(map[int]map[string]string),
+		postings: make(map[string]map[int]map[string]int),
+	}
+}
+
+// Add indexes the document, replacing it if it's already in the index
+func (ix *Index) Add(id int, fields map[string]string) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	ix.remove(id)
+	ix.add(id, fields)
+}
+
+// Remove takes a document out of the index
+func (ix *Index) Remove(id int) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	ix.remove(id)
+}
+
+// Replace swaps the whole contents of the index for the given documents
+func (ix *Index) Replace(docs map[int]map[string]string) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	ix.docs = make(map[int]map[string]string, len(docs))
+	ix.postings = make(map[string]map[int]map[string]int)
+	for id, fields := range docs {
+		ix.index(id, fields)
+	}
+	// sorting once is much quicker than inserting each term in place
+	ix.terms = make([]string, 0, len(ix.postings))
+	for term := range ix.postings {
+		ix.terms = append(ix.terms, term)
+	}
+	sort.Strings(ix.terms)
+}
+
+// add indexes a document, inserting any words that are new to the index into terms
+func (ix *Index) add(id int, fields map[string]string) {
+	for _, term := range ix.index(id, fields) {
+		i := sort.SearchStrings(ix.terms, term)
+		ix.terms = append(ix.terms, "")
+		copy(ix.terms[i+1:], ix.terms[i:])
+		ix.terms[i] = term
+	}
+}
+
+// index adds a document to the postings, returning the words that weren't in the index before
+func (ix *Index) index(id int, fields map[string]string) []string {
+	var newTerms []string
+	ix.docs[id] = fields
+	for field, text := range fields {
+		for _, t := range tokenize(text) {
+			docs, ok := ix.postings[t.term]
+			if !ok {
+				docs = make(map[int]map[string]int)
+				ix.postings[t.term] = docs
+				newTerms = append(newTerms, t.term)
+			}
+			if docs[id] == nil {
+				docs[id] = make(map[string]int)
+			}
+			docs[id][field]++
+		}
+	}
+	return newTerms
+}
+
+func (ix *Index) remove(id int) {
+	fields, ok := ix.docs[id]
+	if !ok {
+		return
+	}
+	for _, text := range fields {
+		for _, t := range tokenize(text) {
+			docs, ok := ix.postings[t.term]
+			if !ok {
+				// a word that's in the document more than once has already gone
+				continue
+			}
+			delete(docs, id)
+			if len(docs) == 0 {
+				delete(ix.postings, t.term)
+				i := sort.SearchStrings(ix.terms, t.term)
+				ix.terms = append(ix.terms[:i], ix.terms[i+1:]...)
+			}
+		}
+	}
+	delete(ix.docs, id)
+}
+
+// Search returns the documents matching every word of the query, best match first
+func (ix *Index) Search(query string) []Hit {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+	queryTerms := tokenize(query)
+	if len(queryTerms) == 0 {
+		return []Hit{}
+	}
+
+	scores := make(map[int]float64)
+	// matched records which query words each document has matched, and the terms to highlight in each field
+	matched := make(map[int]map[int]bool)
+	highlight := make(map[int]map[string]map[string]bool)
+	for i, q := range queryTerms {
+		for term, strength := range ix.expand(q.term) {
+			docs := ix.postings[term]
+			idf := math.Log(1 + float64(len(ix.docs))/float64(len(docs)))
+			for id, fields := range docs {
+				if matched[id] == nil {
+					matched[id] = make(map[int]bool)
+					highlight[id] = make(map[string]map[string]bool)
+				}
+				matched[id][i] = true
+				for field, count := range fields {
+					scores[id] += strength * idf * ix.weight(field) * (1 + math.Log(float64(count)))
+					if highlight[id][field] == nil {
+						highlight[id][field] = make(map[string]bool)
+					}
+					highlight[id][field][term] = true
+				}
+			}
+		}
+	}
+
+	hits := make([]Hit, 0)
+	for id, words := range matched {
+		if len(words) < len(queryTerms) {
+			continue
+		}
+		hit := Hit{ID: id, Score: math.Round(scores[id]*1000) / 1000, Highlights: make(map[string]string)}
+		for field, terms := range highlight[id] {
+			hit.Highlights[field] = mark(ix.docs[id][field], terms)
+		}
+		hits = append(hits, hit)
+	}
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].Score != hits[j].Score {
+			return hits[i].Score > hits[j].Score
+		}
+		return hits[i].ID < hits[j].ID
+	})
+	return hits
+}
+
+func (ix *Index) weight(field string) float64 {
+	if w, ok := ix.weights[field]; ok {
+		return w
+	}
+	return 1
+}
+
+// expand finds the indexed terms a query word matches and how strongly
+func (ix *Index) expand(word string) map[string]float64 {
+	matches := make(map[string]float64)
+	if _, ok := ix.postings[word]; ok {
+		matches[word] = 1
+	}
+	// a single letter would match far too much to be useful as a prefix
+	if len([]rune(word)) >= 2 {
+		i := sort.SearchStrings(ix.terms, word)
+		for ; i < len(ix.terms) && strings.HasPrefix(ix.terms[i], word); i++ {
+			if _, ok := matches[ix.terms[i]]; !ok {
+				matches[ix.terms[i]] = prefixMatch
+			}
+		}
+	}
+	maxEdits := allowedEdits(word)
+	if maxEdits > 0 {
+		for _, term := range ix.terms {
+			if _, ok := matches[term]; ok {
+				continue
+			}
+			if withinEdits(word, term, maxEdits) {
+				matches[term] = fuzzyMatch
+			}
+		}
+	}
+	return matches
+}
+
+// allowedEdits is how many typos a word can have, short words need to be spelt right or they'd match almost anything
+func allowedEdits(word string) int {
+	switch n := len([]rune(word)); {
+	case n >= 8:
+		return 2
+	case n >= 4:
+		return 1
+	}
+	return 0
+}
+
+// withinEdits reports whether a can be turned into b with at most max edits
+// An edit is adding, removing or changing a letter, or swapping two neighbouring letters which is the most common typo
+func withinEdits(a, b string, max int) bool {
+	ra, rb := []rune(a), []rune(b)
+	if d := len(ra) - len(rb); d > max || -d > max {
+		return false
+	}
+	// only the last two rows of the distance table are needed
+	beforePrevious := make([]int, len(rb)+1)
+	previous := make([]int, len(rb)+1)
+	current := make([]int, len(rb)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		current[0] = i
+		best := current[0]
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			current[j] = min3(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+			if i > 1 && j > 1 && ra[i-1] == rb[j-2] && ra[i-2] == rb[j-1] && beforePrevious[j-2]+1 < current[j] {
+				current[j] = beforePrevious[j-2] + 1
+			}
+			if current[j] < best {
+				best = current[j]
+			}
+		}
+		// every later row can only be worse, so give up early
+		if best > max {
+			return false
+		}
+		beforePrevious, previous, current = previous, current, beforePrevious
+	}
+	return previous[len(rb)] <= max
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
+
+type token struct {
+	term       string
+	start, end int
+}
+
+// tokenize splits text into lower case words of letters and digits, keeping where each one is in the text for highlighting
+func tokenize(text string) []token {
+	tokens := make([]token, 0)
+	start := -1
+	for i, r := range text {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if start < 0 {
+				start = i
+			}
+			continue
+		}
+		if start >= 0 {
+			tokens = append(tokens, token{term: strings.ToLower(text[start:i]), start: start, end: i})
+			start = -1
+		}
+	}
+	if start >= 0 {
+		tokens = append(tokens, token{term: strings.ToLower(text[start:]), start: start, end: len(text)})
+	}
+	return tokens
+}
+
+// mark wraps the words of text that are in terms with <mark></mark>
+func mark(text string, terms map[string]bool) string {
+	var b strings.Builder
+	last := 0
+	for _, t := range tokenize(text) {
+		if !terms[t.term] {
+			continue
+		}
+		b.WriteString(html.EscapeString(text[last:t.start]))
+		b.WriteString("<mark>")
+		b.WriteString(html.EscapeString(text[t.start:t.end]))
+		b.WriteString("</mark>")
+		last = t.end
+	}
+	b.WriteString(html.EscapeString(text[last:]))
+	return b.String()
+}
@@ -0,0 +1,76 @@
+package search
+
+import (
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+)
+
+func hitIDs(hits []Hit) []int {
+	ids := make([]int, len(hits))
+	for i, hit := range hits {
+		ids[i] = hit.ID
+	}
+	sort.Ints(ids)
+	return ids
+}
+
+func TestIndexTermsFollowChanges(t *testing.T) {
+	ix := New(map[string]float64{"sku": 3})
+	ix.Replace(map[int]map[string]string{
+		1: {"name": "Widget widget", "sku": "WID-100"},
+		2: {"name": "Gadget", "sku": "GAD-1"},
+	})
+	if !sort.StringsAreSorted(ix.terms) {
+		t.Fatalf("terms %q aren't sorted", ix.terms)
+	}
+
+	tests := []struct {
+		change func()
+		query  string
+		want   []int
+	}{
+		{func() {}, "widg", []int{1}},
+		{func() { ix.Add(3, map[string]string{"name": "Widgetry kit"}) }, "widg", []int{1, 3}},
+		{func() { ix.Remove(1) }, "widg", []int{3}},
+		{func() { ix.Add(3, map[string]string{"name": "Sprocket"}) }, "widg", []int{}},
+		{func() {}, "spro", []int{3}},
+		{func() {}, "gad", []int{2}},
+	}
+	for _, test := range tests {
+		test.change()
+		if got := hitIDs(ix.Search(test.query)); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("Search(%q) = %v, want %v", test.query, got, test.want)
+		}
+	}
+
+	want := []string{"1", "gad", "gadget", "sprocket"}
+	if !reflect.DeepEqual(ix.terms, want) {
+		t.Errorf("terms %q, want %q", ix.terms, want)
+	}
+}
+
+func TestIndexConcurrentSearch(t *testing.T) {
+	ix := New(nil)
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				ix.Add(i*100+j, map[string]string{"name": "widget number"})
+			}
+		}(i)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				ix.Search("widg")
+			}
+		}()
+	}
+	wg.Wait()
+	if hits := ix.Search("widget"); len(hits) != 400 {
+		t.Errorf("%d hits, want 400", len(hits))
+	}
+}
@@ -0,0 +1,237 @@
+package supplier
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jordbick/Golang/inventory-service/database"
+)
+
+const supplierColumns = `supplierId,
+	name,
+	contactName,
+	email,
+	phone,
+	address,
+	leadTimeDays`
+
+func scanSupplier(scan func(dest ...interface{}) error) (*Supplier, error) {
+	supplier := &Supplier{}
+	err := scan(&supplier.SupplierID,
+		&supplier.Name,
+		&supplier.ContactName,
+		&supplier.Email,
+		&supplier.Phone,
+		&supplier.Address,
+		&supplier.LeadTimeDays)
+	if err != nil {
+		return nil, err
+	}
+	return supplier, nil
+}
+
+// GetSupplier returns the supplier with the given ID, or nil if it doesn't exist
+func GetSupplier(supplierID int) (*Supplier, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	row := database.DbConn.QueryRowContext(ctx, `SELECT `+supplierColumns+`
+	FROM suppliers
+	WHERE supplierId = ?`, supplierID)
+	supplier, err := scanSupplier(row.Scan)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return supplier, nil
+}
+
+// escapeLike stops % and _ in the search text acting as wildcards
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
+
+// duplicateError turns a duplicate key error on the normalized name into ErrDuplicateName
+func duplicateError(err error) error {
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) && mysqlErr.Number == 1062 && strings.Contains(mysqlErr.Message, "uq_suppliers_normalized_name") {
+		return ErrDuplicateName
+	}
+	return err
+}
+
+// getSupplierList returns all suppliers, optionally only those whose name contains the search text
+func getSupplierList(search string) ([]Supplier, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	queryArgs := make([]interface{}, 0)
+	query := `SELECT ` + supplierColumns + ` FROM suppliers `
+	if search != "" {
+		query += `WHERE name LIKE ? OR normalizedName LIKE ? `
+		queryArgs = append(queryArgs, "%"+escapeLike(search)+"%", "%"+escapeLike(NormalizeName(search))+"%")
+	}
+	query += `ORDER BY name`
+	results, err := database.DbConn.QueryContext(ctx, query, queryArgs...)
+	if err != nil {
+		return nil, err
+	}
+	defer results.Close()
+	suppliers := make([]Supplier, 0)
+	for results.Next() {
+		supplier, err := scanSupplier(results.Scan)
+		if err != nil {
+			return nil, err
+		}
+		suppliers = append(suppliers, *supplier)
+	}
+	return suppliers, nil
+}
+
+// insertSupplier returns ErrDuplicateName if another supplier has the same normalized name
+func insertSupplier(supplier Supplier) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	result, err := database.DbConn.ExecContext(ctx, `INSERT INTO suppliers (
+	name,
+	normalizedName,
+	contactName,
+	email,
+	phone,
+	address,
+	leadTimeDays) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		strings.TrimSpace(supplier.Name),
+		NormalizeName(supplier.Name),
+		supplier.ContactName,
+		supplier.Email,
+		supplier.Phone,
+		supplier.Address,
+		supplier.LeadTimeDays)
+	if err != nil {
+		return 0, duplicateError(err)
+	}
+	insertID, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	return int(insertID), nil
+}
+
+// updateSupplier also renames the denormalized manufacturer text on the supplier's products
+// it returns ErrDuplicateName if another supplier has the same normalized name
+func updateSupplier(supplier Supplier) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	tx, err := database.DbConn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	_, err = tx.ExecContext(ctx, `UPDATE suppliers SET
+	name=?,
+	normalizedName=?,
+	contactName=?,
+	email=?,
+	phone=?,
+	address=?,
+	leadTimeDays=?
+	WHERE supplierId=?`,
+		strings.TrimSpace(supplier.Name),
+		NormalizeName(supplier.Name),
+		supplier.ContactName,
+		supplier.Email,
+		supplier.Phone,
+		supplier.Address,
+		supplier.LeadTimeDays,
+		supplier.SupplierID)
+	if err != nil {
+		return duplicateError(err)
+	}
+	_, err = tx.ExecContext(ctx, `UPDATE products SET manufacturer=? WHERE manufacturerId=?`, strings.TrimSpace(supplier.Name), supplier.SupplierID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `UPDATE purchase_orders SET supplier=? WHERE supplierId=?`, strings.TrimSpace(supplier.Name), supplier.SupplierID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// removeSupplier only deletes suppliers that nothing refers to any more
+func removeSupplier(supplierID int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	var references int
+	err := database.DbConn.QueryRowContext(ctx, `SELECT
+	(SELECT COUNT(*) FROM products WHERE manufacturerId = ?) +
+	(SELECT COUNT(*) FROM purchase_orders WHERE supplierId = ?)`, supplierID, supplierID).Scan(&references)
+	if err != nil {
+		return err
+	}
+	if references > 0 {
+		return ErrSupplierInUse
+	}
+	_, err = database.DbConn.ExecContext(ctx, `DELETE FROM suppliers WHERE supplierId = ?`, supplierID)
+	return err
+}
+
+// Resolve returns the supplier for a free text name, creating it if there isn't one with the same normalized name yet
+// It's used when a client still sends a manufacturer or supplier as text rather than an ID
+func Resolve(name string) (*Supplier, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	normalizedName := NormalizeName(name)
+	// INSERT IGNORE leaves the existing row alone if the normalized name is already taken
+	_, err := database.DbConn.ExecContext(ctx, `INSERT IGNORE INTO suppliers (name, normalizedName) VALUES (?, ?)`, strings.TrimSpace(name), normalizedName)
+	if err != nil {
+		return nil, err
+	}
+	row := database.DbConn.QueryRowContext(ctx, `SELECT `+supplierColumns+`
+	FROM suppliers
+	WHERE normalizedName = ?`, normalizedName)
+	return scanSupplier(row.Scan)
+}
+
+// DeduplicateManufacturers links every product that doesn't have a manufacturerId yet to a supplier
+// Each distinct manufacturer string is resolved by its normalized name, so duplicates collapse onto one supplier,
+// and the product's manufacturer text is rewritten to that supplier's name
+// It only touches unlinked products so it's safe to run on every start up
+func DeduplicateManufacturers() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+	results, err := database.DbConn.QueryContext(ctx, `SELECT DISTINCT manufacturer FROM products WHERE manufacturerId IS NULL AND TRIM(manufacturer) <> ''`)
+	if err != nil {
+		return err
+	}
+	names := make([]string, 0)
+	for results.Next() {
+		var name string
+		if err := results.Scan(&name); err != nil {
+			results.Close()
+			return err
+		}
+		names = append(names, name)
+	}
+	results.Close()
+
+	for _, name := range names {
+		supplier, err := Resolve(name)
+		if err != nil {
+			return err
+		}
+		_, err = database.DbConn.ExecContext(ctx, `UPDATE products SET manufacturerId=?, manufacturer=? WHERE manufacturerId IS NULL AND manufacturer=?`,
+			supplier.SupplierID, supplier.Name, name)
+		if err != nil {
+			return err
+		}
+	}
+	if len(names) > 0 {
+		log.Printf("linked %d manufacturer names to suppliers\n", len(names))
+	}
+	return nil
+}
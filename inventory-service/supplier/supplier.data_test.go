@@ -0,0 +1,59 @@
+package supplier
+
+import (
+	"database/sql/driver"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jordbick/Golang/inventory-service/database"
+	"github.com/jordbick/Golang/inventory-service/database/dbtest"
+)
+
+func TestSupplierListSearchIsLiteral(t *testing.T) {
+	var args []driver.Value
+	database.DbConn = dbtest.Open(func(query string, queryArgs []driver.Value) (dbtest.Result, error) {
+		args = queryArgs
+		return dbtest.Result{}, nil
+	})
+	if _, err := getSupplierList(`100%_A\B`); err != nil {
+		t.Fatal(err)
+	}
+	want := []driver.Value{`%100\%\_A\\B%`, `%100 a b%`}
+	if !reflect.DeepEqual(args, want) {
+		t.Errorf("searched with %q, want %q", args, want)
+	}
+}
+
+func TestDuplicateSupplierName(t *testing.T) {
+	tests := []struct {
+		method, path, body string
+	}{
+		{http.MethodPost, "/api/suppliers", `{"name": "ACME, Inc."}`},
+		{http.MethodPut, "/api/suppliers/2", `{"supplierId": 2, "name": "ACME, Inc."}`},
+	}
+	for _, test := range tests {
+		database.DbConn = dbtest.Open(func(query string, args []driver.Value) (dbtest.Result, error) {
+			switch {
+			case strings.HasPrefix(query, "INSERT INTO suppliers"), strings.HasPrefix(query, "UPDATE suppliers"):
+				return dbtest.Result{}, &mysql.MySQLError{Number: 1062, Message: "Duplicate entry 'acme' for key 'suppliers.uq_suppliers_normalized_name'"}
+			case strings.HasPrefix(query, "SELECT supplierId"):
+				return dbtest.Result{Rows: [][]driver.Value{{int64(2), "Acme Corp", "", "", "", "", int64(0)}}}, nil
+			}
+			return dbtest.Result{RowsAffected: 1}, nil
+		})
+		r := httptest.NewRequest(test.method, test.path, strings.NewReader(test.body))
+		w := httptest.NewRecorder()
+		if test.method == http.MethodPost {
+			suppliersHandler(w, r)
+		} else {
+			supplierHandler(w, r)
+		}
+		if w.Code != http.StatusConflict {
+			t.Errorf("%s %s: %d %s, want 409", test.method, test.path, w.Code, w.Body.String())
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package supplier
+
+import (
+	"errors"
+	"strings"
+	"unicode"
+)
+
+// Suppliers are the companies we buy from, which includes the manufacturers of our products
+// Products and purchase orders reference a supplier by ID instead of free text
+// so "Acme", "ACME Inc" and "acme" all end up as the same supplier
+
+var ErrSupplierInUse = errors.New("supplier is still referenced by products or purchase orders")
+var ErrDuplicateName = errors.New("a supplier with the same normalized name already exists")
+
+// Supplier
+type Supplier struct {
+	SupplierID   int    `json:"supplierId"`
+	Name         string `json:"name"`
+	ContactName  string `json:"contactName"`
+	Email        string `json:"email"`
+	Phone        string `json:"phone"`
+	Address      string `json:"address"`
+	LeadTimeDays int    `json:"leadTimeDays"`
+}
+
+// legal suffixes that don't make two company names different
+var companySuffixes = map[string]bool{
+	"inc":          true,
+	"incorporated": true,
+	"ltd":          true,
+	"limited":      true,
+	"llc":          true,
+	"co":           true,
+	"corp":         true,
+	"corporation":  true,
+	"company":      true,
+	"gmbh":         true,
+	"plc":          true,
+}
+
+// NormalizeName reduces a company name to the form used to detect duplicates
+// It lowercases the name, drops punctuation and trailing legal suffixes, e.g. "ACME, Inc." becomes "acme"
+func NormalizeName(name string) string {
+	words := strings.FieldsFunc(strings.ToLower(name), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '&'
+	})
+	for len(words) > 1 && companySuffixes[words[len(words)-1]] {
+		words = words[:len(words)-1]
+	}
+	return strings.Join(words, " ")
+}
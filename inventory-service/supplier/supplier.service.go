@@ -0,0 +1,157 @@
+package supplier
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/jordbick/Golang/inventory-service/cors"
+)
+
+const suppliersBasePath = "suppliers"
+
+// SetupRoutes registers the supplier handlers
+func SetupRoutes(apiBasePath string) {
+	handleSuppliers := http.HandlerFunc(suppliersHandler)
+	handleSupplier := http.HandlerFunc(supplierHandler)
+	http.Handle(fmt.Sprintf("%s/%s", apiBasePath, suppliersBasePath), cors.Middleware(handleSuppliers))
+	http.Handle(fmt.Sprintf("%s/%s/", apiBasePath, suppliersBasePath), cors.Middleware(handleSupplier))
+}
+
+func validateSupplier(supplier Supplier) error {
+	if NormalizeName(supplier.Name) == "" {
+		return fmt.Errorf("name is required")
+	}
+	if supplier.LeadTimeDays < 0 {
+		return fmt.Errorf("leadTimeDays can't be negative")
+	}
+	return nil
+}
+
+func suppliersHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		suppliers, err := getSupplierList(strings.TrimSpace(r.URL.Query().Get("name")))
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		suppliersJSON, err := json.Marshal(suppliers)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Write(suppliersJSON)
+
+	case http.MethodPost:
+		var newSupplier Supplier
+		bodyBytes, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		err = json.Unmarshal(bodyBytes, &newSupplier)
+		if err != nil || newSupplier.SupplierID != 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if err = validateSupplier(newSupplier); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		// the unique key on the normalized name catches duplicates, including two added at the same time
+		supplierID, err := insertSupplier(newSupplier)
+		if err == ErrDuplicateName {
+			http.Error(w, fmt.Sprintf("a supplier named like [%s] already exists", newSupplier.Name), http.StatusConflict)
+			return
+		} else if err != nil {
+			log.Println(err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(fmt.Sprintf(`{"supplierId":%d}`, supplierID)))
+
+	case http.MethodOptions:
+		return
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func supplierHandler(w http.ResponseWriter, r *http.Request) {
+	urlPathSegments := strings.Split(r.URL.Path, fmt.Sprintf("/%s/", suppliersBasePath))
+	supplierID, err := strconv.Atoi(urlPathSegments[len(urlPathSegments)-1])
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if r.Method == http.MethodOptions {
+		return
+	}
+	supplier, err := GetSupplier(supplierID)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if supplier == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		supplierJSON, err := json.Marshal(supplier)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Write(supplierJSON)
+
+	case http.MethodPut:
+		var updatedSupplier Supplier
+		bodyBytes, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		err = json.Unmarshal(bodyBytes, &updatedSupplier)
+		if err != nil || updatedSupplier.SupplierID != supplierID {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if err = validateSupplier(updatedSupplier); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		err = updateSupplier(updatedSupplier)
+		if err == ErrDuplicateName {
+			http.Error(w, fmt.Sprintf("a supplier named like [%s] already exists", updatedSupplier.Name), http.StatusConflict)
+			return
+		} else if err != nil {
+			log.Println(err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+
+	case http.MethodDelete:
+		err = removeSupplier(supplierID)
+		if err == ErrSupplierInUse {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		} else if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
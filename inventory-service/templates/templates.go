@@ -0,0 +1,10 @@
+package templates
+
+import "embed"
+
+// The report templates are built into the binary, so the service works whatever directory it's started from
+
+// FS holds every .gotmpl file in this directory
+//
+//go:embed *.gotmpl
+var FS embed.FS
@@ -0,0 +1,286 @@
+package webhook
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/jordbick/Golang/inventory-service/database"
+)
+
+// Event types are stored as a comma separated list in a single column
+func scanSubscription(scan func(dest ...interface{}) error) (*Subscription, error) {
+	subscription := &Subscription{}
+	var eventTypes string
+	err := scan(&subscription.SubscriptionID,
+		&subscription.URL,
+		&subscription.Secret,
+		&eventTypes,
+		&subscription.Active,
+		&subscription.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	subscription.EventTypes = strings.Split(eventTypes, ",")
+	return subscription, nil
+}
+
+func getSubscription(subscriptionID int) (*Subscription, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	row := database.DbConn.QueryRowContext(ctx, `SELECT subscriptionId,
+	url,
+	secret,
+	eventTypes,
+	active,
+	createdAt
+	FROM webhook_subscriptions
+	WHERE subscriptionId = ?`, subscriptionID)
+	subscription, err := scanSubscription(row.Scan)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return subscription, nil
+}
+
+func getSubscriptionList() ([]Subscription, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	results, err := database.DbConn.QueryContext(ctx, `SELECT subscriptionId,
+	url,
+	secret,
+	eventTypes,
+	active,
+	createdAt
+	FROM webhook_subscriptions`)
+	if err != nil {
+		return nil, err
+	}
+	defer results.Close()
+	subscriptions := make([]Subscription, 0)
+	for results.Next() {
+		subscription, err := scanSubscription(results.Scan)
+		if err != nil {
+			return nil, err
+		}
+		subscriptions = append(subscriptions, *subscription)
+	}
+	return subscriptions, nil
+}
+
+func insertSubscription(subscription Subscription) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	result, err := database.DbConn.ExecContext(ctx, `INSERT INTO webhook_subscriptions (
+	url,
+	secret,
+	eventTypes,
+	active) VALUES (?, ?, ?, ?)`,
+		subscription.URL,
+		subscription.Secret,
+		strings.Join(subscription.EventTypes, ","),
+		subscription.Active)
+	if err != nil {
+		return 0, err
+	}
+	insertID, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	return int(insertID), nil
+}
+
+func updateSubscription(subscription Subscription) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	_, err := database.DbConn.ExecContext(ctx, `UPDATE webhook_subscriptions SET
+	url=?,
+	secret=?,
+	eventTypes=?,
+	active=?
+	WHERE subscriptionId=?`,
+		subscription.URL,
+		subscription.Secret,
+		strings.Join(subscription.EventTypes, ","),
+		subscription.Active,
+		subscription.SubscriptionID)
+	return err
+}
+
+func removeSubscription(subscriptionID int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	_, err := database.DbConn.ExecContext(ctx, `DELETE FROM webhook_subscriptions WHERE subscriptionId = ?`, subscriptionID)
+	return err
+}
+
+// insertDelivery writes a new event into the outbox, ready to be picked up by the dispatcher straight away
+func insertDelivery(subscriptionID int, eventType string, payload []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	_, err := database.DbConn.ExecContext(ctx, `INSERT INTO webhook_deliveries (
+	subscriptionId,
+	eventType,
+	payload,
+	status,
+	nextAttemptAt) VALUES (?, ?, ?, ?, ?)`,
+		subscriptionID,
+		eventType,
+		string(payload),
+		StatusPending,
+		time.Now().UTC())
+	return err
+}
+
+const deliveryColumns = `deliveryId,
+	subscriptionId,
+	eventType,
+	payload,
+	status,
+	attempts,
+	nextAttemptAt,
+	lastStatusCode,
+	lastError,
+	createdAt,
+	deliveredAt`
+
+func scanDeliveries(results *sql.Rows) ([]Delivery, error) {
+	deliveries := make([]Delivery, 0)
+	for results.Next() {
+		var delivery Delivery
+		var payload string
+		var deliveredAt sql.NullTime
+		err := results.Scan(&delivery.DeliveryID,
+			&delivery.SubscriptionID,
+			&delivery.EventType,
+			&payload,
+			&delivery.Status,
+			&delivery.Attempts,
+			&delivery.NextAttemptAt,
+			&delivery.LastStatusCode,
+			&delivery.LastError,
+			&delivery.CreatedAt,
+			&deliveredAt)
+		if err != nil {
+			return nil, err
+		}
+		delivery.Payload = []byte(payload)
+		if deliveredAt.Valid {
+			delivery.DeliveredAt = &deliveredAt.Time
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	return deliveries, nil
+}
+
+// getDueDeliveries returns pending deliveries whose next attempt time has passed, oldest first
+func getDueDeliveries(limit int) ([]Delivery, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	results, err := database.DbConn.QueryContext(ctx, `SELECT `+deliveryColumns+`
+	FROM webhook_deliveries
+	WHERE status = ? AND nextAttemptAt <= ?
+	ORDER BY nextAttemptAt
+	LIMIT ?`, StatusPending, time.Now().UTC(), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer results.Close()
+	return scanDeliveries(results)
+}
+
+// getDeliveryLog returns the most recent deliveries for a subscription, optionally filtered by status
+func getDeliveryLog(subscriptionID int, status string, limit int) ([]Delivery, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	queryArgs := []interface{}{subscriptionID}
+	var queryBuilder strings.Builder
+	queryBuilder.WriteString(`SELECT ` + deliveryColumns + `
+	FROM webhook_deliveries
+	WHERE subscriptionId = ? `)
+	if status != "" {
+		queryBuilder.WriteString(`AND status = ? `)
+		queryArgs = append(queryArgs, status)
+	}
+	queryBuilder.WriteString(`ORDER BY deliveryId DESC LIMIT ?`)
+	queryArgs = append(queryArgs, limit)
+	results, err := database.DbConn.QueryContext(ctx, queryBuilder.String(), queryArgs...)
+	if err != nil {
+		return nil, err
+	}
+	defer results.Close()
+	return scanDeliveries(results)
+}
+
+// claimDelivery moves a due delivery's next attempt on to leaseUntil before it's sent
+// It only succeeds if the delivery is still pending and nextAttemptAt hasn't been moved by someone else in the meantime,
+// so if several instances of the service are running only one of them sends each delivery. If the instance that claimed it
+// stops before recording the attempt, the delivery is due again once the lease runs out
+func claimDelivery(delivery Delivery, leaseUntil time.Time) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	result, err := database.DbConn.ExecContext(ctx, `UPDATE webhook_deliveries SET
+	nextAttemptAt=?
+	WHERE deliveryId=? AND status=? AND nextAttemptAt=?`,
+		leaseUntil.UTC(),
+		delivery.DeliveryID,
+		StatusPending,
+		delivery.NextAttemptAt.UTC())
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rows == 1, nil
+}
+
+// recordAttempt stores the outcome of a delivery attempt along with its new status and next attempt time
+func recordAttempt(delivery Delivery) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	_, err := database.DbConn.ExecContext(ctx, `UPDATE webhook_deliveries SET
+	status=?,
+	attempts=?,
+	nextAttemptAt=?,
+	lastStatusCode=?,
+	lastError=?,
+	deliveredAt=?
+	WHERE deliveryId=?`,
+		delivery.Status,
+		delivery.Attempts,
+		delivery.NextAttemptAt.UTC(),
+		delivery.LastStatusCode,
+		delivery.LastError,
+		delivery.DeliveredAt,
+		delivery.DeliveryID)
+	return err
+}
+
+// requeueDelivery puts a dead-lettered delivery back into the outbox with a fresh set of attempts
+func requeueDelivery(subscriptionID int, deliveryID int) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	result, err := database.DbConn.ExecContext(ctx, `UPDATE webhook_deliveries SET
+	status=?,
+	attempts=0,
+	nextAttemptAt=?
+	WHERE deliveryId=? AND subscriptionId=? AND status=?`,
+		StatusPending,
+		time.Now().UTC(),
+		deliveryID,
+		subscriptionID,
+		StatusDead)
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rows > 0, nil
+}
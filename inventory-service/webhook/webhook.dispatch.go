@@ -0,0 +1,174 @@
+package webhook
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Dispatcher polls the outbox for due deliveries and POSTs them to their subscribers
+// Because the outbox lives in the database, anything still pending when the service stops is picked up again on the next start
+// Failed attempts are retried with exponential backoff, and after MaxAttempts the delivery is dead-lettered
+type Dispatcher struct {
+	Client       *http.Client
+	MaxAttempts  int
+	BaseBackoff  time.Duration
+	MaxBackoff   time.Duration
+	PollInterval time.Duration
+	BatchSize    int
+	// Lease is how long a claimed delivery is left alone by other instances while it's being sent
+	Lease time.Duration
+}
+
+// NewDispatcher returns a dispatcher with the default retry settings
+func NewDispatcher() *Dispatcher {
+	return &Dispatcher{
+		Client:       &http.Client{Timeout: 10 * time.Second},
+		MaxAttempts:  8,
+		BaseBackoff:  30 * time.Second,
+		MaxBackoff:   6 * time.Hour,
+		PollInterval: 5 * time.Second,
+		BatchSize:    50,
+		Lease:        2 * time.Minute,
+	}
+}
+
+// Publish queues an event for every active subscription that wants it
+// Errors are returned so the caller can log them, but publishing should never fail the request that triggered it
+func Publish(eventType string, data interface{}) error {
+	subscriptions, err := getSubscriptionList()
+	if err != nil {
+		return err
+	}
+	payload, err := json.Marshal(Event{Type: eventType, OccurredAt: time.Now().UTC(), Data: data})
+	if err != nil {
+		return err
+	}
+	for _, subscription := range subscriptions {
+		if !subscription.wants(eventType) {
+			continue
+		}
+		err = insertDelivery(subscription.SubscriptionID, eventType, payload)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Start sends whatever deliveries are due now and then every PollInterval, in the background until done is closed
+func (d *Dispatcher) Start(done <-chan struct{}) {
+	go func() {
+		ticker := time.NewTicker(d.PollInterval)
+		defer ticker.Stop()
+		for {
+			d.dispatchDue()
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+func (d *Dispatcher) dispatchDue() {
+	deliveries, err := getDueDeliveries(d.BatchSize)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	for _, delivery := range deliveries {
+		claimed, err := claimDelivery(delivery, time.Now().Add(d.Lease))
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		if !claimed {
+			// another instance is sending it
+			continue
+		}
+		subscription, err := getSubscription(delivery.SubscriptionID)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		if subscription == nil {
+			// the subscription was removed after the event was queued, there's nobody left to deliver to
+			delivery.Status = StatusDead
+			delivery.LastError = "subscription no longer exists"
+		} else {
+			statusCode, err := d.Deliver(*subscription, delivery)
+			delivery = d.nextState(delivery, statusCode, err, time.Now())
+		}
+		err = recordAttempt(delivery)
+		if err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+// Deliver sends a single delivery to the subscriber and returns the HTTP status code it responded with
+// Any 2xx response counts as success
+func (d *Dispatcher) Deliver(subscription Subscription, delivery Delivery) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, subscription.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventHeader, delivery.EventType)
+	req.Header.Set(DeliveryHeader, strconv.Itoa(delivery.DeliveryID))
+	req.Header.Set(SignatureHeader, Sign(subscription.Secret, delivery.Payload))
+	resp, err := d.Client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	// drain the body so the connection can be reused
+	io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("subscriber responded with %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// nextState works out what happens to a delivery after an attempt
+// Success marks it delivered, failure schedules a retry, and running out of attempts dead-letters it
+func (d *Dispatcher) nextState(delivery Delivery, statusCode int, err error, now time.Time) Delivery {
+	delivery.Attempts++
+	delivery.LastStatusCode = statusCode
+	if err == nil {
+		delivery.Status = StatusDelivered
+		delivery.LastError = ""
+		delivery.DeliveredAt = &now
+		return delivery
+	}
+	delivery.LastError = err.Error()
+	if len(delivery.LastError) > 1024 {
+		delivery.LastError = delivery.LastError[:1024]
+	}
+	if delivery.Attempts >= d.MaxAttempts {
+		delivery.Status = StatusDead
+		return delivery
+	}
+	delivery.NextAttemptAt = now.Add(d.backoff(delivery.Attempts))
+	return delivery
+}
+
+// backoff doubles the wait after every failed attempt, up to MaxBackoff
+func (d *Dispatcher) backoff(attempts int) time.Duration {
+	wait := d.BaseBackoff
+	for i := 1; i < attempts; i++ {
+		wait *= 2
+		if wait >= d.MaxBackoff {
+			return d.MaxBackoff
+		}
+	}
+	return wait
+}
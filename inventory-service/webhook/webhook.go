@@ -0,0 +1,103 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+)
+
+// Outbound webhooks let other systems (e.g. the ERP) hear about changes in the inventory service
+// A subscription registers a URL and a secret for a list of event types
+// Every matching event is written to the webhook_deliveries table (the outbox) and a background dispatcher POSTs it to the subscriber
+
+// Event types that can be subscribed to
+const (
+	EventProductCreated  = "product.created"
+	EventProductUpdated  = "product.updated"
+	EventProductDeleted  = "product.deleted"
+	EventReceiptUploaded = "receipt.uploaded"
+	// subscribe with "*" to receive every event type
+	EventAll = "*"
+)
+
+// Delivery statuses
+const (
+	StatusPending   = "pending"
+	StatusDelivered = "delivered"
+	StatusDead      = "dead"
+)
+
+// Headers sent with every delivery so the receiver can verify and de-duplicate it
+const (
+	SignatureHeader = "X-Webhook-Signature"
+	EventHeader     = "X-Webhook-Event"
+	DeliveryHeader  = "X-Webhook-Delivery"
+)
+
+// Subscription
+type Subscription struct {
+	SubscriptionID int       `json:"subscriptionId"`
+	URL            string    `json:"url"`
+	Secret         string    `json:"secret,omitempty"`
+	EventTypes     []string  `json:"eventTypes"`
+	Active         bool      `json:"active"`
+	CreatedAt      time.Time `json:"createdAt"`
+}
+
+// Delivery is a single event queued for a single subscription
+type Delivery struct {
+	DeliveryID     int             `json:"deliveryId"`
+	SubscriptionID int             `json:"subscriptionId"`
+	EventType      string          `json:"eventType"`
+	Payload        json.RawMessage `json:"payload"`
+	Status         string          `json:"status"`
+	Attempts       int             `json:"attempts"`
+	NextAttemptAt  time.Time       `json:"nextAttemptAt"`
+	LastStatusCode int             `json:"lastStatusCode"`
+	LastError      string          `json:"lastError"`
+	CreatedAt      time.Time       `json:"createdAt"`
+	DeliveredAt    *time.Time      `json:"deliveredAt"`
+}
+
+// Event is the JSON body that is POSTed to subscribers
+type Event struct {
+	Type       string      `json:"type"`
+	OccurredAt time.Time   `json:"occurredAt"`
+	Data       interface{} `json:"data"`
+}
+
+// wants returns whether the subscription is interested in the given event type
+func (s Subscription) wants(eventType string) bool {
+	if !s.Active {
+		return false
+	}
+	for _, t := range s.EventTypes {
+		if t == eventType || t == EventAll {
+			return true
+		}
+	}
+	return false
+}
+
+// Sign returns the hex encoded HMAC-SHA256 of the body using the subscription secret
+// The receiver recomputes this with its copy of the secret and compares it to the X-Webhook-Signature header
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks a signature header value against the body, using a constant time comparison
+func Verify(secret string, body []byte, signature string) bool {
+	return hmac.Equal([]byte(Sign(secret, body)), []byte(signature))
+}
+
+func validEventType(eventType string) bool {
+	switch eventType {
+	case EventProductCreated, EventProductUpdated, EventProductDeleted, EventReceiptUploaded, EventAll:
+		return true
+	}
+	return false
+}
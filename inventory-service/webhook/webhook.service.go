@@ -0,0 +1,236 @@
+package webhook
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/jordbick/Golang/inventory-service/cors"
+)
+
+const webhooksBasePath = "webhooks"
+
+// SetupRoutes registers the subscription and delivery log handlers
+func SetupRoutes(apiBasePath string) {
+	handleWebhooks := http.HandlerFunc(webhooksHandler)
+	handleWebhook := http.HandlerFunc(webhookHandler)
+	http.Handle(fmt.Sprintf("%s/%s", apiBasePath, webhooksBasePath), cors.Middleware(handleWebhooks))
+	http.Handle(fmt.Sprintf("%s/%s/", apiBasePath, webhooksBasePath), cors.Middleware(handleWebhook))
+}
+
+// validateSubscription checks the fields a client is allowed to set
+func validateSubscription(subscription Subscription) error {
+	u, err := url.Parse(subscription.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("url must be an absolute http or https URL")
+	}
+	if subscription.Secret == "" {
+		return fmt.Errorf("secret is required")
+	}
+	if len(subscription.EventTypes) == 0 {
+		return fmt.Errorf("at least one event type is required")
+	}
+	for _, eventType := range subscription.EventTypes {
+		if !validEventType(eventType) {
+			return fmt.Errorf("unknown event type [%s]", eventType)
+		}
+	}
+	return nil
+}
+
+func webhooksHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		subscriptions, err := getSubscriptionList()
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		// never send the secrets back out
+		for i := range subscriptions {
+			subscriptions[i].Secret = ""
+		}
+		subscriptionsJSON, err := json.Marshal(subscriptions)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Write(subscriptionsJSON)
+
+	case http.MethodPost:
+		var newSubscription Subscription
+		bodyBytes, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		err = json.Unmarshal(bodyBytes, &newSubscription)
+		if err != nil || newSubscription.SubscriptionID != 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if err = validateSubscription(newSubscription); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		newSubscription.Active = true
+		subscriptionID, err := insertSubscription(newSubscription)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(fmt.Sprintf(`{"subscriptionId":%d}`, subscriptionID)))
+
+	case http.MethodOptions:
+		return
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// Handles /webhooks/{id}, /webhooks/{id}/deliveries and /webhooks/{id}/deliveries/{deliveryId}/retry
+func webhookHandler(w http.ResponseWriter, r *http.Request) {
+	urlPathSegments := strings.Split(strings.Trim(strings.SplitN(r.URL.Path, fmt.Sprintf("/%s/", webhooksBasePath), 2)[1], "/"), "/")
+	subscriptionID, err := strconv.Atoi(urlPathSegments[0])
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if r.Method == http.MethodOptions {
+		return
+	}
+	subscription, err := getSubscription(subscriptionID)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if subscription == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	switch {
+	case len(urlPathSegments) == 1:
+		subscriptionHandler(w, r, *subscription)
+	case len(urlPathSegments) == 2 && urlPathSegments[1] == "deliveries":
+		deliveryLogHandler(w, r, *subscription)
+	case len(urlPathSegments) == 4 && urlPathSegments[1] == "deliveries" && urlPathSegments[3] == "retry":
+		deliveryID, err := strconv.Atoi(urlPathSegments[2])
+		if err != nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		retryHandler(w, r, *subscription, deliveryID)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func subscriptionHandler(w http.ResponseWriter, r *http.Request, subscription Subscription) {
+	switch r.Method {
+	case http.MethodGet:
+		subscription.Secret = ""
+		subscriptionJSON, err := json.Marshal(subscription)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Write(subscriptionJSON)
+
+	case http.MethodPut:
+		var updatedSubscription Subscription
+		bodyBytes, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		err = json.Unmarshal(bodyBytes, &updatedSubscription)
+		if err != nil || updatedSubscription.SubscriptionID != subscription.SubscriptionID {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		// leaving the secret out of an update keeps the existing one
+		if updatedSubscription.Secret == "" {
+			updatedSubscription.Secret = subscription.Secret
+		}
+		if err = validateSubscription(updatedSubscription); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		err = updateSubscription(updatedSubscription)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+
+	case http.MethodDelete:
+		err := removeSubscription(subscription.SubscriptionID)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// deliveryLogHandler returns the delivery history for a subscription
+// Supports ?status=pending|delivered|dead and ?limit=n (default 100)
+func deliveryLogHandler(w http.ResponseWriter, r *http.Request, subscription Subscription) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	status := r.URL.Query().Get("status")
+	if status != "" && status != StatusPending && status != StatusDelivered && status != StatusDead {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	limit := 100
+	if l := r.URL.Query().Get("limit"); l != "" {
+		var err error
+		limit, err = strconv.Atoi(l)
+		if err != nil || limit <= 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
+	deliveries, err := getDeliveryLog(subscription.SubscriptionID, status, limit)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	deliveriesJSON, err := json.Marshal(deliveries)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Write(deliveriesJSON)
+}
+
+// retryHandler moves a dead-lettered delivery back into the outbox
+func retryHandler(w http.ResponseWriter, r *http.Request, subscription Subscription, deliveryID int) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	requeued, err := requeueDelivery(subscription.SubscriptionID, deliveryID)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if !requeued {
+		w.WriteHeader(http.StatusConflict)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
@@ -0,0 +1,230 @@
+package webhook
+
+import (
+	"database/sql/driver"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jordbick/Golang/inventory-service/database"
+	"github.com/jordbick/Golang/inventory-service/database/dbtest"
+)
+
+func TestSignVerify(t *testing.T) {
+	body := []byte(`{"type":"product.created"}`)
+	signature := Sign("secret", body)
+	if !strings.HasPrefix(signature, "sha256=") || len(signature) != len("sha256=")+64 {
+		t.Fatalf("Sign() = %q, want sha256= and 64 hex digits", signature)
+	}
+	if !Verify("secret", body, signature) {
+		t.Error("Verify() = false for the signature Sign made")
+	}
+	if Verify("other secret", body, signature) {
+		t.Error("Verify() = true with the wrong secret")
+	}
+	if Verify("secret", []byte(`{"type":"product.deleted"}`), signature) {
+		t.Error("Verify() = true for a changed body")
+	}
+	if Verify("secret", body, "") {
+		t.Error("Verify() = true for an empty signature")
+	}
+}
+
+func TestDeliver(t *testing.T) {
+	var got *http.Request
+	var gotBody []byte
+	status := http.StatusNoContent
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+		gotBody, _ = ioutil.ReadAll(r.Body)
+		w.WriteHeader(status)
+	}))
+	defer receiver.Close()
+
+	d := NewDispatcher()
+	subscription := Subscription{SubscriptionID: 1, URL: receiver.URL, Secret: "secret"}
+	delivery := Delivery{DeliveryID: 42, EventType: EventProductCreated, Payload: []byte(`{"type":"product.created"}`)}
+	statusCode, err := d.Deliver(subscription, delivery)
+	if err != nil || statusCode != http.StatusNoContent {
+		t.Fatalf("Deliver() = %d, %v, want 204, nil", statusCode, err)
+	}
+	if got.Method != http.MethodPost || got.Header.Get("Content-Type") != "application/json" {
+		t.Errorf("request was %s with Content-Type %q", got.Method, got.Header.Get("Content-Type"))
+	}
+	if got.Header.Get(EventHeader) != EventProductCreated || got.Header.Get(DeliveryHeader) != "42" {
+		t.Errorf("event header %q, delivery header %q", got.Header.Get(EventHeader), got.Header.Get(DeliveryHeader))
+	}
+	if !Verify("secret", gotBody, got.Header.Get(SignatureHeader)) {
+		t.Errorf("%s %q doesn't verify for body %s", SignatureHeader, got.Header.Get(SignatureHeader), gotBody)
+	}
+
+	status = http.StatusServiceUnavailable
+	statusCode, err = d.Deliver(subscription, delivery)
+	if err == nil || statusCode != http.StatusServiceUnavailable {
+		t.Errorf("Deliver() = %d, %v, want 503 and an error", statusCode, err)
+	}
+}
+
+func TestNextStateBackoff(t *testing.T) {
+	d := &Dispatcher{MaxAttempts: 10, BaseBackoff: 30 * time.Second, MaxBackoff: 10 * time.Minute}
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	want := []time.Duration{
+		30 * time.Second,
+		time.Minute,
+		2 * time.Minute,
+		4 * time.Minute,
+		8 * time.Minute,
+		10 * time.Minute,
+		10 * time.Minute,
+	}
+	delivery := Delivery{Status: StatusPending}
+	for i, wait := range want {
+		delivery = d.nextState(delivery, http.StatusInternalServerError, errors.New("subscriber responded with 500"), now)
+		if delivery.Attempts != i+1 || delivery.Status != StatusPending {
+			t.Fatalf("attempt %d: attempts %d, status %s", i+1, delivery.Attempts, delivery.Status)
+		}
+		if got := delivery.NextAttemptAt.Sub(now); got != wait {
+			t.Errorf("attempt %d: next attempt in %v, want %v", i+1, got, wait)
+		}
+		if delivery.LastStatusCode != http.StatusInternalServerError || delivery.LastError == "" {
+			t.Errorf("attempt %d: last status %d, last error %q", i+1, delivery.LastStatusCode, delivery.LastError)
+		}
+	}
+
+	delivery = d.nextState(delivery, http.StatusOK, nil, now)
+	if delivery.Status != StatusDelivered || delivery.LastError != "" || delivery.DeliveredAt == nil || !delivery.DeliveredAt.Equal(now) {
+		t.Errorf("after success: status %s, last error %q, delivered at %v", delivery.Status, delivery.LastError, delivery.DeliveredAt)
+	}
+}
+
+func TestNextStateDeadLetter(t *testing.T) {
+	d := &Dispatcher{MaxAttempts: 3, BaseBackoff: time.Second, MaxBackoff: time.Minute}
+	now := time.Now()
+	delivery := Delivery{Status: StatusPending}
+	for i := 1; i <= 3; i++ {
+		delivery = d.nextState(delivery, 0, errors.New(strings.Repeat("x", 2000)), now)
+		wantStatus := StatusPending
+		if i == 3 {
+			wantStatus = StatusDead
+		}
+		if delivery.Status != wantStatus {
+			t.Errorf("after %d attempts status is %s, want %s", i, delivery.Status, wantStatus)
+		}
+		if len(delivery.LastError) != 1024 {
+			t.Errorf("last error is %d bytes, want it cut to 1024", len(delivery.LastError))
+		}
+	}
+}
+
+// outbox is the webhook tables as the fake database sees them, one subscription and the deliveries due for it
+type outbox struct {
+	mu           sync.Mutex
+	subscription Subscription
+	due          []Delivery
+	// claimed is how many rows a claim updates, 0 as if another instance got there first
+	claimed  int64
+	recorded [][]driver.Value
+	requeued int64
+}
+
+func (o *outbox) handle(query string, args []driver.Value) (dbtest.Result, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	switch {
+	case strings.Contains(query, "FROM webhook_subscriptions"):
+		s := o.subscription
+		return dbtest.Result{Rows: [][]driver.Value{{int64(s.SubscriptionID), s.URL, s.Secret, strings.Join(s.EventTypes, ","), s.Active, s.CreatedAt}}}, nil
+	case strings.Contains(query, "FROM webhook_deliveries"):
+		var rows [][]driver.Value
+		for _, d := range o.due {
+			rows = append(rows, []driver.Value{int64(d.DeliveryID), int64(d.SubscriptionID), d.EventType, string(d.Payload), d.Status,
+				int64(d.Attempts), d.NextAttemptAt, int64(d.LastStatusCode), d.LastError, d.CreatedAt, nil})
+		}
+		return dbtest.Result{Rows: rows}, nil
+	case strings.Contains(query, "WHERE deliveryId=? AND status=? AND nextAttemptAt=?"):
+		return dbtest.Result{RowsAffected: o.claimed}, nil
+	case strings.Contains(query, "deliveredAt=?"):
+		o.recorded = append(o.recorded, args)
+		return dbtest.Result{RowsAffected: 1}, nil
+	case strings.Contains(query, "attempts=0"):
+		return dbtest.Result{RowsAffected: o.requeued}, nil
+	}
+	return dbtest.Result{}, errors.New("unexpected query " + query)
+}
+
+func TestDispatchDue(t *testing.T) {
+	var mu sync.Mutex
+	var received []string
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if !Verify("secret", body, r.Header.Get(SignatureHeader)) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		mu.Lock()
+		received = append(received, r.Header.Get(DeliveryHeader))
+		mu.Unlock()
+	}))
+	defer receiver.Close()
+
+	due := time.Now().UTC().Add(-time.Minute).Truncate(time.Second)
+	box := &outbox{
+		subscription: Subscription{SubscriptionID: 1, URL: receiver.URL, Secret: "secret", EventTypes: []string{EventAll}, Active: true},
+		due:          []Delivery{{DeliveryID: 7, SubscriptionID: 1, EventType: EventProductUpdated, Payload: []byte(`{}`), Status: StatusPending, NextAttemptAt: due}},
+		claimed:      1,
+	}
+	database.DbConn = dbtest.Open(box.handle)
+	d := NewDispatcher()
+
+	d.dispatchDue()
+	if len(received) != 1 || received[0] != "7" {
+		t.Fatalf("receiver got deliveries %v, want [7]", received)
+	}
+	if len(box.recorded) != 1 || box.recorded[0][0] != StatusDelivered || box.recorded[0][1] != int64(1) {
+		t.Fatalf("recorded %v, want delivery 7 delivered after 1 attempt", box.recorded)
+	}
+
+	// a delivery another instance has claimed is left to it
+	box.claimed = 0
+	d.dispatchDue()
+	if len(received) != 1 || len(box.recorded) != 1 {
+		t.Errorf("a delivery that wasn't claimed was sent, receiver got %v", received)
+	}
+
+	// a subscriber that's down has the delivery rescheduled
+	box.claimed = 1
+	receiver.Close()
+	d.dispatchDue()
+	if len(box.recorded) != 2 || box.recorded[1][0] != StatusPending || box.recorded[1][2].(time.Time).Before(time.Now().Add(d.BaseBackoff/2)) {
+		t.Errorf("recorded %v, want delivery 7 pending with a retry after the backoff", box.recorded[1:])
+	}
+}
+
+func TestRetryHandler(t *testing.T) {
+	box := &outbox{subscription: Subscription{SubscriptionID: 1, URL: "http://example.com", Secret: "secret", EventTypes: []string{EventAll}, Active: true}}
+	database.DbConn = dbtest.Open(box.handle)
+	tests := []struct {
+		method   string
+		path     string
+		requeued int64
+		want     int
+	}{
+		{http.MethodPost, "/api/webhooks/1/deliveries/7/retry", 1, http.StatusAccepted},
+		{http.MethodPost, "/api/webhooks/1/deliveries/7/retry", 0, http.StatusConflict},
+		{http.MethodGet, "/api/webhooks/1/deliveries/7/retry", 1, http.StatusMethodNotAllowed},
+		{http.MethodPost, "/api/webhooks/1/deliveries/x/retry", 1, http.StatusNotFound},
+	}
+	for _, test := range tests {
+		box.requeued = test.requeued
+		w := httptest.NewRecorder()
+		webhookHandler(w, httptest.NewRequest(test.method, test.path, nil))
+		if w.Code != test.want {
+			t.Errorf("%s %s with %d requeued: status %d, want %d", test.method, test.path, test.requeued, w.Code, test.want)
+		}
+	}
+}
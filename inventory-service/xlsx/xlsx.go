@@ -0,0 +1,188 @@
+package xlsx
+
+import (
+	"archive/zip"
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// A streaming writer for single sheet XLSX workbooks
+// An XLSX file is a zip of XML parts, the sheet itself is written a row at a time straight into the zip so nothing is held in memory
+// Strings are written inline rather than in a shared strings table, which Excel, LibreOffice and Google Sheets all read fine
+
+// Cell styles, these are indexes into the cellXfs in styles.xml below
+const (
+	StyleNone    = 0
+	StyleBold    = 1
+	StyleInteger = 2
+	StyleDecimal = 3
+	// bold versions of the number styles, for totals
+	StyleBoldInteger = 4
+	StyleBoldDecimal = 5
+)
+
+// Cell is a single cell, Number is used when IsNumber is set and Text otherwise
+// A Formula is stored along with its value so the sheet shows the right thing even before it's recalculated
+type Cell struct {
+	Text     string
+	Number   float64
+	IsNumber bool
+	Formula  string
+	Style    int
+}
+
+// Writer
+type Writer struct {
+	zw    *zip.Writer
+	sheet *bufio.Writer
+	rows  int
+	err   error
+}
+
+// NewWriter starts a workbook with a single sheet
+func NewWriter(w io.Writer, sheetName string) (*Writer, error) {
+	zw := zip.NewWriter(w)
+	parts := []struct{ name, content string }{
+		{"[Content_Types].xml", contentTypes},
+		{"_rels/.rels", rootRels},
+		{"xl/workbook.xml", fmt.Sprintf(workbook, escape(sheetName))},
+		{"xl/_rels/workbook.xml.rels", workbookRels},
+		{"xl/styles.xml", styles},
+	}
+	for _, part := range parts {
+		f, err := zw.Create(part.name)
+		if err != nil {
+			return nil, err
+		}
+		if _, err = io.WriteString(f, part.content); err != nil {
+			return nil, err
+		}
+	}
+	f, err := zw.Create("xl/worksheets/sheet1.xml")
+	if err != nil {
+		return nil, err
+	}
+	x := &Writer{zw: zw, sheet: bufio.NewWriter(f)}
+	x.sheet.WriteString(xml.Header)
+	x.sheet.WriteString(`<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`)
+	return x, nil
+}
+
+// Rows returns how many rows have been written so far
+func (x *Writer) Rows() int {
+	return x.rows
+}
+
+// WriteRow adds the next row to the sheet
+func (x *Writer) WriteRow(cells []Cell) error {
+	if x.err != nil {
+		return x.err
+	}
+	x.rows++
+	fmt.Fprintf(x.sheet, `<row r="%d">`, x.rows)
+	for i, c := range cells {
+		ref := CellRef(i, x.rows)
+		style := ""
+		if c.Style != StyleNone {
+			style = fmt.Sprintf(` s="%d"`, c.Style)
+		}
+		switch {
+		case c.Formula != "":
+			fmt.Fprintf(x.sheet, `<c r="%s"%s><f>%s</f><v>%s</v></c>`, ref, style, escape(c.Formula), strconv.FormatFloat(c.Number, 'f', -1, 64))
+		case c.IsNumber:
+			fmt.Fprintf(x.sheet, `<c r="%s"%s><v>%s</v></c>`, ref, style, strconv.FormatFloat(c.Number, 'f', -1, 64))
+		default:
+			fmt.Fprintf(x.sheet, `<c r="%s"%s t="inlineStr"><is><t xml:space="preserve">%s</t></is></c>`, ref, style, escape(c.Text))
+		}
+	}
+	_, x.err = x.sheet.WriteString(`</row>`)
+	return x.err
+}
+
+// Close finishes the sheet and the zip, it doesn't close the underlying writer
+func (x *Writer) Close() error {
+	if x.err != nil {
+		return x.err
+	}
+	x.sheet.WriteString(`</sheetData></worksheet>`)
+	if err := x.sheet.Flush(); err != nil {
+		return err
+	}
+	return x.zw.Close()
+}
+
+// CellRef returns the A1 style reference of a zero based column and one based row, e.g. (27, 3) is AB3
+func CellRef(column, row int) string {
+	return ColumnName(column) + strconv.Itoa(row)
+}
+
+// ColumnName returns the letters of a zero based column, 0 is A, 25 is Z and 26 is AA
+func ColumnName(column int) string {
+	name := ""
+	for column >= 0 {
+		name = string(rune('A'+column%26)) + name
+		column = column/26 - 1
+	}
+	return name
+}
+
+// escape makes text safe for XML, dropping control characters which XML 1.0 doesn't allow at all
+func escape(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if r < 0x20 && r != '\t' && r != '\n' && r != '\r' {
+			continue
+		}
+		b.WriteRune(r)
+	}
+	var out strings.Builder
+	xml.EscapeText(&out, []byte(b.String()))
+	return out.String()
+}
+
+const contentTypes = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">
+<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>
+<Default Extension="xml" ContentType="application/xml"/>
+<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>
+<Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>
+<Override PartName="/xl/styles.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.styles+xml"/>
+</Types>`
+
+const rootRels = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>
+</Relationships>`
+
+const workbook = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">
+<sheets><sheet name="%s" sheetId="1" r:id="rId1"/></sheets>
+</workbook>`
+
+const workbookRels = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/>
+<Relationship Id="rId2" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/styles" Target="styles.xml"/>
+</Relationships>`
+
+// numFmtId 3 and 4 are Excel's built in #,##0 and #,##0.00, which are shown with the separators of whoever opens the file
+const styles = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<styleSheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">
+<fonts count="2"><font><sz val="11"/><name val="Calibri"/></font><font><b/><sz val="11"/><name val="Calibri"/></font></fonts>
+<fills count="2"><fill><patternFill patternType="none"/></fill><fill><patternFill patternType="gray125"/></fill></fills>
+<borders count="1"><border><left/><right/><top/><bottom/><diagonal/></border></borders>
+<cellStyleXfs count="1"><xf numFmtId="0" fontId="0" fillId="0" borderId="0"/></cellStyleXfs>
+<cellXfs count="6">
+<xf numFmtId="0" fontId="0" fillId="0" borderId="0" xfId="0"/>
+<xf numFmtId="0" fontId="1" fillId="0" borderId="0" xfId="0" applyFont="1"/>
+<xf numFmtId="3" fontId="0" fillId="0" borderId="0" xfId="0" applyNumberFormat="1"/>
+<xf numFmtId="4" fontId="0" fillId="0" borderId="0" xfId="0" applyNumberFormat="1"/>
+<xf numFmtId="3" fontId="1" fillId="0" borderId="0" xfId="0" applyNumberFormat="1" applyFont="1"/>
+<xf numFmtId="4" fontId="1" fillId="0" borderId="0" xfId="0" applyNumberFormat="1" applyFont="1"/>
+</cellXfs>
+<cellStyles count="1"><cellStyle name="Normal" xfId="0" builtinId="0"/></cellStyles>
+</styleSheet>`